@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"VectorLite/internal/bench"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// benchCmd represents the bench command
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure recall and speed of the search algorithms",
+	Long: `Build the same dataset into bruteforce and the given algorithms, run a set of
+queries against each and report recall@k, queries per second, p50/p99 latency,
+build time and memory. Bruteforce answers are the ground truth.
+
+The dataset is generated unless --data is given. Settings can be swept: every
+combination of the --sweep values is measured.
+
+Example:
+  vectorlite bench --n 100000 --dims 128 --sweep M=8,16,32 --sweep efSearch=16,64,256`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		dataPath, _ := flags.GetString("data")
+		queriesPath, _ := flags.GetString("queries-file")
+		n, _ := flags.GetInt("n")
+		queries, _ := flags.GetInt("queries")
+		dims, _ := flags.GetInt("dims")
+		clusters, _ := flags.GetInt("clusters")
+		seed, _ := flags.GetInt64("seed")
+		k, _ := flags.GetInt("k")
+		metric, _ := flags.GetString("metric")
+		algorithms, _ := flags.GetStringSlice("algorithm")
+		settings, _ := flags.GetStringArray("set")
+		sweeps, _ := flags.GetStringArray("sweep")
+		format, _ := flags.GetString("format")
+
+		if format != "table" && format != "json" {
+			return fmt.Errorf("invalid format %q, use table or json", format)
+		}
+
+		config := bench.Config{
+			K:          k,
+			Metric:     metric,
+			Algorithms: algorithms,
+			Settings:   map[string]interface{}{},
+			Progress: func(algorithm string, settings map[string]interface{}) {
+				fmt.Fprintf(os.Stderr, "building %s %v\n", algorithm, settings)
+			},
+		}
+		for _, setting := range settings {
+			name, value, found := strings.Cut(setting, "=")
+			if !found || name == "" {
+				return fmt.Errorf("invalid setting %q, use name=value", setting)
+			}
+			config.Settings[name] = bench.ParseValue(value)
+		}
+		for _, sweep := range sweeps {
+			param, err := bench.ParseParam(sweep)
+			if err != nil {
+				return err
+			}
+			config.Sweep = append(config.Sweep, param)
+		}
+
+		dataset, err := loadDataset(dataPath, queriesPath, n, queries, dims, clusters, seed)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%d vectors, %d queries, %d dimensions\n", len(dataset.Vectors), len(dataset.Queries), len(dataset.Vectors[0].Values))
+
+		results, err := bench.Run(dataset, config)
+		if err != nil {
+			return err
+		}
+		if format == "json" {
+			return bench.WriteJSON(os.Stdout, results)
+		}
+		return bench.WriteTable(os.Stdout, results, k)
+	},
+}
+
+// loadDataset generates the dataset, or reads it from dataPath. Without a
+// queries file the queries are held out of the end of the data file.
+func loadDataset(dataPath string, queriesPath string, n int, queries int, dims int, clusters int, seed int64) (bench.Dataset, error) {
+	if dataPath == "" {
+		if n < 1 || queries < 1 || dims < 1 {
+			return bench.Dataset{}, fmt.Errorf("--n, --queries and --dims must be at least 1")
+		}
+		return bench.Generate(n, queries, dims, clusters, seed), nil
+	}
+
+	vectors, err := bench.Load(dataPath)
+	if err != nil {
+		return bench.Dataset{}, err
+	}
+	if queriesPath == "" {
+		return bench.HoldOut(vectors, queries)
+	}
+	queryVectors, err := bench.Load(queriesPath)
+	if err != nil {
+		return bench.Dataset{}, err
+	}
+	if len(queryVectors) > queries {
+		queryVectors = queryVectors[:queries]
+	}
+	return bench.Dataset{Vectors: vectors, Queries: queryVectors}, nil
+}
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+
+	benchCmd.Flags().String("data", "", "Dataset to index, a .fvecs or CSV file; generated when empty")
+	benchCmd.Flags().String("queries-file", "", "Queries to run, a .fvecs or CSV file; held out of --data when empty")
+	benchCmd.Flags().Int("n", 10000, "Number of vectors to generate")
+	benchCmd.Flags().Int("queries", 200, "Number of queries to run")
+	benchCmd.Flags().Int("dims", 64, "Dimensions of the generated vectors")
+	benchCmd.Flags().Int("clusters", 0, "Generate vectors around this many clusters, uniformly when 0")
+	benchCmd.Flags().Int64("seed", 1, "Seed of the generated dataset")
+	benchCmd.Flags().Int("k", 10, "Neighbours asked for by each query")
+	benchCmd.Flags().String("metric", "cosine", "Metric used to query: cosine, dot_product, euclidean or hamming")
+	benchCmd.Flags().StringSlice("algorithm", []string{"hnsw"}, "Algorithms compared with bruteforce")
+	benchCmd.Flags().StringArray("set", nil, "Setting passed to the algorithms, as name=value (repeatable)")
+	benchCmd.Flags().StringArray("sweep", nil, "Setting to sweep, as name=value1,value2,... (repeatable)")
+	benchCmd.Flags().String("format", "table", "Output format: table or json")
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -86,6 +87,12 @@ func handleCommand(input string) {
 		handleListDatabases()
 	case "add":
 		handleAddEntry(parts[1:])
+	case "get":
+		handleGetEntries(parts[1:])
+	case "update":
+		handleUpdateEntry(parts[1:])
+	case "delete":
+		handleDeleteEntry(parts[1:])
 	case "query":
 		handleQuery(parts[1:])
 	case "list":
@@ -101,17 +108,46 @@ func showHelp() {
 	fmt.Println("Available commands:")
 	fmt.Println("  help                          - Show this help message")
 	fmt.Println("  status                        - Check server connection")
-	fmt.Println("  create-db <name> <algorithm>  - Create a new database")
+	fmt.Println("  create-db <name> <algorithm> [settings] - Create a new database")
 	fmt.Println("    Example: create-db mydb bruteforce")
-	fmt.Println("    Algorithms: bruteforce, hnsw")
+	fmt.Println("    Example: create-db mydb hnsw M=32,efSearch=100,metric=euclidean")
+	fmt.Println("    Example: create-db mydb hnsw quantization=int8,keepOriginals=true")
+	fmt.Println("    Example: create-db mydb bruteforce quantization=binary,rerankFactor=10")
+	fmt.Println("    Example: create-db mydb ivf nlist=256,nprobe=16")
+	fmt.Println("    Example: create-db mydb pq subspaces=96,keepOriginals=true")
+	fmt.Println("    Example: create-db mydb lsh tables=16,bits=16,probes=4")
+	fmt.Println("    Example: create-db mydb annoy trees=50,searchK=2000")
+	fmt.Println("    Example: create-db mydb kdtree leafSize=8")
+	fmt.Println("    Example: create-db mydb vamana maxDegree=64,searchList=100")
+	fmt.Println("    Example: create-db mydb sparse metric=dot_product")
+	fmt.Println("    Algorithms: bruteforce, hnsw, ivf, pq, lsh, annoy, kdtree, vamana, sparse")
+	fmt.Println("    HNSW settings: M, Mmax0, efConstruction, efSearch, mL, seed, metric, neighbourSelection,")
+	fmt.Println("                   extendCandidates, keepPrunedConnections, deletion, tombstoneRatio, insertWorkers")
+	fmt.Println("    Quantized storage (bruteforce, hnsw): quantization, trainSize, keepOriginals, rerankFactor")
+	fmt.Println("    IVF settings: nlist, nprobe, metric, iterations, trainSize, sampleSize, retrainRatio, seed")
+	fmt.Println("    PQ settings: subspaces, metric, iterations, trainSize, sampleSize, keepOriginals, rerankFactor, seed")
+	fmt.Println("    LSH settings: tables, bits, probes, bucketWidth, metric, seed")
+	fmt.Println("    Annoy settings: trees, leafSize, searchK, metric, seed")
+	fmt.Println("    KD-tree settings: leafSize, metric (euclidean or cosine)")
+	fmt.Println("    Vamana settings: maxDegree, buildList, searchList, beamWidth, alpha, metric, trainSize, seed, file")
+	fmt.Println("    Sparse settings: metric (dot_product or cosine), for sparse vectors only")
 	fmt.Println("  use-db <name>                 - Select database to use")
 	fmt.Println("    Example: use-db mydb")
 	fmt.Println("  list-dbs                      - List all databases")
-	fmt.Println("  add <vector> <metadata>       - Add vector entry")
-	fmt.Println("    Example: add [1.0,2.0,3.0] name=test,type=example")
-	fmt.Println("  query <vector> <k> <metric>   - Query similar vectors")
+	fmt.Println("  add <vector> <metadata> [id]  - Add vector entry, replacing the entry with the same id")
+	fmt.Println("    Example: add [1.0,2.0,3.0] name=test,type=example doc-42")
+	fmt.Println("    Example: add {3:0.5,17:1.2} name=test (a sparse vector, index:value pairs)")
+	fmt.Println("  get <id> [id...]              - Fetch entries by id")
+	fmt.Println("    Example: get doc-42 7")
+	fmt.Println("  update <id> <metadata>        - Update the metadata of an entry")
+	fmt.Println("    Example: update 42 name=renamed,type=example")
+	fmt.Println("  delete <id>                   - Delete an entry")
+	fmt.Println("    Example: delete 42")
+	fmt.Println("  query <vector> <k> <metric> [filter] - Query similar vectors")
 	fmt.Println("    Example: query [1.0,2.0,3.0] 5 cosine")
-	fmt.Println("    Metrics: cosine, dot_product, euclidean")
+	fmt.Println("    Example: query [1.0,2.0,3.0] 5 cosine lang=en,type=doc")
+	fmt.Println("    Example: query {3:1.0,17:0.4} 10 dot_product")
+	fmt.Println("    Metrics: cosine, dot_product, euclidean, hamming")
 	fmt.Println("  import <file>                 - Import vectors from file")
 	fmt.Println("    Example: import vectors.csv")
 	fmt.Println("    Supported formats: CSV")
@@ -141,8 +177,8 @@ func handleAddEntry(args []string) {
 	}
 	
 	if len(args) < 2 {
-		fmt.Println("Usage: add <vector> <metadata>")
-		fmt.Println("Example: add [1.0,2.0,3.0] name=test,type=example")
+		fmt.Println("Usage: add <vector> <metadata> [id]")
+		fmt.Println("Example: add [1.0,2.0,3.0] name=test,type=example doc-42")
 		return
 	}
 	
@@ -165,9 +201,17 @@ func handleAddEntry(args []string) {
 	// Create request
 	reqData := map[string]interface{}{
 		"database":  selectedDatabase,
-		"vectors":   [][]float64{vector},
+		"vectors":   []interface{}{vector},
 		"metadatas": []map[string]string{metadata},
 	}
+	if len(args) > 2 {
+		// an integer is the internal id of the entry, anything else its client id
+		if id, err := strconv.Atoi(args[2]); err == nil {
+			reqData["ids"] = []int{id}
+		} else {
+			reqData["ids"] = []string{args[2]}
+		}
+	}
 	
 	err = makePostRequest("/entries", reqData)
 	if err != nil {
@@ -178,6 +222,103 @@ func handleAddEntry(args []string) {
 	fmt.Println("Entry added successfully")
 }
 
+func handleGetEntries(args []string) {
+	if selectedDatabase == "" {
+		fmt.Println("Error: No database selected. Use 'use-db <name>' to select a database first.")
+		return
+	}
+	
+	if len(args) < 1 {
+		fmt.Println("Usage: get <id> [id...]")
+		fmt.Println("Example: get doc-42 7")
+		return
+	}
+	
+	reqData := map[string]interface{}{
+		"database": selectedDatabase,
+		"ids":      args,
+	}
+	
+	resp, err := makePostRequestWithResponse("/entries/get", reqData)
+	if err != nil {
+		fmt.Printf("Error fetching entries: %v\n", err)
+		return
+	}
+	
+	var result map[string]interface{}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		fmt.Printf("Error parsing response: %v\n", err)
+		return
+	}
+	
+	entries, _ := result["entries"].([]interface{})
+	for i, entry := range entries {
+		entryMap := entry.(map[string]interface{})
+		printEntryId(i+1, entryMap)
+		fmt.Printf("   Vector: %v\n", entryMap["vector"])
+		fmt.Printf("   Metadata: %v\n", entryMap["metadata"])
+		fmt.Println()
+	}
+	
+	if missing, ok := result["missing"].([]interface{}); ok && len(missing) > 0 {
+		fmt.Printf("Not found: %v\n", missing)
+	}
+}
+
+func handleUpdateEntry(args []string) {
+	if selectedDatabase == "" {
+		fmt.Println("Error: No database selected. Use 'use-db <name>' to select a database first.")
+		return
+	}
+	
+	if len(args) < 2 {
+		fmt.Println("Usage: update <id> <metadata>")
+		fmt.Println("Example: update 42 name=renamed,type=example")
+		return
+	}
+	
+	id := args[0]
+	metadata, err := parseMetadata(args[1])
+	if err != nil {
+		fmt.Printf("Error parsing metadata: %v\n", err)
+		return
+	}
+	
+	reqData := map[string]interface{}{
+		"metadata": metadata,
+	}
+	
+	_, err = makeRequest(http.MethodPatch, fmt.Sprintf("/entries/%s?database=%s", url.PathEscape(id), url.QueryEscape(selectedDatabase)), reqData)
+	if err != nil {
+		fmt.Printf("Error updating entry: %v\n", err)
+		return
+	}
+	
+	fmt.Printf("Entry %s updated successfully\n", id)
+}
+
+func handleDeleteEntry(args []string) {
+	if selectedDatabase == "" {
+		fmt.Println("Error: No database selected. Use 'use-db <name>' to select a database first.")
+		return
+	}
+	
+	if len(args) < 1 {
+		fmt.Println("Usage: delete <id>")
+		fmt.Println("Example: delete 42")
+		return
+	}
+	
+	id := args[0]
+	_, err := makeRequest(http.MethodDelete, fmt.Sprintf("/entries/%s?database=%s", url.PathEscape(id), url.QueryEscape(selectedDatabase)), nil)
+	if err != nil {
+		fmt.Printf("Error deleting entry: %v\n", err)
+		return
+	}
+	
+	fmt.Printf("Entry %s deleted successfully\n", id)
+}
+
 func handleQuery(args []string) {
 	if selectedDatabase == "" {
 		fmt.Println("Error: No database selected. Use 'use-db <name>' to select a database first.")
@@ -185,9 +326,10 @@ func handleQuery(args []string) {
 	}
 	
 	if len(args) < 3 {
-		fmt.Println("Usage: query <vector> <k> <metric>")
+		fmt.Println("Usage: query <vector> <k> <metric> [filter]")
 		fmt.Println("Example: query [1.0,2.0,3.0] 5 cosine")
-		fmt.Println("Metrics: cosine, dot_product, euclidean")
+		fmt.Println("Example: query [1.0,2.0,3.0] 5 cosine lang=en,type=doc")
+		fmt.Println("Metrics: cosine, dot_product, euclidean, hamming")
 		return
 	}
 	
@@ -207,8 +349,8 @@ func handleQuery(args []string) {
 	
 	// Get metric
 	metric := args[2]
-	if metric != "cosine" && metric != "dot_product" && metric != "euclidean" {
-		fmt.Printf("Invalid metric: %s. Use: cosine, dot_product, or euclidean\n", metric)
+	if metric != "cosine" && metric != "dot_product" && metric != "euclidean" && metric != "hamming" {
+		fmt.Printf("Invalid metric: %s. Use: cosine, dot_product, euclidean or hamming\n", metric)
 		return
 	}
 	
@@ -220,6 +362,20 @@ func handleQuery(args []string) {
 		"metric":   metric,
 	}
 	
+	// the filter is a list of key=value pairs that must all match
+	if len(args) > 3 {
+		pairs, err := parseMetadata(args[3])
+		if err != nil {
+			fmt.Printf("Error parsing filter: %v\n", err)
+			return
+		}
+		conditions := []map[string]interface{}{}
+		for key, value := range pairs {
+			conditions = append(conditions, map[string]interface{}{"field": key, "eq": value})
+		}
+		reqData["filter"] = map[string]interface{}{"and": conditions}
+	}
+	
 	resp, err := makePostRequestWithResponse("/query", reqData)
 	if err != nil {
 		fmt.Printf("Error querying: %v\n", err)
@@ -242,7 +398,7 @@ func handleQuery(args []string) {
 	fmt.Printf("Found %d similar entries:\n", len(entries))
 	for i, entry := range entries {
 		entryMap := entry.(map[string]interface{})
-		fmt.Printf("%d. ID: %.0f\n", i+1, entryMap["id"])
+		printEntryId(i+1, entryMap)
 		fmt.Printf("   Vector: %v\n", entryMap["vector"])
 		fmt.Printf("   Metadata: %v\n", entryMap["metadata"])
 		fmt.Println()
@@ -284,14 +440,28 @@ func handleListEntries() {
 	fmt.Printf("Total entries: %d\n", len(entries))
 	for i, entry := range entries {
 		entryMap := entry.(map[string]interface{})
-		fmt.Printf("%d. ID: %.0f\n", i+1, entryMap["id"])
+		printEntryId(i+1, entryMap)
 		fmt.Printf("   Vector: %v\n", entryMap["vector"])
 		fmt.Printf("   Metadata: %v\n", entryMap["metadata"])
 		fmt.Println()
 	}
 }
 
-func parseVector(vectorStr string) ([]float64, error) {
+func printEntryId(position int, entryMap map[string]interface{}) {
+	if externalId, ok := entryMap["external_id"]; ok {
+		fmt.Printf("%d. ID: %.0f (%v)\n", position, entryMap["id"], externalId)
+		return
+	}
+	fmt.Printf("%d. ID: %.0f\n", position, entryMap["id"])
+}
+
+// parseVector reads [1.0,2.0,3.0] as a dense vector, and {3:0.5,17:1.2} as a
+// sparse one holding values in the given dimensions.
+func parseVector(vectorStr string) (interface{}, error) {
+	if strings.HasPrefix(vectorStr, "{") {
+		return parseSparseVector(vectorStr)
+	}
+
 	// Remove brackets and split by comma
 	vectorStr = strings.Trim(vectorStr, "[]")
 	parts := strings.Split(vectorStr, ",")
@@ -308,6 +478,28 @@ func parseVector(vectorStr string) ([]float64, error) {
 	return vector, nil
 }
 
+func parseSparseVector(vectorStr string) (map[string]interface{}, error) {
+	indices := []uint32{}
+	values := []float64{}
+	for _, pair := range strings.Split(strings.Trim(vectorStr, "{}"), ",") {
+		index, value, found := strings.Cut(pair, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid sparse value: %s, expected index:value", pair)
+		}
+		parsedIndex, err := strconv.ParseUint(strings.TrimSpace(index), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index: %s", index)
+		}
+		parsedValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float value: %s", value)
+		}
+		indices = append(indices, uint32(parsedIndex))
+		values = append(values, parsedValue)
+	}
+	return map[string]interface{}{"indices": indices, "values": values}, nil
+}
+
 func parseMetadata(metadataStr string) (map[string]string, error) {
 	metadata := make(map[string]string)
 	
@@ -329,12 +521,28 @@ func makePostRequest(endpoint string, data interface{}) error {
 }
 
 func makePostRequestWithResponse(endpoint string, data interface{}) ([]byte, error) {
-	jsonData, err := json.Marshal(data)
+	return makeRequest(http.MethodPost, endpoint, data)
+}
+
+func makeRequest(method string, endpoint string, data interface{}) ([]byte, error) {
+	var reqBody io.Reader
+	if data != nil {
+		jsonData, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+	
+	req, err := http.NewRequest(method, serverURL+endpoint, reqBody)
 	if err != nil {
 		return nil, err
 	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	
-	resp, err := http.Post(serverURL+endpoint, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -549,17 +757,25 @@ func parseCSVRecord(record []string) ([]float64, map[string]string, error) {
 
 func handleCreateDatabase(args []string) {
 	if len(args) < 2 {
-		fmt.Println("Usage: create-db <name> <algorithm>")
+		fmt.Println("Usage: create-db <name> <algorithm> [settings]")
 		fmt.Println("Example: create-db mydb bruteforce")
-		fmt.Println("Algorithms: bruteforce, hnsw")
+		fmt.Println("Example: create-db mydb hnsw M=32,efConstruction=400,efSearch=100,metric=euclidean")
+		fmt.Println("Example: create-db mydb ivf nlist=256,nprobe=16")
+		fmt.Println("Example: create-db mydb pq subspaces=96,keepOriginals=true")
+		fmt.Println("Example: create-db mydb lsh tables=16,bits=16,probes=4")
+		fmt.Println("Example: create-db mydb annoy trees=50,searchK=2000")
+		fmt.Println("Example: create-db mydb kdtree leafSize=8")
+		fmt.Println("Example: create-db mydb vamana maxDegree=64,searchList=100")
+		fmt.Println("Example: create-db mydb sparse metric=dot_product")
+		fmt.Println("Algorithms: bruteforce, hnsw, ivf, pq, lsh, annoy, kdtree, vamana, sparse")
 		return
 	}
 	
 	name := args[0]
 	algorithm := args[1]
 	
-	if algorithm != "bruteforce" && algorithm != "hnsw" {
-		fmt.Printf("Invalid algorithm: %s. Use: bruteforce or hnsw\n", algorithm)
+	if algorithm != "bruteforce" && algorithm != "hnsw" && algorithm != "ivf" && algorithm != "pq" && algorithm != "lsh" && algorithm != "annoy" && algorithm != "kdtree" && algorithm != "vamana" && algorithm != "sparse" {
+		fmt.Printf("Invalid algorithm: %s. Use: bruteforce, hnsw, ivf, pq, lsh, annoy, kdtree, vamana or sparse\n", algorithm)
 		return
 	}
 	
@@ -568,6 +784,15 @@ func handleCreateDatabase(args []string) {
 		"algorithm": algorithm,
 	}
 	
+	if len(args) > 2 {
+		settings, err := parseSettings(args[2])
+		if err != nil {
+			fmt.Printf("Error parsing settings: %v\n", err)
+			return
+		}
+		reqData["settings"] = settings
+	}
+	
 	err := makePostRequest("/databases", reqData)
 	if err != nil {
 		fmt.Printf("Error creating database: %v\n", err)
@@ -577,6 +802,29 @@ func handleCreateDatabase(args []string) {
 	fmt.Printf("Database '%s' created successfully with algorithm '%s'\n", name, algorithm)
 }
 
+// parseSettings reads key=value pairs like parseMetadata, but sends numeric
+// and boolean values as JSON numbers and booleans so the server can validate
+// them.
+func parseSettings(settingsStr string) (map[string]interface{}, error) {
+	pairs, err := parseMetadata(settingsStr)
+	if err != nil {
+		return nil, err
+	}
+	
+	settings := make(map[string]interface{}, len(pairs))
+	for key, value := range pairs {
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			settings[key] = number
+		} else if flag, err := strconv.ParseBool(value); err == nil {
+			settings[key] = flag
+		} else {
+			settings[key] = value
+		}
+	}
+	
+	return settings, nil
+}
+
 func handleUseDatabase(args []string) {
 	if len(args) < 1 {
 		fmt.Println("Usage: use-db <name>")
@@ -617,11 +865,15 @@ func handleListDatabases() {
 	
 	fmt.Printf("Available databases (%d):\n", len(databases))
 	for i, db := range databases {
-		dbName := db.(string)
-		fmt.Printf("%d. %s", i+1, dbName)
+		dbMap := db.(map[string]interface{})
+		dbName := dbMap["name"].(string)
+		fmt.Printf("%d. %s [%v]", i+1, dbName, dbMap["algorithm"])
 		if dbName == selectedDatabase {
 			fmt.Print(" (selected)")
 		}
 		fmt.Println()
+		if settings, ok := dbMap["settings"].(map[string]interface{}); ok && len(settings) > 0 {
+			fmt.Printf("   Settings: %v\n", settings)
+		}
 	}
 }
\ No newline at end of file
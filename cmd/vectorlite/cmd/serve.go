@@ -5,6 +5,7 @@ package cmd
 
 import (
 	"VectorLite/internal/api"
+	"VectorLite/internal/engine"
 
 	"github.com/spf13/cobra"
 )
@@ -15,7 +16,23 @@ var serveCmd = &cobra.Command{
 	Short: "Run a VectorLite instance",
 	Run: func(cmd *cobra.Command, args []string) {
 		port, _ := cmd.Flags().GetInt("port")
-		api.Serve(port)
+		dataDir, _ := cmd.Flags().GetString("data-dir")
+		walSync, _ := cmd.Flags().GetString("wal-sync")
+		walSyncInterval, _ := cmd.Flags().GetDuration("wal-sync-interval")
+		checkpointInterval, _ := cmd.Flags().GetDuration("checkpoint-interval")
+		checkpointWALSize, _ := cmd.Flags().GetInt64("checkpoint-wal-size")
+		api.Serve(api.Options{
+			Port:    port,
+			DataDir: dataDir,
+			WAL: engine.WALOptions{
+				Sync:         engine.SyncPolicy(walSync),
+				SyncInterval: walSyncInterval,
+			},
+			Checkpoint: engine.CheckpointOptions{
+				Interval: checkpointInterval,
+				WALSize:  checkpointWALSize,
+			},
+		})
 	},
 }
 
@@ -23,4 +40,9 @@ func init() {
 	rootCmd.AddCommand(serveCmd)
 
 	serveCmd.Flags().Int("port", 9123, "Port to run the server on")
+	serveCmd.Flags().String("data-dir", "", "Directory to persist databases in, nothing is persisted when empty")
+	serveCmd.Flags().String("wal-sync", string(engine.SyncBatched), "When to fsync the write-ahead log: always, batched or none")
+	serveCmd.Flags().Duration("wal-sync-interval", engine.DefaultSyncInterval, "How often the write-ahead log is fsynced with --wal-sync batched")
+	serveCmd.Flags().Duration("checkpoint-interval", engine.DefaultCheckpointInterval, "How often a snapshot is saved while the write-ahead log holds changes")
+	serveCmd.Flags().Int64("checkpoint-wal-size", engine.DefaultCheckpointWALSize, "Size in bytes of the write-ahead log past which a snapshot is saved early")
 }
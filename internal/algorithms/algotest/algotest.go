@@ -0,0 +1,134 @@
+/*
+Package algotest holds the fixtures the tests of the search algorithms share:
+random entries, the exact answers to queries, and the recall of an
+algorithm against them.
+*/
+package algotest
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/vector"
+	"math/rand"
+	"slices"
+	"sort"
+)
+
+// NormalEntries returns n entries with ids 1 to n, whose dims values are
+// drawn from the standard normal distribution.
+func NormalEntries(rng *rand.Rand, n int, dims int) []algorithms.Entry {
+	return entries(n, dims, rng.NormFloat64)
+}
+
+// UniformEntries returns n entries with ids 1 to n, whose dims values are
+// drawn uniformly in [-1, 1).
+func UniformEntries(rng *rand.Rand, n int, dims int) []algorithms.Entry {
+	return entries(n, dims, func() float64 { return rng.Float64()*2 - 1 })
+}
+
+func entries(n int, dims int, value func() float64) []algorithms.Entry {
+	entries := make([]algorithms.Entry, n)
+	for i := range entries {
+		values := make([]float64, dims)
+		for d := range values {
+			values[d] = value()
+		}
+		entries[i] = algorithms.Entry{Vector: vector.Vector{Values: values}, Id: i + 1}
+	}
+	return entries
+}
+
+// ClusteredEntries spreads n entries around clusters centres drawn by
+// UniformEntries, moved from them by normal noise of deviation spread.
+func ClusteredEntries(rng *rand.Rand, n int, dims int, clusters int, spread float64) []algorithms.Entry {
+	centres := UniformEntries(rng, clusters, dims)
+	entries := make([]algorithms.Entry, n)
+	for i := range entries {
+		centre := centres[rng.Intn(clusters)].Vector.Values
+		values := make([]float64, dims)
+		for d := range values {
+			values[d] = centre[d] + rng.NormFloat64()*spread
+		}
+		entries[i] = algorithms.Entry{Vector: vector.Vector{Values: values}, Id: i + 1}
+	}
+	return entries
+}
+
+// SparseEntries returns n sparse entries of about nonZero dimensions among
+// dims, the low dimensions more frequent as the common terms of a vocabulary
+// are. Weights are positive, except a few.
+func SparseEntries(rng *rand.Rand, n int, dims int, nonZero int) []algorithms.Entry {
+	entries := make([]algorithms.Entry, n)
+	for i := range entries {
+		weights := map[uint32]float64{}
+		for len(weights) < nonZero {
+			index := uint32(rng.ExpFloat64() * float64(dims) / 4)
+			if int(index) >= dims {
+				continue
+			}
+			weights[index] = rng.Float64() * 3
+			if rng.Intn(20) == 0 {
+				weights[index] = -weights[index]
+			}
+		}
+		indices := make([]uint32, 0, len(weights))
+		values := make([]float64, 0, len(weights))
+		for index, weight := range weights {
+			indices = append(indices, index)
+			values = append(values, weight)
+		}
+		v, _ := vector.NewSparseVector(indices, values)
+		entries[i] = algorithms.Entry{Vector: *v, Id: i + 1}
+	}
+	return entries
+}
+
+// Exact returns the k entries closest to query, closest first. Entries at
+// the same distance keep their order.
+func Exact(entries []algorithms.Entry, query *vector.Vector, k int, metric string) []algorithms.Entry {
+	return ExactWith(entries, query, k, metric, algorithms.QueryOptions{})
+}
+
+// ExactWith is Exact over the entries the filter of options accepts.
+func ExactWith(entries []algorithms.Entry, query *vector.Vector, k int, metric string, options algorithms.QueryOptions) []algorithms.Entry {
+	scores := make(map[int]float64, len(entries))
+	accepted := []algorithms.Entry{}
+	for _, entry := range entries {
+		if options.Accepts(entry) {
+			scores[entry.Id] = query.Distance_score(&entry.Vector, metric)
+			accepted = append(accepted, entry)
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return scores[accepted[i].Id] < scores[accepted[j].Id]
+	})
+	return accepted[:min(k, len(accepted))]
+}
+
+// Neighbours returns the exact k nearest neighbours of every query.
+func Neighbours(entries []algorithms.Entry, queries []algorithms.Entry, k int, metric string) [][]algorithms.Entry {
+	truth := make([][]algorithms.Entry, len(queries))
+	for i, query := range queries {
+		truth[i] = Exact(entries, &query.Vector, k, metric)
+	}
+	return truth
+}
+
+// Recall is the share of the true neighbours of every query, truth, that alg
+// returns when asked for as many.
+func Recall(alg algorithms.SearchAlgorithm, queries []algorithms.Entry, truth [][]algorithms.Entry, metric string) float64 {
+	return RecallWith(alg, queries, truth, metric, algorithms.QueryOptions{})
+}
+
+// RecallWith is Recall with queries tuned by options.
+func RecallWith(alg algorithms.SearchAlgorithm, queries []algorithms.Entry, truth [][]algorithms.Entry, metric string, options algorithms.QueryOptions) float64 {
+	hits, total := 0, 0
+	for i, query := range queries {
+		for _, entry := range alg.QueryWithOptions(&query.Vector, len(truth[i]), metric, options) {
+			if slices.ContainsFunc(truth[i], func(expected algorithms.Entry) bool { return expected.Id == entry.Id }) {
+				hits++
+			}
+		}
+		total += len(truth[i])
+	}
+	return float64(hits) / float64(total)
+}
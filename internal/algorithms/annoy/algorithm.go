@@ -0,0 +1,326 @@
+package annoy
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/kmeans"
+	"VectorLite/internal/vector"
+	"cmp"
+	"container/heap"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+const (
+	DefaultTrees    = 10
+	DefaultLeafSize = 16
+)
+
+// DefaultMetric is the metric the trees are split for when the configuration
+// doesn't name one.
+const DefaultMetric = "cosine"
+
+/*
+Algorithm is a forest of random projection trees, as built by Annoy. Every
+tree cuts space in two at every node, with the hyperplane between two means
+of the entries below it, until leaves hold at most LeafSize entries. Trees
+differ by their random choices, an entry close to the query but cut away from
+it in one tree likely shares a leaf with it in another.
+
+A query walks all trees at once with a single priority queue of nodes, most
+promising first: a node is as promising as the smallest margin of the query
+to the hyperplanes on its path, a side the query is not on being penalized
+by the margin. Leaves are opened until SearchK candidates were gathered, and
+the candidates are ranked by their exact distance. More trees and a larger
+SearchK find more neighbours, at the cost of memory and latency.
+
+The forest is built in bulk by AddEntries, in parallel over the trees, and
+rebuilt whenever a batch at least doubles the index. Entries added one at a
+time go down every tree to a leaf, which is split again when it grows past
+LeafSize: the forest stays usable but its splits follow the entries it was
+last built from, so databases written in place are best rebuilt now and
+then. Memory is predictable: about one node per LeafSize/2 entries per tree,
+every internal node holding a hyperplane.
+
+Splits are drawn from Seed, the tree at index t from Seed+t.
+*/
+type Algorithm struct {
+	// trees is nil until the first entry arrives
+	trees    []*tree
+	byId     map[int]*item
+	dims     int
+	nTrees   int
+	leafSize int
+	searchK  int
+	metric   string
+	seed     int64
+}
+
+// Config holds the parameters a forest is built with. The metric decides the
+// splits.
+type Config struct {
+	// Trees is the number of trees of the forest.
+	Trees int
+	// LeafSize is the most entries a leaf holds before it is split.
+	LeafSize int
+	// SearchK is the number of candidates a query gathers before it stops
+	// opening leaves, 0 gathers k times the number of trees.
+	SearchK int
+	Metric  string
+	// Seed feeds the random sources the trees are split with.
+	Seed int64
+}
+
+// item is an entry stored in the forest, in the leaf leaves[t] of the tree at
+// index t.
+type item struct {
+	entry  algorithms.Entry
+	leaves []*node
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Trees:    DefaultTrees,
+		LeafSize: DefaultLeafSize,
+		Metric:   DefaultMetric,
+	}
+}
+
+func New() *Algorithm {
+	return NewWithConfig(DefaultConfig())
+}
+
+func NewWithConfig(config Config) *Algorithm {
+	if config.Trees < 1 {
+		config.Trees = DefaultTrees
+	}
+	if config.LeafSize < 1 {
+		config.LeafSize = DefaultLeafSize
+	}
+	if config.SearchK < 0 {
+		config.SearchK = 0
+	}
+	if config.Metric == "" {
+		config.Metric = DefaultMetric
+	}
+	return &Algorithm{
+		byId:     make(map[int]*item),
+		nTrees:   config.Trees,
+		leafSize: config.LeafSize,
+		searchK:  config.SearchK,
+		metric:   config.Metric,
+		seed:     config.Seed,
+	}
+}
+
+// Metric returns the distance metric the trees are split for.
+func (a *Algorithm) Metric() string {
+	return a.metric
+}
+
+// SetSearchK changes the number of candidates a query gathers, 0 gathers k
+// times the number of trees. Higher values find more neighbours at the cost
+// of latency.
+func (a *Algorithm) SetSearchK(searchK int) {
+	a.searchK = max(searchK, 0)
+}
+
+// angular reports whether the metric only compares directions.
+func (a *Algorithm) angular() bool {
+	return a.metric != "euclidean"
+}
+
+// rebuild builds every tree again from all the entries of the index, sorted
+// by id so that a seed always gives the same forest.
+func (a *Algorithm) rebuild() {
+	items := make([]*item, 0, len(a.byId))
+	for _, it := range a.byId {
+		it.leaves = make([]*node, a.nTrees)
+		items = append(items, it)
+	}
+	slices.SortFunc(items, func(it1 *item, it2 *item) int {
+		return cmp.Compare(it1.entry.Id, it2.entry.Id)
+	})
+
+	a.trees = make([]*tree, a.nTrees)
+	kmeans.Parallel(a.nTrees, func(t int) {
+		rng := rand.New(rand.NewSource(a.seed + int64(t)))
+		// every tree partitions its own copy of the items
+		a.trees[t] = &tree{root: a.build(slices.Clone(items), t, rng), rng: rng}
+	})
+}
+
+func (a *Algorithm) AddEntry(entry algorithms.Entry) {
+	a.AddEntries([]algorithms.Entry{entry})
+}
+
+// AddEntries adds a batch of entries. A batch at least as large as the index
+// rebuilds the forest, smaller ones are inserted in the trees as they are.
+func (a *Algorithm) AddEntries(entries []algorithms.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	if a.trees == nil {
+		a.dims = len(entries[0].Vector.Values)
+	}
+
+	rebuild := len(entries) >= len(a.byId)
+	for _, entry := range entries {
+		it := &item{entry: entry, leaves: make([]*node, a.nTrees)}
+		a.byId[entry.Id] = it
+		if !rebuild {
+			a.store(it)
+		}
+	}
+	if rebuild {
+		a.rebuild()
+	}
+}
+
+// store inserts it in every tree.
+func (a *Algorithm) store(it *item) {
+	for t, tr := range a.trees {
+		a.insert(tr, t, it)
+	}
+	a.byId[it.entry.Id] = it
+}
+
+// unstore takes it out of every tree.
+func (a *Algorithm) unstore(it *item) {
+	for t := range a.trees {
+		remove(t, it)
+	}
+	delete(a.byId, it.entry.Id)
+}
+
+func (a *Algorithm) GetEntry(id int) (algorithms.Entry, bool) {
+	it, exists := a.byId[id]
+	if !exists {
+		return algorithms.Entry{}, false
+	}
+	return it.entry, true
+}
+
+// UpdateEntry swaps the stored entry in place when only its metadata
+// changed. A new vector goes down the trees again, to new leaves.
+func (a *Algorithm) UpdateEntry(entry algorithms.Entry) bool {
+	it, exists := a.byId[entry.Id]
+	if !exists {
+		return false
+	}
+
+	if it.entry.Vector.Equal(&entry.Vector) {
+		it.entry = entry
+		return true
+	}
+
+	a.unstore(it)
+	a.store(&item{entry: entry, leaves: make([]*node, a.nTrees)})
+	return true
+}
+
+func (a *Algorithm) RemoveEntry(id int) bool {
+	it, exists := a.byId[id]
+	if !exists {
+		return false
+	}
+	a.unstore(it)
+	return true
+}
+
+// ListEntries returns the entries sorted by id.
+func (a *Algorithm) ListEntries() []algorithms.Entry {
+	entries := make([]algorithms.Entry, 0, len(a.byId))
+	for _, it := range a.byId {
+		entries = append(entries, it.entry)
+	}
+	slices.SortFunc(entries, func(e1 algorithms.Entry, e2 algorithms.Entry) int {
+		return cmp.Compare(e1.Id, e2.Id)
+	})
+	return entries
+}
+
+func (a *Algorithm) Query(queryVector *vector.Vector, k int, metric string) []algorithms.Entry {
+	return a.QueryWithOptions(queryVector, k, metric, algorithms.QueryOptions{})
+}
+
+/*
+QueryWithOptions opens the leaves of all trees most promising first, until
+searchK candidates accepted by the filter were gathered or every leaf was
+opened, and returns the k candidates closest with the requested metric,
+closest first. Entries in no opened leaf are not found: a query may return
+fewer than k entries when searchK is below k.
+*/
+func (a *Algorithm) QueryWithOptions(queryVector *vector.Vector, k int, metric string, options algorithms.QueryOptions) []algorithms.Entry {
+	if k <= 0 {
+		return []algorithms.Entry{}
+	}
+	searchK := a.searchK
+	if searchK == 0 {
+		searchK = k * len(a.trees)
+	}
+
+	queue := &nodeQueue{}
+	for _, tr := range a.trees {
+		heap.Push(queue, queued{node: tr.root, priority: math.Inf(1)})
+	}
+
+	seen := make(map[*item]bool)
+	type candidate struct {
+		entry algorithms.Entry
+		score float64
+	}
+	candidates := []candidate{}
+	for queue.Len() > 0 && len(candidates) < searchK {
+		next := heap.Pop(queue).(queued)
+		if n := next.node; !n.leaf() {
+			margin := n.margin(queryVector.Values)
+			heap.Push(queue, queued{node: n.right, priority: min(next.priority, margin)})
+			heap.Push(queue, queued{node: n.left, priority: min(next.priority, -margin)})
+			continue
+		}
+		for _, it := range next.node.items {
+			if seen[it] {
+				continue
+			}
+			seen[it] = true
+			if options.Accepts(it.entry) {
+				candidates = append(candidates, candidate{entry: it.entry, score: queryVector.Distance_score(&it.entry.Vector, metric)})
+			}
+		}
+	}
+
+	slices.SortStableFunc(candidates, func(c1 candidate, c2 candidate) int {
+		return cmp.Or(cmp.Compare(c1.score, c2.score), cmp.Compare(c1.entry.Id, c2.entry.Id))
+	})
+	entries := make([]algorithms.Entry, min(k, len(candidates)))
+	for i := range entries {
+		entries[i] = candidates[i].entry
+	}
+	return entries
+}
+
+// queued is a node waiting in the queue of a query, with the smallest margin
+// of the query on its path.
+type queued struct {
+	node     *node
+	priority float64
+}
+
+// nodeQueue is a max-heap on priority.
+type nodeQueue []queued
+
+func (q nodeQueue) Len() int           { return len(q) }
+func (q nodeQueue) Less(i, j int) bool { return q[i].priority > q[j].priority }
+func (q nodeQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *nodeQueue) Push(x any) {
+	*q = append(*q, x.(queued))
+}
+
+func (q *nodeQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
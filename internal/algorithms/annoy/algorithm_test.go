@@ -0,0 +1,169 @@
+package annoy
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/vector"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlgorithm_SearchK(t *testing.T) {
+	for _, metric := range []string{"cosine", "euclidean"} {
+		rng := rand.New(rand.NewSource(1))
+		entries := algotest.NormalEntries(rng, 3000, 16)
+		queries := algotest.NormalEntries(rng, 50, 16)
+		truth := algotest.Neighbours(entries, queries, 10, metric)
+		alg := NewWithConfig(Config{Metric: metric, Seed: 1})
+		alg.AddEntries(entries)
+
+		base := algotest.Recall(alg, queries, truth, metric)
+		alg.SetSearchK(1000)
+		more := algotest.Recall(alg, queries, truth, metric)
+		t.Logf("%s: recall %.3f, with searchK 1000 %.3f", metric, base, more)
+		assert.Greater(t, more, base, "%s: a larger budget finds more neighbours", metric)
+		assert.GreaterOrEqual(t, more, 0.8, metric)
+	}
+}
+
+func TestAlgorithm_Trees(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	entries := algotest.NormalEntries(rng, 2000, 16)
+	queries := algotest.NormalEntries(rng, 50, 16)
+	truth := algotest.Neighbours(entries, queries, 10, "cosine")
+
+	few := NewWithConfig(Config{Trees: 2, SearchK: 400, Seed: 2})
+	few.AddEntries(entries)
+	many := NewWithConfig(Config{Trees: 20, SearchK: 400, Seed: 2})
+	many.AddEntries(entries)
+
+	r1, r2 := algotest.Recall(few, queries, truth, "cosine"), algotest.Recall(many, queries, truth, "cosine")
+	t.Logf("recall with 2 trees %.3f, with 20 trees %.3f", r1, r2)
+	assert.Greater(t, r2, r1, "the same budget spread over more trees finds more neighbours")
+}
+
+func TestAlgorithm_Query_Exact(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	entries := algotest.NormalEntries(rng, 300, 8)
+	alg := NewWithConfig(Config{SearchK: len(entries), Seed: 3})
+	alg.AddEntries(entries)
+
+	for _, metric := range []string{"cosine", "euclidean", "dot_product"} {
+		query := algotest.NormalEntries(rng, 1, 8)[0].Vector
+		assert.Equal(t, algotest.Exact(entries, &query, 10, metric), alg.Query(&query, 10, metric),
+			"%s: a budget of every entry opens every leaf, candidates are ranked exactly", metric)
+	}
+}
+
+func TestAlgorithm_Seed(t *testing.T) {
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(4)), 500, 8)
+	query := entries[0].Vector
+
+	a1 := NewWithConfig(Config{Seed: 4})
+	a2 := NewWithConfig(Config{Seed: 4})
+	a1.AddEntries(entries)
+	// the same entries in another order build the same forest
+	reversed := slices.Clone(entries)
+	slices.Reverse(reversed)
+	a2.AddEntries(reversed)
+	assert.Equal(t, a1.Query(&query, 10, "cosine"), a2.Query(&query, 10, "cosine"))
+	for index := range a1.trees {
+		assert.Equal(t, a1.trees[index].root.normal, a2.trees[index].root.normal, "tree %d", index)
+	}
+}
+
+func TestAlgorithm_AddEntry(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	entries := algotest.NormalEntries(rng, 1500, 16)
+	queries := algotest.NormalEntries(rng, 50, 16)
+	truth := algotest.Neighbours(entries, queries, 10, "cosine")
+
+	alg := NewWithConfig(Config{LeafSize: 8, Seed: 5})
+	alg.AddEntries(entries[:500])
+	for _, entry := range entries[500:] {
+		alg.AddEntry(entry)
+	}
+	assertForest(t, alg)
+
+	bulk := NewWithConfig(Config{LeafSize: 8, Seed: 5})
+	bulk.AddEntries(entries)
+	inserted, built := algotest.Recall(alg, queries, truth, "cosine"), algotest.Recall(bulk, queries, truth, "cosine")
+	t.Logf("recall of inserted entries %.3f, built in bulk %.3f", inserted, built)
+	assert.GreaterOrEqual(t, inserted, built-0.1, "inserted entries split leaves as they grow")
+
+	// a batch at least as large as the index rebuilds the forest
+	roots := alg.trees[0].root
+	alg.AddEntries(nil)
+	assert.Same(t, roots, alg.trees[0].root, "empty batches change nothing")
+	more := algotest.NormalEntries(rng, 1500, 16)
+	for i := range more {
+		more[i].Id += 1500
+	}
+	alg.AddEntries(more)
+	assert.NotSame(t, roots, alg.trees[0].root)
+	assertForest(t, alg)
+}
+
+func TestAlgorithm_Query_Filter(t *testing.T) {
+	alg := NewWithConfig(Config{Seed: 6})
+	rng := rand.New(rand.NewSource(6))
+	alg.AddEntries(algotest.NormalEntries(rng, 300, 8))
+
+	options := algorithms.QueryOptions{Filter: func(entry algorithms.Entry) bool {
+		return entry.Id%30 == 0
+	}}
+	results := alg.QueryWithOptions(&algotest.NormalEntries(rng, 1, 8)[0].Vector, 20, "cosine", options)
+	require.Len(t, results, 10, "leaves are opened until enough entries pass the filter")
+	for _, entry := range results {
+		assert.Zero(t, entry.Id%30)
+	}
+}
+
+func TestAlgorithm_Query_Empty(t *testing.T) {
+	alg := New()
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 5, "cosine"))
+	alg.AddEntry(algorithms.Entry{Vector: *vector.NewVector(1, 0), Id: 1})
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 0, "cosine"))
+	assert.Len(t, alg.Query(vector.NewVector(1, 0), 5, "cosine"), 1)
+}
+
+func TestAlgorithm_RemoveUpdate(t *testing.T) {
+	alg := NewWithConfig(Config{Metric: "euclidean", Seed: 7})
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(7)), 100, 4)
+	alg.AddEntries(entries)
+
+	entry := entries[4]
+	entry.Metadata = map[string]string{"name": "renamed"}
+	it := alg.byId[5]
+	assert.True(t, alg.UpdateEntry(entry))
+	assert.Same(t, it, alg.byId[5], "metadata changes keep the entry in its leaves")
+	got, _ := alg.GetEntry(5)
+	assert.Equal(t, entry, got)
+
+	moved := algorithms.Entry{Vector: *vector.NewVector(9, 9, 9, 9), Id: 5}
+	assert.True(t, alg.UpdateEntry(moved))
+	assert.Equal(t, moved, alg.Query(&moved.Vector, 1, "euclidean")[0])
+	assertForest(t, alg)
+
+	assert.True(t, alg.RemoveEntry(5))
+	assert.False(t, alg.RemoveEntry(5))
+	assert.False(t, alg.UpdateEntry(moved))
+	alg.SetSearchK(100)
+	for _, entry := range alg.Query(&moved.Vector, 100, "euclidean") {
+		assert.NotEqual(t, 5, entry.Id)
+	}
+	assertForest(t, alg)
+}
+
+func TestAlgorithm_ListEntries(t *testing.T) {
+	alg := New()
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(8)), 50, 4)
+	for i := len(entries) - 1; i >= 0; i-- {
+		alg.AddEntry(entries[i])
+	}
+	assert.Equal(t, entries, alg.ListEntries(), "sorted by id")
+}
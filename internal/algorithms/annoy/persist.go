@@ -0,0 +1,151 @@
+package annoy
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/codec"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+const indexVersion = 1
+
+var ErrCorruptIndex = errors.New("corrupt annoy index")
+
+/*
+SaveIndex writes the trees, every node in depth-first order: the hyperplanes
+of internal nodes and the ids of the entries of leaves. A reload gets the
+same forest without building it again. An empty index is written without
+trees.
+
+Entries are not written, they are saved by the caller and handed back to
+LoadIndex.
+*/
+func (a *Algorithm) SaveIndex(w io.Writer) error {
+	out := codec.NewWriter(w)
+	out.Uint32(indexVersion)
+	out.Uint32(uint32(a.dims))
+	out.Uint32(uint32(len(a.trees)))
+	for _, tr := range a.trees {
+		saveNode(out, tr.root)
+	}
+	return out.Err()
+}
+
+func saveNode(out *codec.Writer, n *node) {
+	out.Bool(n.leaf())
+	if n.leaf() {
+		out.Uint32(uint32(len(n.items)))
+		for _, it := range n.items {
+			out.Int64(int64(it.entry.Id))
+		}
+		return
+	}
+	out.Bool(n.normal != nil)
+	if n.normal != nil {
+		out.Float64s(n.normal)
+		out.Float64(n.offset)
+	}
+	saveNode(out, n.left)
+	saveNode(out, n.right)
+}
+
+// LoadIndex restores an index written by SaveIndex into an empty algorithm
+// built with the same settings. entries must hold exactly the entries of the
+// saved index. Leaves split after the reload are split with random sources
+// drawn from the seed again.
+func (a *Algorithm) LoadIndex(r io.Reader, entries []algorithms.Entry) error {
+	if len(a.byId) > 0 {
+		return fmt.Errorf("%w: index is not empty", ErrCorruptIndex)
+	}
+
+	in := codec.NewReader(r)
+	if version := in.Uint32(); in.Err() == nil && version != indexVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrCorruptIndex, version)
+	}
+	dims := in.Length()
+	count := in.Length()
+	if err := in.Err(); err != nil {
+		return err
+	}
+	if count == 0 {
+		if len(entries) > 0 {
+			return fmt.Errorf("%w: %d entries without trees", ErrCorruptIndex, len(entries))
+		}
+		return nil
+	}
+	if count != a.nTrees {
+		return fmt.Errorf("%w: %d trees, expected %d", ErrCorruptIndex, count, a.nTrees)
+	}
+
+	items := make(map[int]*item, len(entries))
+	for _, entry := range entries {
+		items[entry.Id] = &item{entry: entry, leaves: make([]*node, count)}
+	}
+	trees := make([]*tree, count)
+	for t := range trees {
+		loader := nodeLoader{in: in, items: items, tree: t}
+		root, err := loader.load()
+		if err != nil {
+			return err
+		}
+		if loader.found != len(items) {
+			return fmt.Errorf("%w: tree %d holds %d of %d entries", ErrCorruptIndex, t, loader.found, len(items))
+		}
+		trees[t] = &tree{root: root, rng: rand.New(rand.NewSource(a.seed + int64(t)))}
+	}
+
+	a.trees = trees
+	a.byId = items
+	a.dims = dims
+	return nil
+}
+
+// nodeLoader reads the nodes of the tree at index tree, placing the items in
+// their leaves.
+type nodeLoader struct {
+	in    *codec.Reader
+	items map[int]*item
+	tree  int
+	found int
+}
+
+func (l *nodeLoader) load() (*node, error) {
+	leaf := l.in.Bool()
+	if err := l.in.Err(); err != nil {
+		return nil, err
+	}
+
+	n := &node{}
+	if leaf {
+		length := l.in.Length()
+		for i := 0; i < length && l.in.Err() == nil; i++ {
+			id := int(l.in.Int64())
+			it, exists := l.items[id]
+			if l.in.Err() != nil {
+				break
+			}
+			if !exists || it.leaves[l.tree] != nil {
+				return nil, fmt.Errorf("%w: tree %d holds unknown or repeated entry %d", ErrCorruptIndex, l.tree, id)
+			}
+			it.leaves[l.tree] = n
+			n.items = append(n.items, it)
+			l.found++
+		}
+		return n, l.in.Err()
+	}
+
+	if l.in.Bool() {
+		n.normal = l.in.Float64s()
+		n.offset = l.in.Float64()
+	}
+	var err error
+	if n.left, err = l.load(); err != nil {
+		return nil, err
+	}
+	if n.right, err = l.load(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
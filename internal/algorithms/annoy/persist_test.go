@@ -0,0 +1,89 @@
+package annoy
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/codec"
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlgorithm_SaveLoadIndex(t *testing.T) {
+	config := Config{Trees: 4, LeafSize: 8, Metric: "euclidean", Seed: 9}
+	rng := rand.New(rand.NewSource(9))
+	alg := NewWithConfig(config)
+	alg.AddEntries(algotest.NormalEntries(rng, 300, 8))
+	alg.RemoveEntry(1)
+
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+
+	loaded := NewWithConfig(config)
+	require.NoError(t, loaded.LoadIndex(&buf, alg.ListEntries()))
+	assertForest(t, loaded)
+	assert.Equal(t, alg.ListEntries(), loaded.ListEntries())
+	for index := range alg.trees {
+		assert.Equal(t, depth(alg.trees[index].root), depth(loaded.trees[index].root), "tree %d", index)
+		assert.Equal(t, alg.trees[index].root.normal, loaded.trees[index].root.normal, "tree %d", index)
+	}
+	for _, query := range algotest.NormalEntries(rng, 20, 8) {
+		assert.Equal(t, alg.Query(&query.Vector, 10, "euclidean"), loaded.Query(&query.Vector, 10, "euclidean"))
+	}
+
+	// the loaded forest takes new entries
+	more := algotest.NormalEntries(rng, 50, 8)
+	for i := range more {
+		more[i].Id += 300
+		loaded.AddEntry(more[i])
+	}
+	assertForest(t, loaded)
+}
+
+func TestAlgorithm_SaveLoadIndex_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, New().SaveIndex(&buf))
+
+	loaded := New()
+	require.NoError(t, loaded.LoadIndex(&buf, nil))
+	assert.Nil(t, loaded.trees, "trees are built for the first entries")
+	loaded.AddEntries(algotest.NormalEntries(rand.New(rand.NewSource(10)), 20, 2))
+	assertForest(t, loaded)
+}
+
+func TestAlgorithm_LoadIndex_Corrupt(t *testing.T) {
+	alg := New()
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(11)), 10, 2)
+	alg.AddEntries(entries)
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+	saved := buf.Bytes()
+
+	var empty bytes.Buffer
+	require.NoError(t, New().SaveIndex(&empty))
+	err := New().LoadIndex(&empty, entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex, "entries without trees")
+
+	err = New().LoadIndex(bytes.NewReader(saved), entries[1:])
+	assert.ErrorIs(t, err, ErrCorruptIndex, "unknown entry")
+
+	err = New().LoadIndex(bytes.NewReader(saved), append(entries, algorithms.Entry{Id: 11}))
+	assert.ErrorIs(t, err, ErrCorruptIndex, "entry missing from the trees")
+
+	err = NewWithConfig(Config{Trees: 3}).LoadIndex(bytes.NewReader(saved), entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex, "other number of trees")
+
+	err = New().LoadIndex(bytes.NewReader(saved[:len(saved)-1]), entries)
+	assert.Error(t, err)
+
+	var version bytes.Buffer
+	codec.NewWriter(&version).Uint32(indexVersion + 1)
+	err = New().LoadIndex(&version, entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex)
+
+	err = alg.LoadIndex(bytes.NewReader(saved), entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex, "the index is not empty")
+}
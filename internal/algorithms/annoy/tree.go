@@ -0,0 +1,223 @@
+package annoy
+
+import (
+	"VectorLite/internal/kmeans"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+// splitIterations is the number of points the two means of a split are
+// moved towards, as Annoy does.
+const splitIterations = 200
+
+// splitAttempts is the number of two-means splits tried before a node falls
+// back to a random split.
+const splitAttempts = 3
+
+/*
+node is a node of a random projection tree. Internal nodes cut space with
+the hyperplane of unit normal normal and offset offset: vectors of positive
+margin go right, the others left. Leaves hold up to leafSize items.
+
+A node whose items couldn't be told apart by a hyperplane has no normal, its
+items were split at random and every vector has a margin of 0.
+*/
+type node struct {
+	normal      []float64
+	offset      float64
+	left, right *node
+	items       []*item
+}
+
+func (n *node) leaf() bool {
+	return n.left == nil
+}
+
+// margin returns the signed distance of values to the hyperplane of n.
+// Values missing from values count as 0, extra ones are ignored.
+func (n *node) margin(values []float64) float64 {
+	if n.normal == nil {
+		return 0
+	}
+	return dot(n.normal, values) + n.offset
+}
+
+// tree is one tree of the forest, with the random source its nodes are split
+// with.
+type tree struct {
+	root *node
+	rng  *rand.Rand
+}
+
+// build returns the root of a tree holding items, the tree at index t of the
+// forest: the leaves of the items at index t point in it.
+func (a *Algorithm) build(items []*item, t int, rng *rand.Rand) *node {
+	if len(items) <= a.leafSize {
+		n := &node{items: items}
+		for _, it := range items {
+			it.leaves[t] = n
+		}
+		return n
+	}
+
+	n := a.split(items, rng)
+	left, right := []*item{}, []*item{}
+	for _, it := range items {
+		if n.side(it.entry.Vector.Values, rng) {
+			right = append(right, it)
+		} else {
+			left = append(left, it)
+		}
+	}
+	n.left = a.build(left, t, rng)
+	n.right = a.build(right, t, rng)
+	return n
+}
+
+/*
+split returns an internal node cutting items in two sides of similar sizes.
+The hyperplane is the one equidistant from two means of items, found as
+Annoy does: two items picked at random are moved towards the items closest
+to them. Angular metrics compare directions only, their means are normalized
+and their hyperplane goes through the origin.
+
+Splits leaving fewer than one item in twenty on a side are tried again, and
+items are split at random when no hyperplane tells them apart, for instance
+when they are all equal.
+*/
+func (a *Algorithm) split(items []*item, rng *rand.Rand) *node {
+	for attempt := 0; attempt < splitAttempts; attempt++ {
+		n := a.twoMeans(items, rng)
+		if n.normal == nil {
+			break
+		}
+		right := 0
+		for _, it := range items {
+			if n.margin(it.entry.Vector.Values) > 0 {
+				right++
+			}
+		}
+		if smaller := min(right, len(items)-right); smaller > 0 && smaller*20 >= len(items) {
+			return n
+		}
+	}
+	return &node{}
+}
+
+// twoMeans returns the node splitting items between two means, without a
+// normal when the means are equal.
+func (a *Algorithm) twoMeans(items []*item, rng *rand.Rand) *node {
+	i := rng.Intn(len(items))
+	j := rng.Intn(len(items) - 1)
+	if j >= i {
+		j++
+	}
+	p := a.point(items[i])
+	q := a.point(items[j])
+	pCount, qCount := 1.0, 1.0
+	for iteration := 0; iteration < splitIterations; iteration++ {
+		k := a.point(items[rng.Intn(len(items))])
+		pDistance := pCount * kmeans.SquaredDistance(p, k)
+		qDistance := qCount * kmeans.SquaredDistance(q, k)
+		if pDistance < qDistance {
+			moveTowards(p, k, pCount)
+			pCount++
+		} else if qDistance < pDistance {
+			moveTowards(q, k, qCount)
+			qCount++
+		}
+		if a.angular() {
+			p = kmeans.Normalize(p)
+			q = kmeans.Normalize(q)
+		}
+	}
+
+	normal := make([]float64, len(p))
+	for d := range normal {
+		normal[d] = p[d] - q[d]
+	}
+	length := math.Sqrt(dot(normal, normal))
+	if length == 0 {
+		return &node{}
+	}
+	for d := range normal {
+		normal[d] /= length
+	}
+
+	n := &node{normal: normal}
+	if !a.angular() {
+		// the hyperplane goes through the middle of p and q
+		for d := range normal {
+			n.offset -= normal[d] * (p[d] + q[d]) / 2
+		}
+	}
+	return n
+}
+
+// moveTowards moves the mean of count points to include point.
+func moveTowards(mean []float64, point []float64, count float64) {
+	for d := range mean {
+		mean[d] = (mean[d]*count + point[d]) / (count + 1)
+	}
+}
+
+// point returns a copy of the vector of it, normalized for angular metrics.
+func (a *Algorithm) point(it *item) []float64 {
+	if a.angular() {
+		return kmeans.Normalize(it.entry.Vector.Values)
+	}
+	return slices.Clone(it.entry.Vector.Values)
+}
+
+// side reports whether values go right of n, picking at random on the
+// hyperplane.
+func (n *node) side(values []float64, rng *rand.Rand) bool {
+	margin := n.margin(values)
+	if margin == 0 {
+		return rng.Intn(2) == 0
+	}
+	return margin > 0
+}
+
+// insert adds it to the leaf of values in the tree at index t, splitting the
+// leaf when it grows past leafSize.
+func (a *Algorithm) insert(tr *tree, t int, it *item) {
+	n := tr.root
+	for !n.leaf() {
+		if n.side(it.entry.Vector.Values, tr.rng) {
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+	n.items = append(n.items, it)
+	it.leaves[t] = n
+	if len(n.items) > a.leafSize {
+		// the leaf becomes the root of the subtree of its items
+		*n = *a.build(n.items, t, tr.rng)
+	}
+}
+
+// remove takes it out of its leaf in the tree at index t. Leaves left empty
+// stay in the tree.
+func remove(t int, it *item) {
+	n := it.leaves[t]
+	for i, other := range n.items {
+		if other == it {
+			n.items[i] = n.items[len(n.items)-1]
+			n.items[len(n.items)-1] = nil
+			n.items = n.items[:len(n.items)-1]
+			return
+		}
+	}
+}
+
+// dot returns the dot product of v1 and v2.
+func dot(v1 []float64, v2 []float64) float64 {
+	product := 0.0
+	for d, value := range v1 {
+		product += value * v2[d]
+	}
+	return product
+}
@@ -0,0 +1,93 @@
+package annoy
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/vector"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertForest checks that every tree holds every entry once, in a leaf of
+// at most leafSize entries that its item points to.
+func assertForest(t *testing.T, alg *Algorithm) {
+	t.Helper()
+	require.Len(t, alg.trees, alg.nTrees)
+	for index, tr := range alg.trees {
+		held := 0
+		var walk func(n *node)
+		walk = func(n *node) {
+			if !n.leaf() {
+				walk(n.left)
+				walk(n.right)
+				return
+			}
+			assert.LessOrEqual(t, len(n.items), alg.leafSize)
+			for _, it := range n.items {
+				assert.Same(t, n, it.leaves[index], "entry %d points to its leaf in tree %d", it.entry.Id, index)
+				assert.Same(t, it, alg.byId[it.entry.Id])
+			}
+			held += len(n.items)
+		}
+		walk(tr.root)
+		assert.Equal(t, len(alg.byId), held, "tree %d", index)
+	}
+}
+
+// depth returns the depth of the deepest leaf below n.
+func depth(n *node) int {
+	if n.leaf() {
+		return 1
+	}
+	return 1 + max(depth(n.left), depth(n.right))
+}
+
+func TestBuild(t *testing.T) {
+	for _, metric := range []string{"cosine", "euclidean"} {
+		alg := NewWithConfig(Config{Trees: 4, LeafSize: 8, Metric: metric, Seed: 1})
+		alg.AddEntries(algotest.NormalEntries(rand.New(rand.NewSource(1)), 1000, 8))
+		assertForest(t, alg)
+		for _, tr := range alg.trees {
+			// 1000 entries in leaves of 8 need 7 levels when split in halves
+			assert.Less(t, depth(tr.root), 20, "%s: splits are balanced", metric)
+		}
+	}
+}
+
+func TestBuild_Duplicates(t *testing.T) {
+	alg := NewWithConfig(Config{Trees: 2, LeafSize: 4, Seed: 2})
+	entries := make([]algorithms.Entry, 100)
+	for i := range entries {
+		entries[i] = algorithms.Entry{Vector: *vector.NewVector(1, 1), Id: i + 1}
+	}
+	alg.AddEntries(entries)
+	assertForest(t, alg)
+	for _, tr := range alg.trees {
+		assert.Nil(t, tr.root.normal, "equal vectors are split at random")
+	}
+	assert.Len(t, alg.Query(vector.NewVector(1, 1), 100, "cosine"), 100)
+}
+
+func TestNode_Margin(t *testing.T) {
+	alg := NewWithConfig(Config{Metric: "euclidean", Seed: 3})
+	items := []*item{
+		{entry: algorithms.Entry{Vector: *vector.NewVector(0, 0), Id: 1}},
+		{entry: algorithms.Entry{Vector: *vector.NewVector(4, 0), Id: 2}},
+	}
+	alg.dims = 2
+	n := alg.twoMeans(items, rand.New(rand.NewSource(3)))
+	require.NotNil(t, n.normal)
+	assert.InDelta(t, 0, n.margin([]float64{2, 5}), 1e-9, "the hyperplane is halfway between the means")
+	assert.InDelta(t, 2, max(n.margin([]float64{0, 0}), n.margin([]float64{4, 0})), 1e-9, "margins are distances")
+
+	alg = NewWithConfig(Config{Metric: "cosine", Seed: 3})
+	items[0].entry.Vector = *vector.NewVector(1, 0)
+	items[1].entry.Vector = *vector.NewVector(0, 3)
+	alg.dims = 2
+	n = alg.twoMeans(items, rand.New(rand.NewSource(3)))
+	assert.Zero(t, n.offset, "angular hyperplanes go through the origin")
+	assert.InDelta(t, 0, n.margin([]float64{5, 5}), 1e-9)
+}
@@ -2,71 +2,262 @@ package bruteforce
 
 import (
 	"VectorLite/internal/algorithms"
+	"VectorLite/internal/quantization"
 	"VectorLite/internal/vector"
 	"math"
+	"slices"
 	"sort"
 )
 
+/*
+Algorithm compares the query with every entry.
+
+With int8 quantization the entries are stored whole until TrainSize of them
+have arrived, then the quantizer is trained on them and every vector is
+replaced by its code, unless the originals are kept. Queries then score the
+codes, and entries are returned with the vectors decoded from them.
+
+With binary quantization every entry is encoded as it arrives and the
+originals are always kept: queries compare the codes by Hamming distance and
+score the closest candidates again with the original vectors.
+*/
 type Algorithm struct {
 	entries 	[]algorithms.Entry
 	idCounter 	int
+	// positions maps entry ids to their index in entries
+	positions	map[int]int
+	// quantizer is nil until trained, codes[i] is then the code of
+	// entries[i], whose vector is dropped unless originals are kept
+	quantizer	quantization.Quantizer
+	codes		[]quantization.Code
+	quantization	quantization.Config
+}
+
+// Config holds the parameters a bruteforce index is built with.
+type Config struct {
+	Quantization quantization.Config
 }
 
 type entryScore struct {
 	Entry algorithms.Entry
 	Score float64
+	index int
+}
+
+func DefaultConfig() Config {
+	return Config{Quantization: quantization.DefaultConfig()}
 }
 
 func New() *Algorithm {
+	return NewWithConfig(DefaultConfig())
+}
+
+func NewWithConfig(config Config) *Algorithm {
+	if config.Quantization.TrainSize < 1 {
+		config.Quantization.TrainSize = quantization.DefaultTrainSize
+	}
+	if config.Quantization.Mode == quantization.Bits {
+		config.Quantization.KeepOriginals = true
+	}
 	return &Algorithm{
-		entries: []algorithms.Entry{},
+		entries:      []algorithms.Entry{},
+		positions:    make(map[int]int),
+		quantizer:    config.Quantization.Quantizer(),
+		quantization: config.Quantization,
 	}
 }
 
+// Trained reports whether the vectors are quantized, queries are exact until
+// then.
+func (a *Algorithm) Trained() bool {
+	return a.quantizer != nil
+}
+
 func (a *Algorithm) AddEntry(entry algorithms.Entry) {
+	a.positions[entry.Id] = len(a.entries)
+	if a.Trained() {
+		a.codes = append(a.codes, a.quantizer.Encode(entry.Vector.Values))
+		entry = a.stored(entry)
+	}
 	a.entries = append(a.entries, entry)
+
+	if a.quantization.Trains() && !a.Trained() && len(a.entries) >= a.quantization.TrainSize {
+		a.train()
+	}
+}
+
+// train fits the quantizer to the entries and encodes them.
+func (a *Algorithm) train() {
+	points := make([][]float64, len(a.entries))
+	for i, entry := range a.entries {
+		points[i] = entry.Vector.Values
+	}
+	a.quantizer = quantization.TrainScalar(points)
+	a.codes = make([]quantization.Code, len(a.entries))
+	for i, entry := range a.entries {
+		a.codes[i] = a.quantizer.Encode(entry.Vector.Values)
+		a.entries[i] = a.stored(entry)
+	}
+}
+
+// stored returns entry as kept once encoded: without its vector, unless
+// originals are kept.
+func (a *Algorithm) stored(entry algorithms.Entry) algorithms.Entry {
+	if !a.quantization.KeepOriginals {
+		entry.Vector = vector.Vector{}
+	}
+	return entry
+}
+
+// entry returns the entry at index with its vector, decoded from its code
+// when the original was dropped.
+func (a *Algorithm) entry(index int) algorithms.Entry {
+	entry := a.entries[index]
+	if a.Trained() && !a.quantization.KeepOriginals {
+		entry.Vector = vector.Vector{Values: a.quantizer.Decode(a.codes[index])}
+	}
+	return entry
+}
+
+func (a *Algorithm) indexOf(id int) int {
+	index, exists := a.positions[id]
+	if !exists {
+		return -1
+	}
+	return index
+}
+
+func (a *Algorithm) GetEntry(id int) (algorithms.Entry, bool) {
+	index := a.indexOf(id)
+	if index < 0 {
+		return algorithms.Entry{}, false
+	}
+	return a.entry(index), true
+}
+
+func (a *Algorithm) UpdateEntry(entry algorithms.Entry) bool {
+	index := a.indexOf(entry.Id)
+	if index < 0 {
+		return false
+	}
+	if a.Trained() {
+		// metadata updates hand back the vector GetEntry returned
+		if current := a.entry(index); !current.Vector.Equal(&entry.Vector) {
+			a.codes[index] = a.quantizer.Encode(entry.Vector.Values)
+		}
+		entry = a.stored(entry)
+	}
+	a.entries[index] = entry
+	return true
+}
+
+func (a *Algorithm) RemoveEntry(id int) bool {
+	index := a.indexOf(id)
+	if index < 0 {
+		return false
+	}
+	a.entries = slices.Delete(a.entries, index, index+1)
+	if a.Trained() {
+		a.codes = slices.Delete(a.codes, index, index+1)
+	}
+	delete(a.positions, id)
+	// every entry after the removed one moved down by one
+	for i := index; i < len(a.entries); i++ {
+		a.positions[a.entries[i].Id] = i
+	}
+	return true
 }
 
 func (a *Algorithm) ListEntries() []algorithms.Entry {
-	return a.entries
+	if !a.Trained() || a.quantization.KeepOriginals {
+		// RemoveEntry deletes from a.entries in place
+		return slices.Clone(a.entries)
+	}
+	entries := make([]algorithms.Entry, len(a.entries))
+	for i := range entries {
+		entries[i] = a.entry(i)
+	}
+	return entries
 }
 
 func (a *Algorithm) Query(queryVector *vector.Vector, k int, metric string) []algorithms.Entry {
+	return a.QueryWithOptions(queryVector, k, metric, algorithms.QueryOptions{})
+}
+
+/*
+QueryWithOptions scores every entry accepted by the filter and returns the k
+closest, closest first. Once quantized, the codes are scored instead of the
+vectors and the filter is handed entries without their vector, unless
+originals are kept. With originals kept and a rerank factor, the rerank
+factor × k closest codes are scored again with the original vectors, or
+options.Oversample × k when the query sets it.
+*/
+func (a *Algorithm) QueryWithOptions(queryVector *vector.Vector, k int, metric string, options algorithms.QueryOptions) []algorithms.Entry {
 	// Handle edge case where k=0
 	if k <= 0 {
 		return []algorithms.Entry{}
 	}
+	if !a.Trained() {
+		return a.search(k, options, func(index int) float64 {
+			return queryVector.Distance_score(&a.entries[index].Vector, metric)
+		})
+	}
 
+	query := a.quantizer.Query(queryVector.Values)
+	quantized := func(index int) float64 {
+		return query.Distance(a.codes[index], metric)
+	}
+	rerank := a.quantization.Oversample(options.Oversample)
+	if rerank == 0 {
+		return a.search(k, options, quantized)
+	}
+
+	candidates := a.search(rerank*k, options, quantized)
+	scores := make(map[int]float64, len(candidates))
+	for _, candidate := range candidates {
+		scores[candidate.Id] = queryVector.Distance_score(&candidate.Vector, metric)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return scores[candidates[i].Id] < scores[candidates[j].Id]
+	})
+	return candidates[:min(k, len(candidates))]
+}
+
+// search returns the k entries accepted by options with the lowest score,
+// lowest first.
+func (a *Algorithm) search(k int, options algorithms.QueryOptions, scoreOf func(index int) float64) []algorithms.Entry {
 	// this is a brute force implementation of a knn algorithm
 	returnEntriesScores := []entryScore{}
 	highestScore := math.Inf(1) // this is actually the highest score in the return entries
 
-	for _, entry := range a.entries {
-		score := queryVector.Distance_score(&entry.Vector, metric)
+	for i, entry := range a.entries {
+		if !options.Accepts(entry) {
+			continue
+		}
+		score := scoreOf(i)
 		if score < highestScore || len(returnEntriesScores) < k {
-			returnEntriesScores = append(returnEntriesScores, entryScore{Entry: entry, Score: score})
+			returnEntriesScores = append(returnEntriesScores, entryScore{Entry: entry, Score: score, index: i})
 
 			// sorts the returnEntriesScores by score in ascending order
-			sort.Slice(returnEntriesScores, func(i, j int) bool {
-				return returnEntriesScores[i].Score > returnEntriesScores[j].Score
+			sort.SliceStable(returnEntriesScores, func(i, j int) bool {
+				return returnEntriesScores[i].Score < returnEntriesScores[j].Score
 			})
 
 			// here we need to remove
 			if k < len(returnEntriesScores) {
-				cut_from := len(returnEntriesScores) - k
-				returnEntriesScores = returnEntriesScores[cut_from:]
+				returnEntriesScores = returnEntriesScores[:k]
 			}
 
 			// Only update highestScore if we have entries
 			if len(returnEntriesScores) > 0 {
-				highestScore = returnEntriesScores[0].Score
+				highestScore = returnEntriesScores[len(returnEntriesScores)-1].Score
 			}
 		}
 	}
 
 	returnEntries := []algorithms.Entry{}
 	for _, i := range returnEntriesScores {
-		returnEntries = append(returnEntries, i.Entry)
+		returnEntries = append(returnEntries, a.entry(i.index))
 	}
 
 	return returnEntries
@@ -1,13 +1,18 @@
 package bruteforce_test
 
 import (
+	"math/rand"
+	"runtime"
 	"testing"
 
 	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
 	"VectorLite/internal/algorithms/bruteforce"
+	"VectorLite/internal/quantization"
 	"VectorLite/internal/vector"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewAlgorithm(t *testing.T) {
@@ -117,6 +122,14 @@ func TestQueryKnnEuclidean(t *testing.T) {
 	}
 	assert.True(t, foundIds["origin"], "Should include origin")
 	assert.True(t, foundIds["right"] || foundIds["up"], "Should include at least one distance-1 point")
+
+	// Test k=5 (every entry, closest first, ties in the order they were added)
+	result = algo.Query(queryVec, 5, "euclidean")
+	ids := []int{}
+	for _, entry := range result {
+		ids = append(ids, entry.Id)
+	}
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, ids, "Results should be ordered closest first")
 }
 
 func TestQueryKnnCosine(t *testing.T) {
@@ -316,4 +329,270 @@ func TestQueryConsistency(t *testing.T) {
 			assert.Equal(t, results[0][j], results[i][j], "Results should be identical across runs")
 		}
 	}
-}
\ No newline at end of file
+}
+func TestRemoveEntry(t *testing.T) {
+	algo := bruteforce.New()
+	entries := []algorithms.Entry{
+		{Vector: *vector.NewVector(1.0, 0.0), Metadata: map[string]string{"id": "1"}, Id: 1},
+		{Vector: *vector.NewVector(0.0, 1.0), Metadata: map[string]string{"id": "2"}, Id: 2},
+		{Vector: *vector.NewVector(1.0, 1.0), Metadata: map[string]string{"id": "3"}, Id: 3},
+	}
+	for _, entry := range entries {
+		algo.AddEntry(entry)
+	}
+
+	assert.True(t, algo.RemoveEntry(2), "Removing an existing entry should succeed")
+	assert.Equal(t, []algorithms.Entry{entries[0], entries[2]}, algo.ListEntries(), "Remaining entries should keep their order")
+
+	assert.False(t, algo.RemoveEntry(2), "Removing an entry twice should fail")
+	assert.False(t, algo.RemoveEntry(42), "Removing an unknown entry should fail")
+
+	result := algo.Query(vector.NewVector(0.0, 1.0), 3, "euclidean")
+	assert.Equal(t, 2, len(result), "Removed entries should not be returned by queries")
+}
+
+func TestGetAndUpdateEntry(t *testing.T) {
+	algo := bruteforce.New()
+	entry := algorithms.Entry{Vector: *vector.NewVector(1.0, 0.0), Metadata: map[string]string{"id": "1"}, Id: 1}
+	algo.AddEntry(entry)
+
+	got, found := algo.GetEntry(1)
+	assert.True(t, found, "Existing entry should be found")
+	assert.Equal(t, entry, got)
+
+	_, found = algo.GetEntry(2)
+	assert.False(t, found, "Unknown entry should not be found")
+
+	updated := algorithms.Entry{Vector: *vector.NewVector(0.0, 1.0), Metadata: map[string]string{"id": "one"}, Id: 1}
+	assert.True(t, algo.UpdateEntry(updated), "Updating an existing entry should succeed")
+	assert.Equal(t, []algorithms.Entry{updated}, algo.ListEntries())
+
+	assert.False(t, algo.UpdateEntry(algorithms.Entry{Vector: *vector.NewVector(1.0, 1.0), Id: 2}), "Updating an unknown entry should fail")
+	assert.Equal(t, 1, len(algo.ListEntries()))
+}
+
+func TestGetEntryAfterRemoval(t *testing.T) {
+	algo := bruteforce.New()
+	for i := 1; i <= 5; i++ {
+		algo.AddEntry(algorithms.Entry{Vector: *vector.NewVector(float64(i), 0.0), Id: i})
+	}
+
+	assert.True(t, algo.RemoveEntry(2))
+	for _, id := range []int{1, 3, 4, 5} {
+		entry, found := algo.GetEntry(id)
+		assert.True(t, found, "Entry %d should still be found", id)
+		assert.Equal(t, id, entry.Id, "Lookup of %d should return the right entry", id)
+	}
+	_, found := algo.GetEntry(2)
+	assert.False(t, found, "Removed entry should not be found")
+}
+
+func TestQueryWithFilter(t *testing.T) {
+	algo := bruteforce.New()
+	for i := 1; i <= 10; i++ {
+		parity := "odd"
+		if i%2 == 0 {
+			parity = "even"
+		}
+		algo.AddEntry(algorithms.Entry{
+			Vector:   *vector.NewVector(float64(i), 0.0),
+			Metadata: map[string]string{"parity": parity},
+			Id:       i,
+		})
+	}
+
+	options := algorithms.QueryOptions{Filter: func(entry algorithms.Entry) bool {
+		return entry.Metadata["parity"] == "even"
+	}}
+	result := algo.QueryWithOptions(vector.NewVector(1.0, 0.0), 3, "euclidean", options)
+	assert.Equal(t, 3, len(result), "Should return k matching entries")
+
+	foundIds := make(map[int]bool)
+	for _, entry := range result {
+		foundIds[entry.Id] = true
+	}
+	assert.Equal(t, map[int]bool{2: true, 4: true, 6: true}, foundIds, "Should return the closest matching entries")
+
+	result = algo.QueryWithOptions(vector.NewVector(1.0, 0.0), 3, "euclidean", algorithms.QueryOptions{})
+	assert.Equal(t, algo.Query(vector.NewVector(1.0, 0.0), 3, "euclidean"), result, "Zero options should behave as Query")
+}
+
+func int8Config(trainSize int, keepOriginals bool) bruteforce.Config {
+	config := bruteforce.DefaultConfig()
+	config.Quantization.Mode = quantization.Int8
+	config.Quantization.TrainSize = trainSize
+	config.Quantization.KeepOriginals = keepOriginals
+	return config
+}
+
+func TestQuantization_Train(t *testing.T) {
+	algo := bruteforce.NewWithConfig(int8Config(10, false))
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(1)), 15, 4)
+	for _, entry := range entries[:9] {
+		algo.AddEntry(entry)
+	}
+	assert.False(t, algo.Trained())
+	assert.Equal(t, entries[:9], algo.ListEntries(), "stored whole until trained")
+
+	for _, entry := range entries[9:] {
+		algo.AddEntry(entry)
+	}
+	require.True(t, algo.Trained())
+	listed := algo.ListEntries()
+	require.Len(t, listed, 15)
+	for i, entry := range listed {
+		assert.Equal(t, entries[i].Id, entry.Id)
+		assert.NotEqual(t, entries[i].Vector, entry.Vector, "vectors are decoded")
+		if i < 10 {
+			// within the trained range, later entries may be clamped to it
+			assert.InDelta(t, 0, entries[i].Vector.Euclidean_distance(&entry.Vector), 0.05)
+		}
+	}
+	got, exists := algo.GetEntry(3)
+	assert.True(t, exists)
+	assert.Equal(t, listed[2], got)
+}
+
+func TestQuantization_Recall(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	entries := algotest.NormalEntries(rng, 2000, 32)
+	queries := algotest.NormalEntries(rng, 20, 32)
+
+	exact := bruteforce.New()
+	quantized := bruteforce.NewWithConfig(int8Config(500, false))
+	rescored := bruteforce.NewWithConfig(int8Config(500, true))
+	for _, entry := range entries {
+		exact.AddEntry(entry)
+		quantized.AddEntry(entry)
+		rescored.AddEntry(entry)
+	}
+
+	for _, metric := range []string{"cosine", "euclidean"} {
+		truth := algotest.Neighbours(entries, queries, 10, metric)
+		approximate := algotest.Recall(quantized, queries, truth, metric)
+		assert.GreaterOrEqual(t, approximate, 0.9, metric)
+		assert.Equal(t, 1.0, algotest.Recall(rescored, queries, truth, metric), "%s: re-scored with the originals", metric)
+		t.Logf("%s recall@10: int8 %.3f, re-scored 1.000", metric, approximate)
+	}
+
+	// re-scored results come from the originals, ordered as exact ones
+	query := &queries[0].Vector
+	assert.Equal(t, exact.Query(query, 5, "euclidean"), rescored.Query(query, 5, "euclidean"))
+}
+
+// TestQuantization_Memory checks that int8 storage takes at least 4 times
+// less memory than float64 vectors.
+func TestQuantization_Memory(t *testing.T) {
+	heapOf := func(config bruteforce.Config) (*bruteforce.Algorithm, int64) {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		algo := bruteforce.NewWithConfig(config)
+		for _, entry := range algotest.NormalEntries(rand.New(rand.NewSource(3)), 2000, 256) {
+			algo.AddEntry(entry)
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		return algo, int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	}
+
+	full, fullHeap := heapOf(bruteforce.DefaultConfig())
+	quantized, quantizedHeap := heapOf(int8Config(100, false))
+	t.Logf("%d bytes per vector whole, %d quantized", fullHeap/2000, quantizedHeap/2000)
+	assert.GreaterOrEqual(t, float64(fullHeap)/float64(quantizedHeap), 4.0)
+	runtime.KeepAlive(full)
+	runtime.KeepAlive(quantized)
+}
+
+func TestQuantization_UpdateRemove(t *testing.T) {
+	algo := bruteforce.NewWithConfig(int8Config(10, false))
+	for _, entry := range algotest.NormalEntries(rand.New(rand.NewSource(4)), 20, 4) {
+		algo.AddEntry(entry)
+	}
+
+	// metadata only, as the engine does: the entry comes from GetEntry
+	entry, _ := algo.GetEntry(5)
+	entry.Metadata = map[string]string{"name": "renamed"}
+	assert.True(t, algo.UpdateEntry(entry))
+	updated, _ := algo.GetEntry(5)
+	assert.Equal(t, entry, updated)
+
+	moved := algorithms.Entry{Vector: *vector.NewVector(9, 9, 9, 9), Id: 5}
+	assert.True(t, algo.UpdateEntry(moved))
+	assert.Equal(t, 5, algo.Query(&moved.Vector, 1, "euclidean")[0].Id)
+
+	assert.True(t, algo.RemoveEntry(5))
+	assert.True(t, algo.RemoveEntry(1))
+	listed := algo.ListEntries()
+	require.Len(t, listed, 18)
+	for _, entry := range listed {
+		got, _ := algo.GetEntry(entry.Id)
+		assert.Equal(t, entry, got, "codes follow their entries")
+	}
+	assert.NotEqual(t, 5, algo.Query(&moved.Vector, 1, "euclidean")[0].Id)
+}
+
+func binaryConfig(rerankFactor int) bruteforce.Config {
+	config := bruteforce.DefaultConfig()
+	config.Quantization.Mode = quantization.Bits
+	config.Quantization.RerankFactor = rerankFactor
+	return config
+}
+
+func TestBinaryQuantization(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	entries := algotest.NormalEntries(rng, 2000, 64)
+	queries := algotest.NormalEntries(rng, 20, 64)
+
+	exact := bruteforce.New()
+	signs := bruteforce.NewWithConfig(binaryConfig(0))
+	rescored := bruteforce.NewWithConfig(binaryConfig(quantization.DefaultRerankFactor))
+	for i, entry := range entries {
+		exact.AddEntry(entry)
+		signs.AddEntry(entry)
+		rescored.AddEntry(entry)
+		if i == 0 {
+			assert.True(t, signs.Trained(), "binary codes need no training")
+		}
+	}
+	assert.Equal(t, entries, rescored.ListEntries(), "originals are always kept")
+
+	// without re-scoring, results are the closest codes
+	query := &queries[0].Vector
+	hamming := exact.Query(query, 10, "hamming")
+	found := signs.Query(query, 10, "cosine")
+	for i := range found {
+		assert.Equal(t, query.Distance_score(&hamming[i].Vector, "hamming"), query.Distance_score(&found[i].Vector, "hamming"))
+	}
+
+	for _, metric := range []string{"cosine", "euclidean"} {
+		truth := algotest.Neighbours(entries, queries, 10, metric)
+		first := algotest.Recall(signs, queries, truth, metric)
+		second := algotest.Recall(rescored, queries, truth, metric)
+		oversampled := algotest.RecallWith(rescored, queries, truth, metric, algorithms.QueryOptions{Oversample: 50})
+		t.Logf("%s recall@10: binary %.3f, re-scored %.3f, oversampled ×50 %.3f", metric, first, second, oversampled)
+		assert.Greater(t, second, first, metric)
+		assert.Greater(t, oversampled, second, metric)
+		// signs keep the direction of the vectors, not their length
+		assert.GreaterOrEqual(t, oversampled, 0.85, metric)
+	}
+
+	// re-scored results come from the originals, ordered as exact ones
+	all := algorithms.QueryOptions{Oversample: len(entries)}
+	assert.Equal(t, exact.Query(query, 5, "euclidean"), rescored.QueryWithOptions(query, 5, "euclidean", all))
+}
+
+func TestBinaryQuantization_UpdateRemove(t *testing.T) {
+	algo := bruteforce.NewWithConfig(binaryConfig(4))
+	for _, entry := range algotest.NormalEntries(rand.New(rand.NewSource(6)), 20, 4) {
+		algo.AddEntry(entry)
+	}
+
+	moved := algorithms.Entry{Vector: *vector.NewVector(9, -9, 9, -9), Id: 5}
+	assert.True(t, algo.UpdateEntry(moved))
+	assert.Equal(t, moved, algo.Query(&moved.Vector, 1, "euclidean")[0])
+
+	assert.True(t, algo.RemoveEntry(5))
+	assert.Len(t, algo.ListEntries(), 19)
+	assert.NotEqual(t, 5, algo.Query(&moved.Vector, 1, "euclidean")[0].Id)
+}
@@ -0,0 +1,86 @@
+package bruteforce
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/codec"
+	"VectorLite/internal/quantization"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const indexVersion = 1
+
+var ErrCorruptIndex = errors.New("corrupt bruteforce index")
+
+/*
+SaveIndex writes the quantizer and the code of every entry, in the order of
+ListEntries, so a reload keeps the codes instead of training again on the
+decoded vectors. Unquantized indexes only write that they aren't trained, as
+do binary ones: their codes are encoded again from the originals.
+
+Entries are not written, they are saved by the caller and handed back to
+LoadIndex.
+*/
+func (a *Algorithm) SaveIndex(w io.Writer) error {
+	out := codec.NewWriter(w)
+	out.Uint32(indexVersion)
+	scalar, trained := a.quantizer.(*quantization.Scalar)
+	out.Bool(trained)
+	if trained {
+		scalar.Save(out)
+		out.Uint32(uint32(len(a.codes)))
+		for _, code := range a.codes {
+			out.Raw(code.Bytes)
+		}
+	}
+	return out.Err()
+}
+
+// LoadIndex restores an index written by SaveIndex. entries must hold exactly
+// the entries of the saved index, in the order they were listed.
+func (a *Algorithm) LoadIndex(r io.Reader, entries []algorithms.Entry) error {
+	if len(a.entries) > 0 {
+		return fmt.Errorf("%w: index is not empty", ErrCorruptIndex)
+	}
+
+	in := codec.NewReader(r)
+	if version := in.Uint32(); in.Err() == nil && version != indexVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrCorruptIndex, version)
+	}
+	trained := in.Bool()
+	if err := in.Err(); err != nil {
+		return err
+	}
+	if !trained {
+		for _, entry := range entries {
+			a.AddEntry(entry)
+		}
+		return nil
+	}
+
+	quantizer, err := quantization.LoadScalar(in)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptIndex, err)
+	}
+	if count := in.Length(); in.Err() == nil && count != len(entries) {
+		return fmt.Errorf("%w: %d codes for %d entries", ErrCorruptIndex, count, len(entries))
+	}
+	codes := make([]quantization.Code, 0, len(entries))
+	for range entries {
+		bytes := make([]uint8, quantizer.Dims())
+		in.Raw(bytes)
+		codes = append(codes, quantizer.Restore(bytes))
+	}
+	if err := in.Err(); err != nil {
+		return err
+	}
+
+	a.quantizer = quantizer
+	a.codes = codes
+	for i, entry := range entries {
+		a.positions[entry.Id] = i
+		a.entries = append(a.entries, a.stored(entry))
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package bruteforce_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/algorithms/bruteforce"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveLoadIndex(t *testing.T) {
+	for _, keepOriginals := range []bool{false, true} {
+		config := int8Config(50, keepOriginals)
+		rng := rand.New(rand.NewSource(5))
+		algo := bruteforce.NewWithConfig(config)
+		for _, entry := range algotest.NormalEntries(rng, 100, 8) {
+			algo.AddEntry(entry)
+		}
+		for id := 1; id <= 100; id += 7 {
+			algo.RemoveEntry(id)
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, algo.SaveIndex(&buf))
+
+		loaded := bruteforce.NewWithConfig(config)
+		require.NoError(t, loaded.LoadIndex(&buf, algo.ListEntries()))
+		assert.True(t, loaded.Trained())
+		assert.Equal(t, algo.ListEntries(), loaded.ListEntries(), "codes are restored, not encoded again")
+		for _, query := range algotest.NormalEntries(rng, 10, 8) {
+			assert.Equal(t, algo.Query(&query.Vector, 5, "cosine"), loaded.Query(&query.Vector, 5, "cosine"))
+		}
+	}
+}
+
+func TestSaveLoadIndex_Binary(t *testing.T) {
+	algo := bruteforce.NewWithConfig(binaryConfig(2))
+	rng := rand.New(rand.NewSource(8))
+	entries := algotest.NormalEntries(rng, 50, 8)
+	for _, entry := range entries {
+		algo.AddEntry(entry)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, algo.SaveIndex(&buf))
+
+	loaded := bruteforce.NewWithConfig(binaryConfig(2))
+	require.NoError(t, loaded.LoadIndex(&buf, entries))
+	assert.Equal(t, entries, loaded.ListEntries())
+	for _, query := range algotest.NormalEntries(rng, 10, 8) {
+		assert.Equal(t, algo.Query(&query.Vector, 5, "cosine"), loaded.Query(&query.Vector, 5, "cosine"), "codes are encoded again")
+	}
+}
+
+func TestSaveLoadIndex_Untrained(t *testing.T) {
+	algo := bruteforce.New()
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(6)), 10, 2)
+	for _, entry := range entries {
+		algo.AddEntry(entry)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, algo.SaveIndex(&buf))
+
+	loaded := bruteforce.New()
+	require.NoError(t, loaded.LoadIndex(&buf, entries))
+	assert.False(t, loaded.Trained())
+	assert.Equal(t, entries, loaded.ListEntries())
+}
+
+func TestLoadIndex_Corrupt(t *testing.T) {
+	algo := bruteforce.NewWithConfig(int8Config(5, false))
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(7)), 10, 4)
+	for _, entry := range entries {
+		algo.AddEntry(entry)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, algo.SaveIndex(&buf))
+	saved := buf.Bytes()
+	listed := algo.ListEntries()
+
+	err := bruteforce.New().LoadIndex(bytes.NewReader(saved), listed[1:])
+	assert.ErrorIs(t, err, bruteforce.ErrCorruptIndex, "an entry is missing")
+
+	err = bruteforce.New().LoadIndex(bytes.NewReader(saved[:len(saved)-1]), listed)
+	assert.Error(t, err)
+
+	err = algo.LoadIndex(bytes.NewReader(saved), listed)
+	assert.ErrorIs(t, err, bruteforce.ErrCorruptIndex, "the index is not empty")
+}
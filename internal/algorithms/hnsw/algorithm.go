@@ -2,28 +2,219 @@ package hnsw
 
 import (
 	"VectorLite/internal/algorithms"
+	"VectorLite/internal/quantization"
+	"VectorLite/internal/vector"
+	"cmp"
 	"math"
 	"math/rand"
+	"runtime"
 	"slices"
 	"sort"
+	"sync"
+	"sync/atomic"
 )
 
+// DefaultEfSearch is the size of the dynamic candidate list used on layer 0
+// at query time when none is configured. Queries for more than efSearch
+// neighbours widen the list to k.
+const DefaultEfSearch = 64
+
+// DefaultMetric is the metric used to build and search the graph when the
+// configuration doesn't name one.
+const DefaultMetric = "cosine"
+
+// Neighbour selection strategies, see selectNeighbours.
+const (
+	SelectSimple    = "simple"
+	SelectHeuristic = "heuristic"
+)
+
+// Deletion modes, see RemoveEntry.
+const (
+	DeleteRepair    = "repair"
+	DeleteTombstone = "tombstone"
+)
+
+// DefaultTombstoneRatio is the share of tombstoned nodes that triggers a
+// cleanup of the graph when deleting in DeleteTombstone mode.
+const DefaultTombstoneRatio = 0.1
+
+/*
+Algorithm is an HNSW graph.
+
+AddEntry, AddEntries and the read methods (GetEntry, ListEntries, Query and
+QueryWithOptions) are safe for concurrent use: the links of every node are
+guarded by a lock of their own, so inserts only wait for each other when
+they touch the same nodes. RemoveEntry, UpdateEntry, Vacuum, SaveIndex and
+LoadIndex need the graph to themselves.
+
+With int8 quantization the nodes hold their whole vectors until TrainSize of
+them are in the graph, then the quantizer is trained on them and every
+vector is replaced by its code, unless the originals are kept. Links are
+chosen and searches walk the graph by comparing codes from then on, and
+entries are returned with the vectors decoded from them.
+
+With binary quantization every node is encoded as it is inserted and keeps
+its original: the graph is built and searched by the Hamming distance of the
+codes, and queries score the closest candidates again with the originals.
+*/
 type Algorithm struct {
-	nodes          []*HNSWNode
-	entryNode      *HNSWNode
+	// mu guards nodes, byId and rng
+	mu    sync.RWMutex
+	nodes []*HNSWNode
+	byId  map[int]*HNSWNode
+	// entryNode is read without locking by searches, levelMu serializes the
+	// inserts that move it to a higher layer
+	entryNode      atomic.Pointer[HNSWNode]
+	levelMu        sync.Mutex
+	nextOrder      atomic.Uint64
+	visitedPool    sync.Pool
 	M              int
+	mMax0          int
 	efConstruction int
+	efSearch       int
 	mL             float64
+	metric         string
+	rng            *rand.Rand
+	selection      string
+	extend         bool
+	keepPruned     bool
+	deletion       string
+	tombstoneRatio float64
+	tombstones     int
+	insertWorkers  int
+	// quantizer is nil until trained, trainMu is held by the inserts and
+	// queries while they read codes, and taken over by the training that
+	// encodes every node
+	quantizer    quantization.Quantizer
+	quantization quantization.Config
+	trainMu      sync.RWMutex
+}
+
+// Config holds the parameters an HNSW graph is built with.
+// The metric decides which nodes are linked together.
+type Config struct {
+	// M is the number of links a node is given when inserted, and the most
+	// it can have above layer 0.
+	M int
+	// Mmax0 is the most links a node can have on layer 0, twice M if zero.
+	Mmax0          int
+	EfConstruction int
+	EfSearch       int
+	ML             float64
+	Metric         string
+	// Selection is either SelectSimple or SelectHeuristic.
+	Selection string
+	// ExtendCandidates and KeepPrunedConnections are only used by
+	// SelectHeuristic.
+	ExtendCandidates      bool
+	KeepPrunedConnections bool
+	// Seed feeds the random source that assigns node levels, graphs built
+	// from the same entries in the same order with the same seed are identical.
+	Seed int64
+	// Source replaces the random source seeded with Seed when not nil. The
+	// graph takes it over, it must not be used elsewhere.
+	Source rand.Source
+	// Deletion is either DeleteRepair or DeleteTombstone.
+	Deletion string
+	// TombstoneRatio is only used by DeleteTombstone.
+	TombstoneRatio float64
+	// InsertWorkers is the number of goroutines AddEntries inserts with,
+	// all available CPUs if zero. Graphs are only reproducible from Seed
+	// when it is 1.
+	InsertWorkers int
+	Quantization  quantization.Config
+}
+
+func DefaultConfig() Config {
+	return Config{
+		M:              16,
+		EfConstruction: 200,
+		EfSearch:       DefaultEfSearch,
+		ML:             1.0 / math.Log(2.0),
+		Metric:         DefaultMetric,
+		Selection:      SelectHeuristic,
+		Deletion:       DeleteRepair,
+		TombstoneRatio: DefaultTombstoneRatio,
+		Quantization:   quantization.DefaultConfig(),
+	}
 }
 
+// New builds a graph with the default configuration, where M limits the
+// links on every layer, layer 0 included.
 func New(M int, efConstruction int, mL float64) *Algorithm {
+	config := DefaultConfig()
+	config.M = M
+	config.Mmax0 = M
+	config.EfConstruction = efConstruction
+	config.ML = mL
+	return NewWithConfig(config)
+}
+
+func NewWithConfig(config Config) *Algorithm {
+	if config.EfSearch < 1 {
+		config.EfSearch = DefaultEfSearch
+	}
+	if config.Mmax0 < 1 {
+		config.Mmax0 = 2 * config.M
+	}
+	if config.Metric == "" {
+		config.Metric = DefaultMetric
+	}
+	if config.Selection == "" {
+		config.Selection = SelectHeuristic
+	}
+	if config.Deletion == "" {
+		config.Deletion = DeleteRepair
+	}
+	if config.TombstoneRatio <= 0 {
+		config.TombstoneRatio = DefaultTombstoneRatio
+	}
+	if config.InsertWorkers < 1 {
+		config.InsertWorkers = runtime.GOMAXPROCS(0)
+	}
+	if config.Source == nil {
+		config.Source = rand.NewSource(config.Seed)
+	}
+	if config.Quantization.TrainSize < 1 {
+		config.Quantization.TrainSize = quantization.DefaultTrainSize
+	}
+	if config.Quantization.Mode == quantization.Bits {
+		config.Quantization.KeepOriginals = true
+	}
 	return &Algorithm{
 		nodes:          []*HNSWNode{},
-		entryNode:      nil,
-		M:              M,
-		efConstruction: efConstruction,
-		mL:             mL,
+		byId:           make(map[int]*HNSWNode),
+		M:              config.M,
+		mMax0:          config.Mmax0,
+		efConstruction: config.EfConstruction,
+		efSearch:       config.EfSearch,
+		mL:             config.ML,
+		metric:         config.Metric,
+		rng:            rand.New(config.Source),
+		selection:      config.Selection,
+		extend:         config.ExtendCandidates,
+		keepPruned:     config.KeepPrunedConnections,
+		deletion:       config.Deletion,
+		tombstoneRatio: config.TombstoneRatio,
+		insertWorkers:  config.InsertWorkers,
+		quantizer:      config.Quantization.Quantizer(),
+		quantization:   config.Quantization,
+	}
+}
+
+// Metric returns the distance metric the graph is built and searched with.
+func (a *Algorithm) Metric() string {
+	return a.metric
+}
+
+// SetEfSearch changes the beam width used on layer 0 by Query.
+// Higher values improve recall at the cost of latency.
+func (a *Algorithm) SetEfSearch(efSearch int) {
+	if efSearch < 1 {
+		efSearch = 1
 	}
+	a.efSearch = efSearch
 }
 
 /*
@@ -42,6 +233,20 @@ type HNSWNode struct {
 	Entry       algorithms.Entry
 	MaxLayer    int
 	Connections map[int][]*HNSWNode
+	// deleted nodes are tombstones: still walked through while searching,
+	// but never returned
+	deleted bool
+	// mu guards Connections once the node is in a graph that is being
+	// inserted into concurrently
+	mu sync.Mutex
+	// order is unique to every node added to a graph. It ranks nodes so that
+	// locks on several of them are always taken in the same order, and
+	// indexes the visited sets of searches.
+	order uint64
+	// code is the quantized vector, once the graph is quantized
+	code quantization.Code
+	// index is the position of the node in Algorithm.nodes
+	index int
 }
 
 type CandidateNode struct {
@@ -68,6 +273,31 @@ func (n *HNSWNode) connect(otherNode *HNSWNode, layer int) {
 	}
 }
 
+// links returns a copy of the node's connections on layer, safe to walk while
+// other goroutines change them.
+func (n *HNSWNode) links(layer int) []*HNSWNode {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return slices.Clone(n.Connections[layer])
+}
+
+// lockNodes locks the given distinct nodes in a global order, so that two
+// goroutines locking overlapping sets can't deadlock. It returns the function
+// unlocking them.
+func lockNodes(nodes ...*HNSWNode) func() {
+	slices.SortFunc(nodes, func(a *HNSWNode, b *HNSWNode) int {
+		return cmp.Compare(a.order, b.order)
+	})
+	for _, node := range nodes {
+		node.mu.Lock()
+	}
+	return func() {
+		for _, node := range nodes {
+			node.mu.Unlock()
+		}
+	}
+}
+
 func (n *HNSWNode) disconnect(otherNode *HNSWNode, layer int) {
 	n.Connections[layer] = slices.DeleteFunc(n.Connections[layer], func(i *HNSWNode) bool {
 		return i == otherNode
@@ -77,27 +307,122 @@ func (n *HNSWNode) disconnect(otherNode *HNSWNode, layer int) {
 	}
 }
 
-func (n *HNSWNode) getScore(otherNode *HNSWNode) float64 {
-	return n.Entry.Vector.Cosine_similarity(&otherNode.Entry.Vector)
+// distance scores two nodes with the graph's metric, through their codes once
+// the graph is quantized. Lower is closer.
+func (a *Algorithm) distance(node *HNSWNode, otherNode *HNSWNode) float64 {
+	if !node.code.IsZero() {
+		return a.quantizer.Distance(node.code, otherNode.code, a.metric)
+	}
+	return node.Entry.Vector.Distance_score(&otherNode.Entry.Vector, a.metric)
+}
+
+// scorer returns the function scoring nodes against node with metric. Query
+// nodes have no code, they are scored against the codes of a quantized graph
+// without being quantized themselves.
+func (a *Algorithm) scorer(node *HNSWNode, metric string) func(*HNSWNode) float64 {
+	switch {
+	case !node.code.IsZero():
+		return func(otherNode *HNSWNode) float64 {
+			return a.quantizer.Distance(node.code, otherNode.code, metric)
+		}
+	case a.quantizer != nil:
+		query := a.quantizer.Query(node.Entry.Vector.Values)
+		return func(otherNode *HNSWNode) float64 {
+			return query.Distance(otherNode.code, metric)
+		}
+	default:
+		return func(otherNode *HNSWNode) float64 {
+			return node.Entry.Vector.Distance_score(&otherNode.Entry.Vector, metric)
+		}
+	}
 }
 
 func (a *Algorithm) AddEntry(entry algorithms.Entry) {
-	newNode := &HNSWNode{
+	a.insert(a.newNode(entry))
+	a.trainIfDue()
+}
+
+/*
+AddEntries inserts a batch of entries with up to InsertWorkers goroutines.
+Node levels are drawn in the order of entries, but the links depend on how
+the inserts interleave, so the graph is only reproducible with one worker.
+*/
+func (a *Algorithm) AddEntries(entries []algorithms.Entry) {
+	nodes := make([]*HNSWNode, len(entries))
+	for i, entry := range entries {
+		nodes[i] = a.newNode(entry)
+	}
+
+	workers := min(a.insertWorkers, len(nodes))
+	if workers <= 1 {
+		for _, node := range nodes {
+			a.insert(node)
+			a.trainIfDue()
+		}
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := next.Add(1) - 1; i < int64(len(nodes)); i = next.Add(1) - 1 {
+				a.insert(nodes[i])
+				a.trainIfDue()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// newNode registers a node for entry, it still has to be linked with insert.
+func (a *Algorithm) newNode(entry algorithms.Entry) *HNSWNode {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	node := &HNSWNode{
 		Entry:       entry,
 		MaxLayer:    a.calculateLevelProbability(),
 		Connections: make(map[int][]*HNSWNode),
+		order:       a.nextOrder.Add(1),
 	}
-	a.nodes = append(a.nodes, newNode)
+	if a.quantizer != nil {
+		node.code = a.quantizer.Encode(entry.Vector.Values)
+		node.Entry = a.stored(entry)
+	}
+	node.index = len(a.nodes)
+	a.nodes = append(a.nodes, node)
+	a.byId[entry.Id] = node
+	return node
+}
+
+// insert links newNode into the graph. Inserts that raise the top layer hold
+// levelMu until they are done, as they become the new entry point: the other
+// inserts then wait for it, which only happens about once per layer.
+func (a *Algorithm) insert(newNode *HNSWNode) {
+	a.trainMu.RLock()
+	defer a.trainMu.RUnlock()
+
+	a.levelMu.Lock()
+	entryNode := a.entryNode.Load()
 
 	// new nodes becomes king of the hill if we had no previous king or
 	// new king is better than previous
-	if a.entryNode == nil {
-		a.entryNode = newNode
+	if entryNode == nil {
+		a.entryNode.Store(newNode)
+		a.levelMu.Unlock()
 		return
 	}
+	if newNode.MaxLayer > entryNode.MaxLayer {
+		defer a.levelMu.Unlock()
+	} else {
+		a.levelMu.Unlock()
+	}
 
-	currentLayer := a.entryNode.MaxLayer
-	entryPoints := []*HNSWNode{a.entryNode}
+	currentLayer := entryNode.MaxLayer
+	entryPoints := []*HNSWNode{entryNode}
 
 	// till we reach newNode's max layer we do a rapid descent
 	for currentLayer > newNode.MaxLayer {
@@ -108,16 +433,15 @@ func (a *Algorithm) AddEntry(entry algorithms.Entry) {
 	for currentLayer >= 0 {
 		entryPoints = a.searchLayer(newNode, entryPoints, currentLayer, a.efConstruction)
 
-		// now we need to connect to this new entryPoints
-		var connectTo []*HNSWNode
+		// now we need to connect to this new entryPoints, other inserts may
+		// have linked to newNode already, so it can turn up among them
+		candidates := slices.DeleteFunc(slices.Clone(entryPoints), func(n *HNSWNode) bool {
+			return n == newNode
+		})
 
-		// we can't connect to more than the max connections per node
-		if len(entryPoints) > a.M {
-			connectTo = entryPoints[:a.M]
-		} else {
-			connectTo = entryPoints
-		}
-		for _, connectNode := range connectTo {
+		// we pick M of them, the other nodes may link to newNode later on, up
+		// to the maximum links of the layer
+		for _, connectNode := range a.selectNeighbours(newNode, candidates, a.M, currentLayer) {
 			// this may or may not create a connection, depends how strong the score is for our new node
 			a.createConnection(newNode, connectNode, currentLayer)
 		}
@@ -125,121 +449,155 @@ func (a *Algorithm) AddEntry(entry algorithms.Entry) {
 		currentLayer--
 	}
 
-	if a.entryNode.MaxLayer < newNode.MaxLayer {
-		a.entryNode = newNode
+	if entryNode.MaxLayer < newNode.MaxLayer {
+		a.entryNode.Store(newNode)
 	}
 }
 
-func (a *Algorithm) createConnection(newNode *HNSWNode, existentNode *HNSWNode, layer int) {
-	if len(existentNode.Connections[layer]) == a.M {
-		// we need to check the lowest connection for this node
-		// if it's lowest than the newNode, we pop it and connect to the newNode
-		sort.Slice(existentNode.Connections[layer], func(i int, j int) bool {
-			return existentNode.getScore(existentNode.Connections[layer][i]) > existentNode.getScore(existentNode.Connections[layer][j])
-		})
+func (a *Algorithm) GetEntry(id int) (algorithms.Entry, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	node, exists := a.byId[id]
+	if !exists {
+		return algorithms.Entry{}, false
+	}
+	return a.entry(node), true
+}
+
+// UpdateEntry swaps the entry stored in a node in place when only its
+// metadata changed. A new vector moves the node to a different neighbourhood,
+// so it is removed and inserted again.
+func (a *Algorithm) UpdateEntry(entry algorithms.Entry) bool {
+	node, exists := a.byId[entry.Id]
+	if !exists {
+		return false
+	}
 
-		weakestConnection := existentNode.Connections[layer][a.M-1]
-		if existentNode.getScore(newNode) > existentNode.getScore(weakestConnection) {
-			existentNode.disconnect(weakestConnection, layer)
-			existentNode.connect(newNode, layer)
+	// metadata updates hand back the vector GetEntry returned
+	if current := a.entry(node); current.Vector.Equal(&entry.Vector) {
+		if !node.code.IsZero() {
+			entry = a.stored(entry)
 		}
-	} else {
-		existentNode.connect(newNode, layer)
+		node.Entry = entry
+		return true
 	}
+
+	a.RemoveEntry(entry.Id)
+	a.AddEntry(entry)
+	return true
 }
 
-func (a *Algorithm) calculateLevelProbability() int {
-	level := int(math.Floor(-math.Log(rand.Float64() * a.mL)))
-	if level < 0 {
-		level = 0
+// ListEntries returns the entries sorted by id.
+func (a *Algorithm) ListEntries() []algorithms.Entry {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	entries := make([]algorithms.Entry, 0, len(a.nodes))
+	for _, node := range a.nodes {
+		if !node.deleted {
+			entries = append(entries, a.entry(node))
+		}
 	}
-	return level
+	slices.SortFunc(entries, func(e1 algorithms.Entry, e2 algorithms.Entry) int {
+		return cmp.Compare(e1.Id, e2.Id)
+	})
+	return entries
+}
+
+func (a *Algorithm) Query(queryVector *vector.Vector, k int, metric string) []algorithms.Entry {
+	return a.QueryWithOptions(queryVector, k, metric, algorithms.QueryOptions{})
 }
 
 /*
-*   This method searches for the closest nodes on a layer
-*
-*   node
-*     node we are executing the search for
-*   entryNodes
-*     starting nodes for the search
-*   layer
-*     Layer we are alt
-*   numClosest
-*     number of close nodes to return
- */
-func (a *Algorithm) searchLayer(node *HNSWNode, entryNodes []*HNSWNode, layer int, numClosest int) []*HNSWNode {
-	candidates := []*CandidateNode{}
-	visited := []*HNSWNode{}
-
-	for _, entryNode := range entryNodes {
-		candidates = append(candidates, &CandidateNode{
-			Node:  entryNode,
-			Score: node.getScore(entryNode),
-		})
+QueryWithOptions walks the graph top-down the same way AddEntry does: a greedy
+descent from entryNode through the upper layers keeping a single closest node,
+then a beam search on layer 0 with efSearch candidates (or k, if larger).
+
+Tombstones and entries rejected by the filter are walked through on layer 0
+but never kept as results, so the beam widens past them until it holds enough
+accepted entries.
+
+The graph is always traversed with the metric it was built with, and the k
+closest candidates found on layer 0 are returned, closest first.
+
+Once quantized, candidates are ranked by their codes, and the filter is
+handed entries without their vector unless originals are kept. With originals kept
+and a rerank factor, the beam holds at least rerank factor × k candidates,
+and that many closest codes are ranked again with the original vectors.
+options.Oversample replaces the rerank factor for the query.
+*/
+func (a *Algorithm) QueryWithOptions(queryVector *vector.Vector, k int, metric string, options algorithms.QueryOptions) []algorithms.Entry {
+	a.trainMu.RLock()
+	defer a.trainMu.RUnlock()
+
+	entryNode := a.entryNode.Load()
+	if k <= 0 || entryNode == nil {
+		return []algorithms.Entry{}
 	}
 
-	sort.Slice(candidates, func(i int, j int) bool {
-		return candidates[i].Score > candidates[j].Score
-	})
+	queryNode := &HNSWNode{
+		Entry:       algorithms.Entry{Vector: *queryVector},
+		Connections: make(map[int][]*HNSWNode),
+	}
 
-	for len(candidates) > 0 {
-		var current *CandidateNode
+	entryPoints := []*HNSWNode{entryNode}
+	for layer := entryNode.MaxLayer; layer > 0; layer-- {
+		entryPoints = a.searchLayer(queryNode, entryPoints, layer, 1)
+	}
 
-		for _, candidate := range candidates {
-			if !slices.Contains(visited, candidate.Node) {
-				current = candidate
-				break
-			}
-		}
+	rerank := 0
+	if a.quantizer != nil {
+		rerank = a.quantization.Oversample(options.Oversample)
+	}
+	ef := max(a.efSearch, k, rerank*k)
 
-		if current == nil {
-			break
-		}
+	accept := func(node *HNSWNode) bool {
+		return !node.deleted && options.Accepts(node.Entry)
+	}
+	candidates := a.searchLayerFiltered(queryNode, entryPoints, 0, ef, accept)
 
-		// current candidate sucks big time and we just quit finding a better one
-		if len(candidates) >= numClosest && current.Score < candidates[numClosest-1].Score {
-			break
+	scored := rank(candidates, a.scorer(queryNode, metric))
+	if rerank > 0 {
+		scored = scored[:min(rerank*k, len(scored))]
+		for i := range scored {
+			scored[i].Score = queryVector.Distance_score(&scored[i].Node.Entry.Vector, metric)
 		}
+		sort.SliceStable(scored, func(i int, j int) bool {
+			return scored[i].Score < scored[j].Score
+		})
+	}
 
-		visited = append(visited, current.Node)
-
-		for _, conn := range current.Node.Connections[layer] {
-			// we don't want to check visited nodes
-			if !slices.Contains(visited, conn) {
-				connScore := node.getScore(conn)
-
-				connCandidateNode := &CandidateNode{
-					Node:  conn,
-					Score: connScore,
-				}
-				updated := false
-				if len(candidates) == numClosest {
-					worst := candidates[len(candidates)-1]
-					if connScore > worst.Score {
-						candidates = candidates[:numClosest-1]
-						candidates = append(candidates, connCandidateNode)
-						updated = true
-					}
-				} else {
-					candidates = append(candidates, connCandidateNode)
-					updated = true
-				}
-
-				// TODO: keep slice sorted on inserts so we don't need to sorte everytimej
-				if updated {
-					sort.Slice(candidates, func(i int, j int) bool {
-						return candidates[i].Score > candidates[j].Score
-					})
-				}
-			}
-		}
+	if len(scored) > k {
+		scored = scored[:k]
 	}
 
-	closest := []*HNSWNode{}
-	for _, c := range candidates {
-		closest = append(closest, c.Node)
+	results := make([]algorithms.Entry, len(scored))
+	for i, candidate := range scored {
+		results[i] = a.entry(candidate.Node)
 	}
+	return results
+}
 
-	return closest
+// rank scores nodes with score, closest first.
+func rank(nodes []*HNSWNode, score func(*HNSWNode) float64) []CandidateNode {
+	scored := make([]CandidateNode, len(nodes))
+	for i, node := range nodes {
+		scored[i] = CandidateNode{Node: node, Score: score(node)}
+	}
+	sort.SliceStable(scored, func(i int, j int) bool {
+		return scored[i].Score < scored[j].Score
+	})
+	return scored
+}
+
+/*
+calculateLevelProbability draws the top layer of a new node as in the HNSW
+paper: floor(-ln(u) * mL) with u uniform in (0, 1]. A node reaches layer l
+with probability exp(-l/mL), so each layer holds about exp(-1/mL) of the
+nodes of the layer below: half of them with the default mL of 1/ln(2).
+*/
+func (a *Algorithm) calculateLevelProbability() int {
+	// Float64 is in [0, 1), 1 - it can't be 0, whose log is -Inf
+	return int(math.Floor(-math.Log(1-a.rng.Float64()) * a.mL))
 }
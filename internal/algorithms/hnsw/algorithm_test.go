@@ -2,9 +2,11 @@ package hnsw
 
 import (
 	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
 	"VectorLite/internal/vector"
 	"math"
 	"math/rand"
+	"slices"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -26,7 +28,19 @@ func TestNew(t *testing.T) {
 	assert.Equal(t, efConstruction, alg.efConstruction)
 	assert.Equal(t, mL, alg.mL)
 	assert.Empty(t, alg.nodes)
-	assert.Nil(t, alg.entryNode)
+	assert.Nil(t, alg.entryNode.Load())
+}
+
+func TestNewWithConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Metric = "euclidean"
+	config.EfSearch = 0
+
+	alg := NewWithConfig(config)
+
+	assert.Equal(t, "euclidean", alg.Metric())
+	assert.Equal(t, DefaultEfSearch, alg.efSearch)
+	assert.Equal(t, DefaultMetric, New(16, 200, 1.0/math.Log(2.0)).Metric())
 }
 
 func TestHNSWNode_isConnectedTo(t *testing.T) {
@@ -221,9 +235,9 @@ func TestAlgorithm_AddEntry_FirstEntry(t *testing.T) {
 	alg.AddEntry(entry)
 
 	assert.Len(t, alg.nodes, 1)
-	assert.NotNil(t, alg.entryNode)
+	assert.NotNil(t, alg.entryNode.Load())
 	assert.Equal(t, entry, alg.nodes[0].Entry)
-	assert.Equal(t, alg.nodes[0], alg.entryNode)
+	assert.Equal(t, alg.nodes[0], alg.entryNode.Load())
 }
 
 func TestAlgorithm_AddEntry_MultipleEntries(t *testing.T) {
@@ -248,35 +262,30 @@ func TestAlgorithm_AddEntry_MultipleEntries(t *testing.T) {
 	alg.AddEntry(entry2)
 
 	assert.Len(t, alg.nodes, 2)
-	assert.NotNil(t, alg.entryNode)
+	assert.NotNil(t, alg.entryNode.Load())
 
 	// Entry node should be the one with higher max layer
 	expectedEntryNode := alg.nodes[0]
 	if alg.nodes[1].MaxLayer > alg.nodes[0].MaxLayer {
 		expectedEntryNode = alg.nodes[1]
 	}
-	assert.Equal(t, expectedEntryNode, alg.entryNode)
+	assert.Equal(t, expectedEntryNode, alg.entryNode.Load())
 }
 
 func TestAlgorithm_calculateLevelProbability(t *testing.T) {
-	// Set deterministic seed for reproducible tests
-	rand.New(rand.NewSource(123))
-
-	alg := New(16, 200, 1.0/math.Log(2.0))
+	config := DefaultConfig()
+	config.Source = rand.NewSource(123)
+	alg := NewWithConfig(config)
 
-	// Test multiple calculations - current implementation can return negative values
 	for i := 0; i < 100; i++ {
-		level := alg.calculateLevelProbability()
-		assert.IsType(t, int(0), level)
-		// Note: current implementation can return negative values due to log calculation
+		assert.GreaterOrEqual(t, alg.calculateLevelProbability(), 0)
 	}
 }
 
 func TestAlgorithm_calculateLevelProbability_Distribution(t *testing.T) {
-	// Set deterministic seed for reproducible tests
-	rand.New(rand.NewSource(123))
-
-	alg := New(16, 200, 1.0/math.Log(2.0))
+	config := DefaultConfig()
+	config.Source = rand.NewSource(123)
+	alg := NewWithConfig(config)
 
 	// Generate many levels and check distribution
 	levels := make(map[int]int)
@@ -295,6 +304,68 @@ func TestAlgorithm_calculateLevelProbability_Distribution(t *testing.T) {
 	assert.GreaterOrEqual(t, len(levels), 5)
 }
 
+// The share of nodes on each layer must match the paper: a node reaches
+// layer l with probability exp(-l/mL).
+func TestAlgorithm_calculateLevelProbability_Histogram(t *testing.T) {
+	const samples = 200_000
+	for _, M := range []int{2, 16} {
+		config := DefaultConfig()
+		config.ML = 1 / math.Log(float64(M))
+		config.Source = rand.NewSource(7)
+		alg := NewWithConfig(config)
+
+		histogram := map[int]int{}
+		for i := 0; i < samples; i++ {
+			histogram[alg.calculateLevelProbability()]++
+		}
+
+		reached := samples
+		for level := 0; level <= 5; level++ {
+			expected := samples * math.Exp(-float64(level)/config.ML)
+			if expected < 1000 {
+				// too few samples to compare, but the layer is populated
+				assert.NotZero(t, reached, "M=%d: layer %d is empty", M, level)
+				break
+			}
+			assert.InEpsilon(t, expected, float64(reached), 0.05,
+				"M=%d: %d nodes reach layer %d, expected about %.0f", M, reached, level, expected)
+			reached -= histogram[level]
+		}
+	}
+}
+
+func TestAlgorithm_Levels_Reproducible(t *testing.T) {
+	build := func(source rand.Source) []int {
+		config := DefaultConfig()
+		config.M = 4
+		config.EfConstruction = 16
+		config.Source = source
+		alg := NewWithConfig(config)
+
+		levels := []int{}
+		for _, entry := range algotest.UniformEntries(rand.New(rand.NewSource(1)), 200, 4) {
+			alg.AddEntry(entry)
+			levels = append(levels, alg.byId[entry.Id].MaxLayer)
+		}
+		return levels
+	}
+
+	levels := build(rand.NewSource(42))
+	assert.Equal(t, levels, build(rand.NewSource(42)))
+	assert.NotEqual(t, levels, build(rand.NewSource(43)))
+	assert.Greater(t, slices.Max(levels), 2, "upper layers are populated")
+
+	// Seed is the same as a source seeded with it
+	config := DefaultConfig()
+	config.Seed = 42
+	fromSeed := NewWithConfig(config)
+	config.Source = rand.NewSource(42)
+	fromSource := NewWithConfig(config)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, fromSource.calculateLevelProbability(), fromSeed.calculateLevelProbability())
+	}
+}
+
 func TestHNSWNode_MultiLayerConnections(t *testing.T) {
 	// Set deterministic seed for reproducible tests
 	rand.New(rand.NewSource(123))
@@ -372,7 +443,8 @@ func TestAlgorithm_searchLayer(t *testing.T) {
 		Connections: make(map[int][]*HNSWNode),
 	}
 
-	// Create connected nodes at layer 0
+	// Create connected nodes at layer 0, with the distinct orders newNode
+	// would give them
 	node1 := &HNSWNode{
 		Entry: algorithms.Entry{
 			Vector:   vector.Vector{Values: []float64{1.0, 0.0, 0.0}}, // close to query
@@ -381,6 +453,7 @@ func TestAlgorithm_searchLayer(t *testing.T) {
 		},
 		MaxLayer:    1,
 		Connections: make(map[int][]*HNSWNode),
+		order:       1,
 	}
 
 	node2 := &HNSWNode{
@@ -391,6 +464,7 @@ func TestAlgorithm_searchLayer(t *testing.T) {
 		},
 		MaxLayer:    1,
 		Connections: make(map[int][]*HNSWNode),
+		order:       2,
 	}
 
 	node3 := &HNSWNode{
@@ -401,6 +475,7 @@ func TestAlgorithm_searchLayer(t *testing.T) {
 		},
 		MaxLayer:    1,
 		Connections: make(map[int][]*HNSWNode),
+		order:       3,
 	}
 
 	// Connect the nodes: node1 <-> node2 <-> node3 (linear chain)
@@ -483,7 +558,7 @@ func TestAlgorithm_AddEntry_WithConnections(t *testing.T) {
 	// Add entries one by one
 	alg.AddEntry(entry1)
 	assert.Len(t, alg.nodes, 1)
-	assert.Equal(t, alg.nodes[0], alg.entryNode)
+	assert.Equal(t, alg.nodes[0], alg.entryNode.Load())
 
 	alg.AddEntry(entry2)
 	assert.Len(t, alg.nodes, 2)
@@ -569,7 +644,7 @@ func TestAlgorithm_AddEntry_MultiLayer(t *testing.T) {
 	}
 
 	assert.Len(t, alg.nodes, 5)
-	assert.NotNil(t, alg.entryNode)
+	assert.NotNil(t, alg.entryNode.Load())
 
 	// Verify entry node is the one with highest MaxLayer
 	maxLayer := -1
@@ -578,7 +653,7 @@ func TestAlgorithm_AddEntry_MultiLayer(t *testing.T) {
 			maxLayer = node.MaxLayer
 		}
 	}
-	assert.Equal(t, maxLayer, alg.entryNode.MaxLayer)
+	assert.Equal(t, maxLayer, alg.entryNode.Load().MaxLayer)
 
 	// Check that connections exist and respect layer constraints
 	for _, node := range alg.nodes {
@@ -612,6 +687,96 @@ func TestAlgorithm_AddEntry_EmptyGraph(t *testing.T) {
 	alg.AddEntry(entry)
 
 	assert.Len(t, alg.nodes, 1)
-	assert.Equal(t, alg.nodes[0], alg.entryNode)
+	assert.Equal(t, alg.nodes[0], alg.entryNode.Load())
 	assert.Equal(t, entry, alg.nodes[0].Entry)
 }
+
+func TestAlgorithm_ListEntries(t *testing.T) {
+	alg := New(16, 200, 1.0/math.Log(2.0))
+	assert.Empty(t, alg.ListEntries())
+
+	entries := []algorithms.Entry{
+		{Vector: vector.Vector{Values: []float64{1.0, 0.0}}, Metadata: map[string]string{"id": "1"}, Id: 1},
+		{Vector: vector.Vector{Values: []float64{0.0, 1.0}}, Metadata: map[string]string{"id": "2"}, Id: 2},
+		{Vector: vector.Vector{Values: []float64{1.0, 1.0}}, Metadata: map[string]string{"id": "3"}, Id: 3},
+	}
+	for _, entry := range entries {
+		alg.AddEntry(entry)
+	}
+
+	assert.Equal(t, entries, alg.ListEntries())
+}
+
+func TestAlgorithm_Query_Empty(t *testing.T) {
+	alg := New(16, 200, 1.0/math.Log(2.0))
+
+	result := alg.Query(vector.NewVector(1.0, 0.0), 3, "cosine")
+	assert.Empty(t, result)
+}
+
+func TestAlgorithm_Query_ZeroK(t *testing.T) {
+	alg := New(16, 200, 1.0/math.Log(2.0))
+	alg.AddEntry(algorithms.Entry{Vector: vector.Vector{Values: []float64{1.0, 0.0}}, Id: 1})
+
+	result := alg.Query(vector.NewVector(1.0, 0.0), 0, "cosine")
+	assert.Empty(t, result)
+}
+
+func TestAlgorithm_Query_ClosestFirst(t *testing.T) {
+	alg := New(4, 16, 1.0/math.Log(2.0))
+
+	entries := []algorithms.Entry{
+		{Vector: vector.Vector{Values: []float64{1.0, 0.0}}, Metadata: map[string]string{"id": "horizontal"}, Id: 1},
+		{Vector: vector.Vector{Values: []float64{1.0, 1.0}}, Metadata: map[string]string{"id": "diagonal"}, Id: 2},
+		{Vector: vector.Vector{Values: []float64{0.0, 1.0}}, Metadata: map[string]string{"id": "vertical"}, Id: 3},
+		{Vector: vector.Vector{Values: []float64{-1.0, 0.0}}, Metadata: map[string]string{"id": "opposite"}, Id: 4},
+	}
+	for _, entry := range entries {
+		alg.AddEntry(entry)
+	}
+
+	result := alg.Query(vector.NewVector(1.0, 0.1), 2, "cosine")
+	require.Len(t, result, 2)
+	assert.Equal(t, "horizontal", result[0].Metadata["id"])
+	assert.Equal(t, "diagonal", result[1].Metadata["id"])
+
+	// asking for more neighbours than stored returns everything
+	result = alg.Query(vector.NewVector(1.0, 0.1), 10, "cosine")
+	assert.Len(t, result, 4)
+}
+
+func TestAlgorithm_Query_RecallAgainstBruteforce(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	alg := New(16, 100, 1.0/math.Log(2.0))
+	alg.SetEfSearch(100)
+
+	entries := algotest.UniformEntries(rng, 500, 8)
+	for _, entry := range entries {
+		alg.AddEntry(entry)
+	}
+
+	recall := recallAgainstBruteforce(t, alg, entries, rng)
+	assert.GreaterOrEqual(t, recall, 0.9, "recall@10 should be high, got %.2f", recall)
+}
+
+func TestAlgorithm_Query_EuclideanGraph(t *testing.T) {
+	config := DefaultConfig()
+	config.M = 4
+	config.EfConstruction = 16
+	config.Metric = "euclidean"
+	alg := NewWithConfig(config)
+
+	// along a line every point has the same cosine similarity to the query,
+	// only a graph built with euclidean distance can tell them apart
+	for i := 1; i <= 20; i++ {
+		alg.AddEntry(algorithms.Entry{
+			Vector: vector.Vector{Values: []float64{float64(i), float64(i)}},
+			Id:     i,
+		})
+	}
+
+	result := alg.Query(vector.NewVector(7.2, 7.2), 3, "euclidean")
+	require.Len(t, result, 3)
+	assert.Equal(t, 7, result[0].Id)
+	assert.ElementsMatch(t, []int{6, 7, 8}, []int{result[0].Id, result[1].Id, result[2].Id})
+}
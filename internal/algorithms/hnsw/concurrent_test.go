@@ -0,0 +1,102 @@
+package hnsw
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/vector"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// These tests are meant to be run with -race, see make test-race.
+
+func recallAgainstBruteforce(t *testing.T, alg *Algorithm, entries []algorithms.Entry, rng *rand.Rand) float64 {
+	return recallWith(t, alg, entries, rng, algorithms.QueryOptions{})
+}
+
+// recallWith is the recall of the 10 nearest neighbours of 20 random queries.
+func recallWith(t *testing.T, alg *Algorithm, entries []algorithms.Entry, rng *rand.Rand, options algorithms.QueryOptions) float64 {
+	queries := algotest.UniformEntries(rng, 20, len(entries[0].Vector.Values))
+	truth := algotest.Neighbours(entries, queries, 10, "cosine")
+	return algotest.RecallWith(alg, queries, truth, "cosine", options)
+}
+
+func TestAlgorithm_AddEntries(t *testing.T) {
+	for _, workers := range []int{1, 8} {
+		config := DefaultConfig()
+		config.M = 16
+		config.EfConstruction = 100
+		config.EfSearch = 100
+		config.InsertWorkers = workers
+		alg := NewWithConfig(config)
+
+		rng := rand.New(rand.NewSource(42))
+		entries := algotest.UniformEntries(rng, 500, 8)
+		alg.AddEntries(entries)
+
+		assert.Len(t, alg.ListEntries(), len(entries))
+		assertGraphConsistent(t, alg)
+		for _, entry := range entries {
+			_, exists := alg.GetEntry(entry.Id)
+			assert.True(t, exists, "entry %d is missing", entry.Id)
+		}
+
+		entryNode := alg.entryNode.Load()
+		for _, node := range alg.nodes {
+			assert.LessOrEqual(t, node.MaxLayer, entryNode.MaxLayer, "entry node must be on the top layer")
+		}
+
+		recall := recallAgainstBruteforce(t, alg, entries, rng)
+		assert.GreaterOrEqual(t, recall, 0.9, "recall with %d workers should be high, got %.2f", workers, recall)
+	}
+}
+
+func TestAlgorithm_AddEntries_Empty(t *testing.T) {
+	alg := New(4, 16, 1.0)
+	alg.AddEntries(nil)
+	assert.Empty(t, alg.nodes)
+	assert.Nil(t, alg.entryNode.Load())
+}
+
+func TestAlgorithm_ConcurrentAddAndQuery(t *testing.T) {
+	config := DefaultConfig()
+	config.M = 8
+	config.EfConstruction = 32
+	alg := NewWithConfig(config)
+
+	const writers, perWriter = 8, 100
+	rng := rand.New(rand.NewSource(7))
+	entries := algotest.UniformEntries(rng, writers*perWriter, 4)
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(batch []algorithms.Entry) {
+			defer wg.Done()
+			for _, entry := range batch {
+				alg.AddEntry(entry)
+			}
+		}(entries[w*perWriter : (w+1)*perWriter])
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			query := vector.NewVector(1, 0, 0, 0)
+			for i := 0; i < 100; i++ {
+				assert.LessOrEqual(t, len(alg.Query(query, 5, "cosine")), 5)
+				alg.ListEntries()
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, alg.ListEntries(), len(entries))
+	assertGraphConsistent(t, alg)
+	recall := recallAgainstBruteforce(t, alg, entries, rng)
+	assert.GreaterOrEqual(t, recall, 0.8, "recall should survive concurrent inserts, got %.2f", recall)
+}
@@ -0,0 +1,131 @@
+package hnsw
+
+import (
+	"slices"
+	"sort"
+)
+
+/*
+RemoveEntry deletes the entry with the given id from the graph.
+
+In DeleteRepair mode the node is unlinked straight away and every former
+neighbour is offered the other former neighbours as replacement links, so the
+neighbourhood stays navigable. If the node was the entry point, the remaining
+node with the highest layer takes its place.
+
+In DeleteTombstone mode the node is only marked as deleted: it keeps routing
+searches but is never returned. Once tombstones make up more than
+tombstoneRatio of the graph they are all repaired at once, see Vacuum.
+*/
+func (a *Algorithm) RemoveEntry(id int) bool {
+	node, exists := a.byId[id]
+	if !exists {
+		return false
+	}
+	delete(a.byId, id)
+
+	if a.deletion == DeleteTombstone {
+		node.deleted = true
+		a.tombstones++
+		if float64(a.tombstones) > a.tombstoneRatio*float64(len(a.nodes)) {
+			a.Vacuum()
+		}
+		return true
+	}
+
+	a.removeNode(node)
+	return true
+}
+
+// Vacuum unlinks every tombstoned node from the graph, repairing their
+// neighbourhoods as RemoveEntry does in DeleteRepair mode. The node list is
+// filtered once, and a new entry point elected at most once.
+func (a *Algorithm) Vacuum() {
+	if a.tombstones == 0 {
+		return
+	}
+
+	for _, node := range a.nodes {
+		if node.deleted {
+			a.unlink(node)
+		}
+	}
+	a.nodes = slices.DeleteFunc(a.nodes, func(node *HNSWNode) bool {
+		return node.deleted
+	})
+	for i, node := range a.nodes {
+		node.index = i
+	}
+	a.tombstones = 0
+
+	if entryNode := a.entryNode.Load(); entryNode != nil && entryNode.deleted {
+		a.electEntryNode()
+	}
+}
+
+func (a *Algorithm) removeNode(node *HNSWNode) {
+	a.unlink(node)
+
+	// the last node takes the place of the removed one
+	last := a.nodes[len(a.nodes)-1]
+	a.nodes[node.index] = last
+	last.index = node.index
+	a.nodes = a.nodes[:len(a.nodes)-1]
+
+	if a.entryNode.Load() == node {
+		a.electEntryNode()
+	}
+}
+
+// unlink disconnects node from its neighbours on every layer and repairs
+// their neighbourhoods.
+func (a *Algorithm) unlink(node *HNSWNode) {
+	for layer := 0; layer <= node.MaxLayer; layer++ {
+		neighbours := slices.Clone(node.Connections[layer])
+		for _, neighbour := range neighbours {
+			node.disconnect(neighbour, layer)
+		}
+		a.repairNeighbourhood(neighbours, layer)
+	}
+}
+
+// repairNeighbourhood reconnects the former neighbours of a removed node.
+// Each of them links to the closest of the others while it has room, links
+// on full nodes are only swapped if that improves them (see createConnection).
+func (a *Algorithm) repairNeighbourhood(neighbours []*HNSWNode, layer int) {
+	for _, neighbour := range neighbours {
+		candidates := make([]*CandidateNode, 0, len(neighbours)-1)
+		for _, other := range neighbours {
+			if other != neighbour && !neighbour.isConnectedTo(other, layer) {
+				candidates = append(candidates, &CandidateNode{
+					Node:  other,
+					Score: a.distance(neighbour, other),
+				})
+			}
+		}
+		sort.Slice(candidates, func(i int, j int) bool {
+			return candidates[i].Score < candidates[j].Score
+		})
+
+		for _, candidate := range candidates {
+			if len(neighbour.Connections[layer]) >= a.maxLinks(layer) {
+				break
+			}
+			a.createConnection(neighbour, candidate.Node, layer)
+		}
+	}
+}
+
+// electEntryNode picks the node living on the highest layer as the new entry
+// point, preferring live nodes over tombstones.
+func (a *Algorithm) electEntryNode() {
+	var entryNode *HNSWNode
+	for _, node := range a.nodes {
+		if entryNode == nil ||
+			(entryNode.deleted && !node.deleted) ||
+			(entryNode.deleted == node.deleted && node.MaxLayer > entryNode.MaxLayer) {
+			entryNode = node
+		}
+	}
+	a.entryNode.Store(entryNode)
+}
@@ -0,0 +1,179 @@
+package hnsw
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/vector"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertGraphConsistent(t *testing.T, alg *Algorithm) {
+	live := map[*HNSWNode]bool{}
+	for i, node := range alg.nodes {
+		live[node] = true
+		assert.Equal(t, i, node.index, "node %d doesn't know its position", node.Entry.Id)
+	}
+
+	for _, node := range alg.nodes {
+		for layer, connections := range node.Connections {
+			assert.LessOrEqual(t, len(connections), alg.maxLinks(layer), "node %d exceeds the maximum links on layer %d", node.Entry.Id, layer)
+			for _, conn := range connections {
+				assert.True(t, live[conn], "node %d links to removed node %d", node.Entry.Id, conn.Entry.Id)
+				assert.True(t, conn.isConnectedTo(node, layer), "link %d-%d is not bidirectional", node.Entry.Id, conn.Entry.Id)
+			}
+		}
+	}
+
+	if len(alg.nodes) > 0 {
+		require.NotNil(t, alg.entryNode.Load())
+		assert.True(t, live[alg.entryNode.Load()], "entry node must still be in the graph")
+	}
+}
+
+func TestAlgorithm_RemoveEntry_Unknown(t *testing.T) {
+	alg := New(4, 16, 1.0)
+	assert.False(t, alg.RemoveEntry(1))
+
+	alg.AddEntry(algorithms.Entry{Vector: vector.Vector{Values: []float64{1, 0}}, Id: 1})
+	assert.True(t, alg.RemoveEntry(1))
+	assert.False(t, alg.RemoveEntry(1))
+	assert.Empty(t, alg.nodes)
+	assert.Nil(t, alg.entryNode.Load())
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 1, "cosine"))
+}
+
+func TestAlgorithm_RemoveEntry_Repair(t *testing.T) {
+	config := DefaultConfig()
+	config.M = 8
+	config.EfConstruction = 32
+	config.Metric = "euclidean"
+	alg := NewWithConfig(config)
+
+	entries := algotest.UniformEntries(rand.New(rand.NewSource(1)), 200, 4)
+	for _, entry := range entries {
+		alg.AddEntry(entry)
+	}
+
+	for id := 1; id <= 200; id += 3 {
+		require.True(t, alg.RemoveEntry(id))
+	}
+	assertGraphConsistent(t, alg)
+	assert.Len(t, alg.ListEntries(), 200-67)
+
+	for _, entry := range alg.ListEntries() {
+		// every remaining entry should still be reachable through the graph
+		result := alg.Query(&entry.Vector, 1, "euclidean")
+		require.Len(t, result, 1)
+		assert.Equal(t, entry.Id, result[0].Id)
+	}
+}
+
+func TestAlgorithm_RemoveEntry_EntryNode(t *testing.T) {
+	alg := New(4, 16, 1.0)
+	for _, entry := range algotest.UniformEntries(rand.New(rand.NewSource(2)), 50, 3) {
+		alg.AddEntry(entry)
+	}
+
+	for i := 0; i < 10; i++ {
+		entryNode := alg.entryNode.Load()
+		require.True(t, alg.RemoveEntry(entryNode.Entry.Id))
+		assert.NotEqual(t, entryNode, alg.entryNode.Load())
+
+		for _, node := range alg.nodes {
+			assert.LessOrEqual(t, node.MaxLayer, alg.entryNode.Load().MaxLayer, "entry node should live on the highest layer")
+		}
+	}
+	assertGraphConsistent(t, alg)
+}
+
+func TestAlgorithm_RemoveEntry_Tombstone(t *testing.T) {
+	config := DefaultConfig()
+	config.M = 8
+	config.EfConstruction = 32
+	config.Metric = "euclidean"
+	config.Deletion = DeleteTombstone
+	config.TombstoneRatio = 0.5
+	alg := NewWithConfig(config)
+
+	entries := algotest.UniformEntries(rand.New(rand.NewSource(3)), 100, 4)
+	for _, entry := range entries {
+		alg.AddEntry(entry)
+	}
+
+	require.True(t, alg.RemoveEntry(1))
+	assert.False(t, alg.RemoveEntry(1), "tombstoned entries can't be removed twice")
+	assert.Len(t, alg.nodes, 100, "tombstones stay in the graph")
+	assert.Len(t, alg.ListEntries(), 99)
+
+	result := alg.Query(&entries[0].Vector, 5, "euclidean")
+	require.Len(t, result, 5)
+	for _, entry := range result {
+		assert.NotEqual(t, 1, entry.Id, "tombstoned entries must not be returned")
+	}
+
+	// crossing the ratio triggers a cleanup of every tombstone
+	for id := 2; id <= 51; id++ {
+		require.True(t, alg.RemoveEntry(id))
+	}
+	assert.Equal(t, 0, alg.tombstones)
+	assert.Len(t, alg.nodes, 49)
+	assertGraphConsistent(t, alg)
+}
+
+func TestAlgorithm_Vacuum(t *testing.T) {
+	config := DefaultConfig()
+	config.Deletion = DeleteTombstone
+	config.TombstoneRatio = 1
+	alg := NewWithConfig(config)
+
+	for _, entry := range algotest.UniformEntries(rand.New(rand.NewSource(4)), 30, 3) {
+		alg.AddEntry(entry)
+	}
+	for id := 1; id <= 10; id++ {
+		alg.RemoveEntry(id)
+	}
+	assert.Len(t, alg.nodes, 30)
+
+	alg.Vacuum()
+	assert.Len(t, alg.nodes, 20)
+	assertGraphConsistent(t, alg)
+}
+
+func TestAlgorithm_UpdateEntry(t *testing.T) {
+	config := DefaultConfig()
+	config.M = 8
+	config.EfConstruction = 32
+	config.Metric = "euclidean"
+	alg := NewWithConfig(config)
+
+	for _, entry := range algotest.UniformEntries(rand.New(rand.NewSource(5)), 100, 3) {
+		alg.AddEntry(entry)
+	}
+	assert.False(t, alg.UpdateEntry(algorithms.Entry{Vector: vector.Vector{Values: []float64{0, 0, 0}}, Id: 101}))
+
+	// a metadata change keeps the node and its links
+	node := alg.byId[10]
+	links := len(node.Connections[0])
+	entry, found := alg.GetEntry(10)
+	require.True(t, found)
+	entry.Metadata = map[string]string{"tag": "updated"}
+	require.True(t, alg.UpdateEntry(entry))
+	assert.Same(t, node, alg.byId[10])
+	assert.Len(t, node.Connections[0], links)
+	assert.Equal(t, "updated", node.Entry.Metadata["tag"])
+
+	// a vector change re-inserts the node in its new neighbourhood
+	moved := algorithms.Entry{Vector: vector.Vector{Values: []float64{5, 5, 5}}, Id: 10}
+	require.True(t, alg.UpdateEntry(moved))
+	assert.NotSame(t, node, alg.byId[10])
+	assert.Len(t, alg.ListEntries(), 100)
+	assertGraphConsistent(t, alg)
+
+	result := alg.Query(vector.NewVector(5, 5, 5), 1, "euclidean")
+	require.Len(t, result, 1)
+	assert.Equal(t, 10, result[0].Id)
+}
@@ -0,0 +1,57 @@
+package hnsw
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/vector"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlgorithm_QueryWithOptions_SelectiveFilter(t *testing.T) {
+	config := DefaultConfig()
+	config.M = 8
+	config.EfConstruction = 64
+	config.Metric = "euclidean"
+	alg := NewWithConfig(config)
+
+	entries := algotest.UniformEntries(rand.New(rand.NewSource(6)), 1000, 4)
+	for i := range entries {
+		// only 1% of the entries match the filter
+		tag := "common"
+		if i%100 == 0 {
+			tag = "rare"
+		}
+		entries[i].Metadata = map[string]string{"tag": tag}
+		alg.AddEntry(entries[i])
+	}
+
+	isRare := func(entry algorithms.Entry) bool {
+		return entry.Metadata["tag"] == "rare"
+	}
+	query := vector.NewVector(0.1, 0.2, 0.3, 0.4)
+	k := 5
+	result := alg.QueryWithOptions(query, k, "euclidean", algorithms.QueryOptions{Filter: isRare})
+	require.Len(t, result, k, "selective filters should still return k results")
+
+	expected := algotest.ExactWith(entries, query, k, "euclidean", algorithms.QueryOptions{Filter: isRare})
+	for i, entry := range result {
+		assert.True(t, isRare(entry))
+		assert.Equal(t, expected[i].Id, entry.Id)
+	}
+}
+
+func TestAlgorithm_QueryWithOptions_NoMatch(t *testing.T) {
+	alg := New(8, 32, 1.0)
+	for _, entry := range algotest.UniformEntries(rand.New(rand.NewSource(7)), 50, 3) {
+		alg.AddEntry(entry)
+	}
+
+	result := alg.QueryWithOptions(vector.NewVector(0, 0, 0), 5, "cosine", algorithms.QueryOptions{
+		Filter: func(entry algorithms.Entry) bool { return false },
+	})
+	assert.Empty(t, result)
+}
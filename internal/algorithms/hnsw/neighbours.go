@@ -0,0 +1,189 @@
+package hnsw
+
+import (
+	"slices"
+	"sort"
+)
+
+// maxLinks is the most links a node can have on layer.
+func (a *Algorithm) maxLinks(layer int) int {
+	if layer == 0 {
+		return a.mMax0
+	}
+	return a.M
+}
+
+/*
+selectNeighbours picks up to m of candidates, which are sorted closest to
+node first, to link node with on layer.
+
+SelectSimple takes the m closest. On clustered data they tend to all sit in
+the same cluster, so the graph ends up with few links between clusters and
+searches get stuck in the cluster they start from.
+
+SelectHeuristic is the heuristic of the HNSW paper (algorithm 4): candidates
+are taken closest first, but one closer to an already picked neighbour than
+to node is pruned, as that neighbour already leads to it. What is left links
+node in as many directions as possible. With extendCandidates the
+neighbours of the candidates are considered as well, with keepPrunedConnections
+pruned candidates fill up the links left over.
+*/
+func (a *Algorithm) selectNeighbours(node *HNSWNode, candidates []*HNSWNode, m int, layer int) []*HNSWNode {
+	if a.selection == SelectSimple {
+		if len(candidates) > m {
+			return candidates[:m]
+		}
+		return candidates
+	}
+
+	if a.extend {
+		extended := slices.Clone(candidates)
+		for _, candidate := range candidates {
+			extended = append(extended, candidate.links(layer)...)
+		}
+		candidates = extended
+	}
+	return a.selectHeuristic(node, candidates, m, a.keepPruned)
+}
+
+// selectHeuristic is the pruning part of SelectHeuristic, see
+// selectNeighbours. candidates may be in any order and hold duplicates.
+func (a *Algorithm) selectHeuristic(node *HNSWNode, candidates []*HNSWNode, m int, keepPruned bool) []*HNSWNode {
+	seen := make(map[*HNSWNode]bool, len(candidates))
+	scored := make([]CandidateNode, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate == node || seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		scored = append(scored, CandidateNode{Node: candidate, Score: a.distance(node, candidate)})
+	}
+	sort.SliceStable(scored, func(i int, j int) bool {
+		return scored[i].Score < scored[j].Score
+	})
+
+	selected := make([]*HNSWNode, 0, m)
+	pruned := []*HNSWNode{}
+	for _, candidate := range scored {
+		if len(selected) >= m {
+			break
+		}
+		diverse := true
+		for _, neighbour := range selected {
+			if a.distance(candidate.Node, neighbour) < candidate.Score {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			selected = append(selected, candidate.Node)
+		} else {
+			pruned = append(pruned, candidate.Node)
+		}
+	}
+
+	if keepPruned {
+		for _, candidate := range pruned {
+			if len(selected) >= m {
+				break
+			}
+			selected = append(selected, candidate)
+		}
+	}
+	return selected
+}
+
+/*
+createConnection links newNode to existentNode on layer if existentNode has
+room for it. Otherwise existentNode's links are selected again among its
+current ones and newNode (see shrinkLinks), and newNode is only linked if it
+makes the cut. Neither node takes more than the maximum links of the layer.
+
+Both ends of every link are changed together, under the locks of all the
+nodes involved. The links to drop are picked before they are locked, so if
+existentNode's links changed in between the whole decision is taken again.
+*/
+func (a *Algorithm) createConnection(newNode *HNSWNode, existentNode *HNSWNode, layer int) {
+	maxLinks := a.maxLinks(layer)
+	for {
+		unlock := lockNodes(newNode, existentNode)
+		if existentNode.isConnectedTo(newNode, layer) || len(newNode.Connections[layer]) >= maxLinks {
+			unlock()
+			return
+		}
+		if len(existentNode.Connections[layer]) < maxLinks {
+			existentNode.connect(newNode, layer)
+			unlock()
+			return
+		}
+		links := slices.Clone(existentNode.Connections[layer])
+		unlock()
+
+		dropped, ok := a.shrinkLinks(existentNode, links, newNode)
+		if !ok {
+			return
+		}
+
+		unlock = lockNodes(append([]*HNSWNode{newNode, existentNode}, dropped...)...)
+		if !slices.Equal(existentNode.Connections[layer], links) || len(newNode.Connections[layer]) >= maxLinks {
+			unlock()
+			continue
+		}
+
+		// bidirectional links are dropped on both ends, a node only linked
+		// to existentNode would be cut off from the layer
+		kept := len(links) + 1
+		for _, node := range dropped {
+			if len(node.Connections[layer]) > 1 {
+				kept--
+			}
+		}
+		if kept <= maxLinks {
+			for _, node := range dropped {
+				if len(node.Connections[layer]) > 1 {
+					existentNode.disconnect(node, layer)
+				}
+			}
+			existentNode.connect(newNode, layer)
+		}
+		unlock()
+		return
+	}
+}
+
+// shrinkLinks decides which of links, the full set of links of node, to
+// drop to make room for newNode. It reports false if newNode isn't worth it.
+// SelectSimple drops the farthest link if newNode is closer, SelectHeuristic
+// selects the links again among links and newNode, which may drop several.
+func (a *Algorithm) shrinkLinks(node *HNSWNode, links []*HNSWNode, newNode *HNSWNode) ([]*HNSWNode, bool) {
+	if a.selection == SelectSimple {
+		// we need to check the farthest connection for this node
+		// if it's farther than the newNode, we pop it and connect to the newNode
+		weakestConnection := a.farthest(node, links)
+		if !(a.distance(node, newNode) < a.distance(node, weakestConnection)) {
+			return nil, false
+		}
+		return []*HNSWNode{weakestConnection}, true
+	}
+
+	selected := a.selectHeuristic(node, append(slices.Clone(links), newNode), len(links), a.keepPruned)
+	if !slices.Contains(selected, newNode) {
+		return nil, false
+	}
+	return slices.DeleteFunc(slices.Clone(links), func(link *HNSWNode) bool {
+		return slices.Contains(selected, link)
+	}), true
+}
+
+// farthest returns the candidate farthest from node, candidates can't be
+// empty.
+func (a *Algorithm) farthest(node *HNSWNode, candidates []*HNSWNode) *HNSWNode {
+	farthest := candidates[0]
+	farthestDistance := a.distance(node, farthest)
+	for _, candidate := range candidates[1:] {
+		if distance := a.distance(node, candidate); distance > farthestDistance {
+			farthest, farthestDistance = candidate, distance
+		}
+	}
+	return farthest
+}
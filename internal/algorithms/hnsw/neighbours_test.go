@@ -0,0 +1,150 @@
+package hnsw
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/vector"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pointNode(order uint64, values ...float64) *HNSWNode {
+	return &HNSWNode{
+		Entry:       algorithms.Entry{Vector: vector.Vector{Values: values}, Id: int(order)},
+		Connections: make(map[int][]*HNSWNode),
+		order:       order,
+	}
+}
+
+func TestAlgorithm_selectNeighbours(t *testing.T) {
+	node := pointNode(1, 0, 0)
+	right := pointNode(2, 1, 0)
+	behindRight := pointNode(3, 1.5, 0.1)
+	up := pointNode(4, 0, 2)
+	candidates := []*HNSWNode{right, behindRight, up}
+
+	config := DefaultConfig()
+	config.Metric = "euclidean"
+
+	config.Selection = SelectSimple
+	assert.Equal(t, []*HNSWNode{right, behindRight}, NewWithConfig(config).selectNeighbours(node, candidates, 2, 0))
+
+	// behindRight is reached through right, up opens another direction
+	config.Selection = SelectHeuristic
+	assert.Equal(t, []*HNSWNode{right, up}, NewWithConfig(config).selectNeighbours(node, candidates, 2, 0))
+	assert.Equal(t, []*HNSWNode{right, up}, NewWithConfig(config).selectNeighbours(node, candidates, 3, 0))
+
+	config.KeepPrunedConnections = true
+	assert.Equal(t, []*HNSWNode{right, up, behindRight}, NewWithConfig(config).selectNeighbours(node, candidates, 3, 0))
+
+	// the neighbours of the candidates are candidates too
+	config.KeepPrunedConnections = false
+	config.ExtendCandidates = true
+	left := pointNode(5, -1, 0)
+	up.connect(left, 0)
+	assert.Equal(t, []*HNSWNode{right, left, up}, NewWithConfig(config).selectNeighbours(node, []*HNSWNode{right, up}, 3, 0))
+}
+
+func TestAlgorithm_createConnection_Heuristic(t *testing.T) {
+	config := DefaultConfig()
+	config.Metric = "euclidean"
+	config.M = 2
+	config.Mmax0 = 2
+	alg := NewWithConfig(config)
+
+	hub := pointNode(1, 0, 0)
+	right := pointNode(2, 1, 0)
+	behindRight := pointNode(3, 1.5, 0.1)
+	other := pointNode(4, 1.5, 5)
+	hub.connect(right, 0)
+	hub.connect(behindRight, 0)
+	right.connect(behindRight, 0)
+	behindRight.connect(other, 0)
+
+	// hub is full, but both its links lead the same way: the one reached
+	// through the other is dropped to make room
+	up := pointNode(5, 0, 2)
+	alg.createConnection(up, hub, 0)
+	assert.ElementsMatch(t, []*HNSWNode{right, up}, hub.Connections[0])
+	assert.False(t, behindRight.isConnectedTo(hub, 0))
+
+	// a new link that would cut a node off the layer is not made
+	lonely := pointNode(6, 3, 3)
+	hub.disconnect(up, 0)
+	hub.connect(lonely, 0)
+	down := pointNode(7, 0, -2)
+	right.disconnect(behindRight, 0)
+	alg.createConnection(down, hub, 0)
+	assert.ElementsMatch(t, []*HNSWNode{right, lonely}, hub.Connections[0])
+	assert.Empty(t, down.Connections[0])
+}
+
+func TestAlgorithm_Mmax0(t *testing.T) {
+	config := DefaultConfig()
+	config.M = 4
+	config.EfConstruction = 32
+	config.Seed = 1
+	alg := NewWithConfig(config)
+	assert.Equal(t, 8, alg.maxLinks(0))
+	assert.Equal(t, 4, alg.maxLinks(1))
+
+	for _, entry := range algotest.UniformEntries(rand.New(rand.NewSource(1)), 300, 4) {
+		alg.AddEntry(entry)
+	}
+	assertGraphConsistent(t, alg)
+
+	layer0 := 0
+	for _, node := range alg.nodes {
+		layer0 = max(layer0, len(node.Connections[0]))
+	}
+	assert.Greater(t, layer0, config.M, "layer 0 takes more than M links")
+}
+
+// clusteredEntries spreads n entries over tight clusters far apart from each
+// other.
+func clusteredEntries(rng *rand.Rand, clusters int, n int, dims int) []algorithms.Entry {
+	centers := algotest.UniformEntries(rng, clusters, dims)
+	entries := algotest.UniformEntries(rng, n, dims)
+	for i := range entries {
+		center := centers[i%clusters].Vector.Values
+		for d, value := range entries[i].Vector.Values {
+			entries[i].Vector.Values[d] = center[d]*100 + value
+		}
+	}
+	return entries
+}
+
+func TestAlgorithm_Query_ClusteredRecall(t *testing.T) {
+	recalls := map[string]float64{}
+	for _, selection := range []string{SelectSimple, SelectHeuristic} {
+		config := DefaultConfig()
+		config.M = 4
+		config.EfConstruction = 16
+		config.EfSearch = 16
+		config.Metric = "euclidean"
+		config.Selection = selection
+		config.Seed = 1
+		config.InsertWorkers = 1
+		alg := NewWithConfig(config)
+
+		rng := rand.New(rand.NewSource(3))
+		entries := clusteredEntries(rng, 20, 1000, 4)
+		alg.AddEntries(entries)
+		assertGraphConsistent(t, alg)
+
+		hits := 0
+		for q := 0; q < 200; q++ {
+			target := entries[rng.Intn(len(entries))]
+			result := alg.Query(&target.Vector, 1, "euclidean")
+			if len(result) == 1 && result[0].Id == target.Id {
+				hits++
+			}
+		}
+		recalls[selection] = float64(hits) / 200
+	}
+
+	assert.GreaterOrEqual(t, recalls[SelectHeuristic], 0.9, "heuristic recall on clustered data: %v", recalls)
+	assert.Greater(t, recalls[SelectHeuristic], recalls[SelectSimple]+0.05, "recalls: %v", recalls)
+}
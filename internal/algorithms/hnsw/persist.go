@@ -0,0 +1,178 @@
+package hnsw
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/codec"
+	"VectorLite/internal/quantization"
+	"VectorLite/internal/vector"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const indexVersion = 1
+
+var ErrCorruptIndex = errors.New("corrupt hnsw index")
+
+/*
+SaveIndex writes the graph structure: every node with its layers and links,
+and which node is the entry point. Links point at the position of the
+neighbour in the node list.
+
+Live entries are not written, they are saved by the caller and handed back to
+LoadIndex. Tombstones are not listed anywhere else, so their vectors are
+written with them: they keep routing searches after a reload.
+
+A graph quantized to int8 writes its quantizer and the code of every node,
+the vectors of its tombstones only when originals are kept. Binary codes are
+not written, they are encoded again from the originals.
+*/
+func (a *Algorithm) SaveIndex(w io.Writer) error {
+	out := codec.NewWriter(w)
+
+	positions := make(map[*HNSWNode]uint32, len(a.nodes))
+	for i, node := range a.nodes {
+		positions[node] = uint32(i)
+	}
+
+	out.Uint32(indexVersion)
+	out.Uint32(uint32(len(a.nodes)))
+	if entryNode := a.entryNode.Load(); entryNode == nil {
+		out.Int64(-1)
+	} else {
+		out.Int64(int64(positions[entryNode]))
+	}
+	scalar, _ := a.quantizer.(*quantization.Scalar)
+	out.Bool(scalar != nil)
+	if scalar != nil {
+		scalar.Save(out)
+	}
+	originals := scalar == nil || a.quantization.KeepOriginals
+
+	for _, node := range a.nodes {
+		out.Int64(int64(node.Entry.Id))
+		out.Bool(node.deleted)
+		if node.deleted && originals {
+			out.Float64s(node.Entry.Vector.Values)
+		}
+		if scalar != nil {
+			out.Raw(node.code.Bytes)
+		}
+		out.Uint32(uint32(node.MaxLayer))
+		for layer := 0; layer <= node.MaxLayer; layer++ {
+			out.Uint32(uint32(len(node.Connections[layer])))
+			for _, neighbour := range node.Connections[layer] {
+				out.Uint32(positions[neighbour])
+			}
+		}
+	}
+	return out.Err()
+}
+
+// LoadIndex restores a graph written by SaveIndex. entries must hold exactly
+// the live entries of the saved graph.
+func (a *Algorithm) LoadIndex(r io.Reader, entries []algorithms.Entry) error {
+	if len(a.nodes) > 0 {
+		return fmt.Errorf("%w: graph is not empty", ErrCorruptIndex)
+	}
+
+	live := make(map[int]algorithms.Entry, len(entries))
+	for _, entry := range entries {
+		live[entry.Id] = entry
+	}
+
+	in := codec.NewReader(r)
+	version := in.Uint32()
+	if in.Err() == nil && version != indexVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrCorruptIndex, version)
+	}
+	count := int(in.Uint32())
+	entryPosition := in.Int64()
+	var scalar *quantization.Scalar
+	if in.Bool() {
+		var err error
+		if scalar, err = quantization.LoadScalar(in); err != nil {
+			return fmt.Errorf("%w: %v", ErrCorruptIndex, err)
+		}
+	}
+	originals := scalar == nil || a.quantization.KeepOriginals
+	if err := in.Err(); err != nil {
+		return err
+	}
+	if count > codec.MaxLength || entryPosition >= int64(count) || (entryPosition < 0 && count > 0) {
+		return fmt.Errorf("%w: bad node count or entry point", ErrCorruptIndex)
+	}
+
+	nodes := make([]*HNSWNode, count)
+	for i := range nodes {
+		nodes[i] = &HNSWNode{Connections: make(map[int][]*HNSWNode), order: a.nextOrder.Add(1), index: i}
+	}
+
+	tombstones := 0
+	for _, node := range nodes {
+		id := int(in.Int64())
+		node.deleted = in.Bool()
+		if node.deleted {
+			node.Entry.Id = id
+			if originals {
+				node.Entry.Vector.Values = in.Float64s()
+			}
+			tombstones++
+		} else if entry, exists := live[id]; exists {
+			node.Entry = entry
+			delete(live, id)
+		} else if in.Err() == nil {
+			return fmt.Errorf("%w: node %d has no entry", ErrCorruptIndex, id)
+		}
+		if scalar != nil {
+			bytes := make([]uint8, scalar.Dims())
+			in.Raw(bytes)
+			node.code = scalar.Restore(bytes)
+			if !originals {
+				node.Entry.Vector = vector.Vector{}
+			}
+		}
+
+		maxLayer := in.Uint32()
+		if in.Err() == nil && maxLayer > math.MaxInt16 {
+			return fmt.Errorf("%w: node %d has %d layers", ErrCorruptIndex, id, maxLayer)
+		}
+		node.MaxLayer = int(maxLayer)
+		for layer := 0; layer <= node.MaxLayer && in.Err() == nil; layer++ {
+			links := in.Length()
+			for j := 0; j < links && in.Err() == nil; j++ {
+				position := in.Uint32()
+				if int(position) >= count {
+					return fmt.Errorf("%w: node %d links to node %d of %d", ErrCorruptIndex, id, position, count)
+				}
+				node.Connections[layer] = append(node.Connections[layer], nodes[position])
+			}
+		}
+		if err := in.Err(); err != nil {
+			return err
+		}
+	}
+	if len(live) > 0 {
+		return fmt.Errorf("%w: %d entries are missing from the graph", ErrCorruptIndex, len(live))
+	}
+
+	a.nodes = nodes
+	if scalar != nil {
+		a.quantizer = scalar
+	} else if a.quantizer != nil {
+		for _, node := range nodes {
+			node.code = a.quantizer.Encode(node.Entry.Vector.Values)
+		}
+	}
+	for _, node := range nodes {
+		if !node.deleted {
+			a.byId[node.Entry.Id] = node
+		}
+	}
+	if entryPosition >= 0 {
+		a.entryNode.Store(nodes[entryPosition])
+	}
+	a.tombstones = tombstones
+	return nil
+}
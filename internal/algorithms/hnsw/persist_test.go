@@ -0,0 +1,167 @@
+package hnsw
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/codec"
+	"VectorLite/internal/vector"
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlgorithm_SaveLoadIndex(t *testing.T) {
+	config := DefaultConfig()
+	config.M = 8
+	config.Seed = 7
+	config.Deletion = DeleteTombstone
+	config.TombstoneRatio = 0.5
+
+	rng := rand.New(rand.NewSource(7))
+	alg := NewWithConfig(config)
+	for _, entry := range algotest.UniformEntries(rng, 300, 8) {
+		alg.AddEntry(entry)
+	}
+	for id := 1; id <= 30; id++ {
+		alg.RemoveEntry(id)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+
+	loaded := NewWithConfig(config)
+	require.NoError(t, loaded.LoadIndex(&buf, alg.ListEntries()))
+
+	require.Len(t, loaded.nodes, len(alg.nodes))
+	assert.Equal(t, alg.tombstones, loaded.tombstones)
+	assert.Equal(t, alg.entryNode.Load().Entry.Id, loaded.entryNode.Load().Entry.Id)
+	for i, node := range alg.nodes {
+		other := loaded.nodes[i]
+		assert.Equal(t, node.Entry.Id, other.Entry.Id)
+		assert.Equal(t, node.deleted, other.deleted)
+		assert.Equal(t, node.MaxLayer, other.MaxLayer)
+		for layer := 0; layer <= node.MaxLayer; layer++ {
+			require.Len(t, other.Connections[layer], len(node.Connections[layer]))
+			for j, neighbour := range node.Connections[layer] {
+				assert.Equal(t, neighbour.Entry.Id, other.Connections[layer][j].Entry.Id)
+			}
+		}
+	}
+	assertGraphConsistent(t, loaded)
+
+	for _, query := range algotest.UniformEntries(rng, 20, 8) {
+		expected := alg.Query(&query.Vector, 10, "cosine")
+		assert.Equal(t, expected, loaded.Query(&query.Vector, 10, "cosine"))
+	}
+
+	_, found := loaded.GetEntry(1)
+	assert.False(t, found, "tombstones stay deleted")
+	assert.True(t, loaded.RemoveEntry(31))
+}
+
+func TestAlgorithm_SaveLoadIndex_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, New(4, 16, 1.0).SaveIndex(&buf))
+
+	loaded := New(4, 16, 1.0)
+	require.NoError(t, loaded.LoadIndex(&buf, nil))
+	assert.Nil(t, loaded.entryNode.Load())
+
+	loaded.AddEntry(algorithms.Entry{Vector: vector.Vector{Values: []float64{1, 0}}, Id: 1})
+	assert.Len(t, loaded.Query(vector.NewVector(1, 0), 1, "cosine"), 1)
+}
+
+func TestAlgorithm_LoadIndex_MissingEntry(t *testing.T) {
+	alg := New(4, 16, 1.0)
+	for _, entry := range algotest.UniformEntries(rand.New(rand.NewSource(1)), 10, 2) {
+		alg.AddEntry(entry)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+
+	err := New(4, 16, 1.0).LoadIndex(&buf, alg.ListEntries()[1:])
+	assert.ErrorIs(t, err, ErrCorruptIndex)
+}
+
+func TestAlgorithm_LoadIndex_Truncated(t *testing.T) {
+	alg := New(4, 16, 1.0)
+	for _, entry := range algotest.UniformEntries(rand.New(rand.NewSource(1)), 10, 2) {
+		alg.AddEntry(entry)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()-3])
+	assert.Error(t, New(4, 16, 1.0).LoadIndex(truncated, alg.ListEntries()))
+}
+
+func TestAlgorithm_SaveLoadIndex_Quantized(t *testing.T) {
+	for _, keepOriginals := range []bool{false, true} {
+		config := int8Config(100, keepOriginals)
+		config.Deletion = DeleteTombstone
+		config.TombstoneRatio = 0.5
+
+		rng := rand.New(rand.NewSource(8))
+		alg := NewWithConfig(config)
+		alg.AddEntries(algotest.UniformEntries(rng, 300, 8))
+		for id := 1; id <= 30; id++ {
+			alg.RemoveEntry(id)
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, alg.SaveIndex(&buf))
+
+		loaded := NewWithConfig(config)
+		require.NoError(t, loaded.LoadIndex(&buf, alg.ListEntries()))
+		assertQuantized(t, loaded)
+		assert.Equal(t, alg.quantizer, loaded.quantizer)
+		for i, node := range alg.nodes {
+			assert.Equal(t, node.code, loaded.nodes[i].code, "codes are restored, not encoded again")
+			assert.Equal(t, node.Entry, loaded.nodes[i].Entry)
+		}
+
+		for _, query := range algotest.UniformEntries(rng, 20, 8) {
+			assert.Equal(t, alg.Query(&query.Vector, 10, "cosine"), loaded.Query(&query.Vector, 10, "cosine"))
+		}
+	}
+}
+
+func TestAlgorithm_SaveLoadIndex_Binary(t *testing.T) {
+	config := binaryConfig()
+	config.Deletion = DeleteTombstone
+	config.TombstoneRatio = 0.5
+
+	rng := rand.New(rand.NewSource(10))
+	alg := NewWithConfig(config)
+	alg.AddEntries(algotest.UniformEntries(rng, 200, 8))
+	for id := 1; id <= 20; id++ {
+		alg.RemoveEntry(id)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+
+	loaded := NewWithConfig(config)
+	require.NoError(t, loaded.LoadIndex(&buf, alg.ListEntries()))
+	assertQuantized(t, loaded)
+	for i, node := range alg.nodes {
+		assert.Equal(t, node.code, loaded.nodes[i].code, "codes are encoded again, tombstones included")
+		assert.Equal(t, node.Entry, loaded.nodes[i].Entry)
+	}
+
+	for _, query := range algotest.UniformEntries(rng, 20, 8) {
+		assert.Equal(t, alg.Query(&query.Vector, 10, "cosine"), loaded.Query(&query.Vector, 10, "cosine"))
+	}
+}
+
+func TestAlgorithm_LoadIndex_UnknownVersion(t *testing.T) {
+	var version bytes.Buffer
+	codec.NewWriter(&version).Uint32(indexVersion + 1)
+	err := New(4, 16, 1.0).LoadIndex(&version, nil)
+	assert.ErrorIs(t, err, ErrCorruptIndex)
+}
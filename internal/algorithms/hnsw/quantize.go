@@ -0,0 +1,67 @@
+package hnsw
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/quantization"
+	"VectorLite/internal/vector"
+)
+
+// Trained reports whether the graph is quantized.
+func (a *Algorithm) Trained() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.quantizer != nil
+}
+
+// trainIfDue quantizes the graph once it holds TrainSize nodes, with the
+// modes that train. It waits for
+// the inserts and queries in progress, which read the vectors it replaces.
+func (a *Algorithm) trainIfDue() {
+	if !a.quantization.Trains() {
+		return
+	}
+	a.mu.RLock()
+	due := a.quantizer == nil && len(a.nodes) >= a.quantization.TrainSize
+	a.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	a.trainMu.Lock()
+	defer a.trainMu.Unlock()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// another insert may have trained it while we waited
+	if a.quantizer != nil {
+		return
+	}
+
+	points := make([][]float64, len(a.nodes))
+	for i, node := range a.nodes {
+		points[i] = node.Entry.Vector.Values
+	}
+	a.quantizer = quantization.TrainScalar(points)
+	for _, node := range a.nodes {
+		node.code = a.quantizer.Encode(node.Entry.Vector.Values)
+		node.Entry = a.stored(node.Entry)
+	}
+}
+
+// stored returns entry as a quantized node keeps it: without its vector,
+// unless originals are kept.
+func (a *Algorithm) stored(entry algorithms.Entry) algorithms.Entry {
+	if !a.quantization.KeepOriginals {
+		entry.Vector = vector.Vector{}
+	}
+	return entry
+}
+
+// entry returns the entry of node with its vector, decoded from its code
+// when the original was dropped.
+func (a *Algorithm) entry(node *HNSWNode) algorithms.Entry {
+	entry := node.Entry
+	if !node.code.IsZero() && !a.quantization.KeepOriginals {
+		entry.Vector = vector.Vector{Values: a.quantizer.Decode(node.code)}
+	}
+	return entry
+}
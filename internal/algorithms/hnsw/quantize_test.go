@@ -0,0 +1,174 @@
+package hnsw
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/quantization"
+	"VectorLite/internal/vector"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func int8Config(trainSize int, keepOriginals bool) Config {
+	config := DefaultConfig()
+	config.M = 16
+	config.EfConstruction = 100
+	config.Seed = 1
+	config.InsertWorkers = 1
+	config.Quantization.Mode = quantization.Int8
+	config.Quantization.TrainSize = trainSize
+	config.Quantization.KeepOriginals = keepOriginals
+	return config
+}
+
+func assertQuantized(t *testing.T, alg *Algorithm) {
+	t.Helper()
+	require.True(t, alg.Trained())
+	for _, node := range alg.nodes {
+		if scalar, ok := alg.quantizer.(*quantization.Scalar); ok {
+			assert.Len(t, node.code.Bytes, scalar.Dims(), "node %d", node.Entry.Id)
+		} else {
+			assert.Len(t, node.code.Bits, (len(node.Entry.Vector.Values)+63)/64, "node %d", node.Entry.Id)
+		}
+		if alg.quantization.KeepOriginals {
+			assert.NotEmpty(t, node.Entry.Vector.Values, "node %d keeps its original", node.Entry.Id)
+		} else {
+			assert.Empty(t, node.Entry.Vector.Values, "node %d drops its original", node.Entry.Id)
+		}
+	}
+}
+
+func TestAlgorithm_Quantization(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	entries := algotest.UniformEntries(rng, 500, 8)
+	alg := NewWithConfig(int8Config(100, false))
+	for _, entry := range entries[:99] {
+		alg.AddEntry(entry)
+	}
+	assert.False(t, alg.Trained())
+	for _, entry := range entries[99:] {
+		alg.AddEntry(entry)
+	}
+	assertQuantized(t, alg)
+	assertGraphConsistent(t, alg)
+
+	listed := alg.ListEntries()
+	require.Len(t, listed, 500)
+	for i, entry := range listed[:100] {
+		assert.InDelta(t, 0, entries[i].Vector.Euclidean_distance(&entry.Vector), 0.05, "decoded vector of entry %d", entry.Id)
+	}
+
+	recall := recallAgainstBruteforce(t, alg, entries, rng)
+	assert.GreaterOrEqual(t, recall, 0.9, "int8 recall should stay high, got %.2f", recall)
+}
+
+func TestAlgorithm_Quantization_Rerank(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	entries := algotest.UniformEntries(rng, 500, 8)
+	alg := NewWithConfig(int8Config(100, true))
+	alg.AddEntries(entries)
+	assertQuantized(t, alg)
+	assert.Equal(t, entries, alg.ListEntries(), "originals are returned as they are")
+
+	queries := algotest.UniformEntries(rng, 20, 8)
+	for _, query := range queries {
+		for _, entry := range alg.Query(&query.Vector, 10, "cosine") {
+			assert.Contains(t, entries, entry)
+		}
+	}
+	recall := recallAgainstBruteforce(t, alg, entries, rng)
+	assert.GreaterOrEqual(t, recall, 0.95, "re-scored recall should be high, got %.2f", recall)
+}
+
+func TestAlgorithm_Quantization_AddEntries(t *testing.T) {
+	for _, workers := range []int{1, 8} {
+		config := int8Config(200, false)
+		config.InsertWorkers = workers
+		alg := NewWithConfig(config)
+
+		rng := rand.New(rand.NewSource(3))
+		entries := algotest.UniformEntries(rng, 500, 8)
+		alg.AddEntries(entries[:150])
+		assert.False(t, alg.Trained())
+		// the batch crosses trainSize, the graph is quantized halfway
+		alg.AddEntries(entries[150:])
+		assertQuantized(t, alg)
+		assertGraphConsistent(t, alg)
+
+		recall := recallAgainstBruteforce(t, alg, entries, rng)
+		assert.GreaterOrEqual(t, recall, 0.9, "recall with %d workers should be high, got %.2f", workers, recall)
+	}
+}
+
+func TestAlgorithm_Quantization_UpdateRemove(t *testing.T) {
+	config := int8Config(50, false)
+	config.Metric = "euclidean"
+	alg := NewWithConfig(config)
+	alg.AddEntries(algotest.UniformEntries(rand.New(rand.NewSource(4)), 100, 4))
+
+	// metadata only, as the engine does: the entry comes from GetEntry
+	entry, _ := alg.GetEntry(5)
+	node := alg.byId[5]
+	entry.Metadata = map[string]string{"name": "renamed"}
+	assert.True(t, alg.UpdateEntry(entry))
+	updated, _ := alg.GetEntry(5)
+	assert.Equal(t, entry, updated)
+	assert.Same(t, node, alg.byId[5], "the node stays where it is")
+	assert.Empty(t, node.Entry.Vector.Values)
+
+	moved := algorithms.Entry{Vector: *vector.NewVector(5, 5, 5, 5), Id: 5}
+	assert.True(t, alg.UpdateEntry(moved))
+	assert.Equal(t, 5, alg.Query(&moved.Vector, 1, "euclidean")[0].Id)
+	assertQuantized(t, alg)
+
+	assert.True(t, alg.RemoveEntry(5))
+	assert.NotEqual(t, 5, alg.Query(&moved.Vector, 1, "euclidean")[0].Id)
+	assertGraphConsistent(t, alg)
+}
+
+func binaryConfig() Config {
+	config := int8Config(0, false)
+	config.Quantization.Mode = quantization.Bits
+	return config
+}
+
+func TestAlgorithm_BinaryQuantization(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	entries := algotest.UniformEntries(rng, 500, 64)
+	alg := NewWithConfig(binaryConfig())
+	alg.AddEntries(entries[:1])
+	assertQuantized(t, alg)
+	alg.AddEntries(entries[1:])
+	assertQuantized(t, alg)
+	assertGraphConsistent(t, alg)
+	assert.Equal(t, entries, alg.ListEntries(), "originals are always kept")
+
+	rescored := recallAgainstBruteforce(t, alg, entries, rng)
+	oversampled := recallWith(t, alg, entries, rng, algorithms.QueryOptions{Oversample: 20})
+	t.Logf("recall@10: re-scored %.2f, oversampled ×20 %.2f", rescored, oversampled)
+	assert.Greater(t, oversampled, rescored)
+	assert.GreaterOrEqual(t, oversampled, 0.85)
+}
+
+func TestAlgorithm_BinaryQuantization_UpdateRemove(t *testing.T) {
+	alg := NewWithConfig(binaryConfig())
+	alg.AddEntries(algotest.UniformEntries(rand.New(rand.NewSource(6)), 100, 4))
+
+	entry, _ := alg.GetEntry(5)
+	node := alg.byId[5]
+	entry.Metadata = map[string]string{"name": "renamed"}
+	assert.True(t, alg.UpdateEntry(entry))
+	assert.Same(t, node, alg.byId[5], "the node stays where it is")
+
+	moved := algorithms.Entry{Vector: *vector.NewVector(5, -5, 5, -5), Id: 5}
+	assert.True(t, alg.UpdateEntry(moved))
+	assert.Equal(t, moved, alg.Query(&moved.Vector, 1, "euclidean")[0])
+	assertQuantized(t, alg)
+
+	assert.True(t, alg.RemoveEntry(5))
+	assert.NotEqual(t, 5, alg.Query(&moved.Vector, 1, "euclidean")[0].Id)
+	assertGraphConsistent(t, alg)
+}
@@ -0,0 +1,193 @@
+package hnsw
+
+/*
+*   This method searches for the closest nodes on a layer
+*
+*   node
+*     node we are executing the search for
+*   entryNodes
+*     starting nodes for the search
+*   layer
+*     Layer we are alt
+*   numClosest
+*     number of close nodes to return
+ */
+func (a *Algorithm) searchLayer(node *HNSWNode, entryNodes []*HNSWNode, layer int, numClosest int) []*HNSWNode {
+	return a.searchLayerFiltered(node, entryNodes, layer, numClosest, nil)
+}
+
+/*
+*   searchLayerFiltered is searchLayer where only the nodes accepted by accept
+*   are returned. Rejected nodes are still walked through, so a selective
+*   filter makes the search explore further instead of returning fewer nodes.
+*   A nil accept accepts every node.
+*
+*   candidates holds the nodes left to expand, a min-heap, and results the
+*   numClosest closest accepted nodes found so far, a max-heap so the
+*   farthest of them is the one at hand. Each node is scored once at most,
+*   visited remembers which ones were.
+ */
+func (a *Algorithm) searchLayerFiltered(node *HNSWNode, entryNodes []*HNSWNode, layer int, numClosest int, accept func(*HNSWNode) bool) []*HNSWNode {
+	visited := a.visitedSet()
+	defer a.visitedPool.Put(visited)
+	score := a.scorer(node, a.metric)
+
+	candidates := candidateHeap{}
+	results := candidateHeap{farthestFirst: true}
+
+	addResult := func(candidate CandidateNode) {
+		if accept != nil && !accept(candidate.Node) {
+			return
+		}
+		results.push(candidate)
+		if results.len() > numClosest {
+			results.pop()
+		}
+	}
+
+	for _, entryNode := range entryNodes {
+		if !visited.visit(entryNode) {
+			continue
+		}
+		candidate := CandidateNode{
+			Node:  entryNode,
+			Score: score(entryNode),
+		}
+		candidates.push(candidate)
+		addResult(candidate)
+	}
+
+	for candidates.len() > 0 {
+		current := candidates.pop()
+
+		// the closest candidate left is farther than every result, we are done
+		if results.len() >= numClosest && current.Score > results.top().Score {
+			break
+		}
+
+		for _, conn := range current.Node.links(layer) {
+			// we don't want to check visited nodes
+			if !visited.visit(conn) {
+				continue
+			}
+
+			connCandidateNode := CandidateNode{
+				Node:  conn,
+				Score: score(conn),
+			}
+			if results.len() < numClosest || connCandidateNode.Score < results.top().Score {
+				candidates.push(connCandidateNode)
+				addResult(connCandidateNode)
+			}
+		}
+	}
+
+	// popping the max-heap yields the farthest first
+	closest := make([]*HNSWNode, results.len())
+	for i := len(closest) - 1; i >= 0; i-- {
+		closest[i] = results.pop().Node
+	}
+	return closest
+}
+
+// candidateHeap is a binary heap of candidates, the closest on top or the
+// farthest if farthestFirst is set.
+type candidateHeap struct {
+	items         []CandidateNode
+	farthestFirst bool
+}
+
+func (h *candidateHeap) len() int {
+	return len(h.items)
+}
+
+func (h *candidateHeap) top() CandidateNode {
+	return h.items[0]
+}
+
+// before reports whether the item at i belongs above the item at j.
+func (h *candidateHeap) before(i int, j int) bool {
+	if h.farthestFirst {
+		return h.items[i].Score > h.items[j].Score
+	}
+	return h.items[i].Score < h.items[j].Score
+}
+
+func (h *candidateHeap) push(candidate CandidateNode) {
+	h.items = append(h.items, candidate)
+	for i := len(h.items) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !h.before(i, parent) {
+			break
+		}
+		h.items[i], h.items[parent] = h.items[parent], h.items[i]
+		i = parent
+	}
+}
+
+func (h *candidateHeap) pop() CandidateNode {
+	top := h.items[0]
+	last := len(h.items) - 1
+	h.items[0] = h.items[last]
+	h.items = h.items[:last]
+
+	for i := 0; ; {
+		first := i
+		if left := 2*i + 1; left < last && h.before(left, first) {
+			first = left
+		}
+		if right := 2*i + 2; right < last && h.before(right, first) {
+			first = right
+		}
+		if first == i {
+			break
+		}
+		h.items[i], h.items[first] = h.items[first], h.items[i]
+		i = first
+	}
+	return top
+}
+
+/*
+visitedSet marks the nodes a search went through, indexed by their order.
+Instead of clearing the marks between searches, every search stamps them
+with a new generation: a node is visited when its mark holds the current one.
+Sets are pooled per graph, so a search rarely allocates one.
+*/
+type visitedSet struct {
+	marks      []uint32
+	generation uint32
+}
+
+// visitedSet takes a set from the pool, ready for a new search.
+func (a *Algorithm) visitedSet() *visitedSet {
+	visited, _ := a.visitedPool.Get().(*visitedSet)
+	if visited == nil {
+		visited = &visitedSet{}
+	}
+
+	visited.generation++
+	if visited.generation == 0 {
+		// the stamps wrapped around, old marks could pass for new ones
+		clear(visited.marks)
+		visited.generation = 1
+	}
+
+	// nodes inserted concurrently may still outgrow it, see visit
+	if size := int(a.nextOrder.Load()) + 1; len(visited.marks) < size {
+		visited.marks = append(visited.marks, make([]uint32, size-len(visited.marks))...)
+	}
+	return visited
+}
+
+// visit marks node, it reports false if it was marked already.
+func (v *visitedSet) visit(node *HNSWNode) bool {
+	if node.order >= uint64(len(v.marks)) {
+		v.marks = append(v.marks, make([]uint32, int(node.order)+1-len(v.marks))...)
+	}
+	if v.marks[node.order] == v.generation {
+		return false
+	}
+	v.marks[node.order] = v.generation
+	return true
+}
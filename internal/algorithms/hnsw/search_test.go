@@ -0,0 +1,99 @@
+package hnsw
+
+import (
+	"VectorLite/internal/algorithms/algotest"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCandidateHeap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	scores := make([]float64, 200)
+	for i := range scores {
+		scores[i] = rng.Float64()
+	}
+
+	closest := candidateHeap{}
+	farthest := candidateHeap{farthestFirst: true}
+	for _, score := range scores {
+		closest.push(CandidateNode{Score: score})
+		farthest.push(CandidateNode{Score: score})
+	}
+	sort.Float64s(scores)
+
+	for i := range scores {
+		assert.Equal(t, scores[i], closest.top().Score)
+		assert.Equal(t, scores[i], closest.pop().Score)
+		assert.Equal(t, scores[len(scores)-1-i], farthest.pop().Score)
+	}
+	assert.Zero(t, closest.len())
+	assert.Zero(t, farthest.len())
+}
+
+func TestVisitedSet(t *testing.T) {
+	alg := New(4, 16, 1.0)
+	nodes := []*HNSWNode{alg.newNode(algotest.UniformEntries(rand.New(rand.NewSource(1)), 1, 2)[0])}
+
+	visited := alg.visitedSet()
+	assert.True(t, visited.visit(nodes[0]))
+	assert.False(t, visited.visit(nodes[0]))
+
+	// a node added after the set was sized
+	late := &HNSWNode{order: 100}
+	assert.True(t, visited.visit(late))
+	assert.False(t, visited.visit(late))
+	alg.visitedPool.Put(visited)
+
+	// the next search starts from scratch, even when the generations wrap
+	visited.generation = ^uint32(0)
+	again := alg.visitedSet()
+	require.Same(t, visited, again)
+	assert.True(t, again.visit(nodes[0]))
+	assert.True(t, again.visit(late))
+}
+
+// benchmarkGraphs caches the graphs built for the benchmarks, building the
+// one with 100k nodes takes several minutes.
+var benchmarkGraphs = map[int]*Algorithm{}
+
+func benchmarkGraph(b *testing.B, n int) *Algorithm {
+	if alg, exists := benchmarkGraphs[n]; exists {
+		return alg
+	}
+	b.Helper()
+	b.StopTimer()
+	defer b.StartTimer()
+
+	config := DefaultConfig()
+	config.EfConstruction = 64
+	config.Seed = 1
+	alg := NewWithConfig(config)
+	alg.AddEntries(algotest.UniformEntries(rand.New(rand.NewSource(1)), n, 32))
+	benchmarkGraphs[n] = alg
+	return alg
+}
+
+// BenchmarkQuery measures searches at growing graph sizes and beam widths,
+// the wider the beam the more nodes each search visits.
+func BenchmarkQuery(b *testing.B) {
+	for _, n := range []int{10_000, 100_000} {
+		for _, ef := range []int{64, 256} {
+			b.Run(fmt.Sprintf("nodes=%d/ef=%d", n, ef), func(b *testing.B) {
+				alg := benchmarkGraph(b, n)
+				alg.SetEfSearch(ef)
+				queries := algotest.UniformEntries(rand.New(rand.NewSource(2)), 100, 32)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					alg.Query(&queries[i%len(queries)].Vector, 10, "cosine")
+				}
+			})
+		}
+	}
+}
@@ -1,15 +1,120 @@
 package algorithms
 
-import "VectorLite/internal/vector"
+import (
+	"VectorLite/internal/vector"
+	"io"
+)
 
+/*
+SearchAlgorithm is an index answering nearest neighbour queries over its
+entries. Implementations are not safe for concurrent use, except for the read
+methods (GetEntry, ListEntries, Query and QueryWithOptions) between
+themselves, unless they document otherwise: the engine takes its write lock
+around every other call. The engine also checks that dense vectors, added or
+queried, all have the dimensions of the first one.
+*/
 type SearchAlgorithm interface {
 	AddEntry(entry Entry)
+	// Query returns up to k entries closest to queryVector, closest first.
 	Query(queryVector *vector.Vector, k int, metric string) []Entry
+	// QueryWithOptions is Query tuned by options, Query behaves as
+	// QueryWithOptions with zero options.
+	QueryWithOptions(queryVector *vector.Vector, k int, metric string, options QueryOptions) []Entry
 	ListEntries() []Entry
+	// RemoveEntry deletes the entry with the given id, reporting whether it
+	// was present.
+	RemoveEntry(id int) bool
+	// GetEntry returns the entry with the given id, if present.
+	GetEntry(id int) (Entry, bool)
+	// UpdateEntry replaces the stored entry with the same id, reporting
+	// whether it was present. Indexes are only rebuilt for the entry when its
+	// vector changed.
+	UpdateEntry(entry Entry) bool
+}
+
+// QueryOptions tune a single query.
+type QueryOptions struct {
+	// Filter restricts the results to the entries it accepts. Algorithms
+	// apply it while searching, so up to k matching entries are returned
+	// however selective it is.
+	Filter func(entry Entry) bool
+	// Oversample is the number of candidates per result that algorithms
+	// storing quantized vectors score again with the originals, in place of
+	// their rerank factor. Zero keeps the rerank factor, and algorithms that
+	// don't keep the originals ignore it.
+	Oversample int
+}
+
+// Accepts reports whether entry passes the options' filter.
+func (options QueryOptions) Accepts(entry Entry) bool {
+	return options.Filter == nil || options.Filter(entry)
+}
+
+// MetricBound is implemented by algorithms whose index is built around a
+// single distance metric, and can therefore only answer queries in it. The
+// metric is fixed for the lifetime of the index, and the engine rejects
+// queries in any other.
+type MetricBound interface {
+	Metric() string
+}
+
+// BatchInserter is implemented by algorithms that insert a batch of new
+// entries faster than one AddEntry call per entry, typically by inserting
+// them in parallel.
+type BatchInserter interface {
+	AddEntries(entries []Entry)
+}
+
+// Persistent is implemented by algorithms that can save their index next to
+// the entries of a snapshot, so reloading a database doesn't rebuild it.
+// Algorithms without it are rebuilt by adding the entries back one by one.
+type Persistent interface {
+	SaveIndex(w io.Writer) error
+	// LoadIndex restores an index written by SaveIndex into an empty
+	// algorithm built with the same settings. entries are the entries listed
+	// alongside the index.
+	LoadIndex(r io.Reader, entries []Entry) error
+}
+
+/*
+FileBacked is implemented by algorithms that keep their index in a file of
+their own rather than in memory. The file is placed in a directory by SetDir,
+then created empty by Create for a new index, or opened by LoadIndex for a
+saved one. Such algorithms are Persistent, and their index only stays valid
+with the file it was saved with.
+*/
+type FileBacked interface {
+	SetDir(dir string)
+	// Path returns the path of the file.
+	Path() string
+	Create() error
+	// ListStored returns the entries as ListEntries does, without their
+	// vectors, which the file holds.
+	ListStored() []Entry
+	// Reserve readies the file for adding or updating entries, or for a
+	// removal when entries is nil, so that the write can't fail. The engine
+	// calls it before logging the write.
+	Reserve(entries []Entry) error
+	// Saved tells the algorithm that the snapshot holding its last SaveIndex
+	// is durable.
+	Saved()
+	// Close releases the file, Remove deletes it.
+	Close() error
+	Remove() error
+}
+
+// SparseIndex is implemented by algorithms that index sparse vectors, see
+// vector.Vector. The engine only gives them sparse vectors, and gives sparse
+// vectors to no other algorithm.
+type SparseIndex interface {
+	SparseVectors()
 }
 
 type Entry struct {
 	Vector   vector.Vector
 	Metadata map[string]string
 	Id       int
+	// ExternalId is an optional identifier picked by the client, unique per
+	// database. Id stays the dense internal identifier.
+	ExternalId string
 }
\ No newline at end of file
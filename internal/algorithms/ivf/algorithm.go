@@ -0,0 +1,357 @@
+package ivf
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/kmeans"
+	"VectorLite/internal/vector"
+	"cmp"
+	"container/heap"
+	"math/rand"
+	"slices"
+)
+
+const (
+	DefaultNList      = 100
+	DefaultNProbe     = 8
+	DefaultIterations = 20
+	// DefaultRetrainRatio retrains the lists every time the index doubles.
+	DefaultRetrainRatio = 2.0
+)
+
+// DefaultMetric is the metric used to train and search the lists when the
+// configuration doesn't name one.
+const DefaultMetric = "cosine"
+
+// k-means needs enough points per centroid to place them well, and gains
+// little from many more: training starts at TrainPointsPerList points per
+// list and samples at most SamplePointsPerList per list.
+const (
+	TrainPointsPerList  = 39
+	SamplePointsPerList = 256
+)
+
+/*
+Algorithm is an inverted-file index (IVF-Flat). Entries are spread over nlist
+lists, each holding the entries closest to its centroid. A query only scans
+the nprobe lists whose centroids are closest to it, comparing the query with
+every entry stored there.
+
+Centroids are trained with k-means on a sample of the entries once TrainSize
+entries have arrived. Until then, every entry is kept in a single list and
+queries are exact.
+*/
+type Algorithm struct {
+	// centroids is nil until the index is trained, lists then holds one list
+	// per centroid
+	centroids    [][]float64
+	lists        [][]*item
+	byId         map[int]*item
+	trainedSize  int
+	nList        int
+	nProbe       int
+	metric       string
+	iterations   int
+	trainSize    int
+	sampleSize   int
+	retrainRatio float64
+	rng          *rand.Rand
+}
+
+// Config holds the parameters an inverted-file index is built with.
+// The metric decides which list every entry is stored in.
+type Config struct {
+	// NList is the number of lists, and of centroids trained.
+	NList int
+	// NProbe is the number of lists scanned by a query.
+	NProbe int
+	Metric string
+	// Iterations is the number of k-means rounds run when training.
+	Iterations int
+	// TrainSize is the number of entries that triggers the first training,
+	// TrainPointsPerList × NList if zero.
+	TrainSize int
+	// SampleSize is the most entries k-means is trained on,
+	// SamplePointsPerList × NList if zero.
+	SampleSize int
+	// RetrainRatio trains the index again once it holds RetrainRatio times
+	// the entries it was last trained with. Zero never retrains.
+	RetrainRatio float64
+	// Seed feeds the random source used to sample the entries and seed
+	// k-means, indexes built from the same entries in the same order with
+	// the same seed are identical.
+	Seed int64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		NList:        DefaultNList,
+		NProbe:       DefaultNProbe,
+		Metric:       DefaultMetric,
+		Iterations:   DefaultIterations,
+		RetrainRatio: DefaultRetrainRatio,
+	}
+}
+
+func New() *Algorithm {
+	return NewWithConfig(DefaultConfig())
+}
+
+func NewWithConfig(config Config) *Algorithm {
+	if config.NList < 1 {
+		config.NList = DefaultNList
+	}
+	if config.NProbe < 1 {
+		config.NProbe = DefaultNProbe
+	}
+	if config.Metric == "" {
+		config.Metric = DefaultMetric
+	}
+	if config.Iterations < 1 {
+		config.Iterations = DefaultIterations
+	}
+	if config.TrainSize < 1 {
+		config.TrainSize = TrainPointsPerList * config.NList
+	}
+	if config.SampleSize < 1 {
+		config.SampleSize = SamplePointsPerList * config.NList
+	}
+	return &Algorithm{
+		lists:        [][]*item{{}},
+		byId:         make(map[int]*item),
+		nList:        config.NList,
+		nProbe:       config.NProbe,
+		metric:       config.Metric,
+		iterations:   config.Iterations,
+		trainSize:    config.TrainSize,
+		sampleSize:   config.SampleSize,
+		retrainRatio: config.RetrainRatio,
+		rng:          rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Metric returns the distance metric the lists are trained and searched with.
+func (a *Algorithm) Metric() string {
+	return a.metric
+}
+
+// SetNProbe changes the number of lists scanned by Query.
+// Higher values improve recall at the cost of latency.
+func (a *Algorithm) SetNProbe(nProbe int) {
+	if nProbe < 1 {
+		nProbe = 1
+	}
+	a.nProbe = nProbe
+}
+
+// Trained reports whether the centroids have been trained, queries are exact
+// until then.
+func (a *Algorithm) Trained() bool {
+	return a.centroids != nil
+}
+
+// item is an entry stored in the index, at lists[list][slot].
+type item struct {
+	entry algorithms.Entry
+	list  int
+	slot  int
+}
+
+func (a *Algorithm) AddEntry(entry algorithms.Entry) {
+	list := 0
+	if a.Trained() {
+		list = a.nearestCentroid(&entry.Vector)
+	}
+	a.store(&item{entry: entry}, list)
+	a.trainIfDue()
+}
+
+// AddEntries adds a batch of entries, assigning them to their lists in
+// parallel. The index is trained at most once, after the whole batch.
+func (a *Algorithm) AddEntries(entries []algorithms.Entry) {
+	lists := make([]int, len(entries))
+	if a.Trained() {
+		points := make([][]float64, len(entries))
+		for i := range entries {
+			points[i] = a.prepare(&entries[i].Vector)
+		}
+		lists = kmeans.Assign(points, a.centroids, a.spherical())
+	}
+	for i, entry := range entries {
+		a.store(&item{entry: entry}, lists[i])
+	}
+	a.trainIfDue()
+}
+
+// store appends it to the given list.
+func (a *Algorithm) store(it *item, list int) {
+	it.list = list
+	it.slot = len(a.lists[list])
+	a.lists[list] = append(a.lists[list], it)
+	a.byId[it.entry.Id] = it
+}
+
+// unstore takes it out of its list, moving the last item of the list into
+// its slot.
+func (a *Algorithm) unstore(it *item) {
+	list := a.lists[it.list]
+	last := list[len(list)-1]
+	list[it.slot] = last
+	last.slot = it.slot
+	a.lists[it.list] = list[:len(list)-1]
+	delete(a.byId, it.entry.Id)
+}
+
+// trainIfDue trains the index once it first holds trainSize entries, then
+// every time it grew retrainRatio times past the size it was trained with.
+func (a *Algorithm) trainIfDue() {
+	size := len(a.byId)
+	if !a.Trained() {
+		if size >= a.trainSize {
+			a.Train()
+		}
+		return
+	}
+	if a.retrainRatio > 0 && float64(size) >= a.retrainRatio*float64(a.trainedSize) {
+		a.Train()
+	}
+}
+
+func (a *Algorithm) GetEntry(id int) (algorithms.Entry, bool) {
+	it, exists := a.byId[id]
+	if !exists {
+		return algorithms.Entry{}, false
+	}
+	return it.entry, true
+}
+
+// UpdateEntry swaps the stored entry in place when only its metadata
+// changed. A new vector may belong to another list, so the entry is moved to
+// the list of its nearest centroid.
+func (a *Algorithm) UpdateEntry(entry algorithms.Entry) bool {
+	it, exists := a.byId[entry.Id]
+	if !exists {
+		return false
+	}
+
+	if it.entry.Vector.Equal(&entry.Vector) || !a.Trained() {
+		it.entry = entry
+		return true
+	}
+
+	a.unstore(it)
+	a.store(&item{entry: entry}, a.nearestCentroid(&entry.Vector))
+	return true
+}
+
+// RemoveEntry takes the entry out of its list. The centroids are left as
+// they are.
+func (a *Algorithm) RemoveEntry(id int) bool {
+	it, exists := a.byId[id]
+	if !exists {
+		return false
+	}
+	a.unstore(it)
+	return true
+}
+
+// ListEntries returns the entries sorted by id.
+func (a *Algorithm) ListEntries() []algorithms.Entry {
+	entries := make([]algorithms.Entry, 0, len(a.byId))
+	for _, list := range a.lists {
+		for _, it := range list {
+			entries = append(entries, it.entry)
+		}
+	}
+	slices.SortFunc(entries, func(e1 algorithms.Entry, e2 algorithms.Entry) int {
+		return cmp.Compare(e1.Id, e2.Id)
+	})
+	return entries
+}
+
+func (a *Algorithm) Query(queryVector *vector.Vector, k int, metric string) []algorithms.Entry {
+	return a.QueryWithOptions(queryVector, k, metric, algorithms.QueryOptions{})
+}
+
+/*
+QueryWithOptions scans the nprobe lists whose centroids are closest to the
+query, with the index's metric, and returns the k entries found closest with
+the requested metric, closest first.
+
+When the filter rejects so many entries that the nprobe lists hold fewer than
+k accepted ones, the next closest lists are scanned as well until k are found
+or every list was scanned.
+*/
+func (a *Algorithm) QueryWithOptions(queryVector *vector.Vector, k int, metric string, options algorithms.QueryOptions) []algorithms.Entry {
+	if k <= 0 {
+		return []algorithms.Entry{}
+	}
+
+	results := &resultHeap{}
+	for i, list := range a.probeOrder(queryVector) {
+		if i >= a.nProbe && results.Len() >= k {
+			break
+		}
+		for _, it := range a.lists[list] {
+			if !options.Accepts(it.entry) {
+				continue
+			}
+			score := queryVector.Distance_score(&it.entry.Vector, metric)
+			if results.Len() < k {
+				heap.Push(results, scoredItem{item: it, score: score})
+			} else if score < (*results)[0].score {
+				(*results)[0] = scoredItem{item: it, score: score}
+				heap.Fix(results, 0)
+			}
+		}
+	}
+
+	entries := make([]algorithms.Entry, results.Len())
+	for i := len(entries) - 1; i >= 0; i-- {
+		entries[i] = heap.Pop(results).(scoredItem).item.entry
+	}
+	return entries
+}
+
+// probeOrder lists the lists by the distance of their centroid to
+// queryVector with the index's metric, closest first.
+func (a *Algorithm) probeOrder(queryVector *vector.Vector) []int {
+	if !a.Trained() {
+		return []int{0}
+	}
+
+	query := a.prepare(queryVector)
+	order := make([]int, len(a.centroids))
+	scores := make([]float64, len(a.centroids))
+	for i, centroid := range a.centroids {
+		order[i] = i
+		scores[i] = kmeans.Distance(query, centroid, a.spherical())
+	}
+	slices.SortFunc(order, func(i int, j int) int {
+		return cmp.Compare(scores[i], scores[j])
+	})
+	return order
+}
+
+type scoredItem struct {
+	item  *item
+	score float64
+}
+
+// resultHeap is a max-heap on score, the farthest result found so far is on
+// top, ready to be replaced.
+type resultHeap []scoredItem
+
+func (h resultHeap) Len() int           { return len(h) }
+func (h resultHeap) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h resultHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *resultHeap) Push(x any) {
+	*h = append(*h, x.(scoredItem))
+}
+
+func (h *resultHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
@@ -0,0 +1,250 @@
+package ivf
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/vector"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertListsConsistent checks that every entry is stored once, where byId
+// says, and in the list of its nearest centroid once trained.
+func assertListsConsistent(t *testing.T, alg *Algorithm) {
+	t.Helper()
+	stored := 0
+	for list, items := range alg.lists {
+		for slot, it := range items {
+			stored++
+			assert.Equal(t, list, it.list, "entry %d", it.entry.Id)
+			assert.Equal(t, slot, it.slot, "entry %d", it.entry.Id)
+			assert.Same(t, it, alg.byId[it.entry.Id])
+			if alg.Trained() {
+				assert.Equal(t, alg.nearestCentroid(&it.entry.Vector), list, "entry %d is in the wrong list", it.entry.Id)
+			}
+		}
+	}
+	assert.Equal(t, len(alg.byId), stored)
+	if alg.Trained() {
+		assert.Len(t, alg.lists, len(alg.centroids))
+	} else {
+		assert.Len(t, alg.lists, 1)
+	}
+}
+
+func TestAlgorithm_Untrained(t *testing.T) {
+	alg := NewWithConfig(Config{NList: 4, NProbe: 1, TrainSize: 100})
+	rng := rand.New(rand.NewSource(1))
+	entries := algotest.UniformEntries(rng, 99, 4)
+	for _, entry := range entries {
+		alg.AddEntry(entry)
+	}
+
+	assert.False(t, alg.Trained())
+	assertListsConsistent(t, alg)
+	for _, query := range algotest.UniformEntries(rng, 10, 4) {
+		assert.Equal(t, algotest.Exact(entries, &query.Vector, 5, "cosine"), alg.Query(&query.Vector, 5, "cosine"), "untrained queries are exact")
+	}
+}
+
+func TestAlgorithm_Train(t *testing.T) {
+	alg := NewWithConfig(Config{NList: 8, TrainSize: 200, Seed: 1})
+	rng := rand.New(rand.NewSource(1))
+	for _, entry := range algotest.UniformEntries(rng, 200, 4) {
+		alg.AddEntry(entry)
+	}
+
+	require.True(t, alg.Trained())
+	assert.Len(t, alg.centroids, 8)
+	assert.Equal(t, 200, alg.trainedSize)
+	assertListsConsistent(t, alg)
+
+	for _, entry := range algotest.UniformEntries(rng, 50, 4) {
+		entry.Id += 200
+		alg.AddEntry(entry)
+	}
+	assert.Equal(t, 200, alg.trainedSize, "new entries go to the existing lists")
+	assertListsConsistent(t, alg)
+}
+
+func TestAlgorithm_Train_FewerEntriesThanLists(t *testing.T) {
+	alg := NewWithConfig(Config{NList: 16})
+	alg.AddEntries(algotest.UniformEntries(rand.New(rand.NewSource(1)), 5, 3))
+	alg.Train()
+
+	assert.Len(t, alg.centroids, 5)
+	assertListsConsistent(t, alg)
+
+	empty := New()
+	empty.Train()
+	assert.False(t, empty.Trained())
+}
+
+func TestAlgorithm_nearestCentroid(t *testing.T) {
+	// the nearest centroid is the closest with Distance_score
+	rng := rand.New(rand.NewSource(3))
+	for _, metric := range []string{"cosine", "dot_product", "euclidean"} {
+		alg := NewWithConfig(Config{Metric: metric})
+		for _, entry := range algotest.UniformEntries(rng, 8, 5) {
+			alg.centroids = append(alg.centroids, alg.prepare(&entry.Vector))
+		}
+
+		for _, entry := range algotest.UniformEntries(rng, 50, 5) {
+			best, bestScore := 0, math.Inf(1)
+			for i, centroid := range alg.centroids {
+				if score := entry.Vector.Distance_score(vector.NewVector(centroid...), metric); score < bestScore {
+					best, bestScore = i, score
+				}
+			}
+			assert.Equal(t, best, alg.nearestCentroid(&entry.Vector), metric)
+		}
+	}
+}
+
+func TestAlgorithm_Retrain(t *testing.T) {
+	tests := []struct {
+		name         string
+		retrainRatio float64
+		trainedSize  int
+	}{
+		{"every time the index doubles", 2, 400},
+		{"never", 0, 100},
+		{"as soon as it grows by half", 1.5, 338},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alg := NewWithConfig(Config{NList: 4, TrainSize: 100, RetrainRatio: tt.retrainRatio})
+			for _, entry := range algotest.UniformEntries(rand.New(rand.NewSource(1)), 400, 4) {
+				alg.AddEntry(entry)
+			}
+			assert.Equal(t, tt.trainedSize, alg.trainedSize)
+			assertListsConsistent(t, alg)
+		})
+	}
+}
+
+func TestAlgorithm_AddEntries(t *testing.T) {
+	alg := NewWithConfig(Config{NList: 8, TrainSize: 100, RetrainRatio: 2, Seed: 3})
+	rng := rand.New(rand.NewSource(3))
+	entries := algotest.UniformEntries(rng, 1000, 8)
+
+	alg.AddEntries(entries[:500])
+	assert.Equal(t, 500, alg.trainedSize, "a batch is trained once, after it is stored")
+	assertListsConsistent(t, alg)
+
+	alg.AddEntries(entries[500:700])
+	assert.Equal(t, 500, alg.trainedSize)
+	assertListsConsistent(t, alg)
+
+	alg.AddEntries(entries[700:])
+	assert.Equal(t, 1000, alg.trainedSize)
+	assert.Len(t, alg.ListEntries(), 1000)
+	assertListsConsistent(t, alg)
+
+	alg.AddEntries(nil)
+	assert.Len(t, alg.ListEntries(), 1000)
+}
+
+func TestAlgorithm_Query_Recall(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	entries := algotest.ClusteredEntries(rng, 3000, 16, 20, 0.1)
+	queries := algotest.ClusteredEntries(rng, 30, 16, 20, 0.1)
+
+	config := DefaultConfig()
+	config.NList = 32
+	config.Metric = "euclidean"
+	config.Seed = 5
+	alg := NewWithConfig(config)
+	alg.AddEntries(entries)
+	require.True(t, alg.Trained())
+
+	truth := algotest.Neighbours(entries, queries, 10, "euclidean")
+
+	alg.SetNProbe(1)
+	narrow := algotest.Recall(alg, queries, truth, alg.Metric())
+	alg.SetNProbe(8)
+	wide := algotest.Recall(alg, queries, truth, alg.Metric())
+	alg.SetNProbe(32)
+	all := algotest.Recall(alg, queries, truth, alg.Metric())
+
+	assert.GreaterOrEqual(t, wide, narrow)
+	assert.GreaterOrEqual(t, wide, 0.9)
+	assert.Equal(t, 1.0, all, "probing every list is exact")
+}
+
+func TestAlgorithm_Query_Filter(t *testing.T) {
+	alg := NewWithConfig(Config{NList: 16, NProbe: 1, TrainSize: 100, Seed: 2})
+	rng := rand.New(rand.NewSource(2))
+	entries := algotest.UniformEntries(rng, 1000, 4)
+	alg.AddEntries(entries)
+
+	options := algorithms.QueryOptions{Filter: func(entry algorithms.Entry) bool {
+		return entry.Id%100 == 0
+	}}
+	for _, query := range algotest.UniformEntries(rng, 10, 4) {
+		results := alg.QueryWithOptions(&query.Vector, 5, "cosine", options)
+		require.Len(t, results, 5, "more lists are scanned until k entries match")
+		for _, entry := range results {
+			assert.Zero(t, entry.Id%100)
+		}
+	}
+
+	assert.Len(t, alg.QueryWithOptions(&entries[0].Vector, 50, "cosine", options), 10, "every matching entry")
+}
+
+func TestAlgorithm_Query_Empty(t *testing.T) {
+	alg := New()
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 5, "cosine"))
+
+	alg.AddEntry(algorithms.Entry{Vector: *vector.NewVector(1, 0), Id: 1})
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 0, "cosine"))
+	assert.Len(t, alg.Query(vector.NewVector(1, 0), 5, "cosine"), 1)
+}
+
+func TestAlgorithm_RemoveUpdate(t *testing.T) {
+	alg := NewWithConfig(Config{NList: 8, TrainSize: 100, Seed: 4})
+	rng := rand.New(rand.NewSource(4))
+	entries := algotest.UniformEntries(rng, 300, 4)
+	alg.AddEntries(entries)
+
+	for id := 1; id <= 300; id += 3 {
+		assert.True(t, alg.RemoveEntry(id))
+	}
+	assert.False(t, alg.RemoveEntry(1))
+	assert.Len(t, alg.ListEntries(), 200)
+	assertListsConsistent(t, alg)
+
+	_, exists := alg.GetEntry(1)
+	assert.False(t, exists)
+	assert.False(t, alg.UpdateEntry(algorithms.Entry{Vector: *vector.NewVector(1, 1, 1, 1), Id: 1}))
+
+	renamed := entries[1]
+	renamed.Metadata = map[string]string{"name": "renamed"}
+	assert.True(t, alg.UpdateEntry(renamed))
+	entry, _ := alg.GetEntry(2)
+	assert.Equal(t, "renamed", entry.Metadata["name"])
+
+	// moved to the other side of the space, it belongs to another list
+	moved := entries[2]
+	moved.Vector = vector.Vector{Values: slices.Clone(moved.Vector.Values)}
+	for d := range moved.Vector.Values {
+		moved.Vector.Values[d] = -moved.Vector.Values[d]
+	}
+	assert.True(t, alg.UpdateEntry(moved))
+	assertListsConsistent(t, alg)
+	assert.Equal(t, moved.Id, alg.Query(&moved.Vector, 1, "cosine")[0].Id)
+}
+
+func TestAlgorithm_ListEntries(t *testing.T) {
+	alg := NewWithConfig(Config{NList: 4, TrainSize: 10})
+	entries := algotest.UniformEntries(rand.New(rand.NewSource(6)), 40, 3)
+	alg.AddEntries(entries)
+
+	assert.Equal(t, entries, alg.ListEntries(), "sorted by id whatever the lists")
+}
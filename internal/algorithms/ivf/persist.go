@@ -0,0 +1,97 @@
+package ivf
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/codec"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const indexVersion = 1
+
+var ErrCorruptIndex = errors.New("corrupt ivf index")
+
+/*
+SaveIndex writes the trained centroids and the ids of the entries of every
+list, so a reload neither trains the index again nor assigns the entries to
+their lists. An untrained index is written without centroids.
+
+Entries are not written, they are saved by the caller and handed back to
+LoadIndex.
+*/
+func (a *Algorithm) SaveIndex(w io.Writer) error {
+	out := codec.NewWriter(w)
+	out.Uint32(indexVersion)
+	out.Uint64(uint64(a.trainedSize))
+	out.Uint32(uint32(len(a.centroids)))
+	for _, centroid := range a.centroids {
+		out.Float64s(centroid)
+	}
+	for _, list := range a.lists {
+		out.Uint32(uint32(len(list)))
+		for _, it := range list {
+			out.Int64(int64(it.entry.Id))
+		}
+	}
+	return out.Err()
+}
+
+// LoadIndex restores an index written by SaveIndex. entries must hold exactly
+// the entries of the saved index.
+func (a *Algorithm) LoadIndex(r io.Reader, entries []algorithms.Entry) error {
+	if len(a.byId) > 0 {
+		return fmt.Errorf("%w: index is not empty", ErrCorruptIndex)
+	}
+
+	byId := make(map[int]algorithms.Entry, len(entries))
+	for _, entry := range entries {
+		byId[entry.Id] = entry
+	}
+
+	in := codec.NewReader(r)
+	if version := in.Uint32(); in.Err() == nil && version != indexVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrCorruptIndex, version)
+	}
+	trainedSize := in.Uint64()
+	count := in.Length()
+	if err := in.Err(); err != nil {
+		return err
+	}
+
+	centroids := make([][]float64, count)
+	for i := range centroids {
+		centroids[i] = in.Float64s()
+	}
+
+	lists := make([][]*item, max(count, 1))
+	items := make(map[int]*item, len(entries))
+	for list := range lists {
+		length := in.Length()
+		for slot := 0; slot < length && in.Err() == nil; slot++ {
+			id := int(in.Int64())
+			entry, exists := byId[id]
+			if !exists && in.Err() == nil {
+				return fmt.Errorf("%w: list %d holds unknown entry %d", ErrCorruptIndex, list, id)
+			}
+			delete(byId, id)
+			it := &item{entry: entry, list: list, slot: slot}
+			lists[list] = append(lists[list], it)
+			items[id] = it
+		}
+		if err := in.Err(); err != nil {
+			return err
+		}
+	}
+	if len(byId) > 0 {
+		return fmt.Errorf("%w: %d entries are missing from the lists", ErrCorruptIndex, len(byId))
+	}
+
+	if count > 0 {
+		a.centroids = centroids
+	}
+	a.lists = lists
+	a.byId = items
+	a.trainedSize = int(trainedSize)
+	return nil
+}
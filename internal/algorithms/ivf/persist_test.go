@@ -0,0 +1,90 @@
+package ivf
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/codec"
+	"VectorLite/internal/vector"
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlgorithm_SaveLoadIndex(t *testing.T) {
+	config := Config{NList: 8, TrainSize: 100, Seed: 7}
+	rng := rand.New(rand.NewSource(7))
+	alg := NewWithConfig(config)
+	alg.AddEntries(algotest.UniformEntries(rng, 300, 8))
+	for id := 1; id <= 30; id++ {
+		alg.RemoveEntry(id)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+
+	loaded := NewWithConfig(config)
+	require.NoError(t, loaded.LoadIndex(&buf, alg.ListEntries()))
+
+	assert.Equal(t, alg.centroids, loaded.centroids)
+	assert.Equal(t, alg.trainedSize, loaded.trainedSize)
+	require.Len(t, loaded.lists, len(alg.lists))
+	for list := range alg.lists {
+		require.Len(t, loaded.lists[list], len(alg.lists[list]))
+		for slot, it := range alg.lists[list] {
+			assert.Equal(t, it.entry, loaded.lists[list][slot].entry)
+		}
+	}
+	assertListsConsistent(t, loaded)
+
+	for _, query := range algotest.UniformEntries(rng, 20, 8) {
+		assert.Equal(t, alg.Query(&query.Vector, 10, "cosine"), loaded.Query(&query.Vector, 10, "cosine"))
+	}
+}
+
+func TestAlgorithm_SaveLoadIndex_Untrained(t *testing.T) {
+	alg := New()
+	entries := algotest.UniformEntries(rand.New(rand.NewSource(1)), 10, 2)
+	alg.AddEntries(entries)
+
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+
+	loaded := New()
+	require.NoError(t, loaded.LoadIndex(&buf, entries))
+	assert.False(t, loaded.Trained())
+	assert.Equal(t, entries, loaded.ListEntries())
+	assertListsConsistent(t, loaded)
+
+	loaded.AddEntry(algorithms.Entry{Vector: *vector.NewVector(1, 0), Id: 11})
+	assert.Len(t, loaded.ListEntries(), 11)
+}
+
+func TestAlgorithm_LoadIndex_Corrupt(t *testing.T) {
+	alg := NewWithConfig(Config{NList: 2, TrainSize: 10})
+	entries := algotest.UniformEntries(rand.New(rand.NewSource(1)), 10, 2)
+	alg.AddEntries(entries)
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+	saved := buf.Bytes()
+
+	err := New().LoadIndex(bytes.NewReader(saved), entries[1:])
+	assert.ErrorIs(t, err, ErrCorruptIndex, "an entry of the lists is missing")
+
+	extra := append(entries, algorithms.Entry{Vector: *vector.NewVector(1, 1), Id: 11})
+	err = New().LoadIndex(bytes.NewReader(saved), extra)
+	assert.ErrorIs(t, err, ErrCorruptIndex, "an entry is in no list")
+
+	err = New().LoadIndex(bytes.NewReader(saved[:len(saved)-3]), entries)
+	assert.Error(t, err)
+
+	var version bytes.Buffer
+	codec.NewWriter(&version).Uint32(indexVersion + 1)
+	err = New().LoadIndex(&version, entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex)
+
+	err = alg.LoadIndex(bytes.NewReader(saved), entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex, "the index is not empty")
+}
@@ -0,0 +1,63 @@
+package ivf
+
+import (
+	"VectorLite/internal/kmeans"
+	"VectorLite/internal/vector"
+)
+
+/*
+Train runs k-means on a sample of the entries and moves every entry to the
+list of its nearest centroid. It is called by AddEntry and AddEntries once
+enough entries have arrived, and can be called again to fit the lists to
+entries that changed since.
+
+An index holding fewer entries than nlist is trained with one centroid per
+entry. Empty indexes are left untrained.
+*/
+func (a *Algorithm) Train() {
+	items := make([]*item, 0, len(a.byId))
+	for _, list := range a.lists {
+		items = append(items, list...)
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	points := make([][]float64, len(items))
+	for i, it := range items {
+		points[i] = a.prepare(&it.entry.Vector)
+	}
+	sample := kmeans.Sample(points, a.sampleSize, a.rng)
+	a.centroids = kmeans.Train(sample, kmeans.Config{
+		K:          a.nList,
+		Iterations: a.iterations,
+		Spherical:  a.spherical(),
+	}, a.rng)
+	a.trainedSize = len(items)
+
+	lists := kmeans.Assign(points, a.centroids, a.spherical())
+	a.lists = make([][]*item, len(a.centroids))
+	for i, it := range items {
+		a.store(it, lists[i])
+	}
+}
+
+// spherical reports whether the centroids are trained by direction, as both
+// angular metrics only compare the angle between vectors.
+func (a *Algorithm) spherical() bool {
+	return a.metric != "euclidean"
+}
+
+// prepare returns the values centroids are compared with for v: normalized
+// once here for the angular metrics, unchanged for euclidean.
+func (a *Algorithm) prepare(v *vector.Vector) []float64 {
+	if a.spherical() {
+		return kmeans.Normalize(v.Values)
+	}
+	return v.Values
+}
+
+// nearestCentroid returns the position of the centroid closest to v.
+func (a *Algorithm) nearestCentroid(v *vector.Vector) int {
+	return kmeans.Nearest(a.prepare(v), a.centroids, a.spherical())
+}
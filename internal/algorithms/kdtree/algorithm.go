@@ -0,0 +1,345 @@
+package kdtree
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/kmeans"
+	"VectorLite/internal/vector"
+	"cmp"
+	"container/heap"
+	"math"
+	"slices"
+)
+
+const DefaultLeafSize = 16
+
+// DefaultMetric is the metric the tree is built for when the configuration
+// doesn't name one. Low-dimensional vectors are mostly coordinates.
+const DefaultMetric = "euclidean"
+
+/*
+Algorithm is an exact k-d tree. Every node cuts the box bounding its entries
+in two, at the median of the dimension they spread the most along, until
+leaves hold at most LeafSize entries. A query goes down the tree nearest
+side first and skips every node whose box lies farther than the k-th closest
+entry found so far, so it answers exactly what bruteforce answers, in the
+same order, while scoring a small share of the entries. Only entries at the
+same distance may come in another order.
+
+The tree pays off on low-dimensional vectors, up to about 16 dimensions:
+boxes stop excluding anything as dimensions grow, and queries end up scoring
+every entry. Cosine trees index the vectors scaled to unit length, where
+euclidean distances rank entries as cosine does.
+
+Entries added one at a time go down to their leaf, which is split when it
+grows past LeafSize. The tree is rebuilt, balanced again, once as many entries
+were added or removed as it was built with.
+*/
+type Algorithm struct {
+	// root is nil until the first entry arrives
+	root *node
+	byId map[int]*item
+	// built is the number of entries the tree was last built with, changes
+	// the number added or removed since
+	built    int
+	changes  int
+	leafSize int
+	metric   string
+	// added counts the entries ever added, ordering them as bruteforce does
+	added int
+}
+
+// Config holds the parameters a k-d tree is built with. Cosine trees index
+// unit vectors.
+type Config struct {
+	// LeafSize is the most entries a leaf holds before it is split.
+	LeafSize int
+	// Metric is euclidean or cosine.
+	Metric string
+}
+
+// item is an entry stored in the tree, at point in the leaf leaf.
+type item struct {
+	entry algorithms.Entry
+	point []float64
+	leaf  *node
+	// order is the rank of the entry among the entries ever added, an
+	// update keeps it
+	order int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		LeafSize: DefaultLeafSize,
+		Metric:   DefaultMetric,
+	}
+}
+
+func New() *Algorithm {
+	return NewWithConfig(DefaultConfig())
+}
+
+func NewWithConfig(config Config) *Algorithm {
+	if config.LeafSize < 1 {
+		config.LeafSize = DefaultLeafSize
+	}
+	if config.Metric != "cosine" {
+		config.Metric = DefaultMetric
+	}
+	return &Algorithm{
+		byId:     make(map[int]*item),
+		leafSize: config.LeafSize,
+		metric:   config.Metric,
+	}
+}
+
+// Metric returns the distance metric the tree is built for.
+func (a *Algorithm) Metric() string {
+	return a.metric
+}
+
+// point returns the point of values in the tree: values scaled to unit
+// length for cosine.
+func (a *Algorithm) point(values []float64) []float64 {
+	if a.metric == "cosine" {
+		return kmeans.Normalize(values)
+	}
+	return values
+}
+
+func (a *Algorithm) newItem(entry algorithms.Entry, order int) *item {
+	return &item{entry: entry, point: a.point(entry.Vector.Values), order: order}
+}
+
+// rebuild builds the tree again from all the entries of the index.
+func (a *Algorithm) rebuild() {
+	a.built = len(a.byId)
+	a.changes = 0
+	if len(a.byId) == 0 {
+		a.root = nil
+		return
+	}
+	items := make([]*item, 0, len(a.byId))
+	for _, it := range a.byId {
+		items = append(items, it)
+	}
+	a.root = a.build(items)
+}
+
+// changed counts one entry added or removed, rebuilding the tree when they
+// add up to the entries it was built with.
+func (a *Algorithm) changed() {
+	a.changes++
+	if a.changes >= max(a.built, a.leafSize) {
+		a.rebuild()
+	}
+}
+
+func (a *Algorithm) AddEntry(entry algorithms.Entry) {
+	a.added++
+	a.store(a.newItem(entry, a.added))
+	a.changed()
+}
+
+// AddEntries adds a batch of entries, building the tree again with them when
+// they are at least as many as the entries it was built with.
+func (a *Algorithm) AddEntries(entries []algorithms.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	if a.changes+len(entries) < max(a.built, a.leafSize) {
+		for _, entry := range entries {
+			a.AddEntry(entry)
+		}
+		return
+	}
+	for _, entry := range entries {
+		a.added++
+		it := a.newItem(entry, a.added)
+		a.byId[entry.Id] = it
+	}
+	a.rebuild()
+}
+
+// store inserts it in the tree.
+func (a *Algorithm) store(it *item) {
+	a.byId[it.entry.Id] = it
+	if a.root == nil {
+		a.root = a.build([]*item{it})
+		return
+	}
+	a.insert(it)
+}
+
+func (a *Algorithm) GetEntry(id int) (algorithms.Entry, bool) {
+	it, exists := a.byId[id]
+	if !exists {
+		return algorithms.Entry{}, false
+	}
+	return it.entry, true
+}
+
+// UpdateEntry swaps the stored entry in place when only its metadata
+// changed. A new vector moves the entry to a new leaf.
+func (a *Algorithm) UpdateEntry(entry algorithms.Entry) bool {
+	it, exists := a.byId[entry.Id]
+	if !exists {
+		return false
+	}
+
+	if it.entry.Vector.Equal(&entry.Vector) {
+		it.entry = entry
+		return true
+	}
+
+	remove(it)
+	a.store(a.newItem(entry, it.order))
+	a.changed()
+	return true
+}
+
+func (a *Algorithm) RemoveEntry(id int) bool {
+	it, exists := a.byId[id]
+	if !exists {
+		return false
+	}
+	remove(it)
+	delete(a.byId, id)
+	a.changed()
+	return true
+}
+
+// ListEntries returns the entries sorted by id.
+func (a *Algorithm) ListEntries() []algorithms.Entry {
+	entries := make([]algorithms.Entry, 0, len(a.byId))
+	for _, it := range a.byId {
+		entries = append(entries, it.entry)
+	}
+	slices.SortFunc(entries, func(e1 algorithms.Entry, e2 algorithms.Entry) int {
+		return cmp.Compare(e1.Id, e2.Id)
+	})
+	return entries
+}
+
+func (a *Algorithm) Query(queryVector *vector.Vector, k int, metric string) []algorithms.Entry {
+	return a.QueryWithOptions(queryVector, k, metric, algorithms.QueryOptions{})
+}
+
+/*
+QueryWithOptions returns the k entries accepted by the filter that are closest
+with the requested metric, closest first. Of the entries at the same
+distance, the earliest added are kept.
+
+The tree only prunes for the metric it was built for, queries in any other
+metric score every entry.
+*/
+func (a *Algorithm) QueryWithOptions(queryVector *vector.Vector, k int, metric string, options algorithms.QueryOptions) []algorithms.Entry {
+	if k <= 0 || a.root == nil {
+		return []algorithms.Entry{}
+	}
+
+	s := search{
+		query:   queryVector,
+		point:   a.point(queryVector.Values),
+		k:       k,
+		metric:  metric,
+		options: options,
+		prune:   metric == a.metric,
+	}
+	s.visit(a, a.root)
+
+	// popping the max-heap yields the farthest first
+	entries := make([]algorithms.Entry, len(s.results))
+	for i := len(entries) - 1; i >= 0; i-- {
+		entries[i] = heap.Pop(&s.results).(result).entry
+	}
+	return entries
+}
+
+// search is the state of a query going down the tree.
+type search struct {
+	query   *vector.Vector
+	point   []float64
+	k       int
+	metric  string
+	options algorithms.QueryOptions
+	prune   bool
+	results resultHeap
+}
+
+// visit scores the entries below n that may be closer than the k-th closest
+// found so far, nearest child first.
+func (s *search) visit(a *Algorithm, n *node) {
+	if n.leaf() {
+		for _, it := range n.items {
+			if s.options.Accepts(it.entry) {
+				s.offer(result{entry: it.entry, score: s.query.Distance_score(&it.entry.Vector, s.metric), order: it.order})
+			}
+		}
+		return
+	}
+
+	near, far := n.left, n.right
+	if s.point[n.dim] >= n.split {
+		near, far = far, near
+	}
+	if !s.skips(a, near) {
+		s.visit(a, near)
+	}
+	if !s.skips(a, far) {
+		s.visit(a, far)
+	}
+}
+
+// skips reports whether no entry in the box of n can make the results. Bounds
+// are lowered by a margin, so that rounding never skips an entry the scores
+// of Distance_score would keep.
+func (s *search) skips(a *Algorithm, n *node) bool {
+	if !s.prune || len(s.results) < s.k {
+		return false
+	}
+	worst := s.results[0].score
+	bound := a.bound(n, s.point)
+	return bound-1e-9*(1+math.Abs(bound)) > worst
+}
+
+// offer keeps r when it is among the k best so far.
+func (s *search) offer(r result) {
+	if len(s.results) < s.k {
+		heap.Push(&s.results, r)
+		return
+	}
+	if s.results.worse(s.results[0], r) {
+		s.results[0] = r
+		heap.Fix(&s.results, 0)
+	}
+}
+
+// result is an entry scored by a query.
+type result struct {
+	entry algorithms.Entry
+	score float64
+	order int
+}
+
+// resultHeap is a max-heap of results, the worst on top: the highest score,
+// and at equal scores the last added.
+type resultHeap []result
+
+func (h resultHeap) worse(r1 result, r2 result) bool {
+	return r1.score > r2.score || r1.score == r2.score && r1.order > r2.order
+}
+
+func (h resultHeap) Len() int           { return len(h) }
+func (h resultHeap) Less(i, j int) bool { return h.worse(h[i], h[j]) }
+func (h resultHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *resultHeap) Push(x any) {
+	*h = append(*h, x.(result))
+}
+
+func (h *resultHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
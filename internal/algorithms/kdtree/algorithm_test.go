@@ -0,0 +1,216 @@
+package kdtree
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/algorithms/bruteforce"
+	"VectorLite/internal/vector"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clusteredEntries returns entries gathered around a few centres, spread
+// unevenly over the dimensions as coordinates and features are.
+func clusteredEntries(rng *rand.Rand, n int, dims int) []algorithms.Entry {
+	centres := algotest.NormalEntries(rng, 8, dims)
+	entries := make([]algorithms.Entry, n)
+	for i := range entries {
+		centre := centres[rng.Intn(len(centres))].Vector.Values
+		values := make([]float64, dims)
+		for d := range values {
+			values[d] = 10*centre[d] + rng.NormFloat64()*float64(d+1)/float64(dims)
+		}
+		entries[i] = algorithms.Entry{Vector: vector.Vector{Values: values}, Id: i + 1}
+	}
+	return entries
+}
+
+// assertSameAnswers checks that alg answers queries exactly as bf.
+func assertSameAnswers(t *testing.T, bf *bruteforce.Algorithm, alg *Algorithm, queries []algorithms.Entry, options algorithms.QueryOptions) {
+	t.Helper()
+	for _, k := range []int{1, 10, 100} {
+		for _, query := range queries {
+			expected := bf.QueryWithOptions(&query.Vector, k, alg.Metric(), options)
+			results := alg.QueryWithOptions(&query.Vector, k, alg.Metric(), options)
+			require.Equal(t, expected, results, "k=%d", k)
+			for i := 1; i < len(results); i++ {
+				require.LessOrEqual(t, query.Vector.Distance_score(&results[i-1].Vector, alg.Metric()), query.Vector.Distance_score(&results[i].Vector, alg.Metric()), "closest first")
+			}
+		}
+	}
+}
+
+func TestAlgorithm_MatchesBruteforce(t *testing.T) {
+	for _, metric := range []string{"euclidean", "cosine"} {
+		for _, dims := range []int{2, 3, 8, 16} {
+			t.Run(fmt.Sprintf("%s/%d", metric, dims), func(t *testing.T) {
+				rng := rand.New(rand.NewSource(int64(dims)))
+				for _, entries := range [][]algorithms.Entry{algotest.NormalEntries(rng, 2000, dims), clusteredEntries(rng, 2000, dims)} {
+					bf := bruteforce.New()
+					alg := NewWithConfig(Config{Metric: metric})
+					for _, entry := range entries {
+						bf.AddEntry(entry)
+					}
+					alg.AddEntries(entries)
+
+					assertSameAnswers(t, bf, alg, algotest.NormalEntries(rng, 20, dims), algorithms.QueryOptions{})
+					// entries are their own nearest neighbours
+					assertSameAnswers(t, bf, alg, entries[:20], algorithms.QueryOptions{})
+				}
+			})
+		}
+	}
+}
+
+func TestAlgorithm_MatchesBruteforce_Filter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	entries := clusteredEntries(rng, 2000, 4)
+	bf := bruteforce.New()
+	alg := New()
+	for _, entry := range entries {
+		bf.AddEntry(entry)
+	}
+	alg.AddEntries(entries)
+
+	options := algorithms.QueryOptions{Filter: func(entry algorithms.Entry) bool {
+		return entry.Id%7 == 0
+	}}
+	assertSameAnswers(t, bf, alg, algotest.NormalEntries(rng, 20, 4), options)
+}
+
+func TestAlgorithm_MatchesBruteforce_Writes(t *testing.T) {
+	for _, metric := range []string{"euclidean", "cosine"} {
+		rng := rand.New(rand.NewSource(2))
+		entries := clusteredEntries(rng, 3000, 3)
+		bf := bruteforce.New()
+		alg := NewWithConfig(Config{LeafSize: 4, Metric: metric})
+		// one by one, with the tree built and split as they arrive
+		for _, entry := range entries[:1500] {
+			bf.AddEntry(entry)
+			alg.AddEntry(entry)
+		}
+		for _, id := range rng.Perm(1500)[:300] {
+			assert.Equal(t, bf.RemoveEntry(id+1), alg.RemoveEntry(id+1))
+		}
+		for _, entry := range clusteredEntries(rng, 200, 3) {
+			entry.Id += 100
+			assert.Equal(t, bf.UpdateEntry(entry), alg.UpdateEntry(entry))
+		}
+		bf2 := entries[1500:]
+		for _, entry := range bf2 {
+			bf.AddEntry(entry)
+		}
+		alg.AddEntries(bf2)
+		assert.Equal(t, len(bf.ListEntries()), len(alg.ListEntries()))
+
+		assertSameAnswers(t, bf, alg, algotest.NormalEntries(rng, 20, 3), algorithms.QueryOptions{})
+		assertTree(t, alg)
+	}
+}
+
+func TestAlgorithm_Pruning(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	entries := clusteredEntries(rng, 20000, 3)
+	alg := New()
+	alg.AddEntries(entries)
+
+	scored := 0
+	options := algorithms.QueryOptions{Filter: func(entry algorithms.Entry) bool {
+		scored++
+		return true
+	}}
+	queries := algotest.NormalEntries(rng, 20, 3)
+	for _, query := range queries {
+		alg.QueryWithOptions(&query.Vector, 10, "euclidean", options)
+	}
+	t.Logf("%d entries scored per query", scored/len(queries))
+	assert.Less(t, scored/len(queries), len(entries)/50, "boxes farther than the results are skipped")
+}
+
+func TestAlgorithm_Duplicates(t *testing.T) {
+	bf := bruteforce.New()
+	alg := NewWithConfig(Config{LeafSize: 2})
+	for i := 0; i < 100; i++ {
+		entry := algorithms.Entry{Vector: *vector.NewVector(float64(i%3), 1), Id: i + 1}
+		bf.AddEntry(entry)
+		alg.AddEntry(entry)
+	}
+	assertTree(t, alg)
+	query := vector.NewVector(0, 1)
+	results := alg.Query(query, 40, "euclidean")
+	expected := bf.Query(query, 40, "euclidean")
+	require.Len(t, results, len(expected))
+	for i := range results {
+		assert.Equal(t, query.Distance_score(&expected[i].Vector, "euclidean"), query.Distance_score(&results[i].Vector, "euclidean"))
+	}
+	ids := []int{}
+	for _, entry := range results[len(results)-6:] {
+		ids = append(ids, entry.Id)
+	}
+	assert.ElementsMatch(t, []int{2, 5, 8, 11, 14, 17}, ids, "the earliest added of the entries at the k-th distance are kept")
+}
+
+func TestAlgorithm_Query_OtherMetric(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	entries := algotest.NormalEntries(rng, 500, 4)
+	bf := bruteforce.New()
+	alg := New()
+	for _, entry := range entries {
+		bf.AddEntry(entry)
+	}
+	alg.AddEntries(entries)
+
+	query := algotest.NormalEntries(rng, 1, 4)[0].Vector
+	assert.Equal(t, bf.Query(&query, 10, "dot_product"), alg.Query(&query, 10, "dot_product"),
+		"other metrics score every entry")
+}
+
+func TestAlgorithm_Query_Empty(t *testing.T) {
+	alg := New()
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 5, "euclidean"))
+	alg.AddEntry(algorithms.Entry{Vector: *vector.NewVector(1, 0), Id: 1})
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 0, "euclidean"))
+	assert.Len(t, alg.Query(vector.NewVector(1, 0), 5, "euclidean"), 1)
+	alg.RemoveEntry(1)
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 5, "euclidean"))
+}
+
+func TestAlgorithm_RemoveUpdate(t *testing.T) {
+	alg := New()
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(5)), 100, 2)
+	alg.AddEntries(entries)
+
+	entry := entries[4]
+	entry.Metadata = map[string]string{"name": "renamed"}
+	it := alg.byId[5]
+	assert.True(t, alg.UpdateEntry(entry))
+	assert.Same(t, it, alg.byId[5], "metadata changes keep the entry in its leaf")
+	got, _ := alg.GetEntry(5)
+	assert.Equal(t, entry, got)
+
+	moved := algorithms.Entry{Vector: *vector.NewVector(9, 9), Id: 5}
+	assert.True(t, alg.UpdateEntry(moved))
+	assert.Equal(t, moved, alg.Query(&moved.Vector, 1, "euclidean")[0])
+
+	assert.True(t, alg.RemoveEntry(5))
+	assert.False(t, alg.RemoveEntry(5))
+	assert.False(t, alg.UpdateEntry(moved))
+	assert.False(t, slices.ContainsFunc(alg.Query(&moved.Vector, 100, "euclidean"), func(entry algorithms.Entry) bool {
+		return entry.Id == 5
+	}))
+	assertTree(t, alg)
+}
+
+func TestAlgorithm_ListEntries(t *testing.T) {
+	alg := New()
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(6)), 50, 2)
+	for i := len(entries) - 1; i >= 0; i-- {
+		alg.AddEntry(entries[i])
+	}
+	assert.Equal(t, entries, alg.ListEntries(), "sorted by id")
+}
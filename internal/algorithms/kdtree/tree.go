@@ -0,0 +1,185 @@
+package kdtree
+
+import (
+	"cmp"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+/*
+node is a node of the tree, bounding the points below it with the box of
+corners lower and upper. Internal nodes cut their box along dimension dim:
+points below split go left, the others right. Leaves hold the items.
+
+Boxes are only grown, by inserts, never shrunk: they stay a valid bound when
+items leave, at worst a loose one.
+*/
+type node struct {
+	lower, upper []float64
+	dim          int
+	split        float64
+	left, right  *node
+	items        []*item
+	// count is the number of items the node was built with plus the number
+	// inserted below it since, removals aside
+	count int
+}
+
+func (n *node) leaf() bool {
+	return n.left == nil
+}
+
+// build returns the root of a tree holding items, cut at the median of the
+// dimension they spread the most along until leaves hold at most leafSize
+// items. Items that don't spread at all stay in a single leaf.
+func (a *Algorithm) build(items []*item) *node {
+	n := &node{lower: slices.Clone(items[0].point), upper: slices.Clone(items[0].point), count: len(items)}
+	for _, it := range items[1:] {
+		n.grow(it.point)
+	}
+
+	n.dim = n.widest()
+	if len(items) <= a.leafSize || n.upper[n.dim] == n.lower[n.dim] {
+		n.items = items
+		for _, it := range items {
+			it.leaf = n
+		}
+		return n
+	}
+
+	slices.SortFunc(items, func(it1 *item, it2 *item) int {
+		return cmp.Compare(it1.point[n.dim], it2.point[n.dim])
+	})
+	middle := len(items) / 2
+	// equal coordinates go right, as inserts send them
+	for middle > 0 && items[middle-1].point[n.dim] == items[middle].point[n.dim] {
+		middle--
+	}
+	if middle == 0 {
+		middle = len(items) / 2
+		for items[middle].point[n.dim] == items[0].point[n.dim] {
+			middle++
+		}
+	}
+	n.split = items[middle].point[n.dim]
+	n.left = a.build(slices.Clone(items[:middle]))
+	n.right = a.build(slices.Clone(items[middle:]))
+	return n
+}
+
+// widest returns the dimension the box of n is the widest along.
+func (n *node) widest() int {
+	widest := 0
+	for d := range n.lower {
+		if n.upper[d]-n.lower[d] > n.upper[widest]-n.lower[widest] {
+			widest = d
+		}
+	}
+	return widest
+}
+
+// grow extends the box of n to hold point.
+func (n *node) grow(point []float64) {
+	for d, value := range point {
+		n.lower[d] = min(n.lower[d], value)
+		n.upper[d] = max(n.upper[d], value)
+	}
+}
+
+/*
+insert adds it to its leaf, growing the boxes on the way, and splits the
+leaf when it grows past leafSize.
+
+Inserts in a sorted order all land in the same leaf and would grow the tree
+into a list: when the path to the leaf gets longer than twice the depth of a
+balanced tree, the highest node whose child holds more than 3/4 of its items
+is rebuilt, as in a scapegoat tree.
+*/
+func (a *Algorithm) insert(it *item) {
+	path := []*node{}
+	n := a.root
+	for {
+		n.grow(it.point)
+		n.count++
+		path = append(path, n)
+		if n.leaf() {
+			break
+		}
+		if it.point[n.dim] < n.split {
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	n.items = append(n.items, it)
+	it.leaf = n
+	if len(n.items) > a.leafSize {
+		a.replace(n, n.items)
+	}
+
+	if len(path) <= 2*bits.Len(uint(len(a.byId))) {
+		return
+	}
+	for i, parent := range path[:len(path)-1] {
+		if path[i+1].count*4 > parent.count*3 {
+			a.replace(parent, parent.collect(nil))
+			return
+		}
+	}
+}
+
+// replace makes n the root of a subtree built from items.
+func (a *Algorithm) replace(n *node, items []*item) {
+	*n = *a.build(items)
+	if n.leaf() {
+		for _, it := range n.items {
+			it.leaf = n
+		}
+	}
+}
+
+// collect appends the items below n to items.
+func (n *node) collect(items []*item) []*item {
+	if n.leaf() {
+		return append(items, n.items...)
+	}
+	return n.right.collect(n.left.collect(items))
+}
+
+// remove takes it out of its leaf.
+func remove(it *item) {
+	n := it.leaf
+	i := slices.Index(n.items, it)
+	n.items[i] = n.items[len(n.items)-1]
+	n.items[len(n.items)-1] = nil
+	n.items = n.items[:len(n.items)-1]
+}
+
+// squaredDistance returns the squared euclidean distance from point to the
+// box of n, 0 inside it.
+func (n *node) squaredDistance(point []float64) float64 {
+	distance := 0.0
+	for d, value := range point {
+		var gap float64
+		if value < n.lower[d] {
+			gap = n.lower[d] - value
+		} else if value > n.upper[d] {
+			gap = value - n.upper[d]
+		}
+		distance += gap * gap
+	}
+	return distance
+}
+
+// bound returns the lowest score an entry in the box of n may have against
+// point, for the metric of the tree: the euclidean distance to the box, or
+// for cosine its counterpart between unit vectors, (1 - cos)/2 being a
+// quarter of their squared distance.
+func (a *Algorithm) bound(n *node, point []float64) float64 {
+	distance := n.squaredDistance(point)
+	if a.metric == "cosine" {
+		return distance / 4
+	}
+	return math.Sqrt(distance)
+}
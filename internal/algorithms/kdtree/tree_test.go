@@ -0,0 +1,75 @@
+package kdtree
+
+import (
+	"VectorLite/internal/algorithms/algotest"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertTree checks that the tree holds every entry once, in the leaf its
+// item points to, inside the boxes of the leaf and of its ancestors.
+func assertTree(t *testing.T, alg *Algorithm) {
+	t.Helper()
+	held := 0
+	var walk func(n *node, ancestors []*node)
+	walk = func(n *node, ancestors []*node) {
+		ancestors = append(ancestors, n)
+		if !n.leaf() {
+			walk(n.left, ancestors)
+			walk(n.right, ancestors)
+			return
+		}
+		for _, it := range n.items {
+			assert.Same(t, n, it.leaf, "entry %d points to its leaf", it.entry.Id)
+			assert.Same(t, it, alg.byId[it.entry.Id])
+			for _, ancestor := range ancestors {
+				assert.Zero(t, ancestor.squaredDistance(it.point), "entry %d is in the boxes above it", it.entry.Id)
+			}
+		}
+		held += len(n.items)
+	}
+	if alg.root != nil {
+		walk(alg.root, nil)
+	}
+	assert.Equal(t, len(alg.byId), held)
+}
+
+// depth returns the depth of the deepest leaf below n.
+func depth(n *node) int {
+	if n.leaf() {
+		return 1
+	}
+	return 1 + max(depth(n.left), depth(n.right))
+}
+
+func TestBuild(t *testing.T) {
+	alg := NewWithConfig(Config{LeafSize: 8})
+	alg.AddEntries(algotest.NormalEntries(rand.New(rand.NewSource(7)), 1024, 3))
+	assertTree(t, alg)
+	assert.Equal(t, 8, depth(alg.root), "median splits halve 1024 entries down to leaves of 8")
+}
+
+func TestBuild_Rebuild(t *testing.T) {
+	alg := NewWithConfig(Config{LeafSize: 4})
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(8)), 1000, 2)
+	// sorted along a line, the worst order for a tree split as it grows
+	for i := range entries {
+		entries[i].Vector.Values[0] = float64(i)
+		alg.AddEntry(entries[i])
+	}
+	assertTree(t, alg)
+	assert.LessOrEqual(t, depth(alg.root), 2*10, "unbalanced subtrees are rebuilt")
+	assert.Equal(t, 512, alg.built)
+}
+
+func TestNode_SquaredDistance(t *testing.T) {
+	n := &node{lower: []float64{0, 0}, upper: []float64{2, 1}}
+	assert.Zero(t, n.squaredDistance([]float64{1, 1}))
+	assert.Equal(t, 4.0, n.squaredDistance([]float64{4, 0.5}))
+	assert.Equal(t, 2.0, n.squaredDistance([]float64{-1, 2}))
+
+	cosine := NewWithConfig(Config{Metric: "cosine"})
+	assert.Equal(t, 0.25, cosine.bound(n, []float64{-1, 1}), "a quarter of the squared distance")
+}
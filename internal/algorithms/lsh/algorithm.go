@@ -0,0 +1,289 @@
+package lsh
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/kmeans"
+	"VectorLite/internal/vector"
+	"cmp"
+	"math/rand"
+	"slices"
+)
+
+const (
+	DefaultTables = 8
+	DefaultBits   = 12
+	DefaultProbes = 8
+	// DefaultBucketWidth suits vectors whose near neighbours are about 1
+	// apart, it should be tuned to the distances of the data.
+	DefaultBucketWidth = 4.0
+	// MaxBits is the most hash bits of a table, random-hyperplane keys are
+	// packed in a uint64.
+	MaxBits = 64
+)
+
+// DefaultMetric is the metric the hash functions are drawn for when the
+// configuration doesn't name one.
+const DefaultMetric = "cosine"
+
+/*
+Algorithm is a locality-sensitive hashing index. Every one of its tables
+hashes an entry to a bucket with Bits random functions, such that close
+vectors likely share a bucket and distant ones likely don't. A query gathers
+the entries of its own bucket and of Probes neighbouring buckets in every
+table, and ranks these candidates by their exact distance.
+
+Angular metrics (cosine, dot_product) hash with random hyperplanes, euclidean
+with p-stable projections cut in intervals of BucketWidth. More bits make
+buckets smaller and queries faster but miss more neighbours, more tables and
+probes find the missed neighbours again at the cost of more candidates: the
+false-negative rate is tuned with Tables and Probes.
+
+Entries never shared a bucket with the query are not found, a query may
+return fewer than k entries even when the index holds more, and filters only
+apply to the candidates.
+
+The hash functions are drawn from Seed when the first entry arrives, for the
+dimensions of its vector.
+*/
+type Algorithm struct {
+	// tables is nil until the first entry arrives
+	tables      []*table
+	byId        map[int]*item
+	nTables     int
+	bits        int
+	probes      int
+	bucketWidth float64
+	metric      string
+	seed        int64
+}
+
+// Config holds the parameters an LSH index is built with. The metric decides
+// the hash functions.
+type Config struct {
+	// Tables is the number of hash tables.
+	Tables int
+	// Bits is the number of hash functions per table, at most MaxBits.
+	Bits int
+	// Probes is the number of buckets visited in every table besides the
+	// bucket of the query.
+	Probes int
+	// BucketWidth is the width of the intervals of the euclidean hash
+	// functions.
+	BucketWidth float64
+	Metric      string
+	// Seed feeds the random source the hash functions are drawn from.
+	Seed int64
+}
+
+// item is an entry stored in the index, in the bucket keys[t] of table t.
+type item struct {
+	entry algorithms.Entry
+	keys  []uint64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Tables:      DefaultTables,
+		Bits:        DefaultBits,
+		Probes:      DefaultProbes,
+		BucketWidth: DefaultBucketWidth,
+		Metric:      DefaultMetric,
+	}
+}
+
+func New() *Algorithm {
+	return NewWithConfig(DefaultConfig())
+}
+
+func NewWithConfig(config Config) *Algorithm {
+	if config.Tables < 1 {
+		config.Tables = DefaultTables
+	}
+	if config.Bits < 1 || config.Bits > MaxBits {
+		config.Bits = DefaultBits
+	}
+	if config.Probes < 0 {
+		config.Probes = 0
+	}
+	if config.BucketWidth <= 0 {
+		config.BucketWidth = DefaultBucketWidth
+	}
+	if config.Metric == "" {
+		config.Metric = DefaultMetric
+	}
+	return &Algorithm{
+		byId:        make(map[int]*item),
+		nTables:     config.Tables,
+		bits:        config.Bits,
+		probes:      config.Probes,
+		bucketWidth: config.BucketWidth,
+		metric:      config.Metric,
+		seed:        config.Seed,
+	}
+}
+
+// Metric returns the distance metric the hash functions are drawn for.
+func (a *Algorithm) Metric() string {
+	return a.metric
+}
+
+// SetProbes changes the number of neighbouring buckets visited per table.
+// Higher values find more neighbours at the cost of latency.
+func (a *Algorithm) SetProbes(probes int) {
+	a.probes = max(probes, 0)
+}
+
+// init draws the hash functions for vectors of dims dimensions.
+func (a *Algorithm) init(dims int) {
+	width := 0.0
+	if a.metric == "euclidean" {
+		width = a.bucketWidth
+	}
+	rng := rand.New(rand.NewSource(a.seed))
+	a.tables = make([]*table, a.nTables)
+	for t := range a.tables {
+		a.tables[t] = newTable(a.bits, dims, width, rng)
+	}
+}
+
+// hash returns the bucket of values in every table.
+func (a *Algorithm) hash(values []float64) []uint64 {
+	keys := make([]uint64, len(a.tables))
+	for t, table := range a.tables {
+		keys[t] = table.key(table.project(values))
+	}
+	return keys
+}
+
+func (a *Algorithm) AddEntry(entry algorithms.Entry) {
+	if a.tables == nil {
+		a.init(len(entry.Vector.Values))
+	}
+	a.store(&item{entry: entry, keys: a.hash(entry.Vector.Values)})
+}
+
+// AddEntries adds a batch of entries, hashing them in parallel.
+func (a *Algorithm) AddEntries(entries []algorithms.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	if a.tables == nil {
+		a.init(len(entries[0].Vector.Values))
+	}
+	items := make([]*item, len(entries))
+	kmeans.Parallel(len(entries), func(i int) {
+		items[i] = &item{entry: entries[i], keys: a.hash(entries[i].Vector.Values)}
+	})
+	for _, it := range items {
+		a.store(it)
+	}
+}
+
+// store adds it to its buckets.
+func (a *Algorithm) store(it *item) {
+	for t, table := range a.tables {
+		table.add(it.keys[t], it)
+	}
+	a.byId[it.entry.Id] = it
+}
+
+// unstore takes it out of its buckets.
+func (a *Algorithm) unstore(it *item) {
+	for t, table := range a.tables {
+		table.remove(it.keys[t], it)
+	}
+	delete(a.byId, it.entry.Id)
+}
+
+func (a *Algorithm) GetEntry(id int) (algorithms.Entry, bool) {
+	it, exists := a.byId[id]
+	if !exists {
+		return algorithms.Entry{}, false
+	}
+	return it.entry, true
+}
+
+// UpdateEntry swaps the stored entry in place when only its metadata
+// changed. A new vector is hashed again, to new buckets.
+func (a *Algorithm) UpdateEntry(entry algorithms.Entry) bool {
+	it, exists := a.byId[entry.Id]
+	if !exists {
+		return false
+	}
+
+	if it.entry.Vector.Equal(&entry.Vector) {
+		it.entry = entry
+		return true
+	}
+
+	a.unstore(it)
+	a.store(&item{entry: entry, keys: a.hash(entry.Vector.Values)})
+	return true
+}
+
+func (a *Algorithm) RemoveEntry(id int) bool {
+	it, exists := a.byId[id]
+	if !exists {
+		return false
+	}
+	a.unstore(it)
+	return true
+}
+
+// ListEntries returns the entries sorted by id.
+func (a *Algorithm) ListEntries() []algorithms.Entry {
+	entries := make([]algorithms.Entry, 0, len(a.byId))
+	for _, it := range a.byId {
+		entries = append(entries, it.entry)
+	}
+	slices.SortFunc(entries, func(e1 algorithms.Entry, e2 algorithms.Entry) int {
+		return cmp.Compare(e1.Id, e2.Id)
+	})
+	return entries
+}
+
+func (a *Algorithm) Query(queryVector *vector.Vector, k int, metric string) []algorithms.Entry {
+	return a.QueryWithOptions(queryVector, k, metric, algorithms.QueryOptions{})
+}
+
+/*
+QueryWithOptions gathers the entries accepted by the filter from the bucket
+of the query and its probes nearest buckets in every table, and returns the k
+candidates closest with the requested metric, closest first.
+*/
+func (a *Algorithm) QueryWithOptions(queryVector *vector.Vector, k int, metric string, options algorithms.QueryOptions) []algorithms.Entry {
+	if k <= 0 {
+		return []algorithms.Entry{}
+	}
+
+	seen := make(map[*item]bool)
+	type candidate struct {
+		entry algorithms.Entry
+		score float64
+	}
+	candidates := []candidate{}
+	for _, table := range a.tables {
+		projections := table.project(queryVector.Values)
+		keys := append([]uint64{table.key(projections)}, table.probes(projections, a.probes)...)
+		for _, key := range keys {
+			for _, it := range table.buckets[key] {
+				if seen[it] {
+					continue
+				}
+				seen[it] = true
+				if options.Accepts(it.entry) {
+					candidates = append(candidates, candidate{entry: it.entry, score: queryVector.Distance_score(&it.entry.Vector, metric)})
+				}
+			}
+		}
+	}
+
+	slices.SortStableFunc(candidates, func(c1 candidate, c2 candidate) int {
+		return cmp.Or(cmp.Compare(c1.score, c2.score), cmp.Compare(c1.entry.Id, c2.entry.Id))
+	})
+	entries := make([]algorithms.Entry, min(k, len(candidates)))
+	for i := range entries {
+		entries[i] = candidates[i].entry
+	}
+	return entries
+}
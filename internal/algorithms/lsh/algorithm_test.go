@@ -0,0 +1,185 @@
+package lsh
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/vector"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nearDuplicates returns a copy of every entry moved by noise in every
+// dimension, with the id of the original.
+func nearDuplicates(rng *rand.Rand, entries []algorithms.Entry, noise float64) []algorithms.Entry {
+	duplicates := make([]algorithms.Entry, len(entries))
+	for i, entry := range entries {
+		values := slices.Clone(entry.Vector.Values)
+		for d := range values {
+			values[d] += rng.NormFloat64() * noise
+		}
+		duplicates[i] = algorithms.Entry{Vector: vector.Vector{Values: values}, Id: entry.Id}
+	}
+	return duplicates
+}
+
+// found is the share of queries whose original, the entry with their id,
+// comes first.
+func found(alg *Algorithm, queries []algorithms.Entry, metric string) float64 {
+	hits := 0
+	for _, query := range queries {
+		results := alg.Query(&query.Vector, 1, metric)
+		if len(results) > 0 && results[0].Id == query.Id {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(queries))
+}
+
+// candidates counts the entries a query scores.
+func candidates(alg *Algorithm, query *vector.Vector) int {
+	return len(alg.Query(query, len(alg.byId), alg.Metric()))
+}
+
+func TestAlgorithm_NearDuplicates(t *testing.T) {
+	for _, metric := range []string{"cosine", "euclidean"} {
+		rng := rand.New(rand.NewSource(1))
+		entries := algotest.NormalEntries(rng, 5000, 32)
+		alg := NewWithConfig(Config{Metric: metric, Seed: 1})
+		alg.AddEntries(entries)
+
+		queries := nearDuplicates(rng, entries[:200], 0.05)
+		share := found(alg, queries, metric)
+		scanned := 0
+		for _, query := range queries {
+			scanned += candidates(alg, &query.Vector)
+		}
+		t.Logf("%s: %.3f of near duplicates found, %d candidates per query", metric, share, scanned/len(queries))
+		assert.GreaterOrEqual(t, share, 0.98, metric)
+		assert.Less(t, scanned/len(queries), len(entries)/4, "%s: lookups are sub-linear", metric)
+	}
+}
+
+func TestAlgorithm_Tuning(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	entries := algotest.NormalEntries(rng, 3000, 16)
+	queries := nearDuplicates(rng, entries[:200], 0.15)
+
+	config := Config{Tables: 2, Bits: 8, Probes: 0, Metric: "euclidean", BucketWidth: 2, Seed: 2}
+	alg := NewWithConfig(config)
+	alg.AddEntries(entries)
+	base := found(alg, queries, "euclidean")
+
+	alg.SetProbes(16)
+	probed := found(alg, queries, "euclidean")
+
+	config.Tables = 8
+	more := NewWithConfig(config)
+	more.AddEntries(entries)
+	tables := found(more, queries, "euclidean")
+
+	t.Logf("found: %.3f, with 16 probes %.3f, with 8 tables %.3f", base, probed, tables)
+	assert.Greater(t, probed, base, "probes find missed neighbours")
+	assert.Greater(t, tables, base, "tables find missed neighbours")
+
+	// probes only add buckets, the candidates of a query grow with them
+	alg.SetProbes(0)
+	before := candidates(alg, &queries[0].Vector)
+	alg.SetProbes(16)
+	assert.GreaterOrEqual(t, candidates(alg, &queries[0].Vector), before)
+}
+
+func TestAlgorithm_Query_Exact(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	entries := algotest.NormalEntries(rng, 300, 8)
+	// one bit, every probe: the two buckets of every table hold every entry
+	alg := NewWithConfig(Config{Tables: 1, Bits: 1, Probes: 1, Seed: 3})
+	alg.AddEntries(entries)
+
+	query := algotest.NormalEntries(rng, 1, 8)[0].Vector
+	assert.Equal(t, algotest.Exact(entries, &query, 10, "cosine"), alg.Query(&query, 10, "cosine"), "candidates are ranked exactly, closest first")
+}
+
+func TestAlgorithm_Seed(t *testing.T) {
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(4)), 500, 8)
+	query := entries[0].Vector
+
+	a1 := NewWithConfig(Config{Seed: 4})
+	a2 := NewWithConfig(Config{Seed: 4})
+	for _, entry := range entries {
+		a1.AddEntry(entry)
+	}
+	a2.AddEntries(entries)
+	assert.Equal(t, a1.Query(&query, 10, "cosine"), a2.Query(&query, 10, "cosine"))
+	for id, it := range a1.byId {
+		assert.Equal(t, it.keys, a2.byId[id].keys, "entry %d", id)
+	}
+}
+
+func TestAlgorithm_Query_Filter(t *testing.T) {
+	alg := NewWithConfig(Config{Tables: 1, Bits: 1, Probes: 1})
+	rng := rand.New(rand.NewSource(5))
+	alg.AddEntries(algotest.NormalEntries(rng, 300, 8))
+
+	options := algorithms.QueryOptions{Filter: func(entry algorithms.Entry) bool {
+		return entry.Id%30 == 0
+	}}
+	results := alg.QueryWithOptions(&algotest.NormalEntries(rng, 1, 8)[0].Vector, 20, "cosine", options)
+	require.Len(t, results, 10)
+	for _, entry := range results {
+		assert.Zero(t, entry.Id%30)
+	}
+}
+
+func TestAlgorithm_Query_Empty(t *testing.T) {
+	alg := New()
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 5, "cosine"))
+	alg.AddEntry(algorithms.Entry{Vector: *vector.NewVector(1, 0), Id: 1})
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 0, "cosine"))
+	assert.Len(t, alg.Query(vector.NewVector(1, 0), 5, "cosine"), 1)
+}
+
+func TestAlgorithm_RemoveUpdate(t *testing.T) {
+	alg := NewWithConfig(Config{Metric: "euclidean", Seed: 6})
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(6)), 100, 4)
+	alg.AddEntries(entries)
+
+	entry := entries[4]
+	entry.Metadata = map[string]string{"name": "renamed"}
+	it := alg.byId[5]
+	assert.True(t, alg.UpdateEntry(entry))
+	assert.Same(t, it, alg.byId[5], "metadata changes keep the entry in its buckets")
+	got, _ := alg.GetEntry(5)
+	assert.Equal(t, entry, got)
+
+	moved := algorithms.Entry{Vector: *vector.NewVector(9, 9, 9, 9), Id: 5}
+	assert.True(t, alg.UpdateEntry(moved))
+	assert.Equal(t, moved, alg.Query(&moved.Vector, 1, "euclidean")[0])
+
+	assert.True(t, alg.RemoveEntry(5))
+	assert.False(t, alg.RemoveEntry(5))
+	assert.False(t, alg.UpdateEntry(moved))
+	for _, entry := range alg.Query(&moved.Vector, 100, "euclidean") {
+		assert.NotEqual(t, 5, entry.Id)
+	}
+	for _, table := range alg.tables {
+		total := 0
+		for _, bucket := range table.buckets {
+			assert.NotEmpty(t, bucket, "empty buckets are dropped")
+			total += len(bucket)
+		}
+		assert.Equal(t, 99, total)
+	}
+}
+
+func TestAlgorithm_ListEntries(t *testing.T) {
+	alg := New()
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(7)), 50, 4)
+	for i := len(entries) - 1; i >= 0; i-- {
+		alg.AddEntry(entries[i])
+	}
+	assert.Equal(t, entries, alg.ListEntries(), "sorted by id")
+}
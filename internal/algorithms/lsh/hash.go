@@ -0,0 +1,224 @@
+package lsh
+
+import (
+	"cmp"
+	"container/heap"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+/*
+table is one hash table: bits hash functions combined into the key of a
+bucket. A function projects vectors on a random direction drawn from a
+Gaussian, which is 2-stable: the projections of two vectors differ by their
+distance times a Gaussian.
+
+Random-hyperplane functions (width 0) keep the sign of the projection, one
+bit of the key: two vectors at angle θ get different bits with probability
+θ/π. P-stable functions (width > 0) cut the projection in intervals of width,
+shifted by a random offset: vectors closer than width mostly fall in the same
+interval.
+*/
+type table struct {
+	// directions[j] is the direction projected on by function j
+	directions [][]float64
+	// offsets[j] is the shift of the intervals of function j, in widths
+	offsets []float64
+	width   float64
+	buckets map[uint64][]*item
+}
+
+func newTable(bits int, dims int, width float64, rng *rand.Rand) *table {
+	t := &table{
+		directions: make([][]float64, bits),
+		width:      width,
+		buckets:    make(map[uint64][]*item),
+	}
+	for j := range t.directions {
+		t.directions[j] = make([]float64, dims)
+		for d := range t.directions[j] {
+			t.directions[j][d] = rng.NormFloat64()
+		}
+	}
+	if width > 0 {
+		t.offsets = make([]float64, bits)
+		for j := range t.offsets {
+			t.offsets[j] = rng.Float64()
+		}
+	}
+	return t
+}
+
+// project returns the projection of values by every function, in widths and
+// shifted by the offset for p-stable functions. Values missing from values
+// count as 0, extra ones are ignored.
+func (t *table) project(values []float64) []float64 {
+	projections := make([]float64, len(t.directions))
+	for j, direction := range t.directions {
+		dot := 0.0
+		for d, value := range values[:min(len(values), len(direction))] {
+			dot += value * direction[d]
+		}
+		if t.width > 0 {
+			dot = dot/t.width + t.offsets[j]
+		}
+		projections[j] = dot
+	}
+	return projections
+}
+
+// key returns the bucket of projections, with the functions listed in
+// perturbations moved to a neighbouring bit or interval.
+func (t *table) key(projections []float64, perturbations ...perturbation) uint64 {
+	if t.width == 0 {
+		key := uint64(0)
+		for j, projection := range projections {
+			if projection > 0 {
+				key |= 1 << j
+			}
+		}
+		for _, p := range perturbations {
+			key ^= 1 << p.function
+		}
+		return key
+	}
+
+	intervals := make([]int64, len(projections))
+	for j, projection := range projections {
+		intervals[j] = int64(math.Floor(projection))
+	}
+	for _, p := range perturbations {
+		intervals[p.function] += int64(p.shift)
+	}
+	// FNV-1a over the intervals, colliding buckets only add candidates
+	key := uint64(14695981039346656037)
+	for _, interval := range intervals {
+		key = (key ^ uint64(interval)) * 1099511628211
+	}
+	return key
+}
+
+// perturbation moves function to the neighbouring bit, or to the interval
+// shift away. cost is the squared distance of the projection to the boundary
+// crossed.
+type perturbation struct {
+	function int
+	shift    int
+	cost     float64
+}
+
+/*
+probes returns the keys of the n buckets next to the bucket of projections
+most likely to hold neighbours, following multi-probe LSH (Lv et al., 2007).
+Crossing the boundaries a projection is close to is cheap, a set of
+perturbations costs the sum of their costs and sets are visited cheapest
+first.
+
+Sets are generated from the perturbations sorted by cost: from a set whose
+costliest member is the i-th perturbation, shifting replaces that member by
+the i+1-th and expanding adds the i+1-th. Every set is generated once, and
+never before a cheaper one.
+*/
+func (t *table) probes(projections []float64, n int) []uint64 {
+	if n <= 0 {
+		return nil
+	}
+	perturbations := make([]perturbation, 0, 2*len(projections))
+	for j, projection := range projections {
+		if t.width == 0 {
+			perturbations = append(perturbations, perturbation{function: j, cost: projection * projection})
+			continue
+		}
+		below := projection - math.Floor(projection)
+		perturbations = append(perturbations,
+			perturbation{function: j, shift: -1, cost: below * below},
+			perturbation{function: j, shift: 1, cost: (1 - below) * (1 - below)})
+	}
+	slices.SortStableFunc(perturbations, func(p1 perturbation, p2 perturbation) int {
+		return cmp.Compare(p1.cost, p2.cost)
+	})
+
+	keys := make([]uint64, 0, n)
+	sets := &setHeap{{members: []int{0}, cost: perturbations[0].cost}}
+	for sets.Len() > 0 && len(keys) < n {
+		set := heap.Pop(sets).(perturbationSet)
+		if members, ok := t.valid(set, perturbations); ok {
+			keys = append(keys, t.key(projections, members...))
+		}
+
+		last := set.members[len(set.members)-1]
+		if last+1 == len(perturbations) {
+			continue
+		}
+		next := perturbations[last+1].cost
+		shifted := slices.Clone(set.members)
+		shifted[len(shifted)-1] = last + 1
+		heap.Push(sets, perturbationSet{members: shifted, cost: set.cost - perturbations[last].cost + next})
+		expanded := append(slices.Clone(set.members), last+1)
+		heap.Push(sets, perturbationSet{members: expanded, cost: set.cost + next})
+	}
+	return keys
+}
+
+// valid returns the perturbations of set, unless it moves a function both
+// ways.
+func (t *table) valid(set perturbationSet, perturbations []perturbation) ([]perturbation, bool) {
+	members := make([]perturbation, len(set.members))
+	moved := make(map[int]bool, len(set.members))
+	for i, member := range set.members {
+		p := perturbations[member]
+		if moved[p.function] {
+			return nil, false
+		}
+		moved[p.function] = true
+		members[i] = p
+	}
+	return members, true
+}
+
+// add stores it in the bucket of key.
+func (t *table) add(key uint64, it *item) {
+	t.buckets[key] = append(t.buckets[key], it)
+}
+
+// remove takes it out of the bucket of key.
+func (t *table) remove(key uint64, it *item) {
+	bucket := t.buckets[key]
+	i := slices.Index(bucket, it)
+	if i < 0 {
+		return
+	}
+	bucket[i] = bucket[len(bucket)-1]
+	bucket = bucket[:len(bucket)-1]
+	if len(bucket) == 0 {
+		delete(t.buckets, key)
+	} else {
+		t.buckets[key] = bucket
+	}
+}
+
+// perturbationSet is a set of perturbations, by their index in the sorted
+// perturbations, in increasing order.
+type perturbationSet struct {
+	members []int
+	cost    float64
+}
+
+// setHeap is a min-heap on cost.
+type setHeap []perturbationSet
+
+func (h setHeap) Len() int           { return len(h) }
+func (h setHeap) Less(i, j int) bool { return h[i].cost < h[j].cost }
+func (h setHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *setHeap) Push(x any) {
+	*h = append(*h, x.(perturbationSet))
+}
+
+func (h *setHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
@@ -0,0 +1,44 @@
+package lsh
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTable_Hyperplanes(t *testing.T) {
+	table := newTable(4, 2, 0, rand.New(rand.NewSource(1)))
+	table.directions = [][]float64{{1, 0}, {0, 1}, {-1, 0}, {1, 1}}
+
+	projections := table.project([]float64{2, -0.5})
+	assert.Equal(t, []float64{2, -0.5, -2, 1.5}, projections)
+	assert.Equal(t, uint64(0b1001), table.key(projections), "one bit per positive projection")
+
+	// the closest boundaries are crossed first: bits 1, 3, then both
+	assert.Equal(t, []uint64{0b1011, 0b0001, 0b0011}, table.probes(projections, 3))
+	assert.Len(t, table.probes(projections, 100), 15, "every other bucket")
+	assert.Nil(t, table.probes(projections, 0))
+}
+
+func TestTable_PStable(t *testing.T) {
+	table := newTable(2, 1, 2, rand.New(rand.NewSource(2)))
+	table.directions = [][]float64{{1}, {-1}}
+	table.offsets = []float64{0.5, 0.25}
+
+	projections := table.project([]float64{1.1})
+	assert.InDeltaSlice(t, []float64{1.05, -0.3}, projections, 1e-9, "in widths, shifted by the offsets")
+	home := table.key(projections)
+	assert.Equal(t, home, table.key([]float64{1.9, -0.01}), "same intervals, same bucket")
+
+	probes := table.probes(projections, 8)
+	require.Len(t, probes, 8, "2 functions moved up to 1 interval each way")
+	assert.Equal(t, table.key(projections, perturbation{function: 0, shift: -1}), probes[0], "0.05 from the boundary below")
+	assert.Equal(t, table.key(projections, perturbation{function: 1, shift: 1}), probes[1], "0.3 from the boundary above")
+	seen := map[uint64]bool{home: true}
+	for _, key := range probes {
+		assert.False(t, seen[key], "buckets are probed once")
+		seen[key] = true
+	}
+}
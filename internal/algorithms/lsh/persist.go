@@ -0,0 +1,67 @@
+package lsh
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/codec"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const indexVersion = 1
+
+var ErrCorruptIndex = errors.New("corrupt lsh index")
+
+/*
+SaveIndex writes whether the hash functions were drawn, and for how many
+dimensions. They are drawn again from the seed on reload, and the entries
+hashed again: hashing is cheap, the dimensions are what the entries left in
+the index can no longer tell once the first one was removed.
+
+Entries are not written, they are saved by the caller and handed back to
+LoadIndex.
+*/
+func (a *Algorithm) SaveIndex(w io.Writer) error {
+	out := codec.NewWriter(w)
+	out.Uint32(indexVersion)
+	out.Bool(a.tables != nil)
+	if a.tables != nil {
+		out.Uint32(uint32(a.dims()))
+	}
+	return out.Err()
+}
+
+// LoadIndex restores an index written by SaveIndex into an empty algorithm
+// built with the same settings.
+func (a *Algorithm) LoadIndex(r io.Reader, entries []algorithms.Entry) error {
+	if len(a.byId) > 0 {
+		return fmt.Errorf("%w: index is not empty", ErrCorruptIndex)
+	}
+
+	in := codec.NewReader(r)
+	if version := in.Uint32(); in.Err() == nil && version != indexVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrCorruptIndex, version)
+	}
+	drawn := in.Bool()
+	dims := 0
+	if drawn {
+		dims = in.Length()
+	}
+	if err := in.Err(); err != nil {
+		return err
+	}
+	if !drawn && len(entries) > 0 {
+		return fmt.Errorf("%w: %d entries without hash functions", ErrCorruptIndex, len(entries))
+	}
+
+	if drawn {
+		a.init(dims)
+	}
+	a.AddEntries(entries)
+	return nil
+}
+
+// dims returns the dimensions the hash functions were drawn for.
+func (a *Algorithm) dims() int {
+	return len(a.tables[0].directions[0])
+}
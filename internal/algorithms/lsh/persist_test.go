@@ -0,0 +1,74 @@
+package lsh
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/codec"
+	"VectorLite/internal/vector"
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlgorithm_SaveLoadIndex(t *testing.T) {
+	config := Config{Metric: "euclidean", Seed: 8}
+	rng := rand.New(rand.NewSource(8))
+	alg := NewWithConfig(config)
+	// the first entry, which decided the dimensions, has 3 of them
+	alg.AddEntry(algorithms.Entry{Vector: *vector.NewVector(1, 2, 3), Id: 1})
+	alg.AddEntries(algotest.NormalEntries(rng, 100, 8)[1:])
+	alg.RemoveEntry(1)
+
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+
+	loaded := NewWithConfig(config)
+	require.NoError(t, loaded.LoadIndex(&buf, alg.ListEntries()))
+	assert.Equal(t, 3, loaded.dims())
+	assert.Equal(t, alg.ListEntries(), loaded.ListEntries())
+	for id, it := range alg.byId {
+		assert.Equal(t, it.keys, loaded.byId[id].keys, "entry %d is hashed to the same buckets", id)
+	}
+	for _, query := range algotest.NormalEntries(rng, 20, 8) {
+		assert.Equal(t, alg.Query(&query.Vector, 10, "euclidean"), loaded.Query(&query.Vector, 10, "euclidean"))
+	}
+}
+
+func TestAlgorithm_SaveLoadIndex_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, New().SaveIndex(&buf))
+
+	loaded := New()
+	require.NoError(t, loaded.LoadIndex(&buf, nil))
+	assert.Nil(t, loaded.tables, "hash functions are drawn for the first entry")
+	loaded.AddEntry(algorithms.Entry{Vector: *vector.NewVector(1, 0), Id: 1})
+	assert.Equal(t, 2, loaded.dims())
+}
+
+func TestAlgorithm_LoadIndex_Corrupt(t *testing.T) {
+	alg := New()
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(9)), 10, 2)
+	alg.AddEntries(entries)
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+	saved := buf.Bytes()
+
+	var empty bytes.Buffer
+	require.NoError(t, New().SaveIndex(&empty))
+	err := New().LoadIndex(&empty, entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex, "entries without hash functions")
+
+	err = New().LoadIndex(bytes.NewReader(saved[:len(saved)-1]), entries)
+	assert.Error(t, err)
+
+	var version bytes.Buffer
+	codec.NewWriter(&version).Uint32(indexVersion + 1)
+	err = New().LoadIndex(&version, entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex)
+
+	err = alg.LoadIndex(bytes.NewReader(saved), entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex, "the index is not empty")
+}
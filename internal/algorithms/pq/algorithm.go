@@ -0,0 +1,472 @@
+package pq
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/kmeans"
+	"VectorLite/internal/vector"
+	"cmp"
+	"container/heap"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+)
+
+// Codes hold one byte per subspace, so a codebook has at most Centroids
+// centroids.
+const Centroids = 256
+
+const (
+	// DefaultDimsPerSubspace sizes the subspaces when their number isn't
+	// configured: a code byte then stands for 64 bytes of float64 values.
+	DefaultDimsPerSubspace = 8
+	DefaultIterations      = 20
+	// DefaultTrainSize gives k-means about 39 points per centroid.
+	DefaultTrainSize  = 39 * Centroids
+	DefaultSampleSize = 256 * Centroids
+	// DefaultRerankFactor re-ranks four candidates per result.
+	DefaultRerankFactor = 4
+)
+
+// DefaultMetric is the metric used to train and search the codes when the
+// configuration doesn't name one.
+const DefaultMetric = "cosine"
+
+/*
+Algorithm is a product quantization index. Vectors are split into subspaces
+of consecutive dimensions, and every subspace is quantized on its own: it is
+replaced by the position of its nearest centroid in the codebook of the
+subspace, a single byte. A vector of 1536 float64 values, 12KB, is stored as
+192 bytes with the default of 8 dimensions per subspace.
+
+Queries compare the query with the codes through asymmetric distance tables:
+the distance from every subspace of the query to every centroid of its
+codebook is computed once, the distance to a stored vector is then the sum of
+one table entry per subspace.
+
+The codebooks are trained with k-means on a sample of the entries once
+TrainSize entries have arrived. Until then entries are stored whole and
+queries are exact.
+
+Without KeepOriginals the full vectors are dropped once encoded, and the
+entries returned hold the vectors decoded from their codes: an approximation,
+normalized with the angular metrics. With it, the originals are kept next to
+the codes, returned as they are and used to re-rank the results.
+*/
+type Algorithm struct {
+	// entries are stored by slot: records[slot], the codes at
+	// codes[slot*subspaces:] and, until trained or with keepOriginals,
+	// originals[slot]
+	records   []record
+	codes     []byte
+	originals [][]float64
+	byId      map[int]int
+	// codebooks is nil until the index is trained, codebooks[m] quantizes
+	// the dimensions bounds[m] to bounds[m+1]
+	codebooks     [][][]float64
+	bounds        []int
+	dims          int
+	subspaces     int
+	metric        string
+	iterations    int
+	trainSize     int
+	sampleSize    int
+	keepOriginals bool
+	rerankFactor  int
+	rng           *rand.Rand
+}
+
+// Config holds the parameters a product quantization index is built with.
+// With the angular metrics, vectors are normalized before they are encoded.
+type Config struct {
+	// Subspaces is the number of parts vectors are split into, each encoded
+	// in one byte. One per DefaultDimsPerSubspace dimensions if zero.
+	Subspaces int
+	Metric    string
+	// Iterations is the number of k-means rounds run to train a codebook.
+	Iterations int
+	// TrainSize is the number of entries that triggers training,
+	// DefaultTrainSize if zero.
+	TrainSize int
+	// SampleSize is the most entries the codebooks are trained on,
+	// DefaultSampleSize if zero.
+	SampleSize int
+	// KeepOriginals keeps the full vectors next to the codes.
+	KeepOriginals bool
+	// RerankFactor is only used with KeepOriginals: queries for k entries
+	// re-rank the RerankFactor × k closest codes with the original vectors.
+	// Zero disables re-ranking.
+	RerankFactor int
+	// Seed feeds the random source used to sample the entries and seed
+	// k-means, indexes built from the same entries in the same order with
+	// the same seed are identical.
+	Seed int64
+}
+
+// record is what is kept of an entry besides its vector.
+type record struct {
+	id         int
+	externalId string
+	metadata   map[string]string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Metric:       DefaultMetric,
+		Iterations:   DefaultIterations,
+		TrainSize:    DefaultTrainSize,
+		SampleSize:   DefaultSampleSize,
+		RerankFactor: DefaultRerankFactor,
+	}
+}
+
+func New() *Algorithm {
+	return NewWithConfig(DefaultConfig())
+}
+
+func NewWithConfig(config Config) *Algorithm {
+	if config.Metric == "" {
+		config.Metric = DefaultMetric
+	}
+	if config.Iterations < 1 {
+		config.Iterations = DefaultIterations
+	}
+	if config.TrainSize < 1 {
+		config.TrainSize = DefaultTrainSize
+	}
+	if config.SampleSize < 1 {
+		config.SampleSize = DefaultSampleSize
+	}
+	return &Algorithm{
+		byId:          make(map[int]int),
+		subspaces:     config.Subspaces,
+		metric:        config.Metric,
+		iterations:    config.Iterations,
+		trainSize:     config.TrainSize,
+		sampleSize:    config.SampleSize,
+		keepOriginals: config.KeepOriginals,
+		rerankFactor:  config.RerankFactor,
+		rng:           rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Metric returns the distance metric the codes are trained and searched with.
+func (a *Algorithm) Metric() string {
+	return a.metric
+}
+
+// SetRerankFactor changes the number of candidates per result re-ranked
+// with the original vectors, 0 disables re-ranking.
+func (a *Algorithm) SetRerankFactor(rerankFactor int) {
+	a.rerankFactor = max(rerankFactor, 0)
+}
+
+// Trained reports whether the codebooks have been trained, queries are exact
+// until then.
+func (a *Algorithm) Trained() bool {
+	return a.codebooks != nil
+}
+
+// CodeSize returns the number of bytes each vector is stored in once the
+// index is trained.
+func (a *Algorithm) CodeSize() int {
+	return len(a.bounds) - 1
+}
+
+// spherical reports whether vectors are compared by direction, as both
+// angular metrics only compare the angle between vectors.
+func (a *Algorithm) spherical() bool {
+	return a.metric != "euclidean"
+}
+
+// prepare returns the values encoded for values: normalized for the angular
+// metrics.
+func (a *Algorithm) prepare(values []float64) []float64 {
+	if a.spherical() {
+		return kmeans.Normalize(values)
+	}
+	return values
+}
+
+func (a *Algorithm) AddEntry(entry algorithms.Entry) {
+	a.AddEntries([]algorithms.Entry{entry})
+}
+
+// AddEntries adds a batch of entries, encoding them in parallel. The index
+// is trained at most once, after the whole batch.
+func (a *Algorithm) AddEntries(entries []algorithms.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	first := len(a.records)
+	for _, entry := range entries {
+		a.byId[entry.Id] = len(a.records)
+		a.records = append(a.records, record{id: entry.Id, externalId: entry.ExternalId, metadata: entry.Metadata})
+		if !a.Trained() || a.keepOriginals {
+			a.originals = append(a.originals, entry.Vector.Values)
+		}
+	}
+
+	if !a.Trained() {
+		if len(a.records) >= a.trainSize {
+			a.train()
+		}
+		return
+	}
+
+	a.codes = slices.Grow(a.codes, len(entries)*a.CodeSize())
+	a.codes = a.codes[:len(a.records)*a.CodeSize()]
+	kmeans.Parallel(len(entries), func(i int) {
+		a.encode(first+i, entries[i].Vector.Values)
+	})
+}
+
+// encode stores the code of values at slot.
+func (a *Algorithm) encode(slot int, values []float64) {
+	a.encodePoint(slot, a.prepare(values))
+}
+
+// encodePoint stores the code of a prepared point at slot.
+func (a *Algorithm) encodePoint(slot int, point []float64) {
+	code := a.codes[slot*a.CodeSize() : (slot+1)*a.CodeSize()]
+	for m, codebook := range a.codebooks {
+		code[m] = byte(kmeans.Nearest(point[a.bounds[m]:a.bounds[m+1]], codebook, false))
+	}
+}
+
+// decode returns the vector the code at slot stands for.
+func (a *Algorithm) decode(slot int) []float64 {
+	values := make([]float64, 0, a.dims)
+	code := a.codes[slot*a.CodeSize() : (slot+1)*a.CodeSize()]
+	for m, codebook := range a.codebooks {
+		values = append(values, codebook[code[m]]...)
+	}
+	return values
+}
+
+// vector returns the vector stored at slot, the original one if kept.
+func (a *Algorithm) vector(slot int) []float64 {
+	if slot < len(a.originals) {
+		return a.originals[slot]
+	}
+	return a.decode(slot)
+}
+
+func (a *Algorithm) entry(slot int) algorithms.Entry {
+	r := a.records[slot]
+	return algorithms.Entry{
+		Vector:     vector.Vector{Values: a.vector(slot)},
+		Metadata:   r.metadata,
+		Id:         r.id,
+		ExternalId: r.externalId,
+	}
+}
+
+func (a *Algorithm) GetEntry(id int) (algorithms.Entry, bool) {
+	slot, exists := a.byId[id]
+	if !exists {
+		return algorithms.Entry{}, false
+	}
+	return a.entry(slot), true
+}
+
+// UpdateEntry replaces the record of the entry in place, and encodes its
+// vector again when it differs from the one GetEntry returns.
+func (a *Algorithm) UpdateEntry(entry algorithms.Entry) bool {
+	slot, exists := a.byId[entry.Id]
+	if !exists {
+		return false
+	}
+
+	a.records[slot] = record{id: entry.Id, externalId: entry.ExternalId, metadata: entry.Metadata}
+	current := vector.Vector{Values: a.vector(slot)}
+	if current.Equal(&entry.Vector) {
+		return true
+	}
+	if slot < len(a.originals) {
+		a.originals[slot] = entry.Vector.Values
+	}
+	if a.Trained() {
+		a.encode(slot, entry.Vector.Values)
+	}
+	return true
+}
+
+// RemoveEntry moves the last entry into the slot of the removed one.
+func (a *Algorithm) RemoveEntry(id int) bool {
+	slot, exists := a.byId[id]
+	if !exists {
+		return false
+	}
+
+	last := len(a.records) - 1
+	a.records[slot] = a.records[last]
+	a.byId[a.records[slot].id] = slot
+	a.records = a.records[:last]
+	if len(a.originals) > 0 {
+		a.originals[slot] = a.originals[last]
+		a.originals = a.originals[:last]
+	}
+	if a.Trained() {
+		size := a.CodeSize()
+		copy(a.codes[slot*size:(slot+1)*size], a.codes[last*size:])
+		a.codes = a.codes[:last*size]
+	}
+	delete(a.byId, id)
+	return true
+}
+
+// ListEntries returns the entries sorted by id.
+func (a *Algorithm) ListEntries() []algorithms.Entry {
+	entries := make([]algorithms.Entry, len(a.records))
+	for slot := range a.records {
+		entries[slot] = a.entry(slot)
+	}
+	slices.SortFunc(entries, func(e1 algorithms.Entry, e2 algorithms.Entry) int {
+		return cmp.Compare(e1.Id, e2.Id)
+	})
+	return entries
+}
+
+func (a *Algorithm) Query(queryVector *vector.Vector, k int, metric string) []algorithms.Entry {
+	return a.QueryWithOptions(queryVector, k, metric, algorithms.QueryOptions{})
+}
+
+/*
+QueryWithOptions scores every code with the distance tables of the query, in
+the index's metric. With KeepOriginals and a RerankFactor, the RerankFactor × k
+closest are scored again with their original vector and the requested metric,
+otherwise the k closest codes are returned as they are. options.Oversample
+replaces the RerankFactor for the query. Results are closest first.
+
+Filters are applied to every entry, without its vector, before it is scored.
+*/
+func (a *Algorithm) QueryWithOptions(queryVector *vector.Vector, k int, metric string, options algorithms.QueryOptions) []algorithms.Entry {
+	if k <= 0 {
+		return []algorithms.Entry{}
+	}
+
+	accepts := func(slot int) bool {
+		if options.Filter == nil {
+			return true
+		}
+		r := a.records[slot]
+		return options.Filter(algorithms.Entry{Metadata: r.metadata, Id: r.id, ExternalId: r.externalId})
+	}
+
+	var candidates []scoredSlot
+	if !a.Trained() {
+		candidates = nearest(len(a.records), k, accepts, func(slot int) float64 {
+			return queryVector.Distance_score(&vector.Vector{Values: a.originals[slot]}, metric)
+		})
+	} else {
+		factor := a.rerankFactor
+		if options.Oversample > 0 {
+			factor = options.Oversample
+		}
+		rerank := factor > 0 && len(a.originals) > 0
+		size := k
+		if rerank {
+			size = k * factor
+		}
+
+		table := a.distanceTable(queryVector.Values)
+		codeSize := a.CodeSize()
+		candidates = nearest(len(a.records), size, accepts, func(slot int) float64 {
+			score := 0.0
+			for m, c := range a.codes[slot*codeSize : (slot+1)*codeSize] {
+				score += table[m*Centroids+int(c)]
+			}
+			return score
+		})
+
+		if rerank {
+			for i := range candidates {
+				candidates[i].score = queryVector.Distance_score(&vector.Vector{Values: a.originals[candidates[i].slot]}, metric)
+			}
+			sort.SliceStable(candidates, func(i int, j int) bool {
+				return candidates[i].score < candidates[j].score
+			})
+			candidates = candidates[:min(k, len(candidates))]
+		}
+	}
+
+	results := make([]algorithms.Entry, len(candidates))
+	for i, candidate := range candidates {
+		results[i] = a.entry(candidate.slot)
+	}
+	return results
+}
+
+/*
+distanceTable returns the distance from every subspace of the query to every
+centroid of its codebook, at m*Centroids+c for centroid c of subspace m.
+Summed over the subspaces, they give the squared euclidean distance between
+the query and a decoded vector, or with the angular metrics the opposite of
+their dot product, so a lower sum is closer.
+*/
+func (a *Algorithm) distanceTable(query []float64) []float64 {
+	point := a.prepare(query)
+	table := make([]float64, len(a.codebooks)*Centroids)
+	for m, codebook := range a.codebooks {
+		sub := point[a.bounds[m]:a.bounds[m+1]]
+		for c, centroid := range codebook {
+			table[m*Centroids+c] = kmeans.Distance(sub, centroid, a.spherical())
+		}
+		// codebooks trained on fewer points than Centroids have no centroid
+		// past their size, and no code pointing there
+		for c := len(codebook); c < Centroids; c++ {
+			table[m*Centroids+c] = math.Inf(1)
+		}
+	}
+	return table
+}
+
+type scoredSlot struct {
+	slot  int
+	score float64
+}
+
+// nearest returns the size accepted slots below n with the lowest score,
+// lowest first.
+func nearest(n int, size int, accepts func(slot int) bool, score func(slot int) float64) []scoredSlot {
+	found := &slotHeap{}
+	for slot := 0; slot < n; slot++ {
+		if !accepts(slot) {
+			continue
+		}
+		s := score(slot)
+		if found.Len() < size {
+			heap.Push(found, scoredSlot{slot: slot, score: s})
+		} else if s < (*found)[0].score {
+			(*found)[0] = scoredSlot{slot: slot, score: s}
+			heap.Fix(found, 0)
+		}
+	}
+
+	sorted := make([]scoredSlot, found.Len())
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(found).(scoredSlot)
+	}
+	return sorted
+}
+
+// slotHeap is a max-heap on score, the farthest slot found so far is on top,
+// ready to be replaced.
+type slotHeap []scoredSlot
+
+func (h slotHeap) Len() int           { return len(h) }
+func (h slotHeap) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h slotHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *slotHeap) Push(x any) {
+	*h = append(*h, x.(scoredSlot))
+}
+
+func (h *slotHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
@@ -0,0 +1,254 @@
+package pq
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/kmeans"
+	"VectorLite/internal/vector"
+	"math/rand"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertSlotsConsistent checks that byId, records, codes and originals agree.
+func assertSlotsConsistent(t *testing.T, alg *Algorithm) {
+	t.Helper()
+	require.Len(t, alg.byId, len(alg.records))
+	for slot, r := range alg.records {
+		assert.Equal(t, slot, alg.byId[r.id], "entry %d", r.id)
+	}
+	if alg.Trained() {
+		assert.Len(t, alg.codes, len(alg.records)*alg.CodeSize())
+	}
+	if !alg.Trained() || alg.keepOriginals {
+		assert.Len(t, alg.originals, len(alg.records))
+	} else {
+		assert.Empty(t, alg.originals, "originals are dropped once encoded")
+	}
+}
+
+func TestAlgorithm_Untrained(t *testing.T) {
+	alg := NewWithConfig(Config{TrainSize: 100})
+	rng := rand.New(rand.NewSource(1))
+	entries := algotest.UniformEntries(rng, 99, 4)
+	alg.AddEntries(entries)
+
+	assert.False(t, alg.Trained())
+	assertSlotsConsistent(t, alg)
+	assert.Equal(t, entries, alg.ListEntries())
+	for _, query := range algotest.UniformEntries(rng, 10, 4) {
+		assert.Equal(t, algotest.Exact(entries, &query.Vector, 5, "cosine"), alg.Query(&query.Vector, 5, "cosine"), "untrained queries are exact")
+	}
+}
+
+func TestAlgorithm_Train(t *testing.T) {
+	alg := NewWithConfig(Config{Metric: "euclidean", TrainSize: 300, Iterations: 10, Seed: 1})
+	rng := rand.New(rand.NewSource(1))
+	entries := algotest.UniformEntries(rng, 300, 20)
+	for _, entry := range entries {
+		alg.AddEntry(entry)
+	}
+
+	require.True(t, alg.Trained())
+	assert.Equal(t, 3, alg.CodeSize(), "one subspace per 8 dimensions, rounded up")
+	assert.Equal(t, []int{0, 6, 13, 20}, alg.bounds)
+	assertSlotsConsistent(t, alg)
+
+	// decoded vectors approximate the originals
+	for _, original := range entries[:20] {
+		entry, exists := alg.GetEntry(original.Id)
+		require.True(t, exists)
+		require.Len(t, entry.Vector.Values, 20)
+		assert.Less(t, entry.Vector.Euclidean_distance(&original.Vector), original.Vector.Magnitude())
+	}
+
+	more := algotest.UniformEntries(rng, 50, 20)
+	for i := range more {
+		more[i].Id += 300
+	}
+	alg.AddEntries(more)
+	assertSlotsConsistent(t, alg)
+	for _, entry := range more {
+		assert.Equal(t, entry.Id, alg.Query(&entry.Vector, 1, "euclidean")[0].Id, "an entry is its own nearest code")
+	}
+}
+
+func TestAlgorithm_Train_Subspaces(t *testing.T) {
+	tests := []struct {
+		dims      int
+		subspaces int
+		bounds    []int
+	}{
+		{10, 3, []int{0, 3, 6, 10}},
+		{4, 8, []int{0, 1, 2, 3, 4}},
+		{16, 0, []int{0, 8, 16}},
+	}
+
+	for _, tt := range tests {
+		alg := NewWithConfig(Config{Subspaces: tt.subspaces, TrainSize: 10, Iterations: 2})
+		alg.AddEntries(algotest.UniformEntries(rand.New(rand.NewSource(1)), 10, tt.dims))
+		require.True(t, alg.Trained())
+		assert.Equal(t, tt.bounds, alg.bounds)
+		for m, codebook := range alg.codebooks {
+			assert.Len(t, codebook, 10, "at most one centroid per training point")
+			assert.Len(t, codebook[0], tt.bounds[m+1]-tt.bounds[m])
+		}
+	}
+}
+
+func TestAlgorithm_distanceTable(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for _, metric := range []string{"cosine", "euclidean"} {
+		alg := NewWithConfig(Config{Metric: metric, Subspaces: 4, TrainSize: 300, Iterations: 5})
+		alg.AddEntries(algotest.UniformEntries(rng, 300, 12))
+		require.True(t, alg.Trained())
+
+		query := algotest.UniformEntries(rng, 1, 12)[0].Vector
+		table := alg.distanceTable(query.Values)
+		for slot := 0; slot < 20; slot++ {
+			adc := 0.0
+			for m, c := range alg.codes[slot*4 : (slot+1)*4] {
+				adc += table[m*Centroids+int(c)]
+			}
+			// the table sums to the distance between the query and the
+			// decoded vector
+			expected := kmeans.Distance(alg.prepare(query.Values), alg.decode(slot), metric != "euclidean")
+			assert.InDelta(t, expected, adc, 1e-9, metric)
+		}
+	}
+}
+
+/*
+TestAlgorithm_Query_Recall reports how much recall PQ trades for memory on
+clustered 32 dimensional vectors: fewer subspaces compress more and find
+fewer of the true neighbours, re-ranking with the kept originals wins most of
+it back. Run with -v to see the figures.
+*/
+func TestAlgorithm_Query_Recall(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	entries := algotest.ClusteredEntries(rng, 3000, 32, 30, 0.2)
+	queries := algotest.ClusteredEntries(rng, 30, 32, 30, 0.2)
+	truth := algotest.Neighbours(entries, queries, 10, "euclidean")
+
+	recalls := map[int]float64{}
+	for _, subspaces := range []int{2, 4, 8, 16} {
+		alg := NewWithConfig(Config{Metric: "euclidean", Subspaces: subspaces, TrainSize: 3000, Iterations: 10, Seed: 5})
+		alg.AddEntries(entries)
+		recalls[subspaces] = algotest.Recall(alg, queries, truth, alg.Metric())
+		t.Logf("%2d subspaces: %2d bytes per vector, %4.0fx smaller, recall@10 %.3f",
+			subspaces, alg.CodeSize(), float64(32*8)/float64(alg.CodeSize()), recalls[subspaces])
+	}
+	assert.Less(t, recalls[2], recalls[16], "finer codes find more neighbours")
+	assert.GreaterOrEqual(t, recalls[16], 0.6)
+
+	alg := NewWithConfig(Config{Metric: "euclidean", Subspaces: 4, TrainSize: 3000, Iterations: 10, Seed: 5, KeepOriginals: true})
+	alg.AddEntries(entries)
+	for _, factor := range []int{0, 2, 10} {
+		alg.SetRerankFactor(factor)
+		reranked := algotest.Recall(alg, queries, truth, alg.Metric())
+		t.Logf(" 4 subspaces, re-ranking %2d×k with the originals: recall@10 %.3f", factor, reranked)
+		if factor == 0 {
+			assert.Equal(t, recalls[4], reranked, "same codes without re-ranking")
+		} else {
+			assert.Greater(t, reranked, recalls[4])
+		}
+	}
+	assert.GreaterOrEqual(t, algotest.Recall(alg, queries, truth, alg.Metric()), 0.9)
+}
+
+// TestAlgorithm_Memory checks that 1536 dimensional vectors, 12KB each, take
+// at least 32 times less memory once encoded.
+func TestAlgorithm_Memory(t *testing.T) {
+	const dims = 1536
+	rng := rand.New(rand.NewSource(6))
+	entries := algotest.UniformEntries(rng, 2300, dims)
+
+	alg := NewWithConfig(Config{TrainSize: 300, Iterations: 2, Seed: 6})
+	alg.AddEntries(entries[:300])
+	require.True(t, alg.Trained())
+
+	// measured past training, the codebooks are shared by every vector
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	alg.AddEntries(entries[300:])
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	perVector := (float64(after.HeapAlloc) - float64(before.HeapAlloc)) / 2000
+	t.Logf("%.0f bytes per vector, %d of them codes, against %d bytes of float64 values", perVector, alg.CodeSize(), dims*8)
+	assert.LessOrEqual(t, perVector, float64(dims*8)/32)
+	assertSlotsConsistent(t, alg)
+	runtime.KeepAlive(entries)
+}
+
+func TestAlgorithm_KeepOriginals(t *testing.T) {
+	alg := NewWithConfig(Config{TrainSize: 50, Iterations: 2, KeepOriginals: true})
+	entries := algotest.UniformEntries(rand.New(rand.NewSource(3)), 80, 8)
+	alg.AddEntries(entries[:50])
+	alg.AddEntry(entries[50])
+	alg.AddEntries(entries[51:])
+
+	require.True(t, alg.Trained())
+	assertSlotsConsistent(t, alg)
+	assert.Equal(t, entries, alg.ListEntries(), "originals are returned as they are")
+
+	// oversampling every entry re-scores them all, the answer is exact
+	query := vector.NewVector(1, 2, 3, 4, 5, 6, 7, 8)
+	options := algorithms.QueryOptions{Oversample: len(entries)}
+	assert.Equal(t, algotest.Exact(entries, query, 5, "cosine"), alg.QueryWithOptions(query, 5, "cosine", options))
+}
+
+func TestAlgorithm_Query_Filter(t *testing.T) {
+	alg := NewWithConfig(Config{TrainSize: 100, Iterations: 2})
+	rng := rand.New(rand.NewSource(4))
+	alg.AddEntries(algotest.UniformEntries(rng, 300, 8))
+
+	options := algorithms.QueryOptions{Filter: func(entry algorithms.Entry) bool {
+		return entry.Id%30 == 0
+	}}
+	results := alg.QueryWithOptions(&algotest.UniformEntries(rng, 1, 8)[0].Vector, 20, "cosine", options)
+	require.Len(t, results, 10)
+	for _, entry := range results {
+		assert.Zero(t, entry.Id%30)
+	}
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 0, "cosine"))
+	assert.Empty(t, New().Query(vector.NewVector(1, 0), 5, "cosine"))
+}
+
+func TestAlgorithm_RemoveUpdate(t *testing.T) {
+	for _, keepOriginals := range []bool{false, true} {
+		alg := NewWithConfig(Config{Metric: "euclidean", TrainSize: 100, Iterations: 5, KeepOriginals: keepOriginals})
+		rng := rand.New(rand.NewSource(5))
+		entries := algotest.UniformEntries(rng, 200, 8)
+		alg.AddEntries(entries)
+
+		for id := 1; id <= 200; id += 3 {
+			assert.True(t, alg.RemoveEntry(id))
+		}
+		assert.False(t, alg.RemoveEntry(1))
+		assert.Len(t, alg.ListEntries(), 133)
+		assertSlotsConsistent(t, alg)
+		_, exists := alg.GetEntry(1)
+		assert.False(t, exists)
+		assert.False(t, alg.UpdateEntry(entries[0]))
+
+		// metadata only, as the engine does: the entry comes from GetEntry
+		entry, _ := alg.GetEntry(2)
+		code := slices.Clone(alg.codes[alg.byId[2]*alg.CodeSize() : (alg.byId[2]+1)*alg.CodeSize()])
+		entry.Metadata = map[string]string{"name": "renamed"}
+		assert.True(t, alg.UpdateEntry(entry))
+		updated, _ := alg.GetEntry(2)
+		assert.Equal(t, entry, updated)
+		assert.Equal(t, code, alg.codes[alg.byId[2]*alg.CodeSize():(alg.byId[2]+1)*alg.CodeSize()])
+
+		moved := algorithms.Entry{Vector: *vector.NewVector(5, 5, 5, 5, 5, 5, 5, 5), Id: 3}
+		assert.True(t, alg.UpdateEntry(moved))
+		assert.Equal(t, 3, alg.Query(&moved.Vector, 1, "euclidean")[0].Id)
+		assertSlotsConsistent(t, alg)
+	}
+}
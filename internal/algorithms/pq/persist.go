@@ -0,0 +1,146 @@
+package pq
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/codec"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const indexVersion = 1
+
+var ErrCorruptIndex = errors.New("corrupt pq index")
+
+/*
+SaveIndex writes the codebooks and the codes of every entry, so a reload
+keeps the codes instead of encoding the decoded vectors listed with the
+entries again.
+
+Entries are not written, they are saved by the caller and handed back to
+LoadIndex. Original vectors, when kept, are listed with them.
+*/
+func (a *Algorithm) SaveIndex(w io.Writer) error {
+	out := codec.NewWriter(w)
+	out.Uint32(indexVersion)
+	out.Bool(a.Trained())
+	if a.Trained() {
+		out.Uint32(uint32(a.dims))
+		out.Uint32(uint32(a.CodeSize()))
+		for _, codebook := range a.codebooks {
+			out.Uint32(uint32(len(codebook)))
+			for _, centroid := range codebook {
+				out.Float64s(centroid)
+			}
+		}
+	}
+
+	out.Uint32(uint32(len(a.records)))
+	for slot, r := range a.records {
+		out.Int64(int64(r.id))
+		if a.Trained() {
+			out.Raw(a.codes[slot*a.CodeSize() : (slot+1)*a.CodeSize()])
+		}
+	}
+	return out.Err()
+}
+
+// LoadIndex restores an index written by SaveIndex. entries must hold exactly
+// the entries of the saved index.
+func (a *Algorithm) LoadIndex(r io.Reader, entries []algorithms.Entry) error {
+	if len(a.records) > 0 {
+		return fmt.Errorf("%w: index is not empty", ErrCorruptIndex)
+	}
+
+	byId := make(map[int]algorithms.Entry, len(entries))
+	for _, entry := range entries {
+		byId[entry.Id] = entry
+	}
+
+	in := codec.NewReader(r)
+	if version := in.Uint32(); in.Err() == nil && version != indexVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrCorruptIndex, version)
+	}
+
+	trained := in.Bool()
+	var dims, subspaces int
+	var codebooks [][][]float64
+	if trained {
+		dims = int(in.Uint32())
+		subspaces = in.Length()
+		if in.Err() == nil && (subspaces < 1 || subspaces > dims) {
+			return fmt.Errorf("%w: %d subspaces for %d dimensions", ErrCorruptIndex, subspaces, dims)
+		}
+		codebooks = make([][][]float64, subspaces)
+		for m := 0; m < subspaces && in.Err() == nil; m++ {
+			size := in.Length()
+			if in.Err() == nil && (size < 1 || size > Centroids) {
+				return fmt.Errorf("%w: codebook %d has %d centroids", ErrCorruptIndex, m, size)
+			}
+			for c := 0; c < size && in.Err() == nil; c++ {
+				codebooks[m] = append(codebooks[m], in.Float64s())
+			}
+		}
+	}
+	if err := in.Err(); err != nil {
+		return err
+	}
+
+	count := in.Length()
+	records := make([]record, 0, count)
+	originals := make([][]float64, 0, count)
+	slots := make(map[int]int, count)
+	var codes []byte
+	if trained {
+		codes = make([]byte, count*subspaces)
+	}
+	for slot := 0; slot < count && in.Err() == nil; slot++ {
+		id := int(in.Int64())
+		entry, exists := byId[id]
+		if !exists && in.Err() == nil {
+			return fmt.Errorf("%w: slot %d holds unknown entry %d", ErrCorruptIndex, slot, id)
+		}
+		delete(byId, id)
+		slots[id] = slot
+		records = append(records, record{id: entry.Id, externalId: entry.ExternalId, metadata: entry.Metadata})
+		originals = append(originals, entry.Vector.Values)
+		if trained {
+			in.Raw(codes[slot*subspaces : (slot+1)*subspaces])
+		}
+	}
+	if err := in.Err(); err != nil {
+		return err
+	}
+	if len(byId) > 0 {
+		return fmt.Errorf("%w: %d entries are missing from the index", ErrCorruptIndex, len(byId))
+	}
+	for m, codebook := range codebooks {
+		for _, centroid := range codebook {
+			if len(centroid) != (m+1)*dims/subspaces-m*dims/subspaces {
+				return fmt.Errorf("%w: codebook %d has centroids of %d dimensions", ErrCorruptIndex, m, len(centroid))
+			}
+		}
+	}
+	for i, c := range codes {
+		if int(c) >= len(codebooks[i%subspaces]) {
+			return fmt.Errorf("%w: code %d past codebook %d", ErrCorruptIndex, c, i%subspaces)
+		}
+	}
+
+	a.records = records
+	a.byId = slots
+	if trained {
+		a.dims = dims
+		a.bounds = make([]int, subspaces+1)
+		for m := range a.bounds {
+			a.bounds[m] = m * dims / subspaces
+		}
+		a.codebooks = codebooks
+		a.codes = codes
+	}
+	// listed entries hold decoded vectors when the originals were dropped
+	if !trained || a.keepOriginals {
+		a.originals = originals
+	}
+	return nil
+}
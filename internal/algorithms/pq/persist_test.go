@@ -0,0 +1,93 @@
+package pq
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/codec"
+	"VectorLite/internal/vector"
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlgorithm_SaveLoadIndex(t *testing.T) {
+	for _, keepOriginals := range []bool{false, true} {
+		config := Config{Subspaces: 4, TrainSize: 100, Iterations: 5, KeepOriginals: keepOriginals, Seed: 7}
+		rng := rand.New(rand.NewSource(7))
+		alg := NewWithConfig(config)
+		alg.AddEntries(algotest.UniformEntries(rng, 300, 8))
+		for id := 1; id <= 30; id++ {
+			alg.RemoveEntry(id)
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, alg.SaveIndex(&buf))
+
+		loaded := NewWithConfig(config)
+		require.NoError(t, loaded.LoadIndex(&buf, alg.ListEntries()))
+
+		assert.Equal(t, alg.codebooks, loaded.codebooks)
+		assert.Equal(t, alg.bounds, loaded.bounds)
+		assert.Equal(t, alg.codes, loaded.codes, "codes are restored, not encoded again")
+		assert.Equal(t, alg.ListEntries(), loaded.ListEntries())
+		assertSlotsConsistent(t, loaded)
+
+		for _, query := range algotest.UniformEntries(rng, 20, 8) {
+			assert.Equal(t, alg.Query(&query.Vector, 10, "cosine"), loaded.Query(&query.Vector, 10, "cosine"))
+		}
+	}
+}
+
+func TestAlgorithm_SaveLoadIndex_Untrained(t *testing.T) {
+	alg := New()
+	entries := algotest.UniformEntries(rand.New(rand.NewSource(1)), 10, 2)
+	alg.AddEntries(entries)
+
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+
+	loaded := New()
+	require.NoError(t, loaded.LoadIndex(&buf, entries))
+	assert.False(t, loaded.Trained())
+	assert.Equal(t, entries, loaded.ListEntries())
+	assertSlotsConsistent(t, loaded)
+
+	loaded.AddEntry(algorithms.Entry{Vector: *vector.NewVector(1, 0), Id: 11})
+	assert.Len(t, loaded.ListEntries(), 11)
+}
+
+func TestAlgorithm_LoadIndex_Corrupt(t *testing.T) {
+	alg := NewWithConfig(Config{Subspaces: 2, TrainSize: 10, Iterations: 2})
+	entries := algotest.UniformEntries(rand.New(rand.NewSource(1)), 10, 4)
+	alg.AddEntries(entries)
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+	saved := buf.Bytes()
+
+	err := New().LoadIndex(bytes.NewReader(saved), entries[1:])
+	assert.ErrorIs(t, err, ErrCorruptIndex, "an entry of the index is missing")
+
+	extra := append(entries, algorithms.Entry{Vector: *vector.NewVector(1, 1, 1, 1), Id: 11})
+	err = New().LoadIndex(bytes.NewReader(saved), extra)
+	assert.ErrorIs(t, err, ErrCorruptIndex, "an entry has no code")
+
+	err = New().LoadIndex(bytes.NewReader(saved[:len(saved)-1]), entries)
+	assert.Error(t, err)
+
+	// the last code points past its 10 centroids codebook
+	past := bytes.Clone(saved)
+	past[len(past)-1] = 200
+	err = New().LoadIndex(bytes.NewReader(past), entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex)
+
+	var version bytes.Buffer
+	codec.NewWriter(&version).Uint32(indexVersion + 1)
+	err = New().LoadIndex(&version, entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex)
+
+	err = alg.LoadIndex(bytes.NewReader(saved), entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex, "the index is not empty")
+}
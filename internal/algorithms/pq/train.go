@@ -0,0 +1,59 @@
+package pq
+
+import (
+	"VectorLite/internal/kmeans"
+	"math/rand"
+)
+
+/*
+train splits the dimensions into subspaces, trains the codebook of every
+subspace with k-means on a sample of the entries, and encodes every entry.
+The original vectors are dropped afterwards unless kept, so an index is only
+trained once.
+*/
+func (a *Algorithm) train() {
+	if len(a.records) == 0 {
+		return
+	}
+
+	a.dims = len(a.originals[0])
+	subspaces := a.subspaces
+	if subspaces < 1 {
+		subspaces = (a.dims + DefaultDimsPerSubspace - 1) / DefaultDimsPerSubspace
+	}
+	subspaces = max(min(subspaces, a.dims), 1)
+	a.bounds = make([]int, subspaces+1)
+	for m := range a.bounds {
+		a.bounds[m] = m * a.dims / subspaces
+	}
+
+	points := make([][]float64, len(a.originals))
+	for slot, values := range a.originals {
+		points[slot] = a.prepare(values)
+	}
+	sample := kmeans.Sample(points, a.sampleSize, a.rng)
+
+	// every subspace gets its own source, drawn in order, so codebooks are
+	// the same however the goroutines are scheduled
+	seeds := make([]int64, subspaces)
+	for m := range seeds {
+		seeds[m] = a.rng.Int63()
+	}
+	codebooks := make([][][]float64, subspaces)
+	kmeans.Parallel(subspaces, func(m int) {
+		sub := make([][]float64, len(sample))
+		for i, point := range sample {
+			sub[i] = point[a.bounds[m]:a.bounds[m+1]]
+		}
+		codebooks[m] = kmeans.Train(sub, kmeans.Config{K: Centroids, Iterations: a.iterations}, rand.New(rand.NewSource(seeds[m])))
+	})
+	a.codebooks = codebooks
+
+	a.codes = make([]byte, len(points)*a.CodeSize())
+	kmeans.Parallel(len(points), func(slot int) {
+		a.encodePoint(slot, points[slot])
+	})
+	if !a.keepOriginals {
+		a.originals = nil
+	}
+}
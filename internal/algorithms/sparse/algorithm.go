@@ -0,0 +1,248 @@
+package sparse
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/vector"
+	"cmp"
+	"slices"
+)
+
+// DefaultMetric is the metric the index scores with when the configuration
+// doesn't name one: learned sparse models rank by the raw dot product.
+const DefaultMetric = "dot_product"
+
+// compactShare is the share of removed documents past which the posting lists
+// are written again without them.
+const compactShare = 0.2
+
+/*
+Algorithm is an inverted index of sparse vectors, such as the lexical or
+SPLADE embeddings of documents. Every dimension keeps the list of the
+documents holding it, with their weight; a query only walks the lists of its
+own dimensions, and skips the documents that can't make the results with
+WAND: a document is only scored once the bounds of the lists it may be in
+add up to the k-th best score found so far.
+
+Queries are exact, returning the entries with the highest dot product
+(cosine indexes hold the vectors scaled to unit length), best first. Only
+entries sharing a dimension with the query, both with non-zero values, are
+scored: an entry sharing none is never returned, so a query may return fewer
+than k entries.
+
+Removed and replaced documents stay in the lists, skipped by queries, until
+they make up a fifth of them.
+*/
+type Algorithm struct {
+	// docs are indexed by slot, the position of the document in the
+	// posting lists
+	docs     []doc
+	byId     map[int]uint32
+	postings map[uint32]*postings
+	removed  int
+	metric   string
+}
+
+// Config holds the parameters an inverted index is built with.
+type Config struct {
+	// Metric is dot_product or cosine.
+	Metric string
+}
+
+// doc is an entry stored in the posting lists.
+type doc struct {
+	entry algorithms.Entry
+	live  bool
+}
+
+// postings lists the documents holding a dimension by growing slot, with
+// their weight in it, and the bounds of these weights.
+type postings struct {
+	slots   []uint32
+	weights []float64
+	max     float64
+	min     float64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Metric: DefaultMetric,
+	}
+}
+
+func New() *Algorithm {
+	return NewWithConfig(DefaultConfig())
+}
+
+func NewWithConfig(config Config) *Algorithm {
+	if config.Metric != "cosine" {
+		config.Metric = DefaultMetric
+	}
+	return &Algorithm{
+		byId:     make(map[int]uint32),
+		postings: make(map[uint32]*postings),
+		metric:   config.Metric,
+	}
+}
+
+// Metric returns the metric the index scores with.
+func (a *Algorithm) Metric() string {
+	return a.metric
+}
+
+// SparseVectors marks the index as taking sparse vectors, see
+// algorithms.SparseIndex.
+func (a *Algorithm) SparseVectors() {}
+
+// point returns the dimensions of v with a non-zero value and these values,
+// scaled to unit length for cosine. Dense vectors are read as sparse ones.
+func (a *Algorithm) point(v *vector.Vector) ([]uint32, []float64) {
+	scale := 1.0
+	if a.metric == "cosine" {
+		if magnitude := v.Magnitude(); magnitude > 0 {
+			scale = 1 / magnitude
+		}
+	}
+	indices := make([]uint32, 0, len(v.Values))
+	values := make([]float64, 0, len(v.Values))
+	for i, value := range v.Values {
+		if value == 0 {
+			continue
+		}
+		index := uint32(i)
+		if v.IsSparse() {
+			index = v.Indices[i]
+		}
+		indices = append(indices, index)
+		values = append(values, value*scale)
+	}
+	return indices, values
+}
+
+// add appends entry to the posting lists of its dimensions.
+func (a *Algorithm) add(entry algorithms.Entry) {
+	slot := uint32(len(a.docs))
+	a.docs = append(a.docs, doc{entry: entry, live: true})
+	a.byId[entry.Id] = slot
+
+	indices, values := a.point(&entry.Vector)
+	for i, index := range indices {
+		p, exists := a.postings[index]
+		if !exists {
+			p = &postings{max: values[i], min: values[i]}
+			a.postings[index] = p
+		}
+		p.slots = append(p.slots, slot)
+		p.weights = append(p.weights, values[i])
+		p.max = max(p.max, values[i])
+		p.min = min(p.min, values[i])
+	}
+}
+
+// remove leaves the document of slot in the lists, for queries to skip.
+func (a *Algorithm) remove(slot uint32) {
+	a.docs[slot].live = false
+	delete(a.byId, a.docs[slot].entry.Id)
+	a.removed++
+}
+
+// compactIfDue writes the posting lists again without the removed documents,
+// once they make up a fifth of them.
+func (a *Algorithm) compactIfDue() {
+	if float64(a.removed) < compactShare*float64(len(a.docs)) {
+		return
+	}
+	docs := a.docs
+	a.docs = make([]doc, 0, len(a.byId))
+	a.byId = make(map[int]uint32, len(a.byId))
+	a.postings = make(map[uint32]*postings)
+	a.removed = 0
+	for _, d := range docs {
+		if d.live {
+			a.add(d.entry)
+		}
+	}
+}
+
+func (a *Algorithm) AddEntry(entry algorithms.Entry) {
+	if _, exists := a.byId[entry.Id]; exists {
+		a.UpdateEntry(entry)
+		return
+	}
+	a.add(entry)
+}
+
+func (a *Algorithm) GetEntry(id int) (algorithms.Entry, bool) {
+	slot, exists := a.byId[id]
+	if !exists {
+		return algorithms.Entry{}, false
+	}
+	return a.docs[slot].entry, true
+}
+
+// UpdateEntry swaps the stored entry in place when only its metadata
+// changed. A new vector is appended to the lists, the old document left for
+// queries to skip.
+func (a *Algorithm) UpdateEntry(entry algorithms.Entry) bool {
+	slot, exists := a.byId[entry.Id]
+	if !exists {
+		return false
+	}
+
+	if a.docs[slot].entry.Vector.Equal(&entry.Vector) {
+		a.docs[slot].entry = entry
+		return true
+	}
+
+	a.remove(slot)
+	a.add(entry)
+	a.compactIfDue()
+	return true
+}
+
+func (a *Algorithm) RemoveEntry(id int) bool {
+	slot, exists := a.byId[id]
+	if !exists {
+		return false
+	}
+	a.remove(slot)
+	a.compactIfDue()
+	return true
+}
+
+// ListEntries returns the entries sorted by id.
+func (a *Algorithm) ListEntries() []algorithms.Entry {
+	entries := make([]algorithms.Entry, 0, len(a.byId))
+	for _, slot := range a.byId {
+		entries = append(entries, a.docs[slot].entry)
+	}
+	slices.SortFunc(entries, func(e1 algorithms.Entry, e2 algorithms.Entry) int {
+		return cmp.Compare(e1.Id, e2.Id)
+	})
+	return entries
+}
+
+func (a *Algorithm) Query(queryVector *vector.Vector, k int, metric string) []algorithms.Entry {
+	return a.QueryWithOptions(queryVector, k, metric, algorithms.QueryOptions{})
+}
+
+/*
+QueryWithOptions returns the k entries accepted by the filter with the
+highest dot product with queryVector, best first; of the entries with the
+same score, the lowest ids come first. Filters are applied while searching,
+so up to k accepted entries are returned however selective they are.
+
+The metric argument is ignored: the index only scores with its own.
+*/
+func (a *Algorithm) QueryWithOptions(queryVector *vector.Vector, k int, metric string, options algorithms.QueryOptions) []algorithms.Entry {
+	if k <= 0 || len(a.byId) == 0 {
+		return []algorithms.Entry{}
+	}
+
+	indices, values := a.point(queryVector)
+	results := a.wand(indices, values, k, options)
+	entries := make([]algorithms.Entry, len(results))
+	for i, r := range results {
+		entries[i] = a.docs[r.slot].entry
+	}
+	return entries
+}
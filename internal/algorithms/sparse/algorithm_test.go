@@ -0,0 +1,165 @@
+package sparse
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/vector"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// matching narrows the filter of options to the entries sharing a dimension
+// with query, both with non-zero values, as only those are scored.
+func matching(query *vector.Vector, options algorithms.QueryOptions) algorithms.QueryOptions {
+	accepts := options.Accepts
+	options.Filter = func(entry algorithms.Entry) bool {
+		for i, index := range entry.Vector.Indices {
+			if entry.Vector.Values[i] != 0 && query.Get(index) != 0 {
+				return accepts(entry)
+			}
+		}
+		return false
+	}
+	return options
+}
+
+func assertExact(t *testing.T, alg *Algorithm, entries []algorithms.Entry, queries []algorithms.Entry, options algorithms.QueryOptions) {
+	t.Helper()
+	for _, k := range []int{1, 10, 100} {
+		for _, query := range queries {
+			expected := algotest.ExactWith(entries, &query.Vector, k, alg.Metric(), matching(&query.Vector, options))
+			require.Equal(t, expected, alg.QueryWithOptions(&query.Vector, k, alg.Metric(), options), "k=%d", k)
+		}
+	}
+}
+
+func TestAlgorithm_MatchesExact(t *testing.T) {
+	for _, metric := range []string{"dot_product", "cosine"} {
+		t.Run(metric, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			entries := algotest.SparseEntries(rng, 2000, 500, 20)
+			queries := algotest.SparseEntries(rng, 30, 500, 5)
+			alg := NewWithConfig(Config{Metric: metric})
+			for _, entry := range entries {
+				alg.AddEntry(entry)
+			}
+			assertExact(t, alg, entries, queries, algorithms.QueryOptions{})
+		})
+	}
+}
+
+func TestAlgorithm_Ties(t *testing.T) {
+	alg := New()
+	for id := 5; id >= 1; id-- {
+		v, _ := vector.NewSparseVector([]uint32{3}, []float64{2})
+		alg.AddEntry(algorithms.Entry{Vector: *v, Id: id})
+	}
+	query, _ := vector.NewSparseVector([]uint32{3}, []float64{1})
+	results := alg.Query(query, 3, "dot_product")
+	ids := []int{}
+	for _, entry := range results {
+		ids = append(ids, entry.Id)
+	}
+	assert.Equal(t, []int{1, 2, 3}, ids, "the lowest ids first")
+}
+
+func TestAlgorithm_Query_Filter(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	entries := algotest.SparseEntries(rng, 1000, 200, 10)
+	queries := algotest.SparseEntries(rng, 20, 200, 4)
+	alg := New()
+	for _, entry := range entries {
+		alg.AddEntry(entry)
+	}
+	options := algorithms.QueryOptions{Filter: func(entry algorithms.Entry) bool {
+		return entry.Id%7 == 0
+	}}
+	assertExact(t, alg, entries, queries, options)
+}
+
+func TestAlgorithm_Query_NoMatch(t *testing.T) {
+	alg := New()
+	assert.Empty(t, alg.Query(vector.NewVector(1), 5, "dot_product"))
+
+	v, _ := vector.NewSparseVector([]uint32{1, 2}, []float64{1, 1})
+	alg.AddEntry(algorithms.Entry{Vector: *v, Id: 1})
+	query, _ := vector.NewSparseVector([]uint32{3}, []float64{1})
+	assert.Empty(t, alg.Query(query, 5, "dot_product"), "entries sharing no dimension are left out")
+	assert.Empty(t, alg.Query(v, 0, "dot_product"))
+
+	dense := vector.NewVector(0, 0, 2)
+	assert.Len(t, alg.Query(dense, 5, "dot_product"), 1, "dense queries are read as sparse ones")
+}
+
+func TestAlgorithm_RemoveUpdate(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	entries := algotest.SparseEntries(rng, 500, 100, 8)
+	queries := algotest.SparseEntries(rng, 20, 100, 4)
+	alg := New()
+	for _, entry := range entries {
+		alg.AddEntry(entry)
+	}
+
+	entry := entries[4]
+	entry.Metadata = map[string]string{"name": "renamed"}
+	slot := alg.byId[5]
+	assert.True(t, alg.UpdateEntry(entry))
+	assert.Equal(t, slot, alg.byId[5], "metadata changes keep the slot")
+	got, _ := alg.GetEntry(5)
+	assert.Equal(t, entry, got)
+
+	moved := algotest.SparseEntries(rng, 1, 100, 8)[0]
+	moved.Id = 5
+	assert.True(t, alg.UpdateEntry(moved))
+	assert.NotEqual(t, slot, alg.byId[5], "new vectors take a new slot")
+	entries[4] = moved
+
+	assert.True(t, alg.RemoveEntry(6))
+	assert.False(t, alg.RemoveEntry(6))
+	assert.False(t, alg.UpdateEntry(entries[5]))
+	live := slices.Delete(slices.Clone(entries), 5, 6)
+	assertExact(t, alg, live, queries, algorithms.QueryOptions{})
+
+	// removing a fifth of the entries writes the lists again
+	for _, i := range rng.Perm(500)[:150] {
+		if alg.RemoveEntry(i + 1) {
+			live = slices.DeleteFunc(live, func(entry algorithms.Entry) bool { return entry.Id == i+1 })
+		}
+	}
+	assert.Less(t, alg.removed, len(alg.docs)/5)
+	assert.Equal(t, live, alg.ListEntries())
+	assertExact(t, alg, live, queries, algorithms.QueryOptions{})
+}
+
+func TestWAND_Skips(t *testing.T) {
+	// a rare dimension with high weights and a common one with low weights:
+	// once k documents hold the rare one, the others can't make the results
+	alg := New()
+	for id := 1; id <= 1000; id++ {
+		indices, values := []uint32{0}, []float64{0.1}
+		if id%10 == 0 {
+			indices, values = []uint32{0, 1}, []float64{0.1, 5}
+		}
+		v, _ := vector.NewSparseVector(indices, values)
+		alg.AddEntry(algorithms.Entry{Vector: *v, Id: id})
+	}
+	query, _ := vector.NewSparseVector([]uint32{0, 1}, []float64{1, 1})
+
+	common := 0
+	options := algorithms.QueryOptions{Filter: func(entry algorithms.Entry) bool {
+		if entry.Id%10 != 0 {
+			common++
+		}
+		return true
+	}}
+	results := alg.QueryWithOptions(query, 5, "dot_product", options)
+	require.Len(t, results, 5)
+	for i, entry := range results {
+		assert.Equal(t, 10*(i+1), entry.Id)
+	}
+	assert.Equal(t, 45, common, "documents without the rare dimension are skipped once 5 hold it")
+}
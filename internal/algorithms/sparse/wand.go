@@ -0,0 +1,169 @@
+package sparse
+
+import (
+	"VectorLite/internal/algorithms"
+	"cmp"
+	"container/heap"
+	"math"
+	"slices"
+)
+
+// cursor walks the posting list of one dimension of a query.
+type cursor struct {
+	index    uint32
+	postings *postings
+	pos      int
+	// weight is the query's value in the dimension, bound the most a
+	// document of the list may add to the score, never below 0
+	weight float64
+	bound  float64
+}
+
+func newCursor(index uint32, p *postings, weight float64) *cursor {
+	return &cursor{index: index, postings: p, weight: weight, bound: max(0, weight*p.max, weight*p.min)}
+}
+
+func (c *cursor) done() bool {
+	return c.pos >= len(c.postings.slots)
+}
+
+// slot is the document the cursor is on, past every slot once done.
+func (c *cursor) slot() uint32 {
+	if c.done() {
+		return math.MaxUint32
+	}
+	return c.postings.slots[c.pos]
+}
+
+func (c *cursor) score() float64 {
+	return c.weight * c.postings.weights[c.pos]
+}
+
+// seek moves the cursor to the first document at slot or after.
+func (c *cursor) seek(slot uint32) {
+	at, _ := slices.BinarySearch(c.postings.slots[c.pos:], slot)
+	c.pos += at
+}
+
+// compareCursors orders cursors by document, then by dimension so that
+// scores are summed in the order of the dimensions, as Dot_product does.
+func compareCursors(c1 *cursor, c2 *cursor) int {
+	return cmp.Or(cmp.Compare(c1.slot(), c2.slot()), cmp.Compare(c1.index, c2.index))
+}
+
+/*
+wand returns the k best documents accepted by options for the query holding
+values in the dimensions indices, best first.
+
+The cursors are kept sorted by the document they are on. The pivot is the
+first cursor where the bounds of the cursors up to it reach the k-th best
+score so far: a document before the pivot's is only in lists whose bounds
+add up to less, and can't make the results. When the first cursor is on the
+pivot's document too, it is scored; otherwise the cursors before the pivot
+skip to it.
+*/
+func (a *Algorithm) wand(indices []uint32, values []float64, k int, options algorithms.QueryOptions) []result {
+	cursors := make([]*cursor, 0, len(indices))
+	for i, index := range indices {
+		if p, exists := a.postings[index]; exists {
+			cursors = append(cursors, newCursor(index, p, values[i]))
+		}
+	}
+
+	results := resultHeap{}
+	for {
+		slices.SortFunc(cursors, compareCursors)
+		for len(cursors) > 0 && cursors[len(cursors)-1].done() {
+			cursors = cursors[:len(cursors)-1]
+		}
+
+		pivot := -1
+		bound := 0.0
+		for i, c := range cursors {
+			bound += c.bound
+			if len(results) < k || reaches(bound, results[0].score) {
+				pivot = i
+				break
+			}
+		}
+		if pivot < 0 {
+			break
+		}
+
+		slot := cursors[pivot].slot()
+		if cursors[0].slot() != slot {
+			for _, c := range cursors[:pivot] {
+				c.seek(slot)
+			}
+			continue
+		}
+
+		score := 0.0
+		for _, c := range cursors {
+			if c.slot() != slot {
+				break
+			}
+			score += c.score()
+			c.pos++
+		}
+		d := a.docs[slot]
+		if d.live && options.Accepts(d.entry) {
+			results.offer(result{slot: slot, id: d.entry.Id, score: score}, k)
+		}
+	}
+
+	sorted := make([]result, len(results))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(&results).(result)
+	}
+	return sorted
+}
+
+// reaches reports whether a document whose score is at most bound may tie
+// with worst or beat it. The bound is raised by a margin, so that rounding
+// never skips a document the scores would keep.
+func reaches(bound float64, worst float64) bool {
+	return bound+1e-9*(1+math.Abs(bound)) >= worst
+}
+
+// result is a document scored by a query.
+type result struct {
+	slot  uint32
+	id    int
+	score float64
+}
+
+// resultHeap is a min-heap of results, the worst on top: the lowest score,
+// and at equal scores the highest id.
+type resultHeap []result
+
+func (h resultHeap) worse(r1 result, r2 result) bool {
+	return r1.score < r2.score || r1.score == r2.score && r1.id > r2.id
+}
+
+// offer keeps r when it is among the k best so far.
+func (h *resultHeap) offer(r result, k int) {
+	if len(*h) < k {
+		heap.Push(h, r)
+		return
+	}
+	if h.worse((*h)[0], r) {
+		(*h)[0] = r
+		heap.Fix(h, 0)
+	}
+}
+
+func (h resultHeap) Len() int           { return len(h) }
+func (h resultHeap) Less(i, j int) bool { return h.worse(h[i], h[j]) }
+func (h resultHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *resultHeap) Push(x any) {
+	*h = append(*h, x.(result))
+}
+
+func (h *resultHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
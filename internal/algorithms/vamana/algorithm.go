@@ -0,0 +1,629 @@
+package vamana
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/kmeans"
+	"VectorLite/internal/quantization"
+	"VectorLite/internal/vector"
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const (
+	DefaultMaxDegree  = 32
+	DefaultBuildList  = 64
+	DefaultSearchList = 64
+	DefaultBeamWidth  = 4
+	DefaultAlpha      = 1.2
+)
+
+// DefaultMetric is the metric the graph is built for when the configuration
+// doesn't name one.
+const DefaultMetric = "euclidean"
+
+// consolidateShare is the share of the nodes removed entries may make up
+// before they are unlinked from the graph.
+const consolidateShare = 0.2
+
+/*
+Algorithm is a Vamana graph, the index of DiskANN, kept on disk. Every entry
+is a node linked to at most MaxDegree neighbours, chosen by robust pruning:
+a candidate is dropped when a neighbour already kept is Alpha times closer to
+it than the node is. Alpha above 1 keeps some long links, so that a greedy
+walk from the medoid of the entries reaches any region in a few hops.
+
+Full vectors and the links of every node live in a single memory-mapped file,
+see file. Memory only holds the entries' metadata and their vectors
+quantized to one byte per dimension. A query is a beam search: the
+SearchList closest nodes found so far are ranked by their quantized
+distance, and BeamWidth of them at a time are expanded by reading their
+vector and links from the file. The expanded nodes are ranked by their exact
+distance, so every read both moves the search on and rescores a candidate.
+Until TrainSize entries were added, candidates are ranked by their exact
+distance, read from the file.
+
+A first batch given to AddEntries is built in bulk: a random graph is refined
+in two passes over the nodes, the first one pruning with alpha 1. Later
+entries are inserted one by one, as in FreshDiskANN: a search for the new
+node gives its candidates, and its neighbours link back to it. Removed
+entries stay in the graph as tombstones, walked through but never returned,
+until they make up a fifth of the nodes and their neighbours are linked to
+each other instead.
+
+A new vector takes a slot that was dead at the last durable save, or a new
+one. The slots a snapshot still needs therefore keep their vectors, whatever
+is written after it.
+*/
+type Algorithm struct {
+	dir  string
+	name string
+	// file is nil until Create or LoadIndex
+	file *file
+	// nodes are indexed by slot
+	nodes []node
+	byId  map[int]uint32
+	// start is the slot searches start from, valid while byId is not empty
+	start      uint32
+	tombstones int
+	scalar     *quantization.Scalar
+	// free are the slots new vectors take: dead at the last save known to
+	// be durable, see Saved, or at the load. saved are the ones dead at the
+	// last save.
+	free  []uint32
+	saved []uint32
+	// err is the first failure of a write made without Reserve
+	err error
+
+	maxDegree  int
+	buildList  int
+	searchList int
+	beamWidth  int
+	alpha      float64
+	metric     string
+	trainSize  int
+	rng        *rand.Rand
+}
+
+// Config holds the parameters a graph is built with. The metric decides the
+// links.
+type Config struct {
+	// File is the name of the index file, in the directory given to SetDir.
+	File string
+	// MaxDegree is the most neighbours a node links to.
+	MaxDegree int
+	// BuildList is the size of the candidate list of the searches that
+	// insert entries, SearchList the one of queries. Queries widen it to k.
+	BuildList  int
+	SearchList int
+	// BeamWidth is the number of nodes a search reads from the file at
+	// every step.
+	BeamWidth int
+	// Alpha is the pruning factor, at least 1.
+	Alpha  float64
+	Metric string
+	// TrainSize is the number of entries the quantizer is trained on.
+	TrainSize int
+	// Seed feeds the random source of the bulk build.
+	Seed int64
+}
+
+// node states
+const (
+	live = iota
+	// deleted nodes are tombstones, still linked in the graph
+	deleted
+	// dead nodes are unlinked, their slot is reused once a save is durable
+	dead
+)
+
+// node is the part of a node of the graph kept in memory.
+type node struct {
+	// entry has no vector, the file holds it
+	entry algorithms.Entry
+	// code is zero until the quantizer is trained
+	code  quantization.Code
+	state uint8
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxDegree:  DefaultMaxDegree,
+		BuildList:  DefaultBuildList,
+		SearchList: DefaultSearchList,
+		BeamWidth:  DefaultBeamWidth,
+		Alpha:      DefaultAlpha,
+		Metric:     DefaultMetric,
+		TrainSize:  quantization.DefaultTrainSize,
+	}
+}
+
+func New() *Algorithm {
+	return NewWithConfig(DefaultConfig())
+}
+
+func NewWithConfig(config Config) *Algorithm {
+	defaults := DefaultConfig()
+	if config.File == "" {
+		config.File = fmt.Sprintf("vamana-%016x.index", rand.Uint64())
+	}
+	if config.MaxDegree < 1 {
+		config.MaxDegree = defaults.MaxDegree
+	}
+	if config.BuildList < 1 {
+		config.BuildList = defaults.BuildList
+	}
+	if config.SearchList < 1 {
+		config.SearchList = defaults.SearchList
+	}
+	if config.BeamWidth < 1 {
+		config.BeamWidth = defaults.BeamWidth
+	}
+	if config.Alpha < 1 {
+		config.Alpha = defaults.Alpha
+	}
+	if config.Metric == "" {
+		config.Metric = defaults.Metric
+	}
+	if config.TrainSize < 1 {
+		config.TrainSize = defaults.TrainSize
+	}
+	return &Algorithm{
+		name:       config.File,
+		byId:       make(map[int]uint32),
+		maxDegree:  config.MaxDegree,
+		buildList:  config.BuildList,
+		searchList: config.SearchList,
+		beamWidth:  config.BeamWidth,
+		alpha:      config.Alpha,
+		metric:     config.Metric,
+		trainSize:  config.TrainSize,
+		rng:        rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Metric returns the distance metric the graph is built for.
+func (a *Algorithm) Metric() string {
+	return a.metric
+}
+
+// SetSearchList changes the candidate list size of later queries.
+func (a *Algorithm) SetSearchList(searchList int) {
+	if searchList > 0 {
+		a.searchList = searchList
+	}
+}
+
+// SetDir places the index file in dir, the system's temporary directory when
+// dir is empty. It must be called before Create or LoadIndex.
+func (a *Algorithm) SetDir(dir string) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	a.dir = dir
+}
+
+// File returns the name of the index file.
+func (a *Algorithm) File() string {
+	return a.name
+}
+
+// Path returns the path of the index file.
+func (a *Algorithm) Path() string {
+	if a.dir == "" {
+		return filepath.Join(os.TempDir(), a.name)
+	}
+	return filepath.Join(a.dir, a.name)
+}
+
+// Create creates the index file of an empty index, replacing any file left
+// with its name.
+func (a *Algorithm) Create() error {
+	if a.file != nil {
+		return fmt.Errorf("vamana index already has a file: %s", a.file.path)
+	}
+	fl, err := createFile(a.Path(), a.maxDegree)
+	if err != nil {
+		return err
+	}
+	a.file = fl
+	return nil
+}
+
+// Close releases the index file and empties the index, so that later
+// queries find nothing rather than read a released mapping.
+func (a *Algorithm) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.close()
+	a.file = nil
+	a.nodes = nil
+	a.byId = make(map[int]uint32)
+	a.tombstones = 0
+	a.scalar = nil
+	a.free = nil
+	a.saved = nil
+	return err
+}
+
+// Remove closes the index file and deletes it.
+func (a *Algorithm) Remove() error {
+	if err := a.Close(); err != nil {
+		return err
+	}
+	err := os.Remove(a.Path())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+/*
+Reserve readies the file for the write to come, so that the write can't fail:
+it grows the file to hold entries, the entries about to be added or updated,
+and marks it dirty. entries is nil before a removal. The engine calls it
+before logging a write.
+
+Writes made without Reserve do the same first. When that fails they leave
+the index as it was, and the next Reserve or SaveIndex returns the error.
+*/
+func (a *Algorithm) Reserve(entries []algorithms.Entry) error {
+	if err := a.err; err != nil {
+		a.err = nil
+		return err
+	}
+	return a.prepare(entries)
+}
+
+// prepare does the work of Reserve. It creates the file in the temporary
+// directory when no file was created, so that the algorithm works on its
+// own. The file takes the dimensions of the first vector written.
+func (a *Algorithm) prepare(entries []algorithms.Entry) error {
+	if a.file == nil {
+		if err := a.Create(); err != nil {
+			return err
+		}
+	}
+	fl := a.file
+	if err := fl.touch(); err != nil {
+		return err
+	}
+	if fl.dims == 0 {
+		if len(entries) == 0 {
+			return nil
+		}
+		if err := fl.setDims(len(entries[0].Vector.Values)); err != nil {
+			return err
+		}
+	}
+	return fl.reserve(len(a.nodes) + max(0, len(entries)-len(a.free)))
+}
+
+// fail records the failure of a write made without Reserve.
+func (a *Algorithm) fail(err error) {
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+// allocate writes entry to a free slot, or a new one, and returns the slot.
+// The file must have room for it, see prepare.
+func (a *Algorithm) allocate(entry algorithms.Entry) uint32 {
+	slot := uint32(len(a.nodes))
+	if len(a.free) > 0 {
+		slot = a.free[len(a.free)-1]
+		a.free = a.free[:len(a.free)-1]
+	} else {
+		a.nodes = append(a.nodes, node{})
+	}
+	a.file.setVector(slot, entry.Vector.Values)
+	stored := entry
+	stored.Vector = vector.Vector{}
+	n := node{entry: stored}
+	if a.scalar != nil {
+		n.code = a.scalar.Encode(a.file.point(slot))
+	}
+	a.nodes[slot] = n
+	a.byId[entry.Id] = slot
+	return slot
+}
+
+// deadSlots returns the slots of the dead nodes, last first.
+func (a *Algorithm) deadSlots() []uint32 {
+	slots := []uint32{}
+	for slot := len(a.nodes) - 1; slot >= 0; slot-- {
+		if a.nodes[slot].state == dead {
+			slots = append(slots, uint32(slot))
+		}
+	}
+	return slots
+}
+
+func (a *Algorithm) AddEntry(entry algorithms.Entry) {
+	if _, exists := a.byId[entry.Id]; exists {
+		a.UpdateEntry(entry)
+		return
+	}
+	if err := a.prepare([]algorithms.Entry{entry}); err != nil {
+		a.fail(err)
+		return
+	}
+	a.insert(entry)
+	a.trainIfDue()
+}
+
+// insert adds entry as a new node linked into the graph, after prepare.
+func (a *Algorithm) insert(entry algorithms.Entry) {
+	first := len(a.byId) == 0
+	if first {
+		a.clear()
+	}
+	slot := a.allocate(entry)
+	if first {
+		a.start = slot
+		return
+	}
+	a.link(slot, a.file.point(slot), a.alpha)
+}
+
+// clear marks every node dead, when the last live one is gone and the graph
+// starts over.
+func (a *Algorithm) clear() {
+	for slot := range a.nodes {
+		a.nodes[slot].state = dead
+	}
+	a.tombstones = 0
+}
+
+// AddEntries adds a batch of entries. A batch given to an empty index is
+// built in bulk, see build, the entries of other batches are inserted one by
+// one.
+func (a *Algorithm) AddEntries(entries []algorithms.Entry) {
+	if err := a.prepare(entries); err != nil {
+		a.fail(err)
+		return
+	}
+	if len(a.byId) > 0 || len(entries) < 2 {
+		for _, entry := range entries {
+			a.AddEntry(entry)
+		}
+		return
+	}
+
+	a.clear()
+	slots := make([]uint32, 0, len(entries))
+	for _, entry := range entries {
+		if slot, exists := a.byId[entry.Id]; exists {
+			a.nodes[slot].state = dead
+		}
+		slots = append(slots, a.allocate(entry))
+	}
+	slots = slices.DeleteFunc(slots, func(slot uint32) bool {
+		return a.nodes[slot].state != live
+	})
+	a.trainIfDue()
+	a.build(slots)
+}
+
+// trainIfDue trains the quantizer once the index holds TrainSize entries,
+// on a sample of them, and encodes every node still in the graph.
+func (a *Algorithm) trainIfDue() {
+	if a.scalar != nil || len(a.byId) < a.trainSize {
+		return
+	}
+	slots := make([]uint32, 0, len(a.byId))
+	for _, slot := range a.byId {
+		slots = append(slots, slot)
+	}
+	slices.Sort(slots)
+	a.rng.Shuffle(len(slots), func(i, j int) {
+		slots[i], slots[j] = slots[j], slots[i]
+	})
+	points := make([][]float64, min(len(slots), a.trainSize))
+	for i := range points {
+		points[i] = a.file.point(slots[i])
+	}
+	a.scalar = quantization.TrainScalar(points)
+	for slot := range a.nodes {
+		if a.nodes[slot].state != dead {
+			a.nodes[slot].code = a.scalar.Encode(a.file.point(uint32(slot)))
+		}
+	}
+}
+
+// GetEntry returns the entry with its vector, read from the file.
+func (a *Algorithm) GetEntry(id int) (algorithms.Entry, bool) {
+	slot, exists := a.byId[id]
+	if !exists {
+		return algorithms.Entry{}, false
+	}
+	return a.entry(slot), true
+}
+
+// entry returns the entry of slot with its vector.
+func (a *Algorithm) entry(slot uint32) algorithms.Entry {
+	entry := a.nodes[slot].entry
+	entry.Vector = vector.Vector{Values: a.file.point(slot)}
+	return entry
+}
+
+// UpdateEntry swaps the stored entry in place when only its metadata
+// changed. A new vector is inserted in another slot, the old one left as a
+// tombstone.
+func (a *Algorithm) UpdateEntry(entry algorithms.Entry) bool {
+	slot, exists := a.byId[entry.Id]
+	if !exists {
+		return false
+	}
+
+	current := vector.Vector{Values: a.file.point(slot)}
+	if current.Equal(&entry.Vector) {
+		entry.Vector = vector.Vector{}
+		a.nodes[slot].entry = entry
+		return true
+	}
+
+	if err := a.prepare([]algorithms.Entry{entry}); err != nil {
+		a.fail(err)
+		return true
+	}
+	a.nodes[slot].state = deleted
+	a.tombstones++
+	delete(a.byId, entry.Id)
+	a.insert(entry)
+	a.consolidateIfDue()
+	return true
+}
+
+func (a *Algorithm) RemoveEntry(id int) bool {
+	slot, exists := a.byId[id]
+	if !exists {
+		return false
+	}
+	a.nodes[slot].state = deleted
+	a.tombstones++
+	delete(a.byId, id)
+	if len(a.byId) == 0 {
+		a.clear()
+		return true
+	}
+	a.consolidateIfDue()
+	return true
+}
+
+// consolidateIfDue unlinks the tombstones once they make up a fifth of the
+// nodes in the graph.
+func (a *Algorithm) consolidateIfDue() {
+	if float64(a.tombstones) < consolidateShare*float64(a.tombstones+len(a.byId)) {
+		return
+	}
+	if err := a.prepare(nil); err != nil {
+		a.fail(err)
+		return
+	}
+	a.consolidate()
+}
+
+// ListEntries returns the entries sorted by id, with their vectors.
+func (a *Algorithm) ListEntries() []algorithms.Entry {
+	entries := a.ListStored()
+	for i, entry := range entries {
+		entries[i].Vector = vector.Vector{Values: a.file.point(a.byId[entry.Id])}
+	}
+	return entries
+}
+
+// ListStored returns the entries sorted by id, without their vectors: the
+// file holds them.
+func (a *Algorithm) ListStored() []algorithms.Entry {
+	entries := make([]algorithms.Entry, 0, len(a.byId))
+	for _, slot := range a.byId {
+		entries = append(entries, a.nodes[slot].entry)
+	}
+	slices.SortFunc(entries, func(e1 algorithms.Entry, e2 algorithms.Entry) int {
+		return cmp.Compare(e1.Id, e2.Id)
+	})
+	return entries
+}
+
+func (a *Algorithm) Query(queryVector *vector.Vector, k int, metric string) []algorithms.Entry {
+	return a.QueryWithOptions(queryVector, k, metric, algorithms.QueryOptions{})
+}
+
+/*
+QueryWithOptions returns the k entries accepted by the filter that the beam
+search finds closest, closest first, ranked by their exact distance. The
+candidate list holds at least k nodes, and is doubled while the search
+expands fewer than k accepted entries, up to the whole graph. Entries the
+graph doesn't reach then, such as some of many equal vectors, are scored
+too. Filters are given the entries without their vectors.
+*/
+func (a *Algorithm) QueryWithOptions(queryVector *vector.Vector, k int, metric string, options algorithms.QueryOptions) []algorithms.Entry {
+	if k <= 0 || len(a.byId) == 0 {
+		return []algorithms.Entry{}
+	}
+
+	point := queryVector.Values
+	size := max(a.searchList, k)
+	for {
+		found := a.search(point, metric, size, true)
+		if size >= len(a.nodes) {
+			found = a.unreached(point, metric, found)
+		}
+
+		entries := []algorithms.Entry{}
+		for _, f := range found {
+			n := a.nodes[f.slot]
+			if n.state != live || !options.Accepts(n.entry) {
+				continue
+			}
+			entries = append(entries, a.entry(f.slot))
+			if len(entries) == k {
+				return entries
+			}
+		}
+		if size >= len(a.nodes) {
+			return entries
+		}
+		size *= 2
+	}
+}
+
+// unreached appends to found the live nodes it misses, scored against point,
+// closest first.
+func (a *Algorithm) unreached(point []float64, metric string, found []scored) []scored {
+	seen := make(map[uint32]bool, len(found))
+	for _, f := range found {
+		seen[f.slot] = true
+	}
+	missed := []scored{}
+	for _, slot := range a.byId {
+		if !seen[slot] {
+			missed = append(missed, scored{slot: slot, distance: distance(point, a.file.point(slot), metric)})
+		}
+	}
+	slices.SortFunc(missed, compareScored)
+	return append(found, missed...)
+}
+
+// distance scores two points of the file with metric, as
+// vector.Distance_score does, without allocating for the usual metrics.
+func distance(p1 []float64, p2 []float64, metric string) float64 {
+	switch metric {
+	case "euclidean":
+		return math.Sqrt(kmeans.SquaredDistance(p1, p2))
+	case "cosine", "dot_product":
+		dot, norm1, norm2 := 0.0, 0.0, 0.0
+		for d, value := range p1 {
+			dot += value * p2[d]
+			norm1 += value * value
+			norm2 += p2[d] * p2[d]
+		}
+		return 1 - (1+dot/(math.Sqrt(norm1)*math.Sqrt(norm2)))/2
+	}
+	return (&vector.Vector{Values: p1}).Distance_score(&vector.Vector{Values: p2}, metric)
+}
+
+// medoid returns the slot among slots closest to their mean.
+func (a *Algorithm) medoid(slots []uint32) uint32 {
+	mean := make([]float64, a.file.dims)
+	for _, slot := range slots {
+		for d, value := range a.file.point(slot) {
+			mean[d] += value / float64(len(slots))
+		}
+	}
+	best := slots[0]
+	bestDistance := kmeans.SquaredDistance(mean, a.file.point(best))
+	for _, slot := range slots[1:] {
+		if d := kmeans.SquaredDistance(mean, a.file.point(slot)); d < bestDistance {
+			best, bestDistance = slot, d
+		}
+	}
+	return best
+}
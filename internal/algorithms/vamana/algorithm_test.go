@@ -0,0 +1,185 @@
+package vamana
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/vector"
+	"cmp"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAlgorithm returns an algorithm with its file in a temporary directory,
+// closed at the end of the test.
+func newAlgorithm(t *testing.T, config Config) *Algorithm {
+	t.Helper()
+	alg := NewWithConfig(config)
+	alg.SetDir(t.TempDir())
+	require.NoError(t, alg.Create())
+	t.Cleanup(func() { alg.Close() })
+	return alg
+}
+
+func TestAlgorithm_Recall(t *testing.T) {
+	for _, metric := range []string{"euclidean", "cosine"} {
+		rng := rand.New(rand.NewSource(1))
+		entries := algotest.NormalEntries(rng, 1000, 16)
+		queries := algotest.NormalEntries(rng, 30, 16)
+		alg := newAlgorithm(t, Config{Metric: metric, TrainSize: 500, Seed: 1})
+		alg.AddEntries(entries)
+		require.NotNil(t, alg.scalar, "the quantizer is trained on the batch")
+
+		r := algotest.Recall(alg, queries, algotest.Neighbours(entries, queries, 10, metric), metric)
+		t.Logf("%s: recall %.3f", metric, r)
+		assert.GreaterOrEqual(t, r, 0.9, metric)
+
+		results := alg.Query(&queries[0].Vector, 10, metric)
+		assert.True(t, slices.IsSortedFunc(results, func(e1 algorithms.Entry, e2 algorithms.Entry) int {
+			return compareDistances(&queries[0].Vector, e1, e2, metric)
+		}), "closest first")
+	}
+}
+
+func compareDistances(query *vector.Vector, e1 algorithms.Entry, e2 algorithms.Entry, metric string) int {
+	return cmp.Compare(query.Distance_score(&e1.Vector, metric), query.Distance_score(&e2.Vector, metric))
+}
+
+func TestAlgorithm_SearchList(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	entries := algotest.NormalEntries(rng, 1000, 16)
+	queries := algotest.NormalEntries(rng, 30, 16)
+	alg := newAlgorithm(t, Config{MaxDegree: 8, SearchList: 10, TrainSize: 500, Seed: 2})
+	alg.AddEntries(entries)
+
+	truth := algotest.Neighbours(entries, queries, 10, "euclidean")
+	base := algotest.Recall(alg, queries, truth, "euclidean")
+	alg.SetSearchList(100)
+	more := algotest.Recall(alg, queries, truth, "euclidean")
+	t.Logf("recall %.3f, with searchList 100 %.3f", base, more)
+	assert.Greater(t, more, base, "a longer list finds more neighbours")
+}
+
+func TestAlgorithm_AddEntry(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	entries := algotest.NormalEntries(rng, 1500, 8)
+	queries := algotest.NormalEntries(rng, 30, 8)
+	alg := newAlgorithm(t, Config{TrainSize: 1000, Seed: 3})
+	for i, entry := range entries {
+		alg.AddEntry(entry)
+		if i == 998 {
+			assert.Nil(t, alg.scalar, "candidates are scored exactly until TrainSize entries")
+		}
+	}
+	require.NotNil(t, alg.scalar)
+	assertGraph(t, alg)
+
+	r := algotest.Recall(alg, queries, algotest.Neighbours(entries, queries, 10, "euclidean"), "euclidean")
+	t.Logf("recall %.3f", r)
+	assert.GreaterOrEqual(t, r, 0.9)
+}
+
+func TestAlgorithm_Query_Filter(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	entries := algotest.NormalEntries(rng, 1000, 8)
+	alg := newAlgorithm(t, Config{TrainSize: 500, Seed: 4})
+	alg.AddEntries(entries)
+
+	options := algorithms.QueryOptions{Filter: func(entry algorithms.Entry) bool {
+		return entry.Id%50 == 0
+	}}
+	query := algotest.NormalEntries(rng, 1, 8)[0].Vector
+	results := alg.QueryWithOptions(&query, 10, "euclidean", options)
+	require.Len(t, results, 10, "the list grows until k entries pass the filter")
+	for _, entry := range results {
+		assert.Zero(t, entry.Id%50)
+	}
+	accepted := slices.DeleteFunc(slices.Clone(entries), func(entry algorithms.Entry) bool {
+		return entry.Id%50 != 0
+	})
+	assert.Equal(t, algotest.Exact(accepted, &query, 10, "euclidean"), results)
+}
+
+func TestAlgorithm_Query_Empty(t *testing.T) {
+	alg := newAlgorithm(t, Config{})
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 5, "euclidean"))
+	alg.AddEntry(algorithms.Entry{Vector: *vector.NewVector(1, 0), Id: 1})
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 0, "euclidean"))
+	assert.Len(t, alg.Query(vector.NewVector(1, 0), 5, "euclidean"), 1)
+	alg.RemoveEntry(1)
+	assert.Empty(t, alg.Query(vector.NewVector(1, 0), 5, "euclidean"))
+	alg.AddEntry(algorithms.Entry{Vector: *vector.NewVector(0, 1), Id: 2})
+	assert.Equal(t, []algorithms.Entry{{Vector: *vector.NewVector(0, 1), Id: 2}}, alg.Query(vector.NewVector(1, 0), 5, "euclidean"))
+}
+
+func TestAlgorithm_RemoveUpdate(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	entries := algotest.NormalEntries(rng, 600, 8)
+	queries := algotest.NormalEntries(rng, 30, 8)
+	alg := newAlgorithm(t, Config{TrainSize: 500, Seed: 5})
+	alg.AddEntries(entries)
+
+	entry := entries[4]
+	entry.Metadata = map[string]string{"name": "renamed"}
+	slot := alg.byId[5]
+	assert.True(t, alg.UpdateEntry(entry))
+	assert.Equal(t, slot, alg.byId[5], "metadata changes keep the slot")
+	got, _ := alg.GetEntry(5)
+	assert.Equal(t, entry, got)
+
+	moved := algorithms.Entry{Vector: *vector.NewVector(9, 9, 9, 9, 9, 9, 9, 9), Id: 5}
+	assert.True(t, alg.UpdateEntry(moved))
+	assert.NotEqual(t, slot, alg.byId[5], "new vectors take a new slot")
+	assert.Equal(t, moved, alg.Query(&moved.Vector, 1, "euclidean")[0])
+
+	assert.True(t, alg.RemoveEntry(5))
+	assert.False(t, alg.RemoveEntry(5))
+	assert.False(t, alg.UpdateEntry(moved))
+	assert.False(t, slices.ContainsFunc(alg.Query(&moved.Vector, 100, "euclidean"), func(entry algorithms.Entry) bool {
+		return entry.Id == 5
+	}))
+
+	// removing a fifth of the entries unlinks the tombstones
+	removed := rng.Perm(600)[:200]
+	for _, i := range removed {
+		alg.RemoveEntry(i + 1)
+	}
+	assert.Less(t, alg.tombstones, len(alg.nodes)/5)
+	assertGraph(t, alg)
+	live := slices.DeleteFunc(slices.Clone(entries), func(entry algorithms.Entry) bool {
+		_, exists := alg.byId[entry.Id]
+		return !exists
+	})
+	require.Len(t, alg.ListEntries(), len(live))
+	r := algotest.Recall(alg, queries, algotest.Neighbours(live, queries, 10, "euclidean"), "euclidean")
+	t.Logf("recall %.3f after removals", r)
+	assert.GreaterOrEqual(t, r, 0.9)
+}
+
+func TestAlgorithm_ListEntries(t *testing.T) {
+	alg := newAlgorithm(t, Config{})
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(6)), 50, 3)
+	for i := len(entries) - 1; i >= 0; i-- {
+		alg.AddEntry(entries[i])
+	}
+	assert.Equal(t, entries, alg.ListEntries(), "sorted by id, vectors read back as written")
+
+	for _, entry := range alg.ListStored() {
+		assert.Nil(t, entry.Vector.Values)
+	}
+	for _, n := range alg.nodes {
+		assert.Nil(t, n.entry.Vector.Values, "memory holds no vector")
+	}
+}
+
+func TestAlgorithm_WithoutFile(t *testing.T) {
+	alg := New()
+	t.Cleanup(func() { alg.Remove() })
+	alg.AddEntry(algorithms.Entry{Vector: *vector.NewVector(1, 0), Id: 1})
+	assert.FileExists(t, alg.Path(), "the file is created in the temporary directory")
+	require.NoError(t, alg.Remove())
+	assert.NoFileExists(t, alg.Path())
+}
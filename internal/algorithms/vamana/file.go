@@ -0,0 +1,286 @@
+package vamana
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+)
+
+const (
+	fileMagic   = "VLVAMANA"
+	fileVersion = 2
+	// headerSize keeps the slots aligned on pages
+	headerSize = 4096
+	// initialSlots is the number of slots of a new file, doubled as it fills
+	initialSlots = 1024
+)
+
+var ErrCorruptFile = errors.New("corrupt vamana file")
+
+/*
+file is the index file, memory-mapped. It starts with a header page:
+
+	magic "VLVAMANA", version uint32
+	dims uint32, maximum degree uint32, dirty uint32
+	token uint64, stamp uint64
+
+followed by fixed-size slots, one per node of the graph:
+
+	values, dims float64
+	degree uint32
+	neighbours, maximum degree uint32 slots
+
+Numbers are little-endian. The token is drawn when the file is created, the
+snapshot records it so that it is never loaded with the index of another
+file. dims is 0 until the first vector is written, the file holds no slot
+until then.
+
+The stamp is drawn by every save, and recorded by the snapshot along with the
+token. dirty is set, and made durable, before the first write that follows a
+save: a file that is dirty, or whose stamp is not the one of the snapshot,
+may have links the snapshot doesn't know about.
+
+The mapping is remapped when the file grows, so slices of it must not be kept
+across writes. Writes must follow a successful touch.
+*/
+type file struct {
+	path   string
+	f      *os.File
+	data   []byte
+	dims   int
+	degree int
+	token  uint64
+	stamp  uint64
+	// dirty is set once the file was written since the last save
+	dirty bool
+	// capacity is the number of slots the file has room for
+	capacity int
+}
+
+// createFile creates the file at path, replacing any file already there, for
+// nodes linked to at most degree neighbours.
+func createFile(path string, degree int) (*file, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	fl := &file{path: path, f: f, degree: degree, token: rand.Uint64()}
+	if err := fl.resize(0); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	fl.writeHeader()
+	return fl, nil
+}
+
+// openFile opens the file at path, written by a previous createFile.
+func openFile(path string) (*file, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	fl, err := mapExisting(path, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fl, nil
+}
+
+// mapExisting maps f and reads its header.
+func mapExisting(path string, f *os.File) (*file, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < headerSize || info.Size() > math.MaxInt {
+		return nil, fmt.Errorf("%w: %s is %d bytes", ErrCorruptFile, path, info.Size())
+	}
+	data, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	fl := &file{path: path, f: f, data: data}
+	if string(data[:len(fileMagic)]) != fileMagic {
+		unmapFile(data)
+		return nil, fmt.Errorf("%w: %s is not a vamana file", ErrCorruptFile, path)
+	}
+	if version := binary.LittleEndian.Uint32(data[8:]); version != fileVersion {
+		unmapFile(data)
+		return nil, fmt.Errorf("%w: %s has unsupported version %d", ErrCorruptFile, path, version)
+	}
+	fl.dims = int(binary.LittleEndian.Uint32(data[12:]))
+	fl.degree = int(binary.LittleEndian.Uint32(data[16:]))
+	fl.dirty = binary.LittleEndian.Uint32(data[20:]) != 0
+	fl.token = binary.LittleEndian.Uint64(data[24:])
+	fl.stamp = binary.LittleEndian.Uint64(data[32:])
+	if fl.dims > 0 {
+		fl.capacity = (len(data) - headerSize) / fl.recordSize()
+	}
+	return fl, nil
+}
+
+func (fl *file) writeHeader() {
+	copy(fl.data, fileMagic)
+	binary.LittleEndian.PutUint32(fl.data[8:], fileVersion)
+	binary.LittleEndian.PutUint32(fl.data[12:], uint32(fl.dims))
+	binary.LittleEndian.PutUint32(fl.data[16:], uint32(fl.degree))
+	dirty := uint32(0)
+	if fl.dirty {
+		dirty = 1
+	}
+	binary.LittleEndian.PutUint32(fl.data[20:], dirty)
+	binary.LittleEndian.PutUint64(fl.data[24:], fl.token)
+	binary.LittleEndian.PutUint64(fl.data[32:], fl.stamp)
+}
+
+// touch marks the file dirty before its first write since the last save.
+// The header is synced, so that the mark reaches the disk before the write.
+func (fl *file) touch() error {
+	if fl.dirty {
+		return nil
+	}
+	fl.dirty = true
+	fl.writeHeader()
+	if err := syncMapping(fl.data[:headerSize]); err != nil {
+		fl.dirty = false
+		fl.writeHeader()
+		return err
+	}
+	return nil
+}
+
+// save makes the writes to the file durable, then stamps it clean and
+// returns the stamp.
+func (fl *file) save() (uint64, error) {
+	if err := syncMapping(fl.data); err != nil {
+		return 0, err
+	}
+	fl.stamp = rand.Uint64()
+	fl.dirty = false
+	fl.writeHeader()
+	if err := syncMapping(fl.data[:headerSize]); err != nil {
+		return 0, err
+	}
+	return fl.stamp, nil
+}
+
+// setDims fixes the number of values of the slots, once, before the first
+// vector is written.
+func (fl *file) setDims(dims int) error {
+	fl.dims = dims
+	fl.writeHeader()
+	return fl.reserve(initialSlots)
+}
+
+func (fl *file) recordSize() int {
+	return 8*fl.dims + 4 + 4*fl.degree
+}
+
+// reserve grows the file, doubling it, until it has room for slots slots.
+func (fl *file) reserve(slots int) error {
+	if slots <= fl.capacity {
+		return nil
+	}
+	return fl.resize(max(slots, 2*fl.capacity))
+}
+
+// resize grows the file to hold capacity slots and maps it again. The new
+// bytes are written rather than left as a hole, so that a full disk fails
+// here and not in a later write through the mapping.
+func (fl *file) resize(capacity int) error {
+	size := headerSize + capacity*fl.recordSize()
+	if err := fl.fill(int64(size)); err != nil {
+		return err
+	}
+	if fl.data != nil {
+		if err := unmapFile(fl.data); err != nil {
+			return err
+		}
+		fl.data = nil
+	}
+	data, err := mapFile(fl.f, size)
+	if err != nil {
+		return err
+	}
+	fl.data = data
+	fl.capacity = capacity
+	return nil
+}
+
+// fill writes zeros from the end of the file up to size.
+func (fl *file) fill(size int64) error {
+	info, err := fl.f.Stat()
+	if err != nil {
+		return err
+	}
+	zeros := make([]byte, min(size, 1<<20))
+	for offset := info.Size(); offset < size; {
+		n, err := fl.f.WriteAt(zeros[:min(int64(len(zeros)), size-offset)], offset)
+		if err != nil {
+			return err
+		}
+		offset += int64(n)
+	}
+	return nil
+}
+
+func (fl *file) record(slot uint32) []byte {
+	size := fl.recordSize()
+	offset := headerSize + int(slot)*size
+	return fl.data[offset : offset+size]
+}
+
+// point returns the vector of slot.
+func (fl *file) point(slot uint32) []float64 {
+	record := fl.record(slot)
+	point := make([]float64, fl.dims)
+	for d := range point {
+		point[d] = math.Float64frombits(binary.LittleEndian.Uint64(record[8*d:]))
+	}
+	return point
+}
+
+// setVector writes values, which have the dimensions of the file, to slot and
+// clears its neighbours.
+func (fl *file) setVector(slot uint32, values []float64) {
+	record := fl.record(slot)
+	for d := 0; d < fl.dims; d++ {
+		binary.LittleEndian.PutUint64(record[8*d:], math.Float64bits(values[d]))
+	}
+	fl.setNeighbours(slot, nil)
+}
+
+// neighbours returns the neighbours of slot. A degree torn by a crash is
+// capped to the maximum.
+func (fl *file) neighbours(slot uint32) []uint32 {
+	record := fl.record(slot)[8*fl.dims:]
+	degree := min(int(binary.LittleEndian.Uint32(record)), fl.degree)
+	neighbours := make([]uint32, degree)
+	for i := range neighbours {
+		neighbours[i] = binary.LittleEndian.Uint32(record[4+4*i:])
+	}
+	return neighbours
+}
+
+func (fl *file) setNeighbours(slot uint32, neighbours []uint32) {
+	record := fl.record(slot)[8*fl.dims:]
+	for i, neighbour := range neighbours {
+		binary.LittleEndian.PutUint32(record[4+4*i:], neighbour)
+	}
+	binary.LittleEndian.PutUint32(record, uint32(len(neighbours)))
+}
+
+func (fl *file) close() error {
+	err := unmapFile(fl.data)
+	fl.data = nil
+	if closeErr := fl.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,291 @@
+package vamana
+
+import (
+	"cmp"
+	"slices"
+)
+
+// scored is a slot at some distance from a point.
+type scored struct {
+	slot     uint32
+	distance float64
+}
+
+func compareScored(s1 scored, s2 scored) int {
+	if c := cmp.Compare(s1.distance, s2.distance); c != 0 {
+		return c
+	}
+	return cmp.Compare(s1.slot, s2.slot)
+}
+
+// candidate is a node of the list of a beam search.
+type candidate struct {
+	scored
+	expanded bool
+}
+
+/*
+search is a beam search for point from the start node. The list holds the
+size closest nodes found so far, by their quantized distance when quantized
+is set and the quantizer trained, by their exact distance otherwise. The
+beamWidth closest nodes not expanded yet are expanded together: their vector
+and links are read from the file, and their neighbours join the list. The
+search stops when every node of the list was expanded.
+
+It returns the expanded nodes, tombstones included, closest first by their
+exact distance. Links to slots the graph doesn't hold, written after the
+snapshot it was loaded from, are skipped.
+*/
+func (a *Algorithm) search(point []float64, metric string, size int, quantized bool) []scored {
+	exact := func(slot uint32) float64 {
+		return distance(point, a.file.point(slot), metric)
+	}
+	estimate := exact
+	if quantized && a.scalar != nil {
+		query := a.scalar.Query(point)
+		estimate = func(slot uint32) float64 {
+			return query.Distance(a.nodes[slot].code, metric)
+		}
+	} else {
+		quantized = false
+	}
+
+	seen := map[uint32]bool{a.start: true}
+	list := []candidate{{scored: scored{slot: a.start, distance: estimate(a.start)}}}
+	expanded := []scored{}
+	for {
+		beam := make([]int, 0, a.beamWidth)
+		for i := range list {
+			if !list[i].expanded {
+				beam = append(beam, i)
+				if len(beam) == a.beamWidth {
+					break
+				}
+			}
+		}
+		if len(beam) == 0 {
+			break
+		}
+
+		for _, i := range beam {
+			c := &list[i]
+			c.expanded = true
+			found := c.scored
+			if quantized {
+				found.distance = exact(c.slot)
+			}
+			expanded = append(expanded, found)
+			for _, neighbour := range a.file.neighbours(c.slot) {
+				if int(neighbour) >= len(a.nodes) || a.nodes[neighbour].state == dead || seen[neighbour] {
+					continue
+				}
+				seen[neighbour] = true
+				list = append(list, candidate{scored: scored{slot: neighbour, distance: estimate(neighbour)}})
+			}
+		}
+		slices.SortFunc(list, func(c1 candidate, c2 candidate) int {
+			return compareScored(c1.scored, c2.scored)
+		})
+		list = list[:min(len(list), size)]
+	}
+
+	slices.SortFunc(expanded, compareScored)
+	return expanded
+}
+
+/*
+prune returns the neighbours of slot, at point, chosen among candidates and
+its current neighbours, as RobustPrune of Vamana: going through them closest
+first, a candidate is kept unless a neighbour already kept is more than alpha
+times closer to it than point, until maxDegree are kept. Ties keep the
+candidate, so that equal vectors still link to each other. candidates are
+scored by their distance to point, tombstones and slot itself are left out.
+*/
+func (a *Algorithm) prune(slot uint32, point []float64, candidates []scored, alpha float64) []uint32 {
+	pool := slices.Clone(candidates)
+	for _, neighbour := range a.file.neighbours(slot) {
+		if int(neighbour) < len(a.nodes) {
+			pool = append(pool, scored{slot: neighbour, distance: distance(point, a.file.point(neighbour), a.metric)})
+		}
+	}
+	// at equal distances the slots closest to slot come first: equal vectors
+	// link to their neighbours in the file rather than all to the same few
+	gap := func(c scored) uint32 {
+		return max(c.slot, slot) - min(c.slot, slot)
+	}
+	slices.SortFunc(pool, func(s1 scored, s2 scored) int {
+		if c := cmp.Compare(s1.distance, s2.distance); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(gap(s1), gap(s2)); c != 0 {
+			return c
+		}
+		return cmp.Compare(s1.slot, s2.slot)
+	})
+	pool = slices.CompactFunc(pool, func(s1 scored, s2 scored) bool {
+		return s1.slot == s2.slot
+	})
+
+	kept := make([]uint32, 0, a.maxDegree)
+	keptPoints := make([][]float64, 0, a.maxDegree)
+	for _, c := range pool {
+		if len(kept) == a.maxDegree {
+			break
+		}
+		if c.slot == slot || a.nodes[c.slot].state != live {
+			continue
+		}
+		cPoint := a.file.point(c.slot)
+		dominated := false
+		for _, keptPoint := range keptPoints {
+			if alpha*distance(keptPoint, cPoint, a.metric) < c.distance {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			kept = append(kept, c.slot)
+			keptPoints = append(keptPoints, cPoint)
+		}
+	}
+	return kept
+}
+
+// link links slot, at point, into the graph: it takes the neighbours pruned
+// from the nodes a search for it expands, and they link back to it.
+func (a *Algorithm) link(slot uint32, point []float64, alpha float64) {
+	visited := a.search(point, a.metric, a.buildList, false)
+	neighbours := a.prune(slot, point, visited, alpha)
+	a.file.setNeighbours(slot, neighbours)
+	for _, neighbour := range neighbours {
+		a.linkBack(neighbour, slot, alpha)
+	}
+}
+
+// linkBack adds a link from slot to target, pruning the neighbours of slot
+// when they are too many.
+func (a *Algorithm) linkBack(slot uint32, target uint32, alpha float64) {
+	neighbours := a.file.neighbours(slot)
+	if slices.Contains(neighbours, target) {
+		return
+	}
+	if len(neighbours) < a.maxDegree {
+		a.file.setNeighbours(slot, append(neighbours, target))
+		return
+	}
+	point := a.file.point(slot)
+	candidate := scored{slot: target, distance: distance(point, a.file.point(target), a.metric)}
+	a.file.setNeighbours(slot, a.prune(slot, point, []scored{candidate}, alpha))
+}
+
+/*
+build links slots, new nodes without links, into a graph as Vamana does: every
+node starts with random neighbours, then every node is linked again, in a
+random order, with searches from the medoid. The first pass prunes with alpha
+1 and keeps the closest links, the second one with the configured alpha adds
+the long ones.
+*/
+func (a *Algorithm) build(slots []uint32) {
+	a.start = a.medoid(slots)
+	degree := min(a.maxDegree, len(slots)-1)
+	for _, slot := range slots {
+		neighbours := make([]uint32, 0, degree)
+		for len(neighbours) < degree {
+			neighbour := slots[a.rng.Intn(len(slots))]
+			if neighbour != slot && !slices.Contains(neighbours, neighbour) {
+				neighbours = append(neighbours, neighbour)
+			}
+		}
+		a.file.setNeighbours(slot, neighbours)
+	}
+
+	for _, alpha := range []float64{1, a.alpha} {
+		for _, i := range a.rng.Perm(len(slots)) {
+			slot := slots[i]
+			a.link(slot, a.file.point(slot), alpha)
+		}
+	}
+}
+
+// relink builds the graph again over the live nodes, dropping the
+// tombstones.
+func (a *Algorithm) relink() {
+	for slot := range a.nodes {
+		if a.nodes[slot].state == deleted {
+			a.nodes[slot] = node{state: dead}
+		}
+	}
+	a.tombstones = 0
+	if len(a.byId) == 0 {
+		return
+	}
+	slots := make([]uint32, 0, len(a.byId))
+	for _, slot := range a.byId {
+		slots = append(slots, slot)
+	}
+	slices.Sort(slots)
+	a.build(slots)
+}
+
+/*
+consolidate unlinks the tombstones, as FreshDiskANN does: every live node
+linking to one is given the neighbours of the tombstone instead, pruned along
+with its other neighbours. A removed start node is replaced by the live node
+closest to it. The tombstones then die, their slots are never read again.
+*/
+func (a *Algorithm) consolidate() {
+	if a.nodes[a.start].state != live {
+		for _, found := range a.search(a.file.point(a.start), a.metric, a.buildList, false) {
+			if a.nodes[found.slot].state == live {
+				a.start = found.slot
+				break
+			}
+		}
+		if a.nodes[a.start].state != live {
+			for _, slot := range a.byId {
+				a.start = slot
+				break
+			}
+		}
+	}
+
+	for slot := range a.nodes {
+		if a.nodes[slot].state != live {
+			continue
+		}
+		neighbours := a.file.neighbours(uint32(slot))
+		if !slices.ContainsFunc(neighbours, a.isTombstone) {
+			continue
+		}
+
+		candidates := []uint32{}
+		for _, neighbour := range neighbours {
+			if !a.isTombstone(neighbour) {
+				continue
+			}
+			for _, next := range a.file.neighbours(neighbour) {
+				if int(next) < len(a.nodes) && a.nodes[next].state == live {
+					candidates = append(candidates, next)
+				}
+			}
+		}
+		point := a.file.point(uint32(slot))
+		pool := make([]scored, len(candidates))
+		for i, candidate := range candidates {
+			pool[i] = scored{slot: candidate, distance: distance(point, a.file.point(candidate), a.metric)}
+		}
+		a.file.setNeighbours(uint32(slot), a.prune(uint32(slot), point, pool, a.alpha))
+	}
+
+	for slot := range a.nodes {
+		if a.nodes[slot].state == deleted {
+			a.nodes[slot] = node{state: dead}
+		}
+	}
+	a.tombstones = 0
+}
+
+// isTombstone reports whether slot is a node the graph holds as a tombstone.
+func (a *Algorithm) isTombstone(slot uint32) bool {
+	return int(slot) < len(a.nodes) && a.nodes[slot].state == deleted
+}
@@ -0,0 +1,99 @@
+package vamana
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"VectorLite/internal/vector"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertGraph checks that every live node links to at most maxDegree other
+// nodes still in the graph, and is reached from the start node. Links past
+// the graph are skipped, as searches skip them.
+func assertGraph(t *testing.T, alg *Algorithm) {
+	t.Helper()
+	if len(alg.byId) == 0 {
+		return
+	}
+	assert.Equal(t, uint8(live), alg.nodes[alg.start].state, "the start node is live")
+
+	reached := map[uint32]bool{alg.start: true}
+	queue := []uint32{alg.start}
+	for len(queue) > 0 {
+		slot := queue[0]
+		queue = queue[1:]
+		neighbours := alg.file.neighbours(slot)
+		assert.LessOrEqual(t, len(neighbours), alg.maxDegree)
+		assert.NotContains(t, neighbours, slot, "no node links to itself")
+		for _, neighbour := range neighbours {
+			if int(neighbour) >= len(alg.nodes) {
+				// written after the snapshot the graph was loaded from
+				continue
+			}
+			assert.NotEqual(t, uint8(dead), alg.nodes[neighbour].state, "links to dead nodes are removed")
+			if !reached[neighbour] {
+				reached[neighbour] = true
+				queue = append(queue, neighbour)
+			}
+		}
+	}
+	for id, slot := range alg.byId {
+		assert.Equal(t, id, alg.nodes[slot].entry.Id)
+		assert.True(t, reached[slot], "entry %d is reached from the start node", id)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	alg := newAlgorithm(t, Config{MaxDegree: 8, Seed: 7})
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(7)), 500, 4)
+	alg.AddEntries(entries)
+	assertGraph(t, alg)
+	slots := make([]uint32, len(alg.nodes))
+	for slot := range slots {
+		slots[slot] = uint32(slot)
+	}
+	assert.Equal(t, alg.medoid(slots), alg.start, "searches start from the medoid")
+}
+
+func TestBuild_Duplicates(t *testing.T) {
+	alg := newAlgorithm(t, Config{MaxDegree: 4, Seed: 8})
+	entries := make([]algorithms.Entry, 100)
+	for i := range entries {
+		entries[i] = algorithms.Entry{Vector: *vector.NewVector(1, 1), Id: i + 1}
+	}
+	alg.AddEntries(entries)
+	assert.Len(t, alg.Query(vector.NewVector(1, 1), 100, "euclidean"), 100, "copies the graph misses are scored too")
+	results := alg.Query(vector.NewVector(1, 1), 10, "euclidean")
+	assert.Len(t, results, 10)
+}
+
+func TestPrune(t *testing.T) {
+	alg := newAlgorithm(t, Config{MaxDegree: 3})
+	// a node at the origin and candidates along a line to its right, and one
+	// to its left
+	entries := []algorithms.Entry{}
+	for i, values := range [][]float64{{0, 0}, {1, 0}, {2, 0}, {3, 0}, {-1, 0}, {0, 5}} {
+		entries = append(entries, algorithms.Entry{Vector: vector.Vector{Values: values}, Id: i + 1})
+	}
+	require.NoError(t, alg.Reserve(entries))
+	for _, entry := range entries {
+		alg.allocate(entry)
+	}
+	point := alg.file.point(0)
+	candidates := []scored{}
+	for slot := uint32(1); slot < uint32(len(alg.nodes)); slot++ {
+		candidates = append(candidates, scored{slot: slot, distance: distance(point, alg.file.point(slot), "euclidean")})
+	}
+
+	assert.Equal(t, []uint32{1, 4, 5}, alg.prune(0, point, candidates, 1),
+		"farther points behind a kept neighbour are dropped")
+	assert.Equal(t, []uint32{1, 4, 2}, alg.prune(0, point, candidates, 2.5),
+		"alpha keeps longer links, up to the maximum degree")
+
+	alg.nodes[4].state = deleted
+	assert.Equal(t, []uint32{1, 5}, alg.prune(0, point, candidates, 1), "tombstones are left out")
+}
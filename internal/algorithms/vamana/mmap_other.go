@@ -0,0 +1,22 @@
+//go:build !unix
+
+package vamana
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoMmap = errors.New("vamana: memory-mapped files are not supported on this platform")
+
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errNoMmap
+}
+
+func unmapFile(data []byte) error {
+	return errNoMmap
+}
+
+func syncMapping(data []byte) error {
+	return errNoMmap
+}
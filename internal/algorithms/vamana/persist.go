@@ -0,0 +1,212 @@
+package vamana
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/codec"
+	"VectorLite/internal/quantization"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const indexVersion = 1
+
+var ErrCorruptIndex = errors.New("corrupt vamana index")
+
+/*
+SaveIndex makes the index file durable and stamps it, then writes what memory
+holds: the token and stamp of the file, the state of every slot with the id
+of live entries, the start node, and the quantizer with the codes of the
+nodes still in the graph. A reload opens the same file and gets the same
+graph without reading the vectors again. The dead slots are reused once
+Saved reports the snapshot durable, see allocate.
+
+It fails with the error of a write made without Reserve, if any.
+
+Entries are not written, they are saved by the caller and handed back to
+LoadIndex; their vectors are not needed, see ListStored.
+*/
+func (a *Algorithm) SaveIndex(w io.Writer) error {
+	if err := a.err; err != nil {
+		a.err = nil
+		return err
+	}
+	out := codec.NewWriter(w)
+	out.Uint32(indexVersion)
+	out.Bool(a.file != nil)
+	if a.file == nil {
+		return out.Err()
+	}
+	stamp, err := a.file.save()
+	if err != nil {
+		return err
+	}
+
+	out.Uint64(a.file.token)
+	out.Uint64(stamp)
+	out.Uint32(uint32(len(a.nodes)))
+	for _, n := range a.nodes {
+		out.Byte(n.state)
+		if n.state == live {
+			out.Int64(int64(n.entry.Id))
+		}
+	}
+	a.saved = a.deadSlots()
+	out.Uint32(a.start)
+	out.Bool(a.scalar != nil)
+	if a.scalar != nil {
+		a.scalar.Save(out)
+		for _, n := range a.nodes {
+			if n.state != dead {
+				out.Raw(n.code.Bytes)
+			}
+		}
+	}
+	return out.Err()
+}
+
+/*
+LoadIndex opens the index file of the directory given to SetDir and restores
+an index written by SaveIndex with it, into an empty algorithm built with the
+same settings. entries must hold exactly the live entries of the saved
+index, their vectors are ignored.
+
+A file written after SaveIndex, as when the process stopped before the next
+snapshot, still holds the vectors of the slots live at the save, which are
+not reused until the next one, but its links may lead to slots the index
+doesn't know. The graph is then built again from the live slots.
+*/
+func (a *Algorithm) LoadIndex(r io.Reader, entries []algorithms.Entry) error {
+	if a.file != nil || len(a.byId) > 0 {
+		return fmt.Errorf("%w: index is not empty", ErrCorruptIndex)
+	}
+
+	in := codec.NewReader(r)
+	if version := in.Uint32(); in.Err() == nil && version != indexVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrCorruptIndex, version)
+	}
+	hasFile := in.Bool()
+	if err := in.Err(); err != nil {
+		return err
+	}
+	if !hasFile {
+		if len(entries) > 0 {
+			return fmt.Errorf("%w: %d entries without a file", ErrCorruptIndex, len(entries))
+		}
+		return nil
+	}
+
+	token := in.Uint64()
+	stamp := in.Uint64()
+	count := in.Length()
+	if err := in.Err(); err != nil {
+		return err
+	}
+	byId := make(map[int]algorithms.Entry, len(entries))
+	for _, entry := range entries {
+		entry.Vector.Values = nil
+		byId[entry.Id] = entry
+	}
+
+	nodes := make([]node, count)
+	slots := make(map[int]uint32, len(entries))
+	tombstones := 0
+	for slot := range nodes {
+		n := &nodes[slot]
+		n.state = in.Byte()
+		if in.Err() != nil {
+			break
+		}
+		switch n.state {
+		case live:
+			id := int(in.Int64())
+			entry, exists := byId[id]
+			if in.Err() != nil {
+				break
+			}
+			if _, repeated := slots[id]; !exists || repeated {
+				return fmt.Errorf("%w: unknown or repeated entry %d", ErrCorruptIndex, id)
+			}
+			n.entry = entry
+			slots[id] = uint32(slot)
+		case deleted:
+			tombstones++
+		case dead:
+		default:
+			return fmt.Errorf("%w: slot %d has unknown state %d", ErrCorruptIndex, slot, n.state)
+		}
+	}
+	start := in.Uint32()
+	trained := in.Bool()
+	if err := in.Err(); err != nil {
+		return err
+	}
+	if len(slots) != len(entries) {
+		return fmt.Errorf("%w: %d of %d entries in the graph", ErrCorruptIndex, len(slots), len(entries))
+	}
+	if len(slots) > 0 && (int(start) >= count || nodes[start].state == dead) {
+		return fmt.Errorf("%w: start node %d is not in the graph", ErrCorruptIndex, start)
+	}
+
+	fl, err := openFile(a.Path())
+	if err != nil {
+		return err
+	}
+	if fl.token != token {
+		fl.close()
+		return fmt.Errorf("%w: %s belongs to another index", ErrCorruptIndex, fl.path)
+	}
+	if count > 0 && count > fl.capacity {
+		fl.close()
+		return fmt.Errorf("%w: %s holds %d slots, the index %d", ErrCorruptIndex, fl.path, fl.capacity, count)
+	}
+
+	var scalar *quantization.Scalar
+	if trained {
+		if scalar, err = quantization.LoadScalar(in); err != nil {
+			fl.close()
+			return err
+		}
+		if scalar.Dims() != fl.dims {
+			fl.close()
+			return fmt.Errorf("%w: quantizer of %d dimensions for vectors of %d", ErrCorruptIndex, scalar.Dims(), fl.dims)
+		}
+		for slot := range nodes {
+			if nodes[slot].state == dead {
+				continue
+			}
+			bytes := make([]uint8, scalar.Dims())
+			in.Raw(bytes)
+			nodes[slot].code = scalar.Restore(bytes)
+		}
+		if err := in.Err(); err != nil {
+			fl.close()
+			return err
+		}
+	}
+
+	a.file = fl
+	a.nodes = nodes
+	a.byId = slots
+	a.start = start
+	a.tombstones = tombstones
+	a.scalar = scalar
+	if fl.dirty || fl.stamp != stamp {
+		if err := fl.touch(); err != nil {
+			a.Close()
+			return err
+		}
+		a.relink()
+	}
+	a.free = a.deadSlots()
+	return nil
+}
+
+// Saved makes the slots that were dead at the last SaveIndex free, once the
+// snapshot holding it is durable: no snapshot needs their vectors any more.
+func (a *Algorithm) Saved() {
+	if a.saved != nil {
+		a.free = a.saved
+		a.saved = nil
+	}
+}
@@ -0,0 +1,207 @@
+package vamana
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/algotest"
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reopen loads the index saved in saved into a new algorithm over the file of
+// alg, once alg is closed.
+func reopen(t *testing.T, alg *Algorithm, config Config, saved []byte, entries []algorithms.Entry) (*Algorithm, error) {
+	t.Helper()
+	config.File = alg.name
+	loaded := NewWithConfig(config)
+	loaded.SetDir(alg.dir)
+	t.Cleanup(func() { loaded.Close() })
+	return loaded, loaded.LoadIndex(bytes.NewReader(saved), entries)
+}
+
+func TestAlgorithm_SaveLoadIndex(t *testing.T) {
+	config := Config{MaxDegree: 16, TrainSize: 300, Seed: 9}
+	rng := rand.New(rand.NewSource(9))
+	alg := newAlgorithm(t, config)
+	alg.AddEntries(algotest.NormalEntries(rng, 500, 8))
+	alg.RemoveEntry(1)
+
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+	entries := alg.ListEntries()
+	queries := algotest.NormalEntries(rng, 20, 8)
+	expected := make([][]algorithms.Entry, len(queries))
+	for i, query := range queries {
+		expected[i] = alg.Query(&query.Vector, 10, "euclidean")
+	}
+	stored := alg.ListStored()
+	require.NoError(t, alg.Close())
+	assert.Empty(t, alg.Query(&queries[0].Vector, 10, "euclidean"), "a closed index is empty")
+
+	loaded, err := reopen(t, alg, config, buf.Bytes(), stored)
+	require.NoError(t, err)
+	assert.False(t, loaded.file.dirty, "the graph is loaded as saved")
+	assertGraph(t, loaded)
+	assert.Equal(t, entries, loaded.ListEntries(), "vectors are read from the file")
+	assert.Equal(t, 1, loaded.tombstones)
+	for i, query := range queries {
+		assert.Equal(t, expected[i], loaded.Query(&query.Vector, 10, "euclidean"))
+	}
+
+	// the loaded graph takes new entries
+	more := algotest.NormalEntries(rng, 50, 8)
+	for i := range more {
+		more[i].Id += 500
+		loaded.AddEntry(more[i])
+	}
+	assertGraph(t, loaded)
+}
+
+func TestAlgorithm_LoadIndex_AfterLaterWrites(t *testing.T) {
+	config := Config{MaxDegree: 8, TrainSize: 100, Seed: 10}
+	rng := rand.New(rand.NewSource(10))
+	alg := newAlgorithm(t, config)
+	entries := algotest.NormalEntries(rng, 200, 4)
+	alg.AddEntries(entries)
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+	saved := alg.ListEntries()
+
+	// writes after the snapshot, lost in a crash: new slots, new links and
+	// tombstones unlinked
+	assert.False(t, alg.file.dirty)
+	for i := 0; i < 60; i++ {
+		alg.RemoveEntry(i + 1)
+	}
+	for _, entry := range algotest.NormalEntries(rng, 1500, 4) {
+		entry.Id += 200
+		alg.AddEntry(entry)
+	}
+	assert.True(t, alg.file.dirty)
+	require.NoError(t, alg.Close())
+
+	loaded, err := reopen(t, alg, config, buf.Bytes(), saved)
+	require.NoError(t, err)
+	assert.Equal(t, saved, loaded.ListEntries(), "the slots of the snapshot kept their vectors")
+	assertGraph(t, loaded)
+	for _, entry := range saved[:20] {
+		results := loaded.Query(&entry.Vector, 1, "euclidean")
+		require.Len(t, results, 1)
+		assert.Equal(t, entry, results[0], "the graph is built again")
+	}
+}
+
+func TestAlgorithm_SaveLoadIndex_Empty(t *testing.T) {
+	alg := newAlgorithm(t, Config{})
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+	require.NoError(t, alg.Close())
+
+	loaded, err := reopen(t, alg, Config{}, buf.Bytes(), nil)
+	require.NoError(t, err)
+	loaded.AddEntries(algotest.NormalEntries(rand.New(rand.NewSource(11)), 20, 2))
+	assertGraph(t, loaded)
+}
+
+func TestAlgorithm_LoadIndex_Corrupt(t *testing.T) {
+	alg := newAlgorithm(t, Config{})
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(12)), 10, 2)
+	alg.AddEntries(entries)
+	var buf bytes.Buffer
+	require.NoError(t, alg.SaveIndex(&buf))
+	saved := buf.Bytes()
+	require.NoError(t, alg.Close())
+
+	_, err := reopen(t, alg, Config{}, saved, entries[1:])
+	assert.ErrorIs(t, err, ErrCorruptIndex, "unknown entry")
+
+	_, err = reopen(t, alg, Config{}, saved, append(entries, algorithms.Entry{Id: 11}))
+	assert.ErrorIs(t, err, ErrCorruptIndex, "entry missing from the graph")
+
+	_, err = reopen(t, alg, Config{}, saved[:len(saved)-1], entries)
+	assert.Error(t, err)
+
+	fl, err := createFile(alg.Path(), DefaultMaxDegree)
+	require.NoError(t, err)
+	require.NoError(t, fl.close())
+	_, err = reopen(t, alg, Config{}, saved, entries)
+	assert.ErrorIs(t, err, ErrCorruptIndex, "file of another index")
+
+	require.NoError(t, os.Remove(alg.Path()))
+	_, err = reopen(t, alg, Config{}, saved, entries)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, os.WriteFile(alg.Path(), bytes.Repeat([]byte{1}, headerSize), 0o644))
+	_, err = reopen(t, alg, Config{}, saved, entries)
+	assert.ErrorIs(t, err, ErrCorruptFile)
+}
+
+func TestFile_Grow(t *testing.T) {
+	alg := newAlgorithm(t, Config{MaxDegree: 4, Seed: 13})
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(13)), initialSlots+500, 3)
+	for _, entry := range entries[:initialSlots] {
+		alg.AddEntry(entry)
+	}
+	assert.Equal(t, initialSlots, alg.file.capacity)
+	for _, entry := range entries[initialSlots:] {
+		alg.AddEntry(entry)
+	}
+	assert.Equal(t, 2*initialSlots, alg.file.capacity, "the file doubles when full")
+	assert.Equal(t, entries, alg.ListEntries())
+
+	info, err := os.Stat(alg.Path())
+	require.NoError(t, err)
+	assert.Equal(t, int64(headerSize+2*initialSlots*alg.file.recordSize()), info.Size())
+}
+
+func TestFile_ReuseSlots(t *testing.T) {
+	config := Config{MaxDegree: 8, TrainSize: 100, Seed: 14}
+	rng := rand.New(rand.NewSource(14))
+	alg := newAlgorithm(t, config)
+	alg.AddEntries(algotest.NormalEntries(rng, 200, 4))
+
+	var buf bytes.Buffer
+	var saved []algorithms.Entry
+	for round := 0; round < 10; round++ {
+		for _, entry := range algotest.NormalEntries(rng, 200, 4) {
+			alg.UpdateEntry(entry)
+		}
+		buf.Reset()
+		require.NoError(t, alg.SaveIndex(&buf))
+		alg.Saved()
+		saved = alg.ListEntries()
+	}
+	assert.Equal(t, initialSlots, alg.file.capacity, "the slots of replaced vectors are reused")
+	assert.Less(t, len(alg.nodes), 3*200)
+
+	// writes after the snapshot reuse slots it doesn't need
+	free := len(alg.free)
+	for _, entry := range algotest.NormalEntries(rng, 100, 4) {
+		alg.UpdateEntry(entry)
+	}
+	assert.Less(t, len(alg.free), free)
+	require.NoError(t, alg.Close())
+
+	loaded, err := reopen(t, alg, config, buf.Bytes(), saved)
+	require.NoError(t, err)
+	assert.Equal(t, saved, loaded.ListEntries(), "the slots of the snapshot kept their vectors")
+	assertGraph(t, loaded)
+}
+
+func TestAlgorithm_WriteErrors(t *testing.T) {
+	alg := newAlgorithm(t, Config{MaxDegree: 4, Seed: 15})
+	entries := algotest.NormalEntries(rand.New(rand.NewSource(15)), 2*initialSlots, 3)
+	alg.AddEntries(entries[:10])
+	// the file can't grow once closed
+	require.NoError(t, alg.file.f.Close())
+
+	assert.Error(t, alg.Reserve(entries[10:]))
+	alg.AddEntries(entries[10:])
+	assert.Equal(t, entries[:10], alg.ListEntries(), "a failed write leaves the index as it was")
+	assert.Error(t, alg.Reserve(nil), "the failure is reported by the next Reserve")
+	assert.NoError(t, alg.Reserve(nil))
+}
@@ -1,13 +1,12 @@
 package api
 
 import (
-	"VectorLite/internal/algorithms"
-	"VectorLite/internal/algorithms/bruteforce"
-	"VectorLite/internal/algorithms/hnsw"
+	"VectorLite/internal/engine"
 	"VectorLite/internal/state"
+	"errors"
 	"log"
-	"math"
 	"net/http"
+	"sort"
 
 	"github.com/gin-gonic/gin"
 )
@@ -25,40 +24,48 @@ func CreateDatabase(c *gin.Context) {
 		return
 	}
 
-	var algorithm algorithms.SearchAlgorithm
-
-	switch req.Algorithm {
-	case "bruteforce":
-		algorithm = bruteforce.New()
-	case "hnsw":
-		// Default HNSW parameters
-		M := 16
-		efConstruction := 200
-		mL := 1.0 / math.Log(2.0)
-		algorithm = hnsw.New(M, efConstruction, mL)
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported algorithm: " + req.Algorithm})
-		return
-	}
-
-	err := state.State.DatabaseManager.CreateDatabase(req.Name, algorithm)
+	database, err := state.State.DatabaseManager.CreateDatabase(req.Name, req.Algorithm, req.Settings)
 	if err != nil {
-		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		var settingErr *engine.SettingError
+		switch {
+		case errors.Is(err, engine.ErrDatabaseExists), errors.Is(err, engine.ErrFileInUse):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, engine.ErrUnsupportedAlgorithm), errors.As(err, &settingErr):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
 	log.Printf("Created database %s with algorithm %s\n", req.Name, req.Algorithm)
 	c.JSON(http.StatusCreated, gin.H{
 		"message":   "database created successfully",
-		"name":      req.Name,
-		"algorithm": req.Algorithm,
+		"name":      database.Name,
+		"algorithm": database.AlgorithmName,
+		"settings":  database.Settings,
 	})
 }
 
 func ListDatabases(c *gin.Context) {
-	databases := state.State.DatabaseManager.ListDatabases()
-	log.Printf("Listing %d databases\n", len(databases))
-	
+	names := state.State.DatabaseManager.ListDatabases()
+	sort.Strings(names)
+	log.Printf("Listing %d databases\n", len(names))
+
+	databases := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		database, err := state.State.DatabaseManager.GetDatabase(name)
+		if err != nil {
+			// deleted while we were listing
+			continue
+		}
+		databases = append(databases, gin.H{
+			"name":      database.Name,
+			"algorithm": database.AlgorithmName,
+			"settings":  database.Settings,
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{"databases": databases})
 }
 
@@ -71,10 +78,10 @@ func DeleteDatabase(c *gin.Context) {
 
 	err := state.State.DatabaseManager.DeleteDatabase(name)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err, engine.ErrDatabaseNotFound, http.StatusNotFound), gin.H{"error": err.Error()})
 		return
 	}
 
 	log.Printf("Deleted database %s\n", name)
 	c.JSON(http.StatusOK, gin.H{"message": "database deleted successfully"})
-}
\ No newline at end of file
+}
@@ -1,8 +1,12 @@
 package api
 
 import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/engine"
 	"VectorLite/internal/state"
 	"VectorLite/internal/vector"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
@@ -11,8 +15,39 @@ import (
 
 type EntryRequest struct {
 	Database  string              `json:"database" binding:"required"`
-	Vectors   [][]float64         `json:"vectors" binding:"required"`
+	Vectors   []RequestVector     `json:"vectors" binding:"required"`
 	Metadatas []map[string]string `json:"metadatas" binding:"required"`
+	// Ids are optional, either client ids or internal ids, entries whose id
+	// already exists are replaced
+	Ids []RequestId `json:"ids,omitempty"`
+}
+
+// RequestId is the id of an entry in a request body: a string is the client
+// id of the entry, an integer its internal id.
+type RequestId struct {
+	External   string
+	Internal   int
+	IsInternal bool
+}
+
+func (id *RequestId) UnmarshalJSON(data []byte) error {
+	if json.Unmarshal(data, &id.External) == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &id.Internal); err != nil {
+		return errors.New("ids must be strings or integers")
+	}
+	id.IsInternal = true
+	return nil
+}
+
+type GetEntriesRequest struct {
+	Database string   `json:"database" binding:"required"`
+	Ids      []string `json:"ids" binding:"required"`
+}
+
+type UpdateMetadataRequest struct {
+	Metadata map[string]*string `json:"metadata" binding:"required"`
 }
 
 func AddEntries(c *gin.Context) {
@@ -22,6 +57,15 @@ func AddEntries(c *gin.Context) {
 		return
 	}
 
+	if len(rb.Metadatas) != len(rb.Vectors) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "vectors and metadatas must have the same length"})
+		return
+	}
+	if rb.Ids != nil && len(rb.Ids) != len(rb.Vectors) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids and vectors must have the same length"})
+		return
+	}
+
 	database, err := state.State.DatabaseManager.GetDatabase(rb.Database)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -29,12 +73,121 @@ func AddEntries(c *gin.Context) {
 	}
 
 	log.Printf("Adding %d entries to database %s\n", len(rb.Vectors), rb.Database)
+	vectors := make([]vector.Vector, len(rb.Vectors))
 	for i, vec := range rb.Vectors {
-		vector := vector.NewVector(vec...)
-		database.AddEntry(*vector, rb.Metadatas[i])
+		vectors[i] = vec.Vector
+	}
+
+	var externalIds []string
+	var internalIds []int
+	for _, id := range rb.Ids {
+		if id.IsInternal {
+			internalIds = append(internalIds, id.Internal)
+		} else {
+			externalIds = append(externalIds, id.External)
+		}
+	}
+	if externalIds != nil && internalIds != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids must be all strings or all integers"})
+		return
+	}
+
+	ids := internalIds
+	if internalIds != nil {
+		err = database.UpsertEntries(internalIds, vectors, rb.Metadatas)
+	} else {
+		ids, err = database.PutEntries(externalIds, vectors, rb.Metadatas)
+	}
+	if err != nil {
+		status := errorStatus(err, engine.ErrDuplicateId, http.StatusBadRequest)
+		if errors.Is(err, engine.ErrVectorType) || errors.Is(err, engine.ErrInvalidEntryId) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{"message": "entries added successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "entries added successfully", "ids": ids})
+}
+
+func GetEntry(c *gin.Context) {
+	database, err := state.State.DatabaseManager.GetDatabase(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := database.ResolveId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry, err := database.GetEntry(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entry": serializeEntry(entry)})
+}
+
+func GetEntries(c *gin.Context) {
+	var rb GetEntriesRequest
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database, err := state.State.DatabaseManager.GetDatabase(rb.Database)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, missing := database.GetEntries(rb.Ids)
+	log.Printf("Fetched %d of %d entries from database %s\n", len(entries), len(rb.Ids), rb.Database)
+
+	serializedEntries := make([]gin.H, len(entries))
+	for i, entry := range entries {
+		serializedEntries[i] = serializeEntry(entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": serializedEntries, "missing": missing})
+}
+
+func UpdateEntryMetadata(c *gin.Context) {
+	databaseName := c.Query("database")
+	if databaseName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "database parameter is required"})
+		return
+	}
+
+	var rb UpdateMetadataRequest
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database, err := state.State.DatabaseManager.GetDatabase(databaseName)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := database.ResolveId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.UpdateMetadata(id, rb.Metadata); err != nil {
+		c.JSON(errorStatus(err, engine.ErrEntryNotFound, http.StatusNotFound), gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Updated metadata of entry %d in database %s\n", id, databaseName)
+	c.JSON(http.StatusOK, gin.H{"message": "entry updated successfully"})
 }
 
 func ListEntries(c *gin.Context) {
@@ -55,12 +208,57 @@ func ListEntries(c *gin.Context) {
 
 	serializedEntries := make([]gin.H, len(entries))
 	for i, entry := range entries {
-		serializedEntries[i] = gin.H{
-			"vector":   entry.Vector.Values,
-			"metadata": entry.Metadata,
-			"id":       entry.Id,
-		}
+		serializedEntries[i] = serializeEntry(entry)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"entries": serializedEntries})
 }
+
+func DeleteEntry(c *gin.Context) {
+	databaseName := c.Query("database")
+	if databaseName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "database parameter is required"})
+		return
+	}
+
+	database, err := state.State.DatabaseManager.GetDatabase(databaseName)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := database.ResolveId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DeleteEntry(id); err != nil {
+		c.JSON(errorStatus(err, engine.ErrEntryNotFound, http.StatusNotFound), gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Deleted entry %d from database %s\n", id, databaseName)
+	c.JSON(http.StatusOK, gin.H{"message": "entry deleted successfully"})
+}
+
+func serializeEntry(entry algorithms.Entry) gin.H {
+	serialized := gin.H{
+		"vector":   serializeVector(entry.Vector),
+		"metadata": entry.Metadata,
+		"id":       entry.Id,
+	}
+	if entry.ExternalId != "" {
+		serialized["external_id"] = entry.ExternalId
+	}
+	return serialized
+}
+
+// errorStatus is status when err is the client's fault, that is when it wraps
+// target, and 500 otherwise (failing to write the WAL, say).
+func errorStatus(err error, target error, status int) int {
+	if errors.Is(err, target) {
+		return status
+	}
+	return http.StatusInternalServerError
+}
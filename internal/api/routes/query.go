@@ -1,8 +1,9 @@
 package api
 
 import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/filter"
 	"VectorLite/internal/state"
-	"VectorLite/internal/vector"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,10 +12,12 @@ import (
 )
 
 type QueryRequest struct {
-	Database    string    `json:"database" binding:"required"`
-	QueryVector []float64 `json:"vector" binding:"required"`
-	K           int       `json:"k" binding:"required"`
-	Metric      string    `json:"metric" binding:"required"`
+	Database    string                 `json:"database" binding:"required"`
+	QueryVector *RequestVector         `json:"vector" binding:"required"`
+	K           int                    `json:"k" binding:"required"`
+	Metric      string                 `json:"metric" binding:"required"`
+	Filter      map[string]interface{} `json:"filter,omitempty"`
+	Oversample  int                    `json:"oversample,omitempty" binding:"min=0"`
 }
 
 func Query(c *gin.Context) {
@@ -30,18 +33,30 @@ func Query(c *gin.Context) {
 		return
 	}
 
-	vector := vector.NewVector(rb.QueryVector...)
-	log.Println(fmt.Sprintf("database=%s, k=%d, metric=%s", rb.Database, rb.K, rb.Metric))
-	results := database.Query(vector, rb.K, rb.Metric)
+	options := algorithms.QueryOptions{Oversample: rb.Oversample}
+	if rb.Filter != nil {
+		metadataFilter, err := filter.Parse(rb.Filter)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		options.Filter = func(entry algorithms.Entry) bool {
+			return metadataFilter.Match(entry.Metadata)
+		}
+	}
+
+	vector := &rb.QueryVector.Vector
+	log.Println(fmt.Sprintf("database=%s, k=%d, metric=%s, filtered=%t", rb.Database, rb.K, rb.Metric, rb.Filter != nil))
+	results, err := database.QueryWithOptions(vector, rb.K, rb.Metric, options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	log.Println(fmt.Sprintf("Got %d results", len(results)))
 	serializedEntries := make([]gin.H, len(results))
 	for i, entry := range results {
-		serializedEntries[i] = gin.H{
-			"vector":   entry.Vector.Values,
-			"metadata": entry.Metadata,
-			"id":       entry.Id,
-		}
+		serializedEntries[i] = serializeEntry(entry)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"entries": serializedEntries})
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"VectorLite/internal/vector"
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestVector is a vector of a request body: a list of values for a dense
+// vector, or {"indices": [...], "values": [...]} for a sparse one.
+type RequestVector struct {
+	vector.Vector
+}
+
+func (v *RequestVector) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return json.Unmarshal(data, &v.Values)
+	}
+
+	var sparse struct {
+		Indices []uint32  `json:"indices"`
+		Values  []float64 `json:"values"`
+	}
+	if err := json.Unmarshal(data, &sparse); err != nil {
+		return err
+	}
+	if sparse.Indices == nil || sparse.Values == nil {
+		return errors.New("sparse vectors need both indices and values")
+	}
+	parsed, err := vector.NewSparseVector(sparse.Indices, sparse.Values)
+	if err != nil {
+		return err
+	}
+	v.Vector = *parsed
+	return nil
+}
+
+// serializeVector writes v back in the form RequestVector reads.
+func serializeVector(v vector.Vector) interface{} {
+	if v.IsSparse() {
+		return gin.H{"indices": v.Indices, "values": v.Values}
+	}
+	return v.Values
+}
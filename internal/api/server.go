@@ -2,24 +2,128 @@ package api
 
 import (
 	api "VectorLite/internal/api/routes"
+	"VectorLite/internal/engine"
+	"VectorLite/internal/state"
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func Serve(port int) {
+// shutdownTimeout bounds how long in-flight requests may take to finish once
+// the server is asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+type Options struct {
+	Port int
+	// DataDir is where databases are persisted, nothing is when empty.
+	DataDir string
+	// WAL configures the write-ahead log kept in DataDir.
+	WAL engine.WALOptions
+	// Checkpoint decides when snapshots are saved to DataDir while serving.
+	Checkpoint engine.CheckpointOptions
+}
+
+// Serve runs the API until the process is interrupted. With a DataDir, the
+// databases saved there are loaded at startup, every change is logged to the
+// WAL, and a snapshot is saved periodically and on shutdown.
+func Serve(options Options) {
+	dataDir := options.DataDir
+	var wal *engine.WAL
+	stopCheckpoints := make(chan struct{})
+	checkpointsDone := make(chan struct{})
+	if dataDir != "" {
+		wal = openDataDir(dataDir, options.WAL)
+		defer wal.Close()
+		go func() {
+			defer close(checkpointsDone)
+			state.State.DatabaseManager.RunCheckpoints(dataDir, options.Checkpoint, stopCheckpoints)
+		}()
+	} else {
+		close(checkpointsDone)
+	}
+
 	r := gin.Default()
 	
 	// Database management endpoints
 	r.POST("/databases", api.CreateDatabase)
 	r.GET("/databases", api.ListDatabases)
 	r.DELETE("/databases/:name", api.DeleteDatabase)
+	r.GET("/databases/:name/entries/:id", api.GetEntry)
 	
 	// Entry and query endpoints
 	r.POST("/entries", api.AddEntries)
 	r.GET("/entries", api.ListEntries)
+	r.POST("/entries/get", api.GetEntries)
+	r.PATCH("/entries/:id", api.UpdateEntryMetadata)
+	r.DELETE("/entries/:id", api.DeleteEntry)
 	r.POST("/query", api.Query)
 
-	addr := fmt.Sprintf(":%d", port)
-	r.Run(addr)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", options.Port),
+		Handler: r,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to finish in-flight requests: %v", err)
+	}
+	close(stopCheckpoints)
+	<-checkpointsDone
+
+	if dataDir != "" {
+		// on failure the WAL is kept, so nothing is lost
+		if err := state.State.DatabaseManager.Checkpoint(dataDir); err != nil {
+			log.Printf("Failed to save snapshot: %v", err)
+			return
+		}
+		log.Printf("Saved snapshot to %s", dataDir)
+	}
+}
+
+// openDataDir loads the snapshot in dataDir, replays the WAL on top of it and
+// returns the WAL, now logging every change. Index files are kept in dataDir
+// too.
+func openDataDir(dataDir string, walOptions engine.WALOptions) *engine.WAL {
+	dm := state.State.DatabaseManager
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		log.Fatalf("Failed to create data directory: %v", err)
+	}
+	dm.SetDataDir(dataDir)
+	if err := dm.LoadSnapshot(dataDir); err != nil {
+		log.Fatalf("Failed to load snapshot: %v", err)
+	}
+
+	wal, err := engine.OpenWAL(filepath.Join(dataDir, engine.WALFile), walOptions)
+	if err != nil {
+		log.Fatalf("Failed to open WAL: %v", err)
+	}
+	replayed, err := dm.AttachWAL(wal)
+	if err != nil {
+		log.Fatalf("Failed to replay WAL: %v", err)
+	}
+
+	log.Printf("Loaded %d databases from %s, replayed %d WAL records", len(dm.ListDatabases()), dataDir, replayed)
+	return wal
 }
@@ -0,0 +1,337 @@
+/*
+Package bench measures how accurate and how fast the search algorithms are on
+a dataset. Bruteforce answers give the ground truth every other algorithm is
+scored against.
+*/
+package bench
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/annoy"
+	"VectorLite/internal/algorithms/hnsw"
+	"VectorLite/internal/algorithms/ivf"
+	"VectorLite/internal/algorithms/lsh"
+	"VectorLite/internal/algorithms/pq"
+	"VectorLite/internal/algorithms/vamana"
+	"VectorLite/internal/engine"
+	"VectorLite/internal/vector"
+	"errors"
+	"fmt"
+	"maps"
+	"runtime"
+	"slices"
+	"time"
+)
+
+var ErrInvalidConfig = errors.New("invalid benchmark configuration")
+
+// Param is a setting swept over several values.
+type Param struct {
+	Name   string
+	Values []interface{}
+}
+
+// Config describes a benchmark run.
+type Config struct {
+	// K is the number of neighbours asked for by each query, recall is
+	// measured at K.
+	K      int
+	Metric string
+	// Algorithms lists the algorithms compared with bruteforce.
+	Algorithms []string
+	// Settings are passed to every algorithm but bruteforce, the metric
+	// setting defaults to Metric.
+	Settings map[string]interface{}
+	// Sweep runs every combination of the values of the params, on top of
+	// Settings.
+	Sweep []Param
+	// Progress is called before each algorithm is built, if set.
+	Progress func(algorithm string, settings map[string]interface{})
+}
+
+// Result is the measurement of one algorithm with one combination of the
+// swept settings.
+type Result struct {
+	Algorithm string `json:"algorithm"`
+	// Settings holds the swept settings only.
+	Settings map[string]interface{} `json:"settings"`
+	// Build is the time taken to index the dataset, Memory the growth of the
+	// heap meanwhile. Settings that only affect queries are swept on the
+	// same index, so their results share these.
+	Build  time.Duration `json:"buildNs"`
+	Memory int64         `json:"memoryBytes"`
+	// Recall is the share of the true K nearest neighbours found, averaged
+	// over the queries.
+	Recall float64 `json:"recall"`
+	// Queries are run one after the other, QPS is their number divided by
+	// the time they took altogether.
+	QPS float64       `json:"qps"`
+	P50 time.Duration `json:"p50Ns"`
+	P99 time.Duration `json:"p99Ns"`
+}
+
+// querySetting is a setting that only affects queries, an integer of at
+// least min that set applies to a built index.
+type querySetting struct {
+	name string
+	min  int
+	set  func(algorithm algorithms.SearchAlgorithm, value int)
+}
+
+// querySettings are the settings that only affect queries, by algorithm:
+// sweeping them reuses the index instead of building it again.
+var querySettings = map[string]querySetting{
+	"hnsw": {"efSearch", 1, func(algorithm algorithms.SearchAlgorithm, value int) {
+		algorithm.(*hnsw.Algorithm).SetEfSearch(value)
+	}},
+	"ivf": {"nprobe", 1, func(algorithm algorithms.SearchAlgorithm, value int) {
+		algorithm.(*ivf.Algorithm).SetNProbe(value)
+	}},
+	"pq": {"rerankFactor", 0, func(algorithm algorithms.SearchAlgorithm, value int) {
+		algorithm.(*pq.Algorithm).SetRerankFactor(value)
+	}},
+	"lsh": {"probes", 0, func(algorithm algorithms.SearchAlgorithm, value int) {
+		algorithm.(*lsh.Algorithm).SetProbes(value)
+	}},
+	"annoy": {"searchK", 0, func(algorithm algorithms.SearchAlgorithm, value int) {
+		algorithm.(*annoy.Algorithm).SetSearchK(value)
+	}},
+	"vamana": {"searchList", 1, func(algorithm algorithms.SearchAlgorithm, value int) {
+		algorithm.(*vamana.Algorithm).SetSearchList(value)
+	}},
+}
+
+// isQuerySetting reports whether the setting named key of algorithm name only
+// affects queries.
+func isQuerySetting(name string, key string) bool {
+	setting, ok := querySettings[name]
+	return ok && setting.name == key
+}
+
+// apply checks value and sets it on algorithm.
+func (setting querySetting) apply(algorithm algorithms.SearchAlgorithm, value interface{}) error {
+	number, ok := value.(float64)
+	if !ok || number < float64(setting.min) || number != float64(int(number)) {
+		return &engine.SettingError{Setting: setting.name, Value: value, Reason: fmt.Sprintf("must be an integer of at least %d", setting.min)}
+	}
+	setting.set(algorithm, int(number))
+	return nil
+}
+
+// Run builds dataset into bruteforce and every algorithm of config, with
+// every combination of the swept settings, and measures them. The first
+// result is bruteforce.
+func Run(dataset Dataset, config Config) ([]Result, error) {
+	if config.K < 1 {
+		return nil, fmt.Errorf("%w: k must be at least 1", ErrInvalidConfig)
+	}
+	if len(dataset.Vectors) == 0 || len(dataset.Queries) == 0 {
+		return nil, fmt.Errorf("%w: no vectors or no queries", ErrInvalidConfig)
+	}
+	if !vector.IsValidMetric(config.Metric) {
+		return nil, fmt.Errorf("%w: unknown metric %q", ErrInvalidConfig, config.Metric)
+	}
+
+	entries := make([]algorithms.Entry, len(dataset.Vectors))
+	for i, v := range dataset.Vectors {
+		entries[i] = algorithms.Entry{Vector: v, Id: i + 1}
+	}
+
+	progress(config, "bruteforce", nil)
+	bruteforce, buildTime, memory, err := build("bruteforce", nil, entries)
+	if err != nil {
+		return nil, err
+	}
+	truth, timing := search(bruteforce, dataset.Queries, config)
+	results := []Result{timing.result("bruteforce", map[string]interface{}{}, buildTime, memory, 1)}
+
+	for _, name := range config.Algorithms {
+		if name == "bruteforce" {
+			continue
+		}
+		algorithmResults, err := runAlgorithm(name, entries, dataset.Queries, config, truth)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		results = append(results, algorithmResults...)
+	}
+	return results, nil
+}
+
+// runAlgorithm measures one algorithm with every combination of the swept
+// settings. Combinations that only differ in query settings share an index.
+func runAlgorithm(name string, entries []algorithms.Entry, queries []vector.Vector, config Config, truth [][]int) ([]Result, error) {
+	var buildParams, queryParams []Param
+	for _, param := range config.Sweep {
+		if isQuerySetting(name, param.Name) {
+			queryParams = append(queryParams, param)
+		} else {
+			buildParams = append(buildParams, param)
+		}
+	}
+
+	results := []Result{}
+	var algorithm algorithms.SearchAlgorithm
+	for _, buildCombination := range combinations(buildParams) {
+		settings := map[string]interface{}{"metric": config.Metric}
+		maps.Copy(settings, config.Settings)
+		maps.Copy(settings, buildCombination)
+
+		// the previous index must be collected before the next is measured
+		algorithm = nil
+		progress(config, name, buildCombination)
+		var buildTime time.Duration
+		var memory int64
+		var err error
+		algorithm, buildTime, memory, err = build(name, settings, entries)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, queryCombination := range combinations(queryParams) {
+			for _, value := range queryCombination {
+				if err := querySettings[name].apply(algorithm, value); err != nil {
+					release(algorithm)
+					return nil, err
+				}
+			}
+
+			answers, timing := search(algorithm, queries, config)
+			swept := maps.Clone(buildCombination)
+			maps.Copy(swept, queryCombination)
+			results = append(results, timing.result(name, swept, buildTime, memory, recall(answers, truth, config.K)))
+		}
+		release(algorithm)
+	}
+	return results, nil
+}
+
+// release removes the index file of a file-backed algorithm, which the
+// garbage collector doesn't.
+func release(algorithm algorithms.SearchAlgorithm) {
+	if fileBacked, ok := algorithm.(algorithms.FileBacked); ok {
+		fileBacked.Remove()
+	}
+}
+
+// build indexes entries with the algorithm, through engine.NewAlgorithm so
+// settings are checked as for a database.
+func build(name string, settings map[string]interface{}, entries []algorithms.Entry) (algorithms.SearchAlgorithm, time.Duration, int64, error) {
+	var before, after runtime.MemStats
+	collect()
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+	algorithm, _, err := engine.NewAlgorithm(name, settings)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if inserter, ok := algorithm.(algorithms.BatchInserter); ok {
+		inserter.AddEntries(entries)
+	} else {
+		for _, entry := range entries {
+			algorithm.AddEntry(entry)
+		}
+	}
+	elapsed := time.Since(start)
+
+	collect()
+	runtime.ReadMemStats(&after)
+	return algorithm, elapsed, int64(after.HeapAlloc) - int64(before.HeapAlloc), nil
+}
+
+// collect runs the garbage collector until the heap only holds live objects.
+// It takes two collections: objects referenced from sync.Pools, such as the
+// pools inside a graph, are only released by the second.
+func collect() {
+	runtime.GC()
+	runtime.GC()
+}
+
+type timing struct {
+	total     time.Duration
+	latencies []time.Duration
+}
+
+// search runs every query, it returns the ids of the entries found for each.
+func search(algorithm algorithms.SearchAlgorithm, queries []vector.Vector, config Config) ([][]int, timing) {
+	answers := make([][]int, len(queries))
+	t := timing{latencies: make([]time.Duration, len(queries))}
+	for i := range queries {
+		start := time.Now()
+		found := algorithm.Query(&queries[i], config.K, config.Metric)
+		t.latencies[i] = time.Since(start)
+		t.total += t.latencies[i]
+
+		answers[i] = make([]int, len(found))
+		for j, entry := range found {
+			answers[i][j] = entry.Id
+		}
+	}
+	return answers, t
+}
+
+func (t timing) result(algorithm string, settings map[string]interface{}, buildTime time.Duration, memory int64, recall float64) Result {
+	sorted := slices.Clone(t.latencies)
+	slices.Sort(sorted)
+	return Result{
+		Algorithm: algorithm,
+		Settings:  settings,
+		Build:     buildTime,
+		Memory:    memory,
+		Recall:    recall,
+		QPS:       float64(len(sorted)) / t.total.Seconds(),
+		P50:       percentile(sorted, 50),
+		P99:       percentile(sorted, 99),
+	}
+}
+
+// percentile returns the p-th percentile of sorted, by nearest rank.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (len(sorted)*p + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// recall is the share of the ids of truth found in answers, averaged over
+// the queries. Queries with fewer than k true neighbours are scored on those.
+func recall(answers [][]int, truth [][]int, k int) float64 {
+	total := 0.0
+	for i, expected := range truth {
+		if len(expected) == 0 {
+			total++
+			continue
+		}
+		hits := 0
+		for _, id := range answers[i] {
+			if slices.Contains(expected, id) {
+				hits++
+			}
+		}
+		total += float64(hits) / float64(min(len(expected), k))
+	}
+	return total / float64(len(truth))
+}
+
+// combinations lists every combination of one value per param, in order.
+// Without params there is a single, empty, combination.
+func combinations(params []Param) []map[string]interface{} {
+	combinations := []map[string]interface{}{{}}
+	for _, param := range params {
+		next := make([]map[string]interface{}, 0, len(combinations)*len(param.Values))
+		for _, combination := range combinations {
+			for _, value := range param.Values {
+				extended := maps.Clone(combination)
+				extended[param.Name] = value
+				next = append(next, extended)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+func progress(config Config, algorithm string, settings map[string]interface{}) {
+	if config.Progress != nil {
+		config.Progress(algorithm, settings)
+	}
+}
@@ -0,0 +1,157 @@
+package bench_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"VectorLite/internal/bench"
+	"VectorLite/internal/engine"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	dataset := bench.Generate(500, 20, 8, 0, 1)
+	built := []string{}
+	results, err := bench.Run(dataset, bench.Config{
+		K:          5,
+		Metric:     "euclidean",
+		Algorithms: []string{"hnsw"},
+		Settings:   map[string]interface{}{"efConstruction": 32.0},
+		Sweep: []bench.Param{
+			{Name: "M", Values: []interface{}{4.0, 8.0}},
+			{Name: "efSearch", Values: []interface{}{8.0, 64.0}},
+		},
+		Progress: func(algorithm string, settings map[string]interface{}) {
+			built = append(built, algorithm)
+		},
+	})
+	require.NoError(t, err)
+
+	// efSearch only affects queries, each graph is built once
+	assert.Equal(t, []string{"bruteforce", "hnsw", "hnsw"}, built)
+	require.Len(t, results, 5)
+
+	assert.Equal(t, "bruteforce", results[0].Algorithm)
+	assert.Equal(t, 1.0, results[0].Recall)
+	assert.Empty(t, results[0].Settings)
+
+	assert.Equal(t, map[string]interface{}{"M": 4.0, "efSearch": 8.0}, results[1].Settings)
+	assert.Equal(t, map[string]interface{}{"M": 8.0, "efSearch": 64.0}, results[4].Settings)
+	assert.Equal(t, results[1].Build, results[2].Build)
+	assert.Equal(t, results[3].Build, results[4].Build)
+
+	for _, result := range results {
+		assert.Positive(t, result.Build)
+		assert.Greater(t, result.QPS, 0.0)
+		assert.LessOrEqual(t, result.P50, result.P99)
+		assert.True(t, result.Recall >= 0 && result.Recall <= 1, "recall %v", result.Recall)
+	}
+	assert.GreaterOrEqual(t, results[4].Recall, 0.9, "a wide beam finds nearly everything")
+	assert.GreaterOrEqual(t, results[4].Recall, results[1].Recall)
+}
+
+func TestRunIVF(t *testing.T) {
+	dataset := bench.Generate(1000, 20, 8, 10, 1)
+	builds := 0
+	results, err := bench.Run(dataset, bench.Config{
+		K:          5,
+		Metric:     "euclidean",
+		Algorithms: []string{"ivf"},
+		Settings:   map[string]interface{}{"nlist": 16.0},
+		Sweep:      []bench.Param{{Name: "nprobe", Values: []interface{}{1.0, 16.0}}},
+		Progress: func(algorithm string, settings map[string]interface{}) {
+			builds++
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, builds, "nprobe only affects queries, the lists are trained once")
+	require.Len(t, results, 3)
+	assert.Equal(t, results[1].Build, results[2].Build)
+	assert.Equal(t, 1.0, results[2].Recall, "probing every list is exact")
+	assert.LessOrEqual(t, results[1].Recall, results[2].Recall)
+}
+
+func TestRunInvalid(t *testing.T) {
+	dataset := bench.Generate(10, 2, 2, 0, 1)
+	valid := bench.Config{K: 1, Metric: "cosine", Algorithms: []string{"hnsw"}}
+
+	config := valid
+	config.K = 0
+	_, err := bench.Run(dataset, config)
+	assert.ErrorIs(t, err, bench.ErrInvalidConfig)
+
+	config = valid
+	config.Metric = "manhattan"
+	_, err = bench.Run(dataset, config)
+	assert.ErrorIs(t, err, bench.ErrInvalidConfig)
+
+	_, err = bench.Run(bench.Dataset{Vectors: dataset.Vectors}, valid)
+	assert.ErrorIs(t, err, bench.ErrInvalidConfig)
+
+	config = valid
+	config.Algorithms = []string{"faiss"}
+	_, err = bench.Run(dataset, config)
+	assert.ErrorIs(t, err, engine.ErrUnsupportedAlgorithm)
+
+	config = valid
+	config.Sweep = []bench.Param{{Name: "M", Values: []interface{}{1.0}}}
+	_, err = bench.Run(dataset, config)
+	var settingErr *engine.SettingError
+	assert.ErrorAs(t, err, &settingErr)
+
+	config = valid
+	config.Sweep = []bench.Param{{Name: "efSearch", Values: []interface{}{"wide"}}}
+	_, err = bench.Run(dataset, config)
+	assert.ErrorAs(t, err, &settingErr)
+}
+
+func TestParseParam(t *testing.T) {
+	param, err := bench.ParseParam("M=8, 16,32")
+	require.NoError(t, err)
+	assert.Equal(t, bench.Param{Name: "M", Values: []interface{}{8.0, 16.0, 32.0}}, param)
+
+	param, err = bench.ParseParam("neighbourSelection=simple,heuristic")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"simple", "heuristic"}, param.Values)
+
+	param, err = bench.ParseParam("extendCandidates=true,false")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{true, false}, param.Values)
+
+	for _, spec := range []string{"M", "=8", "M="} {
+		_, err := bench.ParseParam(spec)
+		assert.ErrorIs(t, err, bench.ErrInvalidConfig, spec)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	results := []bench.Result{
+		{Algorithm: "bruteforce", Settings: map[string]interface{}{}, Recall: 1, QPS: 100, P50: 2e6, P99: 3e6},
+		{Algorithm: "hnsw", Settings: map[string]interface{}{"efSearch": 64.0, "M": 16.0}, Build: 15e8, Memory: 3 << 20, Recall: 0.98, QPS: 2000},
+	}
+
+	var table bytes.Buffer
+	require.NoError(t, bench.WriteTable(&table, results, 10))
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "recall@10")
+	assert.Contains(t, lines[1], "2.000 ms")
+	assert.Contains(t, lines[2], "M=16,efSearch=64")
+	assert.Contains(t, lines[2], "1.5s")
+	assert.Contains(t, lines[2], "3.0 MB")
+	assert.Contains(t, lines[2], "0.9800")
+
+	var out bytes.Buffer
+	require.NoError(t, bench.WriteJSON(&out, results))
+	var decoded []map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	require.Len(t, decoded, 2)
+	assert.Equal(t, "hnsw", decoded[1]["algorithm"])
+	assert.Equal(t, 0.98, decoded[1]["recall"])
+	assert.Equal(t, 1.5e9, decoded[1]["buildNs"])
+}
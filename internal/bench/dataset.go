@@ -0,0 +1,163 @@
+package bench
+
+import (
+	"VectorLite/internal/vector"
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidDataset = errors.New("invalid dataset")
+
+// Dataset holds the vectors indexed by a benchmark and the queries run
+// against them.
+type Dataset struct {
+	Vectors []vector.Vector
+	Queries []vector.Vector
+}
+
+/*
+Generate draws n vectors and queries more of dims dimensions, all from the
+same distribution. With clusters > 0 they are spread around that many
+centers, with unit spread around centers far apart, otherwise every value is
+uniform in [-1, 1). The same seed always yields the same dataset.
+*/
+func Generate(n int, queries int, dims int, clusters int, seed int64) Dataset {
+	rng := rand.New(rand.NewSource(seed))
+
+	centers := make([][]float64, clusters)
+	for i := range centers {
+		centers[i] = make([]float64, dims)
+		for d := range centers[i] {
+			centers[i][d] = (rng.Float64()*2 - 1) * 10
+		}
+	}
+
+	draw := func() vector.Vector {
+		values := make([]float64, dims)
+		if clusters == 0 {
+			for d := range values {
+				values[d] = rng.Float64()*2 - 1
+			}
+			return vector.Vector{Values: values}
+		}
+		center := centers[rng.Intn(clusters)]
+		for d := range values {
+			values[d] = center[d] + rng.NormFloat64()
+		}
+		return vector.Vector{Values: values}
+	}
+
+	dataset := Dataset{Vectors: make([]vector.Vector, n), Queries: make([]vector.Vector, queries)}
+	for i := range dataset.Vectors {
+		dataset.Vectors[i] = draw()
+	}
+	for i := range dataset.Queries {
+		dataset.Queries[i] = draw()
+	}
+	return dataset
+}
+
+// HoldOut splits vectors into a dataset whose last queries vectors are used
+// as queries rather than indexed.
+func HoldOut(vectors []vector.Vector, queries int) (Dataset, error) {
+	if queries < 1 || queries >= len(vectors) {
+		return Dataset{}, fmt.Errorf("%w: can't hold out %d queries from %d vectors", ErrInvalidDataset, queries, len(vectors))
+	}
+	split := len(vectors) - queries
+	return Dataset{Vectors: vectors[:split], Queries: vectors[split:]}, nil
+}
+
+// Load reads the vectors of a file. Files ending in .fvecs use the format of
+// the classic ANN benchmark datasets (SIFT, GIST...): every vector is its
+// dimension as a little-endian int32 followed by as many float32. Any other
+// file is read as CSV with one vector per line, a first line that isn't
+// numeric is skipped as a header.
+func Load(path string) ([]vector.Vector, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var vectors []vector.Vector
+	if strings.EqualFold(filepath.Ext(path), ".fvecs") {
+		vectors, err = readFvecs(bufio.NewReader(file))
+	} else {
+		vectors, err = readCSV(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(vectors) == 0 {
+		return nil, fmt.Errorf("%w: %s holds no vectors", ErrInvalidDataset, path)
+	}
+	return vectors, nil
+}
+
+func readFvecs(r io.Reader) ([]vector.Vector, error) {
+	vectors := []vector.Vector{}
+	for {
+		var dims int32
+		if err := binary.Read(r, binary.LittleEndian, &dims); err == io.EOF {
+			return vectors, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if dims < 1 || (len(vectors) > 0 && int(dims) != len(vectors[0].Values)) {
+			return nil, fmt.Errorf("%w: vector %d has %d dimensions", ErrInvalidDataset, len(vectors), dims)
+		}
+
+		raw := make([]float32, dims)
+		if err := binary.Read(r, binary.LittleEndian, raw); err != nil {
+			return nil, fmt.Errorf("%w: vector %d is truncated", ErrInvalidDataset, len(vectors))
+		}
+		values := make([]float64, dims)
+		for i, value := range raw {
+			values[i] = float64(value)
+		}
+		vectors = append(vectors, vector.Vector{Values: values})
+	}
+}
+
+func readCSV(r io.Reader) ([]vector.Vector, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([]vector.Vector, 0, len(records))
+	for line, record := range records {
+		values := make([]float64, len(record))
+		for i, field := range record {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				if line == 0 {
+					values = nil
+					break
+				}
+				return nil, fmt.Errorf("%w: line %d: %q is not a number", ErrInvalidDataset, line+1, field)
+			}
+			values[i] = value
+		}
+		if values == nil {
+			continue
+		}
+		if len(vectors) > 0 && len(values) != len(vectors[0].Values) {
+			return nil, fmt.Errorf("%w: line %d has %d values, expected %d", ErrInvalidDataset, line+1, len(values), len(vectors[0].Values))
+		}
+		vectors = append(vectors, vector.Vector{Values: values})
+	}
+	return vectors, nil
+}
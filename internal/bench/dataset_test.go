@@ -0,0 +1,109 @@
+package bench_test
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"VectorLite/internal/bench"
+	"VectorLite/internal/vector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	dataset := bench.Generate(100, 10, 8, 0, 1)
+	assert.Len(t, dataset.Vectors, 100)
+	assert.Len(t, dataset.Queries, 10)
+	for _, v := range dataset.Vectors {
+		require.Len(t, v.Values, 8)
+		for _, value := range v.Values {
+			assert.True(t, value >= -1 && value < 1)
+		}
+	}
+
+	assert.Equal(t, dataset, bench.Generate(100, 10, 8, 0, 1), "same seed, same dataset")
+	assert.NotEqual(t, dataset, bench.Generate(100, 10, 8, 0, 2))
+
+	clustered := bench.Generate(100, 10, 8, 3, 1)
+	assert.Len(t, clustered.Vectors, 100)
+	assert.Len(t, clustered.Queries[0].Values, 8)
+}
+
+func TestHoldOut(t *testing.T) {
+	vectors := bench.Generate(10, 0, 2, 0, 1).Vectors
+	dataset, err := bench.HoldOut(vectors, 3)
+	require.NoError(t, err)
+	assert.Equal(t, vectors[:7], dataset.Vectors)
+	assert.Equal(t, vectors[7:], dataset.Queries)
+
+	_, err = bench.HoldOut(vectors, 10)
+	assert.ErrorIs(t, err, bench.ErrInvalidDataset)
+	_, err = bench.HoldOut(vectors, 0)
+	assert.ErrorIs(t, err, bench.ErrInvalidDataset)
+}
+
+func TestLoadCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vectors.csv")
+	require.NoError(t, os.WriteFile(path, []byte("x,y,z\n1,2,3\n4, 5.5, -6\n"), 0o644))
+
+	vectors, err := bench.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, []vector.Vector{*vector.NewVector(1, 2, 3), *vector.NewVector(4, 5.5, -6)}, vectors)
+
+	tests := map[string]string{
+		"not a number":      "1,2\n3,four\n",
+		"ragged":            "1,2\n3,4,5\n",
+		"only a header":     "x,y\n",
+		"infinite":          "1,2\n3,Inf\n",
+		"header not at top": "1,2\nx,y\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+			_, err := bench.Load(path)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func writeFvecs(t *testing.T, path string, vectors [][]float32) {
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+	for _, values := range vectors {
+		require.NoError(t, binary.Write(file, binary.LittleEndian, int32(len(values))))
+		require.NoError(t, binary.Write(file, binary.LittleEndian, values))
+	}
+}
+
+func TestLoadFvecs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "base.fvecs")
+	writeFvecs(t, path, [][]float32{{1, 2}, {0.5, -3}})
+
+	vectors, err := bench.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, []vector.Vector{*vector.NewVector(1, 2), *vector.NewVector(0.5, -3)}, vectors)
+
+	writeFvecs(t, path, [][]float32{{1, 2}, {1, 2, 3}})
+	_, err = bench.Load(path)
+	assert.ErrorIs(t, err, bench.ErrInvalidDataset)
+
+	// a vector cut short
+	require.NoError(t, os.WriteFile(path, []byte{2, 0, 0, 0, 0, 0, 128, 63}, 0o644))
+	_, err = bench.Load(path)
+	assert.ErrorIs(t, err, bench.ErrInvalidDataset)
+
+	writeFvecs(t, path, [][]float32{{float32(math.Pi)}})
+	vectors, err = bench.Load(path)
+	require.NoError(t, err)
+	assert.InDelta(t, math.Pi, vectors[0].Values[0], 1e-6)
+}
+
+func TestLoadMissing(t *testing.T) {
+	_, err := bench.Load(filepath.Join(t.TempDir(), "missing.csv"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
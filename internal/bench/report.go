@@ -0,0 +1,87 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ParseValue reads a setting value given on the command line: numbers and
+// booleans are sent as such, anything else as a string, as the client does.
+func ParseValue(value string) interface{} {
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number
+	}
+	if flag, err := strconv.ParseBool(value); err == nil {
+		return flag
+	}
+	return value
+}
+
+// ParseParam reads a swept setting written as name=value1,value2,...
+func ParseParam(spec string) (Param, error) {
+	name, values, found := strings.Cut(spec, "=")
+	name = strings.TrimSpace(name)
+	if !found || name == "" || strings.TrimSpace(values) == "" {
+		return Param{}, fmt.Errorf("%w: %q should read name=value1,value2,...", ErrInvalidConfig, spec)
+	}
+
+	param := Param{Name: name}
+	for _, value := range strings.Split(values, ",") {
+		param.Values = append(param.Values, ParseValue(strings.TrimSpace(value)))
+	}
+	return param, nil
+}
+
+// WriteTable writes results as an aligned table, one line per result.
+func WriteTable(w io.Writer, results []Result, k int) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(table, "algorithm\tsettings\tbuild\tmemory\trecall@%d\tQPS\tp50\tp99\t\n", k)
+	for _, result := range results {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%.1f MB\t%.4f\t%.0f\t%s\t%s\t\n",
+			result.Algorithm,
+			formatSettings(result.Settings),
+			result.Build.Round(time.Millisecond),
+			float64(result.Memory)/(1<<20),
+			result.Recall,
+			result.QPS,
+			formatLatency(result.P50),
+			formatLatency(result.P99),
+		)
+	}
+	return table.Flush()
+}
+
+// WriteJSON writes results as an indented JSON array, durations are in
+// nanoseconds.
+func WriteJSON(w io.Writer, results []Result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+func formatSettings(settings map[string]interface{}) string {
+	if len(settings) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%v", key, settings[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatLatency(latency time.Duration) string {
+	return fmt.Sprintf("%.3f ms", float64(latency)/float64(time.Millisecond))
+}
@@ -0,0 +1,242 @@
+/*
+Package codec reads and writes the little-endian primitives the on-disk
+formats are made of.
+
+Writer and Reader remember the first error they hit and turn every later
+call into a no-op, so a whole record can be encoded or decoded before
+checking Err once.
+*/
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// MaxLength bounds the length prefixes accepted by Reader, so a corrupt
+// length can't make it allocate gigabytes.
+const MaxLength = 1 << 30
+
+var ErrTooLong = errors.New("length prefix exceeds limit")
+
+type Writer struct {
+	w   io.Writer
+	buf [8]byte
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Err() error {
+	return w.err
+}
+
+func (w *Writer) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(p)
+}
+
+// Raw writes p as is, without a length prefix.
+func (w *Writer) Raw(p []byte) {
+	w.write(p)
+}
+
+func (w *Writer) Byte(v byte) {
+	w.buf[0] = v
+	w.write(w.buf[:1])
+}
+
+func (w *Writer) Bool(v bool) {
+	if v {
+		w.Byte(1)
+	} else {
+		w.Byte(0)
+	}
+}
+
+func (w *Writer) Uint32(v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[:4], v)
+	w.write(w.buf[:4])
+}
+
+func (w *Writer) Uint64(v uint64) {
+	binary.LittleEndian.PutUint64(w.buf[:8], v)
+	w.write(w.buf[:8])
+}
+
+func (w *Writer) Int64(v int64) {
+	w.Uint64(uint64(v))
+}
+
+func (w *Writer) Float64(v float64) {
+	w.Uint64(math.Float64bits(v))
+}
+
+// Bytes writes p prefixed with its length.
+func (w *Writer) Bytes(p []byte) {
+	w.Uint32(uint32(len(p)))
+	w.write(p)
+}
+
+func (w *Writer) String(v string) {
+	w.Bytes([]byte(v))
+}
+
+// Float64s writes values prefixed with their count.
+func (w *Writer) Float64s(values []float64) {
+	w.Uint32(uint32(len(values)))
+	for _, value := range values {
+		w.Float64(value)
+	}
+}
+
+// Uint32s writes values prefixed with their count.
+func (w *Writer) Uint32s(values []uint32) {
+	w.Uint32(uint32(len(values)))
+	for _, value := range values {
+		w.Uint32(value)
+	}
+}
+
+// StringMap writes the pairs of m prefixed with their count.
+func (w *Writer) StringMap(m map[string]string) {
+	w.Uint32(uint32(len(m)))
+	for key, value := range m {
+		w.String(key)
+		w.String(value)
+	}
+}
+
+type Reader struct {
+	r   io.Reader
+	buf [8]byte
+	err error
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+func (r *Reader) Err() error {
+	return r.err
+}
+
+func (r *Reader) read(p []byte) {
+	if r.err != nil {
+		return
+	}
+	_, r.err = io.ReadFull(r.r, p)
+}
+
+// Raw fills p, see Writer.Raw.
+func (r *Reader) Raw(p []byte) {
+	r.read(p)
+}
+
+func (r *Reader) Byte() byte {
+	r.read(r.buf[:1])
+	if r.err != nil {
+		return 0
+	}
+	return r.buf[0]
+}
+
+func (r *Reader) Bool() bool {
+	return r.Byte() != 0
+}
+
+func (r *Reader) Uint32() uint32 {
+	r.read(r.buf[:4])
+	if r.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(r.buf[:4])
+}
+
+func (r *Reader) Uint64() uint64 {
+	r.read(r.buf[:8])
+	if r.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(r.buf[:8])
+}
+
+func (r *Reader) Int64() int64 {
+	return int64(r.Uint64())
+}
+
+func (r *Reader) Float64() float64 {
+	return math.Float64frombits(r.Uint64())
+}
+
+// Length reads a length prefix, rejecting values above MaxLength.
+func (r *Reader) Length() int {
+	length := r.Uint32()
+	if r.err == nil && length > MaxLength {
+		r.err = ErrTooLong
+	}
+	if r.err != nil {
+		return 0
+	}
+	return int(length)
+}
+
+func (r *Reader) Bytes() []byte {
+	length := r.Length()
+	if r.err != nil {
+		return nil
+	}
+	p := make([]byte, length)
+	r.read(p)
+	if r.err != nil {
+		return nil
+	}
+	return p
+}
+
+func (r *Reader) String() string {
+	return string(r.Bytes())
+}
+
+func (r *Reader) Float64s() []float64 {
+	count := r.Length()
+	values := make([]float64, 0, count)
+	for i := 0; i < count && r.err == nil; i++ {
+		values = append(values, r.Float64())
+	}
+	if r.err != nil {
+		return nil
+	}
+	return values
+}
+
+func (r *Reader) Uint32s() []uint32 {
+	count := r.Length()
+	values := make([]uint32, 0, count)
+	for i := 0; i < count && r.err == nil; i++ {
+		values = append(values, r.Uint32())
+	}
+	if r.err != nil {
+		return nil
+	}
+	return values
+}
+
+func (r *Reader) StringMap() map[string]string {
+	count := r.Length()
+	m := make(map[string]string, count)
+	for i := 0; i < count && r.err == nil; i++ {
+		key := r.String()
+		m[key] = r.String()
+	}
+	if r.err != nil {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,56 @@
+package codec_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"VectorLite/internal/codec"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := codec.NewWriter(&buf)
+	w.Byte(7)
+	w.Bool(true)
+	w.Uint32(42)
+	w.Int64(-5)
+	w.Float64(3.25)
+	w.String("hello")
+	w.Float64s([]float64{1, -2.5})
+	w.Uint32s([]uint32{3, 1 << 31})
+	w.StringMap(map[string]string{"a": "b"})
+	require.NoError(t, w.Err())
+
+	r := codec.NewReader(&buf)
+	assert.Equal(t, byte(7), r.Byte())
+	assert.True(t, r.Bool())
+	assert.Equal(t, uint32(42), r.Uint32())
+	assert.Equal(t, int64(-5), r.Int64())
+	assert.Equal(t, 3.25, r.Float64())
+	assert.Equal(t, "hello", r.String())
+	assert.Equal(t, []float64{1, -2.5}, r.Float64s())
+	assert.Equal(t, []uint32{3, 1 << 31}, r.Uint32s())
+	assert.Equal(t, map[string]string{"a": "b"}, r.StringMap())
+	assert.NoError(t, r.Err())
+}
+
+func TestReaderKeepsFirstError(t *testing.T) {
+	r := codec.NewReader(bytes.NewReader([]byte{1, 2}))
+	assert.Equal(t, uint32(0), r.Uint32())
+	assert.ErrorIs(t, r.Err(), io.ErrUnexpectedEOF)
+	assert.Equal(t, byte(0), r.Byte())
+	assert.ErrorIs(t, r.Err(), io.ErrUnexpectedEOF)
+}
+
+func TestReaderRejectsHugeLength(t *testing.T) {
+	var buf bytes.Buffer
+	codec.NewWriter(&buf).Uint32(codec.MaxLength + 1)
+
+	r := codec.NewReader(&buf)
+	assert.Nil(t, r.Bytes())
+	assert.ErrorIs(t, r.Err(), codec.ErrTooLong)
+}
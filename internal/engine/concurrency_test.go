@@ -0,0 +1,225 @@
+package engine_test
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"VectorLite/internal/engine"
+	"VectorLite/internal/vector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// These tests are meant to be run with -race, see make test-race.
+
+const (
+	writers         = 8
+	readers         = 8
+	writesPerWriter = 50
+	entriesPerWrite = 4
+	readsPerReader  = 100
+	vectorDims      = 4
+)
+
+func randomishVector(seed int) vector.Vector {
+	values := make([]float64, vectorDims)
+	for i := range values {
+		values[i] = float64((seed*31+i*17)%97) - 48
+	}
+	return vector.Vector{Values: values}
+}
+
+func TestConcurrentWritesAndReads(t *testing.T) {
+	for _, algorithm := range []string{"bruteforce", "hnsw"} {
+		t.Run(algorithm, func(t *testing.T) {
+			dm := engine.NewDatabaseManager()
+			settings := map[string]interface{}{}
+			if algorithm == "hnsw" {
+				settings = map[string]interface{}{"M": 8.0, "efConstruction": 32.0}
+			}
+			db, err := dm.CreateDatabase("docs", algorithm, settings)
+			require.NoError(t, err)
+
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < writesPerWriter; i++ {
+						externalIds := make([]string, entriesPerWrite)
+						vectors := make([]vector.Vector, entriesPerWrite)
+						metadatas := make([]map[string]string, entriesPerWrite)
+						for j := range vectors {
+							externalIds[j] = fmt.Sprintf("w%d-%d-%d", w, i, j)
+							vectors[j] = randomishVector(w*1000 + i*10 + j)
+							metadatas[j] = map[string]string{"writer": fmt.Sprint(w)}
+						}
+						ids, err := db.PutEntries(externalIds, vectors, metadatas)
+						assert.NoError(t, err)
+						// let readers list the new entries before some of them go
+						runtime.Gosched()
+
+						if i%5 == 0 {
+							value := "updated"
+							assert.NoError(t, db.UpdateMetadata(ids[0], map[string]*string{"state": &value}))
+						}
+						if i%7 == 0 {
+							assert.NoError(t, db.DeleteEntry(ids[1]))
+						}
+					}
+				}(w)
+			}
+
+			metric := "cosine"
+			for r := 0; r < readers; r++ {
+				wg.Add(1)
+				go func(r int) {
+					defer wg.Done()
+					for i := 0; i < readsPerReader; i++ {
+						query := randomishVector(r*100 + i)
+						results, err := db.Query(&query, 5, metric)
+						assert.NoError(t, err)
+						assert.LessOrEqual(t, len(results), 5)
+
+						db.GetEntries([]string{"w0-0-0", "1", "missing"})
+						// the listed entries must stay valid while writers go on
+						for _, entry := range db.ListEntries() {
+							assert.NotEmpty(t, entry.Vector.Values)
+							runtime.Gosched()
+						}
+						_, _ = db.ResolveId(fmt.Sprintf("w%d-0-0", r%writers))
+					}
+				}(r)
+			}
+			wg.Wait()
+
+			deletes := 0
+			for i := 0; i < writesPerWriter; i += 7 {
+				deletes++
+			}
+			expected := writers * (writesPerWriter*entriesPerWrite - deletes)
+			assert.Len(t, db.ListEntries(), expected)
+			assert.Equal(t, writers*writesPerWriter*entriesPerWrite, db.NumberEntries, "every write got its own id")
+
+			seen := map[int]bool{}
+			for _, entry := range db.ListEntries() {
+				assert.False(t, seen[entry.Id], "id %d handed out twice", entry.Id)
+				seen[entry.Id] = true
+				id, err := db.ResolveId(entry.ExternalId)
+				assert.NoError(t, err)
+				assert.Equal(t, entry.Id, id)
+			}
+		})
+	}
+}
+
+func TestConcurrentDatabaseManagement(t *testing.T) {
+	dm := engine.NewDatabaseManager()
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			name := fmt.Sprintf("db%d", w)
+			for i := 0; i < 20; i++ {
+				db, err := dm.CreateDatabase(name, "bruteforce", nil)
+				if !assert.NoError(t, err) {
+					return
+				}
+				_, err = db.AddEntry(randomishVector(i), map[string]string{})
+				assert.NoError(t, err)
+				assert.NoError(t, dm.DeleteDatabase(name))
+
+				// writes to a deleted database fail
+				_, err = db.AddEntry(randomishVector(i), map[string]string{})
+				assert.ErrorIs(t, err, engine.ErrDatabaseNotFound)
+			}
+		}(w)
+	}
+
+	// all of them race to create the same database, only one wins
+	created := make(chan bool, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := dm.CreateDatabase("shared", "bruteforce", nil)
+			created <- err == nil
+			if err != nil {
+				assert.ErrorIs(t, err, engine.ErrDatabaseExists)
+			}
+		}()
+	}
+
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < readsPerReader; i++ {
+				for _, name := range dm.ListDatabases() {
+					if db, err := dm.GetDatabase(name); err == nil {
+						for _, entry := range db.ListEntries() {
+							assert.NotEmpty(t, entry.Vector.Values)
+						}
+					}
+				}
+				var buf bytes.Buffer
+				assert.NoError(t, dm.WriteSnapshot(&buf))
+			}
+		}()
+	}
+	wg.Wait()
+	close(created)
+
+	wins := 0
+	for ok := range created {
+		if ok {
+			wins++
+		}
+	}
+	assert.Equal(t, 1, wins)
+	assert.Equal(t, []string{"shared"}, dm.ListDatabases())
+}
+
+// Checkpoints taken while writes go on must not lose any of them: every write
+// lands either in the snapshot or in the WAL left after it.
+func TestConcurrentCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	dm, wal, _ := openDataDir(t, dir, engine.SyncNone)
+	db, err := dm.CreateDatabase("docs", "hnsw", map[string]interface{}{"M": 8.0, "efConstruction": 32.0})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writesPerWriter; i++ {
+				_, _, err := db.PutEntry(fmt.Sprintf("w%d-%d", w, i), randomishVector(w*100+i), map[string]string{})
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, dm.Checkpoint(dir))
+		}
+	}()
+	wg.Wait()
+	<-done
+	require.NoError(t, wal.Close())
+
+	restored, wal, _ := openDataDir(t, dir, engine.SyncNone)
+	defer wal.Close()
+	assertSameDatabases(t, dm, restored)
+	assert.FileExists(t, filepath.Join(dir, engine.SnapshotFile))
+}
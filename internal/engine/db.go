@@ -3,30 +3,413 @@ package engine
 import (
 	"VectorLite/internal/algorithms"
 	"VectorLite/internal/vector"
+	"fmt"
+	"strconv"
 )
 
 func NewDatabase(name string, algorithm algorithms.SearchAlgorithm) *Database {
 	return &Database{
-		Name:      name,
-		Algorithm: algorithm,
+		Name:        name,
+		Algorithm:   algorithm,
+		externalIds: make(map[string]int),
 	}
 }
 
-func (database *Database) AddEntry(vector vector.Vector, metadata map[string]string) {
-	database.NumberEntries++
-	entry := algorithms.Entry{
-		Vector:   vector,
-		Metadata: metadata,
-		Id:       database.NumberEntries,
+// AddEntry stores a new entry under the next free id and returns that id.
+func (database *Database) AddEntry(vector vector.Vector, metadata map[string]string) (int, error) {
+	id, _, err := database.PutEntry("", vector, metadata)
+	return id, err
+}
+
+// UpsertEntry stores the entry under the given id, replacing any entry that
+// already has it. It reports whether a new entry was created.
+// A replaced entry keeps its external id.
+func (database *Database) UpsertEntry(id int, vec vector.Vector, metadata map[string]string) (bool, error) {
+	if err := database.lockForWrite(); err != nil {
+		return false, err
+	}
+	defer database.mu.Unlock()
+
+	_, exists := database.Algorithm.GetEntry(id)
+	if _, err := database.putEntries(nil, []int{id}, []vector.Vector{vec}, []map[string]string{metadata}); err != nil {
+		return false, err
+	}
+	return !exists, nil
+}
+
+// UpsertEntries stores a batch of entries with UpsertEntry, logged as a whole
+// as PutEntries does. ids must not hold the same id twice.
+func (database *Database) UpsertEntries(ids []int, vectors []vector.Vector, metadatas []map[string]string) error {
+	if err := database.lockForWrite(); err != nil {
+		return err
+	}
+	defer database.mu.Unlock()
+	_, err := database.putEntries(nil, ids, vectors, metadatas)
+	return err
+}
+
+// PutEntry upserts an entry keyed by its external id: the entry already
+// holding externalId is replaced, otherwise a new entry is created under the
+// next free id. It returns the internal id and whether the entry was created.
+// An empty externalId always creates a new entry.
+func (database *Database) PutEntry(externalId string, vec vector.Vector, metadata map[string]string) (int, bool, error) {
+	if err := database.lockForWrite(); err != nil {
+		return 0, false, err
+	}
+	defer database.mu.Unlock()
+
+	_, exists := database.externalIds[externalId]
+	created := externalId == "" || !exists
+
+	ids, err := database.putEntries([]string{externalId}, nil, []vector.Vector{vec}, []map[string]string{metadata})
+	if err != nil {
+		return 0, false, err
+	}
+	return ids[0], created, nil
+}
+
+// PutEntries stores a batch of entries with PutEntry. externalIds may be nil,
+// otherwise it must not hold the same id twice.
+// The batch is written to the WAL as a whole before any entry is stored.
+// Algorithms implementing algorithms.BatchInserter get the new entries of the
+// batch in a single call.
+func (database *Database) PutEntries(externalIds []string, vectors []vector.Vector, metadatas []map[string]string) ([]int, error) {
+	if err := database.lockForWrite(); err != nil {
+		return nil, err
+	}
+	defer database.mu.Unlock()
+	return database.putEntries(externalIds, nil, vectors, metadatas)
+}
+
+// putEntries stores entries keyed by their external id, see PutEntries, or by
+// their internal id, see UpsertEntry, when internalIds is not nil.
+func (database *Database) putEntries(externalIds []string, internalIds []int, vectors []vector.Vector, metadatas []map[string]string) ([]int, error) {
+	// the first vector gives the dimension of an empty database
+	dimension := database.Dimension
+	for i := range vectors {
+		if dimension == 0 && !vectors[i].IsSparse() {
+			dimension = len(vectors[i].Values)
+		}
+		if err := database.checkVector(&vectors[i], dimension); err != nil {
+			return nil, err
+		}
+	}
+	seen := make(map[string]bool, len(externalIds))
+	for _, externalId := range externalIds {
+		if externalId != "" && seen[externalId] {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicateId, externalId)
+		}
+		seen[externalId] = true
+	}
+	seenIds := make(map[int]bool, len(internalIds))
+	for _, id := range internalIds {
+		if id < 1 {
+			return nil, ErrInvalidEntryId
+		}
+		if seenIds[id] {
+			return nil, fmt.Errorf("%w: %d", ErrDuplicateId, id)
+		}
+		seenIds[id] = true
+	}
+
+	entries := make([]algorithms.Entry, len(vectors))
+	nextId := database.NumberEntries
+	for i := range vectors {
+		entry := algorithms.Entry{
+			Vector:   vectors[i],
+			Metadata: metadatas[i],
+		}
+		if externalIds != nil {
+			entry.ExternalId = externalIds[i]
+		}
+		if internalIds != nil {
+			// a replaced entry keeps its external id
+			entry.Id = internalIds[i]
+			if existing, exists := database.Algorithm.GetEntry(entry.Id); exists {
+				entry.ExternalId = existing.ExternalId
+			}
+		} else if id, exists := database.externalIds[entry.ExternalId]; entry.ExternalId != "" && exists {
+			entry.Id = id
+		} else {
+			nextId++
+			entry.Id = nextId
+		}
+		entries[i] = entry
+	}
+
+	if err := database.reserve(entries...); err != nil {
+		return nil, err
+	}
+	if err := database.logPut(entries...); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.Id
+	}
+
+	inserter, ok := database.Algorithm.(algorithms.BatchInserter)
+	if !ok {
+		for _, entry := range entries {
+			database.putEntry(entry)
+		}
+		return ids, nil
+	}
+
+	added := make([]algorithms.Entry, 0, len(entries))
+	for _, entry := range entries {
+		if _, exists := database.Algorithm.GetEntry(entry.Id); exists {
+			database.putEntry(entry)
+		} else {
+			added = append(added, entry)
+		}
+	}
+	inserter.AddEntries(added)
+	for _, entry := range added {
+		database.trackEntry(entry)
+	}
+	return ids, nil
+}
+
+// putEntry stores entry, replacing the entry holding the same id, and keeps
+// the external ids and the id counter in step. Every write ends up here,
+// whether it comes from a request or from replaying the WAL, except for the
+// batches of new entries PutEntries hands to a BatchInserter.
+func (database *Database) putEntry(entry algorithms.Entry) {
+	if _, exists := database.Algorithm.GetEntry(entry.Id); exists {
+		database.Algorithm.UpdateEntry(entry)
+	} else {
+		database.Algorithm.AddEntry(entry)
+	}
+	database.trackEntry(entry)
+}
+
+// trackEntry records the external id of a stored entry, the dimension of the
+// database if it is the first, and keeps the id counter past its id.
+func (database *Database) trackEntry(entry algorithms.Entry) {
+	if entry.ExternalId != "" {
+		database.externalIds[entry.ExternalId] = entry.Id
+	}
+	if database.Dimension == 0 && !entry.Vector.IsSparse() {
+		database.Dimension = len(entry.Vector.Values)
+	}
+	// keep generated ids clear of the ones picked by clients
+	if entry.Id > database.NumberEntries {
+		database.NumberEntries = entry.Id
+	}
+}
+
+// ResolveId turns a reference to an entry, as found in a URL, into its
+// internal id. External ids take precedence over internal ones.
+func (database *Database) ResolveId(ref string) (int, error) {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
+	return database.resolveId(ref)
+}
+
+func (database *Database) resolveId(ref string) (int, error) {
+	if id, exists := database.externalIds[ref]; exists {
+		return id, nil
+	}
+	id, err := strconv.Atoi(ref)
+	if err != nil {
+		return 0, ErrEntryNotFound
+	}
+	return id, nil
+}
+
+// UpdateMetadata changes the metadata of an entry without touching its
+// vector, so the index is left as it is. Keys mapped to nil are removed, the
+// other keys are set.
+func (database *Database) UpdateMetadata(id int, changes map[string]*string) error {
+	if err := database.lockForWrite(); err != nil {
+		return err
 	}
-	database.Algorithm.AddEntry(entry)
+	defer database.mu.Unlock()
+
+	entry, exists := database.Algorithm.GetEntry(id)
+	if !exists {
+		return ErrEntryNotFound
+	}
+
+	metadata := make(map[string]string, len(entry.Metadata)+len(changes))
+	for key, value := range entry.Metadata {
+		metadata[key] = value
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(metadata, key)
+		} else {
+			metadata[key] = *value
+		}
+	}
+
+	entry.Metadata = metadata
+	if err := database.reserve(entry); err != nil {
+		return err
+	}
+	if err := database.logPut(entry); err != nil {
+		return err
+	}
+	database.putEntry(entry)
+	return nil
+}
+
+func (database *Database) GetEntry(id int) (algorithms.Entry, error) {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
+	return database.getEntry(id)
+}
+
+func (database *Database) getEntry(id int) (algorithms.Entry, error) {
+	entry, exists := database.Algorithm.GetEntry(id)
+	if !exists {
+		return algorithms.Entry{}, ErrEntryNotFound
+	}
+	return entry, nil
+}
+
+// DeleteEntry removes the entry with the given id. New entries never get the
+// id again, but an upsert may store one under it.
+func (database *Database) DeleteEntry(id int) error {
+	if err := database.lockForWrite(); err != nil {
+		return err
+	}
+	defer database.mu.Unlock()
+
+	if _, exists := database.Algorithm.GetEntry(id); !exists {
+		return ErrEntryNotFound
+	}
+	if err := database.reserve(); err != nil {
+		return err
+	}
+	if err := database.log(walRecord{op: opDeleteEntry, database: database.Name, id: id}); err != nil {
+		return err
+	}
+	if !database.removeEntry(id) {
+		return ErrEntryNotFound
+	}
+	return nil
+}
+
+func (database *Database) removeEntry(id int) bool {
+	entry, exists := database.Algorithm.GetEntry(id)
+	if !exists || !database.Algorithm.RemoveEntry(id) {
+		return false
+	}
+	if entry.ExternalId != "" {
+		delete(database.externalIds, entry.ExternalId)
+	}
+	return true
+}
+
+// checkVector rejects sparse vectors unless the algorithm indexes them, see
+// algorithms.SparseIndex, and dense vectors if it does. Dense vectors must
+// have dimension values, any number but 0 when dimension is 0.
+func (database *Database) checkVector(v *vector.Vector, dimension int) error {
+	_, sparse := database.Algorithm.(algorithms.SparseIndex)
+	switch {
+	case sparse && !v.IsSparse():
+		return fmt.Errorf("%w: the index takes sparse vectors", ErrVectorType)
+	case !sparse && v.IsSparse():
+		return fmt.Errorf("%w: the index takes dense vectors", ErrVectorType)
+	case sparse:
+		return nil
+	case len(v.Values) == 0:
+		return fmt.Errorf("%w: the vector is empty", ErrVectorType)
+	case dimension > 0 && len(v.Values) != dimension:
+		return fmt.Errorf("%w: the index takes vectors of %d dimensions, got %d", ErrVectorType, dimension, len(v.Values))
+	}
+	return nil
+}
+
+// lockForWrite takes the write lock, unless the database has been deleted.
+func (database *Database) lockForWrite() error {
+	database.mu.Lock()
+	if database.deleted {
+		database.mu.Unlock()
+		return ErrDatabaseNotFound
+	}
+	return nil
+}
+
+// reserve readies the file of a file-backed algorithm for a write of
+// entries, see algorithms.FileBacked, so that a write that is logged can't
+// fail on I/O.
+func (database *Database) reserve(entries ...algorithms.Entry) error {
+	if fileBacked, ok := database.Algorithm.(algorithms.FileBacked); ok {
+		return fileBacked.Reserve(entries)
+	}
+	return nil
+}
+
+// log appends records to the WAL, if the database has one.
+func (database *Database) log(records ...walRecord) error {
+	if database.wal == nil {
+		return nil
+	}
+	return database.wal.append(records...)
+}
+
+func (database *Database) logPut(entries ...algorithms.Entry) error {
+	if database.wal == nil {
+		return nil
+	}
+	records := make([]walRecord, len(entries))
+	for i, entry := range entries {
+		records[i] = walRecord{op: opPutEntry, database: database.Name, entry: entry}
+	}
+	return database.wal.append(records...)
+}
+
+// GetEntries looks up a batch of entries by reference, see ResolveId.
+// Entries are returned in the order of refs, refs that match no entry are
+// returned separately.
+func (database *Database) GetEntries(refs []string) ([]algorithms.Entry, []string) {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
+
+	entries := make([]algorithms.Entry, 0, len(refs))
+	missing := []string{}
+	for _, ref := range refs {
+		id, err := database.resolveId(ref)
+		if err != nil {
+			missing = append(missing, ref)
+			continue
+		}
+		entry, err := database.getEntry(id)
+		if err != nil {
+			missing = append(missing, ref)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, missing
 }
 
 func (database *Database) ListEntries() []algorithms.Entry {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
 	return database.Algorithm.ListEntries()
 }
 
-func (database *Database) Query(queryVector *vector.Vector, k int, metric string) []algorithms.Entry {
-	return database.Algorithm.Query(queryVector, k, metric)
+// Query returns the k entries closest to queryVector. Algorithms whose index
+// is built for a fixed metric only accept queries in that metric.
+func (database *Database) Query(queryVector *vector.Vector, k int, metric string) ([]algorithms.Entry, error) {
+	return database.QueryWithOptions(queryVector, k, metric, algorithms.QueryOptions{})
+}
+
+func (database *Database) QueryWithOptions(queryVector *vector.Vector, k int, metric string, options algorithms.QueryOptions) ([]algorithms.Entry, error) {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
+
+	if bound, ok := database.Algorithm.(algorithms.MetricBound); ok && bound.Metric() != metric {
+		return nil, fmt.Errorf("%w: index uses %q, query asked for %q", ErrMetricMismatch, bound.Metric(), metric)
+	}
+	if err := database.checkVector(queryVector, database.Dimension); err != nil {
+		return nil, err
+	}
+	return database.Algorithm.QueryWithOptions(queryVector, k, metric, options), nil
 }
 
@@ -3,16 +3,20 @@ package engine_test
 import (
 	"testing"
 
+	"VectorLite/internal/algorithms"
 	"VectorLite/internal/algorithms/bruteforce"
+	"VectorLite/internal/algorithms/hnsw"
+	"VectorLite/internal/algorithms/sparse"
 	"VectorLite/internal/engine"
 	"VectorLite/internal/vector"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewDB(t *testing.T) {
 	algorithm := bruteforce.New()
-	db := engine.NewDatabase(algorithm)
+	db := engine.NewDatabase("test", algorithm)
 
 	assert.NotNil(t, db)
 	assert.Equal(t, 0, len(db.ListEntries()), "New database should have no entries")
@@ -20,7 +24,7 @@ func TestNewDB(t *testing.T) {
 
 func TestAddEntry(t *testing.T) {
 	algorithm := bruteforce.New()
-	db := engine.NewDatabase(algorithm)
+	db := engine.NewDatabase("test", algorithm)
 	vec := vector.NewVector(1.5, 2.2)
 	metadata := map[string]string{"text": "hello world"}
 
@@ -35,7 +39,7 @@ func TestAddEntry(t *testing.T) {
 
 func TestListEntriesEmpty(t *testing.T) {
 	algorithm := bruteforce.New()
-	db := engine.NewDatabase(algorithm)
+	db := engine.NewDatabase("test", algorithm)
 	entries := db.ListEntries()
 
 	assert.Equal(t, 0, len(entries), "ListEntries should return empty list for new database")
@@ -43,7 +47,7 @@ func TestListEntriesEmpty(t *testing.T) {
 
 func TestListEntriesWithEntries(t *testing.T) {
 	algorithm := bruteforce.New()
-	db := engine.NewDatabase(algorithm)
+	db := engine.NewDatabase("test", algorithm)
 	vec1 := vector.NewVector(1.5, 2.2)
 	vec2 := vector.NewVector(3.1, 4.4)
 	metadata1 := map[string]string{"text": "entry1"}
@@ -63,7 +67,7 @@ func TestListEntriesWithEntries(t *testing.T) {
 
 func TestQuery(t *testing.T) {
 	algorithm := bruteforce.New()
-	db := engine.NewDatabase(algorithm)
+	db := engine.NewDatabase("test", algorithm)
 	db.AddEntry(*vector.NewVector(1, 2, 3), map[string]string{"text": "entry1"})
 	db.AddEntry(*vector.NewVector(4, 5, 6), map[string]string{"text": "entry2"})
 	db.AddEntry(*vector.NewVector(7, 8, 9), map[string]string{"text": "entry3"})
@@ -71,22 +75,288 @@ func TestQuery(t *testing.T) {
 	vectorA := vector.NewVector(2, 3, 4)
 
 	// Test case 1: Basic functionality
-	result := db.Query(vectorA, 2, "euclidean")
+	result, err := db.Query(vectorA, 2, "euclidean")
+	assert.NoError(t, err)
 	assert.Equal(t, 2, len(result))
 	// Additional assertions can be made based on the expected vector entries
 
 	// Test case 2: Requesting more neighbors than available
-	result = db.Query(vectorA, 5, "euclidean")
+	result, _ = db.Query(vectorA, 5, "euclidean")
 	assert.Equal(t, 3, len(result))
 
 	// Test case 3: Using a different metric
 	// Assuming implementation supports "manhattan" or other metrics
-	result = db.Query(vectorA, 2, "manhattan")
+	result, _ = db.Query(vectorA, 2, "manhattan")
 	assert.Equal(t, 2, len(result))
 
 	// Test case 5: Empty database
 	emptyAlgorithm := bruteforce.New()
-	emptyDatabase := engine.NewDatabase(emptyAlgorithm)
-	result = emptyDatabase.Query(vectorA, 2, "euclidean")
+	emptyDatabase := engine.NewDatabase("empty", emptyAlgorithm)
+	result, _ = emptyDatabase.Query(vectorA, 2, "euclidean")
 	assert.Equal(t, 0, len(result))
 }
+
+func TestQueryMetricMismatch(t *testing.T) {
+	config := hnsw.DefaultConfig()
+	config.Metric = "euclidean"
+	db := engine.NewDatabase("test", hnsw.NewWithConfig(config))
+	db.AddEntry(*vector.NewVector(1, 2, 3), map[string]string{"text": "entry1"})
+	db.AddEntry(*vector.NewVector(4, 5, 6), map[string]string{"text": "entry2"})
+
+	result, err := db.Query(vector.NewVector(1, 2, 3), 1, "euclidean")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, "entry1", result[0].Metadata["text"])
+
+	_, err = db.Query(vector.NewVector(1, 2, 3), 1, "cosine")
+	assert.ErrorIs(t, err, engine.ErrMetricMismatch)
+}
+
+func TestVectorType(t *testing.T) {
+	sparseVector, err := vector.NewSparseVector([]uint32{4, 9}, []float64{1, 2})
+	assert.NoError(t, err)
+
+	dense := engine.NewDatabase("dense", bruteforce.New())
+	_, err = dense.AddEntry(*sparseVector, nil)
+	assert.ErrorIs(t, err, engine.ErrVectorType)
+	_, err = dense.UpsertEntry(3, *sparseVector, nil)
+	assert.ErrorIs(t, err, engine.ErrVectorType)
+	_, err = dense.PutEntries(nil, []vector.Vector{*vector.NewVector(1, 2), *sparseVector}, []map[string]string{nil, nil})
+	assert.ErrorIs(t, err, engine.ErrVectorType)
+	assert.Empty(t, dense.ListEntries(), "nothing of a rejected batch is stored")
+	_, err = dense.Query(sparseVector, 1, "dot_product")
+	assert.ErrorIs(t, err, engine.ErrVectorType)
+
+	terms := engine.NewDatabase("terms", sparse.New())
+	_, err = terms.AddEntry(*vector.NewVector(1, 2), nil)
+	assert.ErrorIs(t, err, engine.ErrVectorType)
+	id, err := terms.AddEntry(*sparseVector, map[string]string{"text": "entry1"})
+	assert.NoError(t, err)
+	_, err = terms.Query(vector.NewVector(1, 2), 1, "dot_product")
+	assert.ErrorIs(t, err, engine.ErrVectorType)
+	result, err := terms.Query(sparseVector, 1, "dot_product")
+	assert.NoError(t, err)
+	assert.Equal(t, []algorithms.Entry{{Vector: *sparseVector, Metadata: map[string]string{"text": "entry1"}, Id: id}}, result)
+}
+
+func TestVectorDimension(t *testing.T) {
+	db := engine.NewDatabase("test", hnsw.New(4, 16, 1.0))
+	_, err := db.AddEntry(*vector.NewVector(), nil)
+	assert.ErrorIs(t, err, engine.ErrVectorType)
+	_, err = db.PutEntries(nil, []vector.Vector{*vector.NewVector(1, 2), *vector.NewVector(1, 2, 3)}, []map[string]string{nil, nil})
+	assert.ErrorIs(t, err, engine.ErrVectorType, "the first vector of a batch sets the dimension")
+	assert.Zero(t, db.Dimension)
+
+	_, err = db.AddEntry(*vector.NewVector(1, 2, 3), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, db.Dimension)
+	_, err = db.AddEntry(*vector.NewVector(1, 2), nil)
+	assert.ErrorIs(t, err, engine.ErrVectorType)
+	_, err = db.UpsertEntry(1, *vector.NewVector(1, 2, 3, 4), nil)
+	assert.ErrorIs(t, err, engine.ErrVectorType)
+	_, err = db.Query(vector.NewVector(1, 2), 1, "cosine")
+	assert.ErrorIs(t, err, engine.ErrVectorType)
+	assert.Len(t, db.ListEntries(), 1)
+
+	require.NoError(t, db.DeleteEntry(1))
+	_, err = db.AddEntry(*vector.NewVector(1, 2), nil)
+	assert.ErrorIs(t, err, engine.ErrVectorType, "the dimension outlives the entries")
+}
+
+func TestDeleteEntry(t *testing.T) {
+	db := engine.NewDatabase("test", bruteforce.New())
+	db.AddEntry(*vector.NewVector(1, 2), map[string]string{"text": "entry1"})
+	db.AddEntry(*vector.NewVector(3, 4), map[string]string{"text": "entry2"})
+
+	assert.NoError(t, db.DeleteEntry(1))
+	entries := db.ListEntries()
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, 2, entries[0].Id)
+
+	assert.ErrorIs(t, db.DeleteEntry(1), engine.ErrEntryNotFound)
+
+	// ids are not reused after a deletion
+	db.AddEntry(*vector.NewVector(5, 6), map[string]string{"text": "entry3"})
+	assert.Equal(t, 3, db.ListEntries()[1].Id)
+}
+
+func TestUpsertEntry(t *testing.T) {
+	db := engine.NewDatabase("test", bruteforce.New())
+	id, err := db.AddEntry(*vector.NewVector(1, 2), map[string]string{"text": "entry1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	created, err := db.UpsertEntry(1, *vector.NewVector(5, 6), map[string]string{"text": "replaced"})
+	assert.NoError(t, err)
+	assert.False(t, created, "Existing id should be replaced")
+	entry, err := db.GetEntry(1)
+	assert.NoError(t, err)
+	assert.Equal(t, *vector.NewVector(5, 6), entry.Vector)
+	assert.Equal(t, "replaced", entry.Metadata["text"])
+
+	created, err = db.UpsertEntry(10, *vector.NewVector(7, 8), map[string]string{"text": "entry10"})
+	assert.NoError(t, err)
+	assert.True(t, created, "Unknown id should be created")
+	assert.Equal(t, 2, len(db.ListEntries()))
+
+	// generated ids never collide with client picked ones
+	id, err = db.AddEntry(*vector.NewVector(9, 9), map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, 11, id)
+
+	_, err = db.UpsertEntry(0, *vector.NewVector(1, 1), map[string]string{})
+	assert.ErrorIs(t, err, engine.ErrInvalidEntryId)
+}
+
+func TestUpsertEntries(t *testing.T) {
+	db := engine.NewDatabase("test", bruteforce.New())
+	_, _, err := db.PutEntry("doc-1", *vector.NewVector(1, 2), map[string]string{"text": "entry1"})
+	assert.NoError(t, err)
+
+	err = db.UpsertEntries([]int{1, 5},
+		[]vector.Vector{*vector.NewVector(3, 4), *vector.NewVector(5, 6)},
+		[]map[string]string{{"text": "replaced"}, {"text": "entry5"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(db.ListEntries()))
+	entry, err := db.GetEntry(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "doc-1", entry.ExternalId, "A replaced entry should keep its external id")
+	assert.Equal(t, "replaced", entry.Metadata["text"])
+	entry, err = db.GetEntry(5)
+	assert.NoError(t, err)
+	assert.Equal(t, *vector.NewVector(5, 6), entry.Vector)
+
+	err = db.UpsertEntries([]int{7, 7},
+		[]vector.Vector{*vector.NewVector(1, 1), *vector.NewVector(2, 2)},
+		[]map[string]string{{}, {}})
+	assert.ErrorIs(t, err, engine.ErrDuplicateId)
+	err = db.UpsertEntries([]int{8, -1},
+		[]vector.Vector{*vector.NewVector(1, 1), *vector.NewVector(2, 2)},
+		[]map[string]string{{}, {}})
+	assert.ErrorIs(t, err, engine.ErrInvalidEntryId)
+	assert.Equal(t, 2, len(db.ListEntries()), "A rejected batch should store nothing")
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	db := engine.NewDatabase("test", bruteforce.New())
+	db.AddEntry(*vector.NewVector(1, 2), map[string]string{"text": "entry1", "lang": "en"})
+
+	text := "updated"
+	err := db.UpdateMetadata(1, map[string]*string{"text": &text, "lang": nil})
+	assert.NoError(t, err)
+
+	entry, err := db.GetEntry(1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"text": "updated"}, entry.Metadata)
+	assert.Equal(t, *vector.NewVector(1, 2), entry.Vector, "Vector should be left untouched")
+
+	assert.ErrorIs(t, db.UpdateMetadata(2, map[string]*string{}), engine.ErrEntryNotFound)
+	_, err = db.GetEntry(2)
+	assert.ErrorIs(t, err, engine.ErrEntryNotFound)
+}
+
+func TestPutEntriesWithExternalIds(t *testing.T) {
+	db := engine.NewDatabase("test", bruteforce.New())
+
+	ids, err := db.PutEntries(
+		[]string{"doc-a", "doc-b"},
+		[]vector.Vector{*vector.NewVector(1, 2), *vector.NewVector(3, 4)},
+		[]map[string]string{{"text": "a"}, {"text": "b"}},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, ids)
+
+	entry, err := db.GetEntry(2)
+	assert.NoError(t, err)
+	assert.Equal(t, "doc-b", entry.ExternalId)
+
+	// an existing external id replaces its entry and keeps the internal id
+	ids, err = db.PutEntries(
+		[]string{"doc-b", "doc-c"},
+		[]vector.Vector{*vector.NewVector(5, 6), *vector.NewVector(7, 8)},
+		[]map[string]string{{"text": "b2"}, {"text": "c"}},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, ids)
+	assert.Equal(t, 3, len(db.ListEntries()))
+	entry, _ = db.GetEntry(2)
+	assert.Equal(t, "b2", entry.Metadata["text"])
+	assert.Equal(t, "doc-b", entry.ExternalId)
+
+	_, err = db.PutEntries(
+		[]string{"doc-d", "doc-d"},
+		[]vector.Vector{*vector.NewVector(1, 1), *vector.NewVector(2, 2)},
+		[]map[string]string{{}, {}},
+	)
+	assert.ErrorIs(t, err, engine.ErrDuplicateId)
+	assert.Equal(t, 3, len(db.ListEntries()), "Rejected batches should not store anything")
+}
+
+func TestResolveId(t *testing.T) {
+	db := engine.NewDatabase("test", bruteforce.New())
+	db.PutEntry("doc-a", *vector.NewVector(1, 2), map[string]string{})
+	db.AddEntry(*vector.NewVector(3, 4), map[string]string{})
+
+	id, err := db.ResolveId("doc-a")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	id, err = db.ResolveId("2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, id)
+
+	_, err = db.ResolveId("doc-z")
+	assert.ErrorIs(t, err, engine.ErrEntryNotFound)
+
+	// deleting an entry frees its external id
+	assert.NoError(t, db.DeleteEntry(1))
+	_, err = db.ResolveId("doc-a")
+	assert.ErrorIs(t, err, engine.ErrEntryNotFound)
+	id, created, err := db.PutEntry("doc-a", *vector.NewVector(5, 6), map[string]string{})
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, 3, id)
+}
+
+func TestUpsertEntryKeepsExternalId(t *testing.T) {
+	db := engine.NewDatabase("test", bruteforce.New())
+	db.PutEntry("doc-a", *vector.NewVector(1, 2), map[string]string{})
+
+	_, err := db.UpsertEntry(1, *vector.NewVector(3, 4), map[string]string{"text": "new"})
+	assert.NoError(t, err)
+	entry, _ := db.GetEntry(1)
+	assert.Equal(t, "doc-a", entry.ExternalId)
+
+	text := "patched"
+	assert.NoError(t, db.UpdateMetadata(1, map[string]*string{"text": &text}))
+	entry, _ = db.GetEntry(1)
+	assert.Equal(t, "doc-a", entry.ExternalId)
+}
+
+func TestGetEntries(t *testing.T) {
+	db := engine.NewDatabase("test", bruteforce.New())
+	db.PutEntry("doc-a", *vector.NewVector(1, 2), map[string]string{"text": "a"})
+	db.AddEntry(*vector.NewVector(3, 4), map[string]string{"text": "b"})
+
+	entries, missing := db.GetEntries([]string{"2", "doc-a", "doc-z", "9"})
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "b", entries[0].Metadata["text"])
+	assert.Equal(t, "a", entries[1].Metadata["text"])
+	assert.Equal(t, []string{"doc-z", "9"}, missing)
+}
+
+func TestQueryWithOptions(t *testing.T) {
+	db := engine.NewDatabase("test", bruteforce.New())
+	db.AddEntry(*vector.NewVector(1, 1), map[string]string{"lang": "en"})
+	db.AddEntry(*vector.NewVector(1, 2), map[string]string{"lang": "fr"})
+	db.AddEntry(*vector.NewVector(9, 9), map[string]string{"lang": "fr"})
+
+	result, err := db.QueryWithOptions(vector.NewVector(1, 1), 2, "euclidean", algorithms.QueryOptions{
+		Filter: func(entry algorithms.Entry) bool { return entry.Metadata["lang"] == "fr" },
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(result))
+	for _, entry := range result {
+		assert.Equal(t, "fr", entry.Metadata["lang"])
+	}
+}
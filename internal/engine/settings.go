@@ -0,0 +1,637 @@
+package engine
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/annoy"
+	"VectorLite/internal/algorithms/bruteforce"
+	"VectorLite/internal/algorithms/hnsw"
+	"VectorLite/internal/algorithms/ivf"
+	"VectorLite/internal/algorithms/kdtree"
+	"VectorLite/internal/algorithms/lsh"
+	"VectorLite/internal/algorithms/pq"
+	"VectorLite/internal/algorithms/sparse"
+	"VectorLite/internal/algorithms/vamana"
+	"VectorLite/internal/quantization"
+	"VectorLite/internal/vector"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+// SettingError is returned when a database setting is unknown to the chosen
+// algorithm, has the wrong type or is out of range.
+type SettingError struct {
+	Setting string
+	Value   interface{}
+	Reason  string
+}
+
+func (e *SettingError) Error() string {
+	if e.Value == nil {
+		return fmt.Sprintf("setting %q: %s", e.Setting, e.Reason)
+	}
+	return fmt.Sprintf("setting %q=%v: %s", e.Setting, e.Value, e.Reason)
+}
+
+// NewAlgorithm builds the search algorithm called name, configured with the
+// given settings. Settings that are not provided take the algorithm defaults.
+// The returned settings hold every effective value, so that the same
+// algorithm can be rebuilt from them later.
+func NewAlgorithm(name string, settings map[string]interface{}) (algorithms.SearchAlgorithm, map[string]interface{}, error) {
+	switch name {
+	case "bruteforce":
+		config, err := bruteforceConfig(settings)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bruteforce.NewWithConfig(config), quantizationSettings(map[string]interface{}{}, config.Quantization), nil
+	case "hnsw":
+		config, err := hnswConfig(settings)
+		if err != nil {
+			return nil, nil, err
+		}
+		return hnsw.NewWithConfig(config), quantizationSettings(map[string]interface{}{
+			"M":                     config.M,
+			"Mmax0":                 config.Mmax0,
+			"efConstruction":        config.EfConstruction,
+			"efSearch":              config.EfSearch,
+			"mL":                    config.ML,
+			"seed":                  config.Seed,
+			"metric":                config.Metric,
+			"neighbourSelection":    config.Selection,
+			"extendCandidates":      config.ExtendCandidates,
+			"keepPrunedConnections": config.KeepPrunedConnections,
+			"deletion":              config.Deletion,
+			"tombstoneRatio":        config.TombstoneRatio,
+			"insertWorkers":         config.InsertWorkers,
+		}, config.Quantization), nil
+	case "ivf":
+		config, err := ivfConfig(settings)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ivf.NewWithConfig(config), map[string]interface{}{
+			"nlist":        config.NList,
+			"nprobe":       config.NProbe,
+			"metric":       config.Metric,
+			"iterations":   config.Iterations,
+			"trainSize":    config.TrainSize,
+			"sampleSize":   config.SampleSize,
+			"retrainRatio": config.RetrainRatio,
+			"seed":         config.Seed,
+		}, nil
+	case "pq":
+		config, err := pqConfig(settings)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pq.NewWithConfig(config), map[string]interface{}{
+			"subspaces":     config.Subspaces,
+			"metric":        config.Metric,
+			"iterations":    config.Iterations,
+			"trainSize":     config.TrainSize,
+			"sampleSize":    config.SampleSize,
+			"keepOriginals": config.KeepOriginals,
+			"rerankFactor":  config.RerankFactor,
+			"seed":          config.Seed,
+		}, nil
+	case "lsh":
+		config, err := lshConfig(settings)
+		if err != nil {
+			return nil, nil, err
+		}
+		effective := map[string]interface{}{
+			"tables": config.Tables,
+			"bits":   config.Bits,
+			"probes": config.Probes,
+			"metric": config.Metric,
+			"seed":   config.Seed,
+		}
+		if config.Metric == "euclidean" {
+			effective["bucketWidth"] = config.BucketWidth
+		}
+		return lsh.NewWithConfig(config), effective, nil
+	case "annoy":
+		config, err := annoyConfig(settings)
+		if err != nil {
+			return nil, nil, err
+		}
+		return annoy.NewWithConfig(config), map[string]interface{}{
+			"trees":    config.Trees,
+			"leafSize": config.LeafSize,
+			"searchK":  config.SearchK,
+			"metric":   config.Metric,
+			"seed":     config.Seed,
+		}, nil
+	case "kdtree":
+		config, err := kdtreeConfig(settings)
+		if err != nil {
+			return nil, nil, err
+		}
+		return kdtree.NewWithConfig(config), map[string]interface{}{
+			"leafSize": config.LeafSize,
+			"metric":   config.Metric,
+		}, nil
+	case "vamana":
+		config, err := vamanaConfig(settings)
+		if err != nil {
+			return nil, nil, err
+		}
+		algorithm := vamana.NewWithConfig(config)
+		return algorithm, map[string]interface{}{
+			"maxDegree":  config.MaxDegree,
+			"buildList":  config.BuildList,
+			"searchList": config.SearchList,
+			"beamWidth":  config.BeamWidth,
+			"alpha":      config.Alpha,
+			"metric":     config.Metric,
+			"trainSize":  config.TrainSize,
+			"seed":       config.Seed,
+			"file":       algorithm.File(),
+		}, nil
+	case "sparse":
+		config, err := sparseConfig(settings)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sparse.NewWithConfig(config), map[string]interface{}{
+			"metric": config.Metric,
+		}, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, name)
+	}
+}
+
+func bruteforceConfig(settings map[string]interface{}) (bruteforce.Config, error) {
+	config := bruteforce.DefaultConfig()
+	for key, value := range settings {
+		handled, err := quantizationSetting(&config.Quantization, key, value)
+		if err == nil && !handled {
+			err = &SettingError{Setting: key, Reason: "unknown setting"}
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, checkQuantization(settings, config.Quantization)
+}
+
+func hnswConfig(settings map[string]interface{}) (hnsw.Config, error) {
+	config := hnsw.DefaultConfig()
+	seed, err := seedSetting(settings)
+	if err != nil {
+		return config, err
+	}
+	config.Seed = seed
+
+	for key, value := range settings {
+		switch key {
+		case "M":
+			config.M, err = intSetting(key, value, 2)
+		case "Mmax0":
+			config.Mmax0, err = intSetting(key, value, 2)
+		case "efConstruction":
+			config.EfConstruction, err = intSetting(key, value, 1)
+		case "efSearch":
+			config.EfSearch, err = intSetting(key, value, 1)
+		case "mL":
+			config.ML, err = floatSetting(key, value)
+			if err == nil && config.ML <= 0 {
+				err = &SettingError{Setting: key, Value: value, Reason: "must be greater than 0"}
+			}
+		case "seed":
+			// read by seedSetting
+		case "metric":
+			config.Metric, err = metricSetting(key, value)
+		case "neighbourSelection":
+			config.Selection, err = choiceSetting(key, value, hnsw.SelectSimple, hnsw.SelectHeuristic)
+		case "extendCandidates":
+			config.ExtendCandidates, err = boolSetting(key, value)
+		case "keepPrunedConnections":
+			config.KeepPrunedConnections, err = boolSetting(key, value)
+		case "deletion":
+			config.Deletion, err = choiceSetting(key, value, hnsw.DeleteRepair, hnsw.DeleteTombstone)
+		case "tombstoneRatio":
+			config.TombstoneRatio, err = floatSetting(key, value)
+			if err == nil && (config.TombstoneRatio <= 0 || config.TombstoneRatio > 1) {
+				err = &SettingError{Setting: key, Value: value, Reason: "must be greater than 0 and at most 1"}
+			}
+		case "insertWorkers":
+			config.InsertWorkers, err = intSetting(key, value, 0)
+		default:
+			var handled bool
+			handled, err = quantizationSetting(&config.Quantization, key, value)
+			if err == nil && !handled {
+				err = &SettingError{Setting: key, Reason: "unknown setting"}
+			}
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+	if err := checkQuantization(settings, config.Quantization); err != nil {
+		return config, err
+	}
+
+	if config.Mmax0 == 0 {
+		config.Mmax0 = 2 * config.M
+	} else if config.Mmax0 < config.M {
+		return config, &SettingError{Setting: "Mmax0", Value: config.Mmax0, Reason: fmt.Sprintf("must be at least M (%d)", config.M)}
+	}
+	return config, nil
+}
+
+func ivfConfig(settings map[string]interface{}) (ivf.Config, error) {
+	config := ivf.DefaultConfig()
+	seed, err := seedSetting(settings)
+	if err != nil {
+		return config, err
+	}
+	config.Seed = seed
+
+	for key, value := range settings {
+		switch key {
+		case "nlist":
+			config.NList, err = intSetting(key, value, 1)
+		case "nprobe":
+			config.NProbe, err = intSetting(key, value, 1)
+		case "metric":
+			config.Metric, err = geometricMetricSetting(key, value)
+		case "iterations":
+			config.Iterations, err = intSetting(key, value, 1)
+		case "trainSize":
+			config.TrainSize, err = intSetting(key, value, 1)
+		case "sampleSize":
+			config.SampleSize, err = intSetting(key, value, 1)
+		case "retrainRatio":
+			config.RetrainRatio, err = floatSetting(key, value)
+			if err == nil && config.RetrainRatio != 0 && config.RetrainRatio <= 1 {
+				err = &SettingError{Setting: key, Value: value, Reason: "must be 0 or greater than 1"}
+			}
+		case "seed":
+			// read by seedSetting
+		default:
+			err = &SettingError{Setting: key, Reason: "unknown setting"}
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+
+	if config.TrainSize == 0 {
+		config.TrainSize = ivf.TrainPointsPerList * config.NList
+	} else if config.TrainSize < config.NList {
+		return config, &SettingError{Setting: "trainSize", Value: config.TrainSize, Reason: fmt.Sprintf("must be at least nlist (%d)", config.NList)}
+	}
+	if config.SampleSize == 0 {
+		config.SampleSize = ivf.SamplePointsPerList * config.NList
+	} else if config.SampleSize < config.NList {
+		return config, &SettingError{Setting: "sampleSize", Value: config.SampleSize, Reason: fmt.Sprintf("must be at least nlist (%d)", config.NList)}
+	}
+	return config, nil
+}
+
+func pqConfig(settings map[string]interface{}) (pq.Config, error) {
+	config := pq.DefaultConfig()
+	seed, err := seedSetting(settings)
+	if err != nil {
+		return config, err
+	}
+	config.Seed = seed
+
+	for key, value := range settings {
+		switch key {
+		case "subspaces":
+			config.Subspaces, err = intSetting(key, value, 0)
+		case "metric":
+			config.Metric, err = geometricMetricSetting(key, value)
+		case "iterations":
+			config.Iterations, err = intSetting(key, value, 1)
+		case "trainSize":
+			config.TrainSize, err = intSetting(key, value, 1)
+		case "sampleSize":
+			config.SampleSize, err = intSetting(key, value, 1)
+		case "keepOriginals":
+			config.KeepOriginals, err = boolSetting(key, value)
+		case "rerankFactor":
+			config.RerankFactor, err = intSetting(key, value, 0)
+		case "seed":
+			// read by seedSetting
+		default:
+			err = &SettingError{Setting: key, Reason: "unknown setting"}
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+func lshConfig(settings map[string]interface{}) (lsh.Config, error) {
+	config := lsh.DefaultConfig()
+	seed, err := seedSetting(settings)
+	if err != nil {
+		return config, err
+	}
+	config.Seed = seed
+
+	for key, value := range settings {
+		switch key {
+		case "tables":
+			config.Tables, err = intSetting(key, value, 1)
+		case "bits":
+			config.Bits, err = intSetting(key, value, 1)
+			if err == nil && config.Bits > lsh.MaxBits {
+				err = &SettingError{Setting: key, Value: value, Reason: fmt.Sprintf("must be at most %d", lsh.MaxBits)}
+			}
+		case "probes":
+			config.Probes, err = intSetting(key, value, 0)
+		case "bucketWidth":
+			config.BucketWidth, err = floatSetting(key, value)
+			if err == nil && config.BucketWidth <= 0 {
+				err = &SettingError{Setting: key, Value: value, Reason: "must be greater than 0"}
+			}
+		case "metric":
+			config.Metric, err = geometricMetricSetting(key, value)
+		case "seed":
+			// read by seedSetting
+		default:
+			err = &SettingError{Setting: key, Reason: "unknown setting"}
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+
+	if value, exists := settings["bucketWidth"]; exists && config.Metric != "euclidean" {
+		return config, &SettingError{Setting: "bucketWidth", Value: value, Reason: "only used with the euclidean metric"}
+	}
+	return config, nil
+}
+
+func annoyConfig(settings map[string]interface{}) (annoy.Config, error) {
+	config := annoy.DefaultConfig()
+	seed, err := seedSetting(settings)
+	if err != nil {
+		return config, err
+	}
+	config.Seed = seed
+
+	for key, value := range settings {
+		switch key {
+		case "trees":
+			config.Trees, err = intSetting(key, value, 1)
+		case "leafSize":
+			config.LeafSize, err = intSetting(key, value, 1)
+		case "searchK":
+			config.SearchK, err = intSetting(key, value, 0)
+		case "metric":
+			config.Metric, err = geometricMetricSetting(key, value)
+		case "seed":
+			// read by seedSetting
+		default:
+			err = &SettingError{Setting: key, Reason: "unknown setting"}
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+func kdtreeConfig(settings map[string]interface{}) (kdtree.Config, error) {
+	config := kdtree.DefaultConfig()
+	for key, value := range settings {
+		var err error
+		switch key {
+		case "leafSize":
+			config.LeafSize, err = intSetting(key, value, 1)
+		case "metric":
+			config.Metric, err = choiceSetting(key, value, "euclidean", "cosine")
+		default:
+			err = &SettingError{Setting: key, Reason: "unknown setting"}
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// vamanaConfig leaves the file unnamed unless the settings name it, the
+// algorithm then draws a name.
+func vamanaConfig(settings map[string]interface{}) (vamana.Config, error) {
+	config := vamana.DefaultConfig()
+	seed, err := seedSetting(settings)
+	if err != nil {
+		return config, err
+	}
+	config.Seed = seed
+
+	for key, value := range settings {
+		switch key {
+		case "maxDegree":
+			config.MaxDegree, err = intSetting(key, value, 1)
+		case "buildList":
+			config.BuildList, err = intSetting(key, value, 1)
+		case "searchList":
+			config.SearchList, err = intSetting(key, value, 1)
+		case "beamWidth":
+			config.BeamWidth, err = intSetting(key, value, 1)
+		case "alpha":
+			config.Alpha, err = floatSetting(key, value)
+			if err == nil && config.Alpha < 1 {
+				err = &SettingError{Setting: key, Value: value, Reason: "must be at least 1"}
+			}
+		case "metric":
+			config.Metric, err = geometricMetricSetting(key, value)
+		case "trainSize":
+			config.TrainSize, err = intSetting(key, value, 1)
+		case "seed":
+			// read by seedSetting
+		case "file":
+			config.File, err = fileSetting(key, value)
+		default:
+			err = &SettingError{Setting: key, Reason: "unknown setting"}
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// fileSetting accepts the name of a file of the data directory, other than
+// the snapshot and the WAL.
+func fileSetting(key string, value interface{}) (string, error) {
+	name, ok := value.(string)
+	if !ok || name == "" || name == "." || name == ".." || filepath.Base(name) != name || strings.ContainsAny(name, `/\`) {
+		return "", &SettingError{Setting: key, Value: value, Reason: "must be a file name, without directories"}
+	}
+	if name == SnapshotFile || name == WALFile || strings.HasPrefix(name, SnapshotFile+".") {
+		return "", &SettingError{Setting: key, Value: value, Reason: "is a file of the database manager"}
+	}
+	return name, nil
+}
+
+func sparseConfig(settings map[string]interface{}) (sparse.Config, error) {
+	config := sparse.DefaultConfig()
+	for key, value := range settings {
+		var err error
+		switch key {
+		case "metric":
+			config.Metric, err = choiceSetting(key, value, "dot_product", "cosine")
+		default:
+			err = &SettingError{Setting: key, Reason: "unknown setting"}
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// quantizationSetting parses key into config when it is one of the storage
+// settings of the algorithms that can quantize their vectors, reporting
+// whether it was.
+func quantizationSetting(config *quantization.Config, key string, value interface{}) (bool, error) {
+	var err error
+	switch key {
+	case "quantization":
+		config.Mode, err = choiceSetting(key, value, quantization.None, quantization.Int8, quantization.Bits)
+	case "trainSize":
+		config.TrainSize, err = intSetting(key, value, 1)
+	case "keepOriginals":
+		config.KeepOriginals, err = boolSetting(key, value)
+	case "rerankFactor":
+		config.RerankFactor, err = intSetting(key, value, 0)
+	default:
+		return false, nil
+	}
+	return true, err
+}
+
+// checkQuantization rejects the settings of quantized storage that the
+// storage mode doesn't use: binary codes need no training and always keep the
+// originals.
+func checkQuantization(settings map[string]interface{}, config quantization.Config) error {
+	keys := []string{"trainSize", "keepOriginals", "rerankFactor"}
+	reason := "only used with int8 or binary quantization"
+	switch config.Mode {
+	case quantization.Int8:
+		return nil
+	case quantization.Bits:
+		keys = keys[:2]
+		reason = "only used with int8 quantization"
+	}
+	for _, key := range keys {
+		if value, exists := settings[key]; exists {
+			return &SettingError{Setting: key, Value: value, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// quantizationSettings adds the effective storage settings of config to
+// settings.
+func quantizationSettings(settings map[string]interface{}, config quantization.Config) map[string]interface{} {
+	settings["quantization"] = config.Mode
+	if config.Trains() {
+		settings["trainSize"] = config.TrainSize
+		settings["keepOriginals"] = config.KeepOriginals
+	}
+	if config.Enabled() {
+		settings["rerankFactor"] = config.RerankFactor
+	}
+	return settings
+}
+
+// intSetting accepts whole JSON numbers no smaller than min.
+func intSetting(key string, value interface{}, min int) (int, error) {
+	var number float64
+	switch v := value.(type) {
+	case int:
+		return intSetting(key, int64(v), min)
+	case int64:
+		// checked apart from float64, which can't hold every int64 (seeds)
+		if v < int64(min) {
+			return 0, &SettingError{Setting: key, Value: value, Reason: fmt.Sprintf("must be at least %d", min)}
+		}
+		return int(v), nil
+	case float64:
+		number = v
+	default:
+		return 0, &SettingError{Setting: key, Value: value, Reason: "must be an integer"}
+	}
+
+	if number != math.Trunc(number) || math.IsInf(number, 0) {
+		return 0, &SettingError{Setting: key, Value: value, Reason: "must be an integer"}
+	}
+	if number < float64(min) {
+		return 0, &SettingError{Setting: key, Value: value, Reason: fmt.Sprintf("must be at least %d", min)}
+	}
+	return int(number), nil
+}
+
+// seedSetting returns the "seed" setting, or a seed taken from the clock when
+// there is none.
+func seedSetting(settings map[string]interface{}) (int64, error) {
+	value, exists := settings["seed"]
+	if !exists {
+		return time.Now().UnixNano(), nil
+	}
+	seed, err := intSetting("seed", value, math.MinInt)
+	return int64(seed), err
+}
+
+func floatSetting(key string, value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	return 0, &SettingError{Setting: key, Value: value, Reason: "must be a number"}
+}
+
+func boolSetting(key string, value interface{}) (bool, error) {
+	if v, ok := value.(bool); ok {
+		return v, nil
+	}
+	return false, &SettingError{Setting: key, Value: value, Reason: "must be true or false"}
+}
+
+func choiceSetting(key string, value interface{}, choices ...string) (string, error) {
+	choice, ok := value.(string)
+	if ok && slices.Contains(choices, choice) {
+		return choice, nil
+	}
+	return "", &SettingError{Setting: key, Value: value, Reason: "must be one of " + strings.Join(choices, ", ")}
+}
+
+func metricSetting(key string, value interface{}) (string, error) {
+	metric, ok := value.(string)
+	if !ok || !vector.IsValidMetric(metric) {
+		return "", &SettingError{Setting: key, Value: value, Reason: "must be one of cosine, dot_product, euclidean, hamming"}
+	}
+	return metric, nil
+}
+
+// geometricMetricSetting is metricSetting for the algorithms that index
+// vectors by where they lie, around k-means centroids or along random
+// projections, which have nothing to offer the hamming metric.
+func geometricMetricSetting(key string, value interface{}) (string, error) {
+	metric, err := metricSetting(key, value)
+	if err == nil && metric == "hamming" {
+		return "", &SettingError{Setting: key, Value: value, Reason: "must be one of cosine, dot_product, euclidean"}
+	}
+	return metric, err
+}
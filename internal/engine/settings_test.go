@@ -0,0 +1,591 @@
+package engine_test
+
+import (
+	"testing"
+
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/algorithms/annoy"
+	"VectorLite/internal/algorithms/hnsw"
+	"VectorLite/internal/algorithms/ivf"
+	"VectorLite/internal/algorithms/kdtree"
+	"VectorLite/internal/algorithms/lsh"
+	"VectorLite/internal/algorithms/pq"
+	"VectorLite/internal/algorithms/sparse"
+	"VectorLite/internal/algorithms/vamana"
+	"VectorLite/internal/engine"
+	"VectorLite/internal/quantization"
+	"VectorLite/internal/vector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAlgorithmBruteforce(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("bruteforce", nil)
+	require.NoError(t, err)
+	assert.NotNil(t, algorithm)
+	assert.Equal(t, map[string]interface{}{"quantization": "none"}, settings)
+
+	_, _, err = engine.NewAlgorithm("bruteforce", map[string]interface{}{"M": 16.0})
+	var settingErr *engine.SettingError
+	require.ErrorAs(t, err, &settingErr)
+	assert.Equal(t, "M", settingErr.Setting)
+}
+
+func TestNewAlgorithmQuantization(t *testing.T) {
+	for _, name := range []string{"bruteforce", "hnsw"} {
+		_, settings, err := engine.NewAlgorithm(name, map[string]interface{}{"quantization": "int8"})
+		require.NoError(t, err, name)
+		assert.Equal(t, "int8", settings["quantization"], name)
+		assert.Equal(t, quantization.DefaultTrainSize, settings["trainSize"], name)
+		assert.Equal(t, false, settings["keepOriginals"], name)
+		assert.Equal(t, quantization.DefaultRerankFactor, settings["rerankFactor"], name)
+
+		algorithm, settings, err := engine.NewAlgorithm(name, map[string]interface{}{
+			"quantization":  "int8",
+			"trainSize":     10.0,
+			"keepOriginals": true,
+			"rerankFactor":  0.0,
+		})
+		require.NoError(t, err, name)
+		assert.Equal(t, 10, settings["trainSize"], name)
+		assert.Equal(t, true, settings["keepOriginals"], name)
+		assert.Equal(t, 0, settings["rerankFactor"], name)
+		for id := 1; id <= 10; id++ {
+			algorithm.AddEntry(algorithms.Entry{Vector: *vector.NewVector(float64(id), 1), Id: id})
+		}
+		assert.True(t, algorithm.(interface{ Trained() bool }).Trained(), name)
+	}
+}
+
+func TestNewAlgorithmBinaryQuantization(t *testing.T) {
+	for _, name := range []string{"bruteforce", "hnsw"} {
+		algorithm, settings, err := engine.NewAlgorithm(name, map[string]interface{}{"quantization": "binary", "rerankFactor": 8.0})
+		require.NoError(t, err, name)
+		assert.Equal(t, "binary", settings["quantization"], name)
+		assert.Equal(t, 8, settings["rerankFactor"], name)
+		assert.NotContains(t, settings, "trainSize", name)
+		assert.NotContains(t, settings, "keepOriginals", name)
+		assert.True(t, algorithm.(interface{ Trained() bool }).Trained(), "%s needs no training", name)
+	}
+}
+
+func TestNewAlgorithmQuantizationInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		setting  string
+	}{
+		{"unknown mode", map[string]interface{}{"quantization": "int4"}, "quantization"},
+		{"zero trainSize", map[string]interface{}{"quantization": "int8", "trainSize": 0.0}, "trainSize"},
+		{"negative rerankFactor", map[string]interface{}{"quantization": "int8", "rerankFactor": -1.0}, "rerankFactor"},
+		{"keepOriginals as a string", map[string]interface{}{"quantization": "int8", "keepOriginals": "yes"}, "keepOriginals"},
+		{"trainSize without quantization", map[string]interface{}{"trainSize": 10.0}, "trainSize"},
+		{"keepOriginals with none", map[string]interface{}{"quantization": "none", "keepOriginals": true}, "keepOriginals"},
+		{"rerankFactor with none", map[string]interface{}{"rerankFactor": 2.0}, "rerankFactor"},
+		{"trainSize with binary", map[string]interface{}{"quantization": "binary", "trainSize": 10.0}, "trainSize"},
+		{"keepOriginals with binary", map[string]interface{}{"quantization": "binary", "keepOriginals": false}, "keepOriginals"},
+	}
+
+	for _, name := range []string{"bruteforce", "hnsw"} {
+		for _, tt := range tests {
+			t.Run(name+" "+tt.name, func(t *testing.T) {
+				_, _, err := engine.NewAlgorithm(name, tt.settings)
+				var settingErr *engine.SettingError
+				require.ErrorAs(t, err, &settingErr)
+				assert.Equal(t, tt.setting, settingErr.Setting)
+			})
+		}
+	}
+}
+
+func TestNewAlgorithmHNSWDefaults(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("hnsw", nil)
+	require.NoError(t, err)
+	assert.IsType(t, &hnsw.Algorithm{}, algorithm)
+
+	defaults := hnsw.DefaultConfig()
+	assert.Equal(t, defaults.M, settings["M"])
+	assert.Equal(t, defaults.EfConstruction, settings["efConstruction"])
+	assert.Equal(t, defaults.EfSearch, settings["efSearch"])
+	assert.Equal(t, defaults.ML, settings["mL"])
+	assert.Equal(t, defaults.Metric, settings["metric"])
+	assert.Equal(t, 2*defaults.M, settings["Mmax0"])
+	assert.Equal(t, hnsw.SelectHeuristic, settings["neighbourSelection"])
+	assert.Equal(t, false, settings["extendCandidates"])
+	assert.Equal(t, false, settings["keepPrunedConnections"])
+	assert.Contains(t, settings, "seed")
+}
+
+func TestNewAlgorithmHNSWSettings(t *testing.T) {
+	// values arrive from JSON, so numbers are float64
+	algorithm, settings, err := engine.NewAlgorithm("hnsw", map[string]interface{}{
+		"M":              32.0,
+		"efConstruction": 400.0,
+		"efSearch":       128.0,
+		"mL":             0.5,
+		"seed":           7.0,
+		"metric":         "euclidean",
+		"deletion":       "tombstone",
+		"tombstoneRatio": 0.25,
+		"insertWorkers":  4.0,
+		"Mmax0":          48.0,
+
+		"neighbourSelection":    "simple",
+		"extendCandidates":      true,
+		"keepPrunedConnections": true,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 32, settings["M"])
+	assert.Equal(t, 400, settings["efConstruction"])
+	assert.Equal(t, 128, settings["efSearch"])
+	assert.Equal(t, 0.5, settings["mL"])
+	assert.Equal(t, int64(7), settings["seed"])
+	assert.Equal(t, "tombstone", settings["deletion"])
+	assert.Equal(t, 0.25, settings["tombstoneRatio"])
+	assert.Equal(t, 4, settings["insertWorkers"])
+	assert.Equal(t, 48, settings["Mmax0"])
+	assert.Equal(t, "simple", settings["neighbourSelection"])
+	assert.Equal(t, true, settings["extendCandidates"])
+	assert.Equal(t, true, settings["keepPrunedConnections"])
+	assert.Equal(t, "euclidean", algorithm.(*hnsw.Algorithm).Metric())
+}
+
+func TestNewAlgorithmHNSWInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		setting  string
+	}{
+		{"fractional M", map[string]interface{}{"M": 2.5}, "M"},
+		{"M too small", map[string]interface{}{"M": 1.0}, "M"},
+		{"efSearch as string", map[string]interface{}{"efSearch": "100"}, "efSearch"},
+		{"zero efConstruction", map[string]interface{}{"efConstruction": 0.0}, "efConstruction"},
+		{"negative mL", map[string]interface{}{"mL": -1.0}, "mL"},
+		{"fractional seed", map[string]interface{}{"seed": 1.5}, "seed"},
+		{"unknown metric", map[string]interface{}{"metric": "manhattan"}, "metric"},
+		{"unknown deletion mode", map[string]interface{}{"deletion": "lazy"}, "deletion"},
+		{"tombstone ratio above 1", map[string]interface{}{"tombstoneRatio": 1.5}, "tombstoneRatio"},
+		{"negative insert workers", map[string]interface{}{"insertWorkers": -1.0}, "insertWorkers"},
+		{"Mmax0 below M", map[string]interface{}{"M": 16.0, "Mmax0": 8.0}, "Mmax0"},
+		{"unknown neighbour selection", map[string]interface{}{"neighbourSelection": "random"}, "neighbourSelection"},
+		{"extendCandidates as string", map[string]interface{}{"extendCandidates": "yes"}, "extendCandidates"},
+		{"unknown setting", map[string]interface{}{"ef": 10.0}, "ef"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := engine.NewAlgorithm("hnsw", tt.settings)
+			var settingErr *engine.SettingError
+			require.ErrorAs(t, err, &settingErr)
+			assert.Equal(t, tt.setting, settingErr.Setting)
+		})
+	}
+}
+
+func TestNewAlgorithmIVFDefaults(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("ivf", nil)
+	require.NoError(t, err)
+	assert.IsType(t, &ivf.Algorithm{}, algorithm)
+
+	assert.Equal(t, ivf.DefaultNList, settings["nlist"])
+	assert.Equal(t, ivf.DefaultNProbe, settings["nprobe"])
+	assert.Equal(t, ivf.DefaultMetric, settings["metric"])
+	assert.Equal(t, ivf.DefaultIterations, settings["iterations"])
+	assert.Equal(t, ivf.TrainPointsPerList*ivf.DefaultNList, settings["trainSize"])
+	assert.Equal(t, ivf.SamplePointsPerList*ivf.DefaultNList, settings["sampleSize"])
+	assert.Equal(t, ivf.DefaultRetrainRatio, settings["retrainRatio"])
+	assert.Contains(t, settings, "seed")
+}
+
+func TestNewAlgorithmIVFSettings(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("ivf", map[string]interface{}{
+		"nlist":        16.0,
+		"nprobe":       4.0,
+		"metric":       "euclidean",
+		"iterations":   5.0,
+		"trainSize":    100.0,
+		"sampleSize":   1000.0,
+		"retrainRatio": 0.0,
+		"seed":         3.0,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 16, settings["nlist"])
+	assert.Equal(t, 4, settings["nprobe"])
+	assert.Equal(t, 5, settings["iterations"])
+	assert.Equal(t, 100, settings["trainSize"])
+	assert.Equal(t, 1000, settings["sampleSize"])
+	assert.Equal(t, 0.0, settings["retrainRatio"])
+	assert.Equal(t, int64(3), settings["seed"])
+	assert.Equal(t, "euclidean", algorithm.(*ivf.Algorithm).Metric())
+
+	_, settings, err = engine.NewAlgorithm("ivf", map[string]interface{}{"nlist": 10.0})
+	require.NoError(t, err)
+	assert.Equal(t, 390, settings["trainSize"], "defaults follow nlist")
+	assert.Equal(t, 2560, settings["sampleSize"])
+}
+
+func TestNewAlgorithmIVFInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		setting  string
+	}{
+		{"zero nlist", map[string]interface{}{"nlist": 0.0}, "nlist"},
+		{"fractional nprobe", map[string]interface{}{"nprobe": 1.5}, "nprobe"},
+		{"unknown metric", map[string]interface{}{"metric": "manhattan"}, "metric"},
+		{"hamming metric", map[string]interface{}{"metric": "hamming"}, "metric"},
+		{"zero iterations", map[string]interface{}{"iterations": 0.0}, "iterations"},
+		{"trainSize below nlist", map[string]interface{}{"nlist": 10.0, "trainSize": 5.0}, "trainSize"},
+		{"sampleSize below nlist", map[string]interface{}{"nlist": 10.0, "sampleSize": 5.0}, "sampleSize"},
+		{"retrainRatio of 1", map[string]interface{}{"retrainRatio": 1.0}, "retrainRatio"},
+		{"HNSW setting", map[string]interface{}{"M": 16.0}, "M"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := engine.NewAlgorithm("ivf", tt.settings)
+			var settingErr *engine.SettingError
+			require.ErrorAs(t, err, &settingErr)
+			assert.Equal(t, tt.setting, settingErr.Setting)
+		})
+	}
+}
+
+func TestNewAlgorithmPQDefaults(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("pq", nil)
+	require.NoError(t, err)
+	assert.IsType(t, &pq.Algorithm{}, algorithm)
+
+	assert.Equal(t, 0, settings["subspaces"])
+	assert.Equal(t, pq.DefaultMetric, settings["metric"])
+	assert.Equal(t, pq.DefaultIterations, settings["iterations"])
+	assert.Equal(t, pq.DefaultTrainSize, settings["trainSize"])
+	assert.Equal(t, pq.DefaultSampleSize, settings["sampleSize"])
+	assert.Equal(t, false, settings["keepOriginals"])
+	assert.Equal(t, pq.DefaultRerankFactor, settings["rerankFactor"])
+	assert.Contains(t, settings, "seed")
+}
+
+func TestNewAlgorithmPQSettings(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("pq", map[string]interface{}{
+		"subspaces":     16.0,
+		"metric":        "euclidean",
+		"iterations":    5.0,
+		"trainSize":     1000.0,
+		"sampleSize":    2000.0,
+		"keepOriginals": true,
+		"rerankFactor":  0.0,
+		"seed":          3.0,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 16, settings["subspaces"])
+	assert.Equal(t, 5, settings["iterations"])
+	assert.Equal(t, 1000, settings["trainSize"])
+	assert.Equal(t, 2000, settings["sampleSize"])
+	assert.Equal(t, true, settings["keepOriginals"])
+	assert.Equal(t, 0, settings["rerankFactor"])
+	assert.Equal(t, int64(3), settings["seed"])
+	assert.Equal(t, "euclidean", algorithm.(*pq.Algorithm).Metric())
+}
+
+func TestNewAlgorithmPQInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		setting  string
+	}{
+		{"negative subspaces", map[string]interface{}{"subspaces": -1.0}, "subspaces"},
+		{"unknown metric", map[string]interface{}{"metric": "manhattan"}, "metric"},
+		{"hamming metric", map[string]interface{}{"metric": "hamming"}, "metric"},
+		{"zero trainSize", map[string]interface{}{"trainSize": 0.0}, "trainSize"},
+		{"keepOriginals as a string", map[string]interface{}{"keepOriginals": "yes"}, "keepOriginals"},
+		{"negative rerankFactor", map[string]interface{}{"rerankFactor": -2.0}, "rerankFactor"},
+		{"IVF setting", map[string]interface{}{"nlist": 16.0}, "nlist"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := engine.NewAlgorithm("pq", tt.settings)
+			var settingErr *engine.SettingError
+			require.ErrorAs(t, err, &settingErr)
+			assert.Equal(t, tt.setting, settingErr.Setting)
+		})
+	}
+}
+
+func TestNewAlgorithmLSHDefaults(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("lsh", nil)
+	require.NoError(t, err)
+	assert.IsType(t, &lsh.Algorithm{}, algorithm)
+
+	assert.Equal(t, lsh.DefaultTables, settings["tables"])
+	assert.Equal(t, lsh.DefaultBits, settings["bits"])
+	assert.Equal(t, lsh.DefaultProbes, settings["probes"])
+	assert.Equal(t, lsh.DefaultMetric, settings["metric"])
+	assert.NotContains(t, settings, "bucketWidth", "hyperplanes have no width")
+	assert.Contains(t, settings, "seed")
+}
+
+func TestNewAlgorithmLSHSettings(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("lsh", map[string]interface{}{
+		"tables":      4.0,
+		"bits":        64.0,
+		"probes":      0.0,
+		"metric":      "euclidean",
+		"bucketWidth": 0.5,
+		"seed":        3.0,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, settings["tables"])
+	assert.Equal(t, 64, settings["bits"])
+	assert.Equal(t, 0, settings["probes"])
+	assert.Equal(t, 0.5, settings["bucketWidth"])
+	assert.Equal(t, int64(3), settings["seed"])
+	assert.Equal(t, "euclidean", algorithm.(*lsh.Algorithm).Metric())
+}
+
+func TestNewAlgorithmLSHInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		setting  string
+	}{
+		{"zero tables", map[string]interface{}{"tables": 0.0}, "tables"},
+		{"zero bits", map[string]interface{}{"bits": 0.0}, "bits"},
+		{"too many bits", map[string]interface{}{"bits": 65.0}, "bits"},
+		{"negative probes", map[string]interface{}{"probes": -1.0}, "probes"},
+		{"zero bucketWidth", map[string]interface{}{"metric": "euclidean", "bucketWidth": 0.0}, "bucketWidth"},
+		{"bucketWidth with cosine", map[string]interface{}{"bucketWidth": 2.0}, "bucketWidth"},
+		{"hamming metric", map[string]interface{}{"metric": "hamming"}, "metric"},
+		{"IVF setting", map[string]interface{}{"nprobe": 16.0}, "nprobe"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := engine.NewAlgorithm("lsh", tt.settings)
+			var settingErr *engine.SettingError
+			require.ErrorAs(t, err, &settingErr)
+			assert.Equal(t, tt.setting, settingErr.Setting)
+		})
+	}
+}
+
+func TestNewAlgorithmAnnoyDefaults(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("annoy", nil)
+	require.NoError(t, err)
+	assert.IsType(t, &annoy.Algorithm{}, algorithm)
+
+	assert.Equal(t, annoy.DefaultTrees, settings["trees"])
+	assert.Equal(t, annoy.DefaultLeafSize, settings["leafSize"])
+	assert.Equal(t, 0, settings["searchK"], "k per tree")
+	assert.Equal(t, annoy.DefaultMetric, settings["metric"])
+	assert.Contains(t, settings, "seed")
+}
+
+func TestNewAlgorithmAnnoySettings(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("annoy", map[string]interface{}{
+		"trees":    50.0,
+		"leafSize": 32.0,
+		"searchK":  500.0,
+		"metric":   "euclidean",
+		"seed":     3.0,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 50, settings["trees"])
+	assert.Equal(t, 32, settings["leafSize"])
+	assert.Equal(t, 500, settings["searchK"])
+	assert.Equal(t, int64(3), settings["seed"])
+	assert.Equal(t, "euclidean", algorithm.(*annoy.Algorithm).Metric())
+}
+
+func TestNewAlgorithmAnnoyInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		setting  string
+	}{
+		{"zero trees", map[string]interface{}{"trees": 0.0}, "trees"},
+		{"zero leafSize", map[string]interface{}{"leafSize": 0.0}, "leafSize"},
+		{"negative searchK", map[string]interface{}{"searchK": -1.0}, "searchK"},
+		{"fractional searchK", map[string]interface{}{"searchK": 1.5}, "searchK"},
+		{"hamming metric", map[string]interface{}{"metric": "hamming"}, "metric"},
+		{"LSH setting", map[string]interface{}{"tables": 4.0}, "tables"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := engine.NewAlgorithm("annoy", tt.settings)
+			var settingErr *engine.SettingError
+			require.ErrorAs(t, err, &settingErr)
+			assert.Equal(t, tt.setting, settingErr.Setting)
+		})
+	}
+}
+
+func TestNewAlgorithmKDTree(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("kdtree", nil)
+	require.NoError(t, err)
+	assert.IsType(t, &kdtree.Algorithm{}, algorithm)
+	assert.Equal(t, map[string]interface{}{"leafSize": kdtree.DefaultLeafSize, "metric": "euclidean"}, settings)
+
+	algorithm, settings, err = engine.NewAlgorithm("kdtree", map[string]interface{}{
+		"leafSize": 8.0,
+		"metric":   "cosine",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 8, settings["leafSize"])
+	assert.Equal(t, "cosine", algorithm.(*kdtree.Algorithm).Metric())
+}
+
+func TestNewAlgorithmKDTreeInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		setting  string
+	}{
+		{"zero leafSize", map[string]interface{}{"leafSize": 0.0}, "leafSize"},
+		{"dot_product metric", map[string]interface{}{"metric": "dot_product"}, "metric"},
+		{"hamming metric", map[string]interface{}{"metric": "hamming"}, "metric"},
+		{"seed", map[string]interface{}{"seed": 1.0}, "seed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := engine.NewAlgorithm("kdtree", tt.settings)
+			var settingErr *engine.SettingError
+			require.ErrorAs(t, err, &settingErr)
+			assert.Equal(t, tt.setting, settingErr.Setting)
+		})
+	}
+}
+
+func TestNewAlgorithmVamanaDefaults(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("vamana", nil)
+	require.NoError(t, err)
+	assert.IsType(t, &vamana.Algorithm{}, algorithm)
+
+	assert.Equal(t, vamana.DefaultMaxDegree, settings["maxDegree"])
+	assert.Equal(t, vamana.DefaultBuildList, settings["buildList"])
+	assert.Equal(t, vamana.DefaultSearchList, settings["searchList"])
+	assert.Equal(t, vamana.DefaultBeamWidth, settings["beamWidth"])
+	assert.Equal(t, vamana.DefaultAlpha, settings["alpha"])
+	assert.Equal(t, vamana.DefaultMetric, settings["metric"])
+	assert.Contains(t, settings, "trainSize")
+	assert.Contains(t, settings, "seed")
+	assert.NotEmpty(t, settings["file"], "a file name is drawn")
+}
+
+func TestNewAlgorithmVamanaSettings(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("vamana", map[string]interface{}{
+		"maxDegree":  16.0,
+		"buildList":  100.0,
+		"searchList": 40.0,
+		"beamWidth":  2.0,
+		"alpha":      1.5,
+		"metric":     "cosine",
+		"trainSize":  5000.0,
+		"seed":       3.0,
+		"file":       "docs.index",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 16, settings["maxDegree"])
+	assert.Equal(t, 100, settings["buildList"])
+	assert.Equal(t, 40, settings["searchList"])
+	assert.Equal(t, 2, settings["beamWidth"])
+	assert.Equal(t, 1.5, settings["alpha"])
+	assert.Equal(t, 5000, settings["trainSize"])
+	assert.Equal(t, int64(3), settings["seed"])
+	assert.Equal(t, "docs.index", settings["file"])
+	assert.Equal(t, "cosine", algorithm.(*vamana.Algorithm).Metric())
+	assert.Equal(t, "docs.index", algorithm.(*vamana.Algorithm).File())
+}
+
+func TestNewAlgorithmVamanaInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		setting  string
+	}{
+		{"zero maxDegree", map[string]interface{}{"maxDegree": 0.0}, "maxDegree"},
+		{"zero searchList", map[string]interface{}{"searchList": 0.0}, "searchList"},
+		{"alpha below 1", map[string]interface{}{"alpha": 0.5}, "alpha"},
+		{"hamming metric", map[string]interface{}{"metric": "hamming"}, "metric"},
+		{"file in another directory", map[string]interface{}{"file": "../docs.index"}, "file"},
+		{"empty file", map[string]interface{}{"file": ""}, "file"},
+		{"WAL file", map[string]interface{}{"file": engine.WALFile}, "file"},
+		{"snapshot file", map[string]interface{}{"file": engine.SnapshotFile}, "file"},
+		{"HNSW setting", map[string]interface{}{"efSearch": 10.0}, "efSearch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := engine.NewAlgorithm("vamana", tt.settings)
+			var settingErr *engine.SettingError
+			require.ErrorAs(t, err, &settingErr)
+			assert.Equal(t, tt.setting, settingErr.Setting)
+		})
+	}
+}
+
+func TestNewAlgorithmSparse(t *testing.T) {
+	algorithm, settings, err := engine.NewAlgorithm("sparse", nil)
+	require.NoError(t, err)
+	assert.IsType(t, &sparse.Algorithm{}, algorithm)
+	assert.Equal(t, map[string]interface{}{"metric": "dot_product"}, settings)
+
+	algorithm, settings, err = engine.NewAlgorithm("sparse", map[string]interface{}{"metric": "cosine"})
+	require.NoError(t, err)
+	assert.Equal(t, "cosine", settings["metric"])
+	assert.Equal(t, "cosine", algorithm.(*sparse.Algorithm).Metric())
+}
+
+func TestNewAlgorithmSparseInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		setting  string
+	}{
+		{"euclidean metric", map[string]interface{}{"metric": "euclidean"}, "metric"},
+		{"hamming metric", map[string]interface{}{"metric": "hamming"}, "metric"},
+		{"seed", map[string]interface{}{"seed": 1.0}, "seed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := engine.NewAlgorithm("sparse", tt.settings)
+			var settingErr *engine.SettingError
+			require.ErrorAs(t, err, &settingErr)
+			assert.Equal(t, tt.setting, settingErr.Setting)
+		})
+	}
+}
+
+func TestNewAlgorithmUnsupported(t *testing.T) {
+	_, _, err := engine.NewAlgorithm("faiss", nil)
+	assert.ErrorIs(t, err, engine.ErrUnsupportedAlgorithm)
+}
+
+func TestCreateDatabaseWithSettings(t *testing.T) {
+	dm := engine.NewDatabaseManager()
+
+	db, err := dm.CreateDatabase("docs", "hnsw", map[string]interface{}{"M": 8.0})
+	require.NoError(t, err)
+	assert.Equal(t, "hnsw", db.AlgorithmName)
+	assert.Equal(t, 8, db.Settings["M"])
+
+	_, err = dm.CreateDatabase("docs", "bruteforce", nil)
+	assert.ErrorIs(t, err, engine.ErrDatabaseExists)
+
+	_, err = dm.CreateDatabase("bad", "hnsw", map[string]interface{}{"M": "many"})
+	var settingErr *engine.SettingError
+	assert.ErrorAs(t, err, &settingErr)
+	_, err = dm.GetDatabase("bad")
+	assert.ErrorIs(t, err, engine.ErrDatabaseNotFound)
+}
@@ -0,0 +1,399 @@
+package engine
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/codec"
+	"VectorLite/internal/vector"
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// SnapshotFile is the name of the snapshot inside a data directory.
+const SnapshotFile = "vectorlite.snapshot"
+
+// SnapshotVersion is the version of the snapshot format written by
+// WriteSnapshot, the only one ReadSnapshot reads.
+const SnapshotVersion = 1
+
+const snapshotMagic = "VLSNAP\x00\x00"
+
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+// setting value tags
+const (
+	settingInt    = 'i'
+	settingFloat  = 'f'
+	settingString = 's'
+	settingBool   = 'b'
+)
+
+/*
+WriteSnapshot writes every database to w:
+
+	magic "VLSNAP\0\0", version uint32
+	sequence number of the last WAL record applied uint64
+	database count uint32
+	per database:
+		name, algorithm, settings
+		NumberEntries int64, Dimension uint32, entry count uint64
+		per entry: id int64, external id, vector values, is sparse bool,
+			the vector indices if it is, metadata
+		has index bool, followed by the algorithm's own index format
+	CRC-32 (IEEE) of everything after the version, uint32
+
+Numbers are little-endian, strings and lists are prefixed with their uint32
+length. Algorithms implementing algorithms.Persistent save their index, so it
+is restored as is; the others are rebuilt from the entries on load.
+
+Writes to the databases wait for the snapshot to be written, queries don't.
+*/
+func (dm *DatabaseManager) WriteSnapshot(w io.Writer) error {
+	unfreeze := dm.freeze()
+	defer unfreeze()
+	return dm.writeSnapshot(w)
+}
+
+// writeSnapshot is WriteSnapshot for callers that already froze dm.
+func (dm *DatabaseManager) writeSnapshot(w io.Writer) error {
+	header := codec.NewWriter(w)
+	header.Raw([]byte(snapshotMagic))
+	header.Uint32(SnapshotVersion)
+	if err := header.Err(); err != nil {
+		return err
+	}
+
+	checksum := crc32.NewIEEE()
+	body := io.MultiWriter(w, checksum)
+	out := codec.NewWriter(body)
+
+	names := dm.names()
+	out.Uint64(dm.lastSequence())
+	out.Uint32(uint32(len(names)))
+	for _, name := range names {
+		if err := writeDatabase(out, body, dm.databases[name]); err != nil {
+			return err
+		}
+	}
+	if err := out.Err(); err != nil {
+		return err
+	}
+
+	header.Uint32(checksum.Sum32())
+	return header.Err()
+}
+
+// writeDatabase writes db through out, indexes are written straight to w,
+// which must be the writer out writes to.
+func writeDatabase(out *codec.Writer, w io.Writer, db *Database) error {
+	out.String(db.Name)
+	out.String(db.AlgorithmName)
+	if err := writeSettings(out, db.Settings); err != nil {
+		return fmt.Errorf("database %q: %w", db.Name, err)
+	}
+
+	// file-backed indexes keep the vectors in their file
+	var entries []algorithms.Entry
+	if fileBacked, ok := db.Algorithm.(algorithms.FileBacked); ok {
+		entries = fileBacked.ListStored()
+	} else {
+		entries = db.Algorithm.ListEntries()
+	}
+	out.Int64(int64(db.NumberEntries))
+	out.Uint32(uint32(db.Dimension))
+	out.Uint64(uint64(len(entries)))
+	for _, entry := range entries {
+		out.Int64(int64(entry.Id))
+		out.String(entry.ExternalId)
+		out.Float64s(entry.Vector.Values)
+		out.Bool(entry.Vector.IsSparse())
+		if entry.Vector.IsSparse() {
+			out.Uint32s(entry.Vector.Indices)
+		}
+		out.StringMap(entry.Metadata)
+	}
+
+	persistent, hasIndex := db.Algorithm.(algorithms.Persistent)
+	out.Bool(hasIndex)
+	if err := out.Err(); err != nil {
+		return err
+	}
+	if hasIndex {
+		return persistent.SaveIndex(w)
+	}
+	return nil
+}
+
+func writeSettings(out *codec.Writer, settings map[string]interface{}) error {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out.Uint32(uint32(len(keys)))
+	for _, key := range keys {
+		out.String(key)
+		switch value := settings[key].(type) {
+		case int:
+			out.Byte(settingInt)
+			out.Int64(int64(value))
+		case int64:
+			out.Byte(settingInt)
+			out.Int64(value)
+		case float64:
+			out.Byte(settingFloat)
+			out.Float64(value)
+		case string:
+			out.Byte(settingString)
+			out.String(value)
+		case bool:
+			out.Byte(settingBool)
+			out.Bool(value)
+		default:
+			return fmt.Errorf("setting %q: can't snapshot a %T", key, value)
+		}
+	}
+	return nil
+}
+
+// ReadSnapshot loads the databases written by WriteSnapshot. The manager is
+// only changed once the whole snapshot has been read and its checksum
+// verified, and it must not hold any of the snapshot's databases yet.
+func (dm *DatabaseManager) ReadSnapshot(r io.Reader) error {
+	header := codec.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	header.Raw(magic)
+	version := header.Uint32()
+	if err := header.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	if string(magic) != snapshotMagic {
+		return fmt.Errorf("%w: not a snapshot", ErrCorruptSnapshot)
+	}
+	if version != SnapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrCorruptSnapshot, version)
+	}
+
+	checksum := crc32.NewIEEE()
+	tee := io.TeeReader(r, checksum)
+	in := codec.NewReader(tee)
+
+	sequence := in.Uint64()
+	count := in.Length()
+	databases := make([]*Database, 0, count)
+	for i := 0; i < count && in.Err() == nil; i++ {
+		db, err := readDatabase(in, tee, dm.dataDir)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+		}
+		databases = append(databases, db)
+	}
+	if err := in.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+
+	expected := header.Uint32()
+	if err := header.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	if expected != checksum.Sum32() {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	for _, db := range databases {
+		if _, exists := dm.databases[db.Name]; exists {
+			return fmt.Errorf("%w: %s", ErrDatabaseExists, db.Name)
+		}
+	}
+	for _, db := range databases {
+		dm.databases[db.Name] = db
+	}
+	if sequence > dm.sequence {
+		dm.sequence = sequence
+	}
+	return nil
+}
+
+// readDatabase reads a database written by writeDatabase. Indexes are read
+// straight from r, which must be the reader in reads from, the files of
+// file-backed indexes from dataDir.
+func readDatabase(in *codec.Reader, r io.Reader, dataDir string) (*Database, error) {
+	name := in.String()
+	algorithmName := in.String()
+	settings, err := readSettings(in)
+	if err != nil {
+		return nil, fmt.Errorf("database %q: %w", name, err)
+	}
+	numberEntries := int(in.Int64())
+	dimension := int(in.Uint32())
+	count := in.Uint64()
+	if err := in.Err(); err != nil {
+		return nil, err
+	}
+	if count > codec.MaxLength {
+		return nil, fmt.Errorf("database %q: %d entries", name, count)
+	}
+
+	entries := make([]algorithms.Entry, 0, count)
+	for i := uint64(0); i < count && in.Err() == nil; i++ {
+		entry := algorithms.Entry{Id: int(in.Int64())}
+		entry.ExternalId = in.String()
+		entry.Vector = vector.Vector{Values: in.Float64s()}
+		if in.Bool() {
+			entry.Vector.Indices = in.Uint32s()
+		}
+		entry.Metadata = in.StringMap()
+		entries = append(entries, entry)
+	}
+	hasIndex := in.Bool()
+	if err := in.Err(); err != nil {
+		return nil, err
+	}
+
+	algorithm, effectiveSettings, err := NewAlgorithm(algorithmName, settings)
+	if err != nil {
+		return nil, fmt.Errorf("database %q: %w", name, err)
+	}
+
+	if fileBacked, ok := algorithm.(algorithms.FileBacked); ok {
+		fileBacked.SetDir(dataDir)
+	}
+	if hasIndex {
+		persistent, ok := algorithm.(algorithms.Persistent)
+		if !ok {
+			return nil, fmt.Errorf("database %q: %s can't load an index", name, algorithmName)
+		}
+		if err := persistent.LoadIndex(r, entries); err != nil {
+			return nil, fmt.Errorf("database %q: %w", name, err)
+		}
+	} else {
+		for _, entry := range entries {
+			algorithm.AddEntry(entry)
+		}
+	}
+
+	db := NewDatabase(name, algorithm)
+	db.AlgorithmName = algorithmName
+	db.Settings = effectiveSettings
+	db.NumberEntries = numberEntries
+	db.Dimension = dimension
+	for _, entry := range entries {
+		if entry.ExternalId != "" {
+			db.externalIds[entry.ExternalId] = entry.Id
+		}
+	}
+	return db, nil
+}
+
+func readSettings(in *codec.Reader) (map[string]interface{}, error) {
+	count := in.Length()
+	settings := make(map[string]interface{}, count)
+	for i := 0; i < count && in.Err() == nil; i++ {
+		key := in.String()
+		switch tag := in.Byte(); tag {
+		case settingInt:
+			settings[key] = in.Int64()
+		case settingFloat:
+			settings[key] = in.Float64()
+		case settingString:
+			settings[key] = in.String()
+		case settingBool:
+			settings[key] = in.Bool()
+		default:
+			if in.Err() == nil {
+				return nil, fmt.Errorf("setting %q has unknown type %q", key, tag)
+			}
+		}
+	}
+	return settings, in.Err()
+}
+
+// SaveSnapshot writes every database to SnapshotFile in dir. The snapshot is
+// written to a temporary file first and renamed over the previous one, so a
+// crash midway leaves the previous snapshot intact.
+func (dm *DatabaseManager) SaveSnapshot(dir string) error {
+	unfreeze := dm.freeze()
+	defer unfreeze()
+	return dm.saveSnapshot(dir)
+}
+
+// saveSnapshot is SaveSnapshot for callers that already froze dm. The files
+// of the databases deleted before are removed once it is saved, and
+// file-backed indexes told it is.
+func (dm *DatabaseManager) saveSnapshot(dir string) error {
+	dm.droppedMu.Lock()
+	dropped := slices.Clone(dm.dropped)
+	dm.droppedMu.Unlock()
+
+	file, err := os.CreateTemp(dir, SnapshotFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	buffered := bufio.NewWriter(file)
+	// CreateTemp makes the file private, snapshots get the usual permissions
+	err = file.Chmod(0o644)
+	if err == nil {
+		err = dm.writeSnapshot(buffered)
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(file.Name(), filepath.Join(dir, SnapshotFile)); err != nil {
+		return err
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	for _, db := range dm.databases {
+		if fileBacked, ok := db.Algorithm.(algorithms.FileBacked); ok {
+			fileBacked.Saved()
+		}
+	}
+	return dm.removeDropped(dropped)
+}
+
+// LoadSnapshot reads SnapshotFile from dir, see ReadSnapshot. A missing
+// snapshot loads nothing.
+func (dm *DatabaseManager) LoadSnapshot(dir string) error {
+	file, err := os.Open(filepath.Join(dir, SnapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return dm.ReadSnapshot(bufio.NewReader(file))
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
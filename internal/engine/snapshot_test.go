@@ -0,0 +1,309 @@
+package engine_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"VectorLite/internal/engine"
+	"VectorLite/internal/vector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func snapshotFixture(t *testing.T) *engine.DatabaseManager {
+	dm := engine.NewDatabaseManager()
+
+	flat, err := dm.CreateDatabase("flat", "bruteforce", nil)
+	require.NoError(t, err)
+	flat.AddEntry(*vector.NewVector(1, 0), map[string]string{"lang": "en"})
+	flat.PutEntry("doc-2", *vector.NewVector(0, 1), map[string]string{"lang": "fr"})
+	flat.AddEntry(*vector.NewVector(1, 1), nil)
+	require.NoError(t, flat.DeleteEntry(3))
+
+	graph, err := dm.CreateDatabase("graph", "hnsw", map[string]interface{}{
+		"M":        8.0,
+		"metric":   "euclidean",
+		"deletion": "tombstone",
+		"seed":     int64(1) << 60,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		graph.AddEntry(*vector.NewVector(float64(i), float64(i%7)), map[string]string{"i": "x"})
+	}
+	require.NoError(t, graph.DeleteEntry(10))
+
+	lists, err := dm.CreateDatabase("lists", "ivf", map[string]interface{}{
+		"nlist":     4.0,
+		"metric":    "euclidean",
+		"trainSize": 20.0,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		lists.AddEntry(*vector.NewVector(float64(i%10), float64(i/10)), map[string]string{"i": "x"})
+	}
+	require.NoError(t, lists.DeleteEntry(5))
+
+	codes, err := dm.CreateDatabase("codes", "pq", map[string]interface{}{
+		"subspaces": 2.0,
+		"metric":    "euclidean",
+		"trainSize": 20.0,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		codes.AddEntry(*vector.NewVector(float64(i%10), float64(i/10)), map[string]string{"i": "x"})
+	}
+	require.NoError(t, codes.DeleteEntry(7))
+
+	hashes, err := dm.CreateDatabase("hashes", "lsh", map[string]interface{}{
+		"metric":      "euclidean",
+		"bucketWidth": 2.0,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		hashes.AddEntry(*vector.NewVector(float64(i%10), float64(i/10)), map[string]string{"i": "x"})
+	}
+	require.NoError(t, hashes.DeleteEntry(1))
+
+	forest, err := dm.CreateDatabase("forest", "annoy", map[string]interface{}{
+		"trees":    4.0,
+		"leafSize": 4.0,
+		"metric":   "euclidean",
+	})
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		forest.AddEntry(*vector.NewVector(float64(i%10), float64(i/10)), map[string]string{"i": "x"})
+	}
+	require.NoError(t, forest.DeleteEntry(2))
+
+	points, err := dm.CreateDatabase("points", "kdtree", map[string]interface{}{
+		"leafSize": 4.0,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		points.AddEntry(*vector.NewVector(float64(i%10), float64(i/10)), map[string]string{"i": "x"})
+	}
+	require.NoError(t, points.DeleteEntry(4))
+
+	scalar, err := dm.CreateDatabase("scalar", "bruteforce", map[string]interface{}{
+		"quantization": "int8",
+		"trainSize":    20.0,
+	})
+	require.NoError(t, err)
+	quantized, err := dm.CreateDatabase("quantized", "hnsw", map[string]interface{}{
+		"M":            8.0,
+		"metric":       "euclidean",
+		"deletion":     "tombstone",
+		"quantization": "int8",
+		"trainSize":    20.0,
+	})
+	require.NoError(t, err)
+	signs, err := dm.CreateDatabase("signs", "hnsw", map[string]interface{}{
+		"M":            8.0,
+		"metric":       "euclidean",
+		"deletion":     "tombstone",
+		"quantization": "binary",
+	})
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		scalar.AddEntry(*vector.NewVector(float64(i%10), float64(i/10)), map[string]string{"i": "x"})
+		quantized.AddEntry(*vector.NewVector(float64(i%10), float64(i/10)), map[string]string{"i": "x"})
+		signs.AddEntry(*vector.NewVector(float64(i%10-5), float64(i/10-2)), map[string]string{"i": "x"})
+	}
+	require.NoError(t, scalar.DeleteEntry(3))
+	require.NoError(t, quantized.DeleteEntry(3))
+	require.NoError(t, signs.DeleteEntry(3))
+
+	terms, err := dm.CreateDatabase("terms", "sparse", nil)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		v, err := vector.NewSparseVector([]uint32{uint32(i % 10), uint32(100 + i%7)}, []float64{float64(i%3 + 1), 0.5})
+		require.NoError(t, err)
+		terms.AddEntry(*v, map[string]string{"i": "x"})
+	}
+	require.NoError(t, terms.DeleteEntry(6))
+
+	return dm
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dm := snapshotFixture(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, dm.WriteSnapshot(&buf))
+
+	loaded := engine.NewDatabaseManager()
+	require.NoError(t, loaded.ReadSnapshot(&buf))
+	assert.ElementsMatch(t, []string{"flat", "graph", "lists", "codes", "hashes", "forest", "points", "scalar", "quantized", "signs", "terms"}, loaded.ListDatabases())
+
+	for _, name := range dm.ListDatabases() {
+		original, _ := dm.GetDatabase(name)
+		restored, err := loaded.GetDatabase(name)
+		require.NoError(t, err)
+
+		assert.Equal(t, original.AlgorithmName, restored.AlgorithmName)
+		assert.Equal(t, original.Settings, restored.Settings)
+		assert.Equal(t, original.NumberEntries, restored.NumberEntries)
+		assert.Equal(t, original.Dimension, restored.Dimension)
+		assert.Equal(t, original.ListEntries(), restored.ListEntries())
+
+		query := vector.NewVector(3, 2)
+		metric := "cosine"
+		if name != "flat" {
+			metric = "euclidean"
+		}
+		if name == "terms" {
+			query, _ = vector.NewSparseVector([]uint32{3, 103}, []float64{1, 2})
+			metric = "dot_product"
+		}
+		expected, err := original.Query(query, 5, metric)
+		require.NoError(t, err)
+		actual, err := restored.Query(query, 5, metric)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+
+	flat, _ := loaded.GetDatabase("flat")
+	id, err := flat.ResolveId("doc-2")
+	require.NoError(t, err)
+	assert.Equal(t, 2, id)
+	id, err = flat.AddEntry(*vector.NewVector(2, 2), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 4, id, "ids keep counting after a reload")
+}
+
+func TestSnapshotRejectsCorruption(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, snapshotFixture(t).WriteSnapshot(&buf))
+	data := buf.Bytes()
+
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)/2] ^= 0xff
+	dm := engine.NewDatabaseManager()
+	assert.ErrorIs(t, dm.ReadSnapshot(bytes.NewReader(flipped)), engine.ErrCorruptSnapshot)
+	assert.Empty(t, dm.ListDatabases(), "nothing is loaded from a corrupt snapshot")
+
+	truncated := data[:len(data)-10]
+	assert.ErrorIs(t, dm.ReadSnapshot(bytes.NewReader(truncated)), engine.ErrCorruptSnapshot)
+
+	version := bytes.Clone(data)
+	version[8] = engine.SnapshotVersion + 1
+	assert.ErrorIs(t, dm.ReadSnapshot(bytes.NewReader(version)), engine.ErrCorruptSnapshot)
+
+	assert.ErrorIs(t, dm.ReadSnapshot(bytes.NewReader([]byte("not a snapshot"))), engine.ErrCorruptSnapshot)
+}
+
+func TestSnapshotExistingDatabase(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, snapshotFixture(t).WriteSnapshot(&buf))
+
+	dm := engine.NewDatabaseManager()
+	_, err := dm.CreateDatabase("graph", "bruteforce", nil)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, dm.ReadSnapshot(&buf), engine.ErrDatabaseExists)
+	assert.Equal(t, []string{"graph"}, dm.ListDatabases())
+}
+
+func TestSaveLoadSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	empty := engine.NewDatabaseManager()
+	require.NoError(t, empty.LoadSnapshot(dir), "a missing snapshot loads nothing")
+	assert.Empty(t, empty.ListDatabases())
+
+	require.NoError(t, snapshotFixture(t).SaveSnapshot(dir))
+	require.NoError(t, snapshotFixture(t).SaveSnapshot(dir), "saving replaces the previous snapshot")
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1, "temporary files are cleaned up")
+	assert.Equal(t, engine.SnapshotFile, files[0].Name())
+
+	loaded := engine.NewDatabaseManager()
+	require.NoError(t, loaded.LoadSnapshot(dir))
+	assert.ElementsMatch(t, []string{"flat", "graph", "lists", "codes", "hashes", "forest", "points", "scalar", "quantized", "signs", "terms"}, loaded.ListDatabases())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, engine.SnapshotFile), []byte("garbage"), 0o644))
+	assert.ErrorIs(t, engine.NewDatabaseManager().LoadSnapshot(dir), engine.ErrCorruptSnapshot)
+}
+
+func TestSnapshotFileBacked(t *testing.T) {
+	dir := t.TempDir()
+	dm := engine.NewDatabaseManager()
+	dm.SetDataDir(dir)
+	db, err := dm.CreateDatabase("disk", "vamana", map[string]interface{}{"file": "disk.index", "seed": 1.0})
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		_, err := db.AddEntry(*vector.NewVector(float64(i%7), float64(i%11)), map[string]string{})
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.DeleteEntry(5))
+	assert.FileExists(t, filepath.Join(dir, "disk.index"))
+
+	_, err = dm.CreateDatabase("other", "vamana", map[string]interface{}{"file": "disk.index"})
+	assert.ErrorIs(t, err, engine.ErrFileInUse)
+
+	require.NoError(t, dm.SaveSnapshot(dir))
+	expected, err := db.Query(vector.NewVector(3, 4), 5, "euclidean")
+	require.NoError(t, err)
+
+	loaded := engine.NewDatabaseManager()
+	loaded.SetDataDir(dir)
+	require.NoError(t, loaded.LoadSnapshot(dir))
+	restored, err := loaded.GetDatabase("disk")
+	require.NoError(t, err)
+	assert.Equal(t, db.ListEntries(), restored.ListEntries(), "vectors are read back from the file")
+	assert.Equal(t, 2, restored.Dimension)
+	actual, err := restored.Query(vector.NewVector(3, 4), 5, "euclidean")
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// the file of a deleted database stays until a snapshot no longer lists it
+	require.NoError(t, loaded.DeleteDatabase("disk"))
+	assert.FileExists(t, filepath.Join(dir, "disk.index"))
+	_, err = loaded.CreateDatabase("disk", "vamana", map[string]interface{}{"file": "disk.index"})
+	assert.ErrorIs(t, err, engine.ErrFileInUse)
+	require.NoError(t, loaded.SaveSnapshot(dir))
+	assert.NoFileExists(t, filepath.Join(dir, "disk.index"))
+	_, err = loaded.CreateDatabase("disk", "vamana", map[string]interface{}{"file": "disk.index"})
+	assert.NoError(t, err)
+}
+
+func TestSnapshotFileBackedReusesSlots(t *testing.T) {
+	dir := t.TempDir()
+	dm := engine.NewDatabaseManager()
+	dm.SetDataDir(dir)
+	db, err := dm.CreateDatabase("disk", "vamana", map[string]interface{}{"file": "disk.index", "seed": 1.0})
+	require.NoError(t, err)
+	replaceAll := func(round int) {
+		for id := 1; id <= 50; id++ {
+			_, err := db.UpsertEntry(id, *vector.NewVector(float64(id), float64(round)), map[string]string{})
+			require.NoError(t, err)
+		}
+	}
+
+	replaceAll(0)
+	require.NoError(t, dm.SaveSnapshot(dir))
+	info, err := os.Stat(filepath.Join(dir, "disk.index"))
+	require.NoError(t, err)
+	for round := 1; round <= 30; round++ {
+		replaceAll(round)
+		require.NoError(t, dm.SaveSnapshot(dir))
+	}
+	after, err := os.Stat(filepath.Join(dir, "disk.index"))
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), after.Size(), "replaced vectors reuse the slots of the old ones")
+
+	// writes after the snapshot, lost in a crash, leave its vectors alone
+	expected := db.ListEntries()
+	replaceAll(31)
+	loaded := engine.NewDatabaseManager()
+	loaded.SetDataDir(dir)
+	require.NoError(t, loaded.LoadSnapshot(dir))
+	restored, err := loaded.GetDatabase("disk")
+	require.NoError(t, err)
+	assert.Equal(t, expected, restored.ListEntries())
+}
@@ -3,21 +3,62 @@ package engine
 import (
 	"VectorLite/internal/algorithms"
 	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
 )
 
 var (
 	ErrDatabaseExists   = errors.New("database already exists")
 	ErrDatabaseNotFound = errors.New("database not found")
+	ErrMetricMismatch   = errors.New("metric does not match the database index")
+	ErrEntryNotFound    = errors.New("entry not found")
+	ErrInvalidEntryId   = errors.New("entry ids must be positive integers")
+	ErrDuplicateId      = errors.New("duplicate external id")
+	ErrFileInUse        = errors.New("index file already in use")
+	ErrVectorType       = errors.New("vector type not supported by the index")
 )
 
+/*
+Database methods are safe for concurrent use: queries and lookups share a
+read lock and run in parallel, writes take the lock exclusively.
+Algorithm, NumberEntries and the other fields must not be used directly
+while the database is being served.
+*/
 type Database struct {
 	Name          string
 	Algorithm     algorithms.SearchAlgorithm
+	AlgorithmName string
+	Settings      map[string]interface{}
 	NumberEntries int
+	// Dimension is the number of values of the database's dense vectors,
+	// set by its first entry. It is 0 until then, and for sparse vectors.
+	Dimension   int
+	mu          sync.RWMutex
+	externalIds map[string]int
+	wal         *WAL
+	// deleted is set once the database is dropped from its manager, so
+	// writes racing with the deletion don't reach the WAL after it
+	deleted bool
 }
 
+// DatabaseManager methods are safe for concurrent use. Its lock guards the
+// set of databases and is always taken before the lock of a database.
 type DatabaseManager struct {
+	mu        sync.RWMutex
 	databases map[string]*Database
+	wal       *WAL
+	// sequence is the sequence number of the last WAL record applied to the
+	// databases, snapshots save it so replaying skips what they already hold
+	sequence uint64
+	// dataDir holds the index files of the file-backed algorithms, the
+	// system's temporary directory when empty
+	dataDir string
+	// dropped are the files of deleted databases that the last snapshot may
+	// still list, removed once a snapshot without them is saved
+	dropped   []algorithms.FileBacked
+	droppedMu sync.Mutex
 }
 
 func NewDatabaseManager() *DatabaseManager {
@@ -26,20 +67,55 @@ func NewDatabaseManager() *DatabaseManager {
 	}
 }
 
-func (dm *DatabaseManager) CreateDatabase(name string, algorithm algorithms.SearchAlgorithm) error {
+// SetDataDir places the index files of file-backed algorithms, see
+// algorithms.FileBacked, in dir. It must be called before any database is
+// created or loaded, with the directory snapshots are saved to.
+func (dm *DatabaseManager) SetDataDir(dir string) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.dataDir = dir
+}
+
+// CreateDatabase registers a new database backed by the algorithm called
+// algorithmName, see NewAlgorithm for the accepted settings.
+func (dm *DatabaseManager) CreateDatabase(name string, algorithmName string, settings map[string]interface{}) (*Database, error) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
 	if _, exists := dm.databases[name]; exists {
-		return ErrDatabaseExists
+		return nil, ErrDatabaseExists
+	}
+
+	algorithm, effectiveSettings, err := NewAlgorithm(algorithmName, settings)
+	if err != nil {
+		return nil, err
 	}
-	
-	db := &Database{
-		Name:      name,
-		Algorithm: algorithm,
+	if err := dm.createFile(algorithm); err != nil {
+		return nil, err
 	}
+
+	if dm.wal != nil {
+		record := walRecord{op: opCreateDatabase, database: name, algorithm: algorithmName, settings: effectiveSettings}
+		if err := dm.wal.append(record); err != nil {
+			if fileBacked, ok := algorithm.(algorithms.FileBacked); ok {
+				fileBacked.Remove()
+			}
+			return nil, err
+		}
+	}
+
+	db := NewDatabase(name, algorithm)
+	db.AlgorithmName = algorithmName
+	db.Settings = effectiveSettings
+	db.wal = dm.wal
 	dm.databases[name] = db
-	return nil
+	return db, nil
 }
 
 func (dm *DatabaseManager) GetDatabase(name string) (*Database, error) {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
 	db, exists := dm.databases[name]
 	if !exists {
 		return nil, ErrDatabaseNotFound
@@ -48,18 +124,123 @@ func (dm *DatabaseManager) GetDatabase(name string) (*Database, error) {
 }
 
 func (dm *DatabaseManager) ListDatabases() []string {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+	return dm.names()
+}
+
+// names lists the databases in name order, the caller holds dm.mu.
+func (dm *DatabaseManager) names() []string {
 	names := make([]string, 0, len(dm.databases))
 	for name := range dm.databases {
 		names = append(names, name)
 	}
+	sort.Strings(names)
 	return names
 }
 
+// DeleteDatabase drops a database. It waits for the writes in progress on it
+// to finish, later writes fail with ErrDatabaseNotFound.
 func (dm *DatabaseManager) DeleteDatabase(name string) error {
-	if _, exists := dm.databases[name]; !exists {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	db, exists := dm.databases[name]
+	if !exists {
 		return ErrDatabaseNotFound
 	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if dm.wal != nil {
+		if err := dm.wal.append(walRecord{op: opDeleteDatabase, database: name}); err != nil {
+			return err
+		}
+	}
+	db.deleted = true
 	delete(dm.databases, name)
+	dm.drop(db)
 	return nil
 }
 
+// createFile creates the index file of a new database in the data directory,
+// when its algorithm keeps its index in a file. A file can't be shared with
+// another database, nor with a deleted one that the last snapshot may still
+// list. The caller holds dm.mu.
+func (dm *DatabaseManager) createFile(algorithm algorithms.SearchAlgorithm) error {
+	fileBacked, ok := algorithm.(algorithms.FileBacked)
+	if !ok {
+		return nil
+	}
+	fileBacked.SetDir(dm.dataDir)
+
+	used := []algorithms.FileBacked{}
+	for _, db := range dm.databases {
+		if other, ok := db.Algorithm.(algorithms.FileBacked); ok {
+			used = append(used, other)
+		}
+	}
+	dm.droppedMu.Lock()
+	used = append(used, dm.dropped...)
+	dm.droppedMu.Unlock()
+	for _, other := range used {
+		if other.Path() == fileBacked.Path() {
+			return fmt.Errorf("%w: %s", ErrFileInUse, fileBacked.Path())
+		}
+	}
+	return fileBacked.Create()
+}
+
+// drop releases the index file of a deleted database. Without a data
+// directory nothing lists it, and it is removed right away. The caller holds
+// dm.mu.
+func (dm *DatabaseManager) drop(db *Database) {
+	fileBacked, ok := db.Algorithm.(algorithms.FileBacked)
+	if !ok {
+		return
+	}
+	if dm.dataDir == "" {
+		fileBacked.Remove()
+		return
+	}
+	fileBacked.Close()
+	dm.droppedMu.Lock()
+	dm.dropped = append(dm.dropped, fileBacked)
+	dm.droppedMu.Unlock()
+}
+
+// removeDropped removes the files of the databases deleted before a snapshot
+// that no longer lists them was saved.
+func (dm *DatabaseManager) removeDropped(dropped []algorithms.FileBacked) error {
+	dm.droppedMu.Lock()
+	defer dm.droppedMu.Unlock()
+	var err error
+	for _, fileBacked := range dropped {
+		if removeErr := fileBacked.Remove(); err == nil {
+			err = removeErr
+		}
+		dm.dropped = slices.DeleteFunc(dm.dropped, func(other algorithms.FileBacked) bool {
+			return other == fileBacked
+		})
+	}
+	return err
+}
+
+// freeze read-locks the manager and all of its databases, so that they can be
+// saved in a consistent state while queries go on. The returned function
+// releases the locks.
+func (dm *DatabaseManager) freeze() func() {
+	dm.mu.RLock()
+	names := dm.names()
+	for _, name := range names {
+		dm.databases[name].mu.RLock()
+	}
+
+	return func() {
+		for _, name := range names {
+			dm.databases[name].mu.RUnlock()
+		}
+		dm.mu.RUnlock()
+	}
+}
+
@@ -0,0 +1,580 @@
+package engine
+
+import (
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/codec"
+	"VectorLite/internal/vector"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// WALFile is the name of the write-ahead log inside a data directory.
+const WALFile = "vectorlite.wal"
+
+// SyncPolicy decides when the WAL is flushed to stable storage.
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs every write before it is acknowledged.
+	SyncAlways SyncPolicy = "always"
+	// SyncBatched fsyncs at most once per sync interval, a crash of the
+	// machine loses at most the writes of the last interval.
+	SyncBatched SyncPolicy = "batched"
+	// SyncNone leaves flushing to the operating system. Writes survive the
+	// process crashing, but not the machine.
+	SyncNone SyncPolicy = "none"
+)
+
+// DefaultSyncInterval is how often SyncBatched fsyncs the WAL.
+const DefaultSyncInterval = time.Second
+
+var (
+	ErrInvalidSyncPolicy = errors.New("sync policy must be one of always, batched, none")
+	ErrCorruptWAL        = errors.New("corrupt wal")
+)
+
+type WALOptions struct {
+	Sync SyncPolicy
+	// SyncInterval is only used by SyncBatched, DefaultSyncInterval if zero.
+	SyncInterval time.Duration
+}
+
+// operations recorded in the WAL
+const (
+	opCreateDatabase byte = iota + 1
+	opDeleteDatabase
+	// opPutEntry records both added and updated entries, as the entry they
+	// leave behind
+	opPutEntry
+	opDeleteEntry
+	// opPutSparseEntry is opPutEntry for an entry with a sparse vector, its
+	// indices follow the values. It is read back as opPutEntry.
+	opPutSparseEntry
+)
+
+type walRecord struct {
+	sequence  uint64
+	op        byte
+	database  string
+	algorithm string
+	settings  map[string]interface{}
+	entry     algorithms.Entry
+	id        int
+}
+
+/*
+WAL is an append-only log of every change made to the databases since the
+last snapshot. Each record is framed as
+
+	payload length uint32, CRC-32 (IEEE) of the payload uint32, payload
+
+and its payload starts with a sequence number that grows by one per record.
+A record that was only partly written, or whose checksum doesn't match, ends
+the log: it and anything after it are dropped on replay.
+*/
+type WAL struct {
+	mu       sync.Mutex
+	file     *os.File
+	writer   *bufio.Writer
+	options  WALOptions
+	sequence uint64
+	// size is the length of the file
+	size  int64
+	dirty bool
+	// err is the first write error, after which the log refuses new records:
+	// they would land behind a torn record and be dropped on replay
+	err  error
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenWAL opens the log at path, creating it if needed. Records are only
+// appended once it is attached to a DatabaseManager with AttachWAL.
+func OpenWAL(path string, options WALOptions) (*WAL, error) {
+	switch options.Sync {
+	case SyncAlways, SyncBatched, SyncNone:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSyncPolicy, options.Sync)
+	}
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = DefaultSyncInterval
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	wal := &WAL{
+		file:    file,
+		writer:  bufio.NewWriter(file),
+		options: options,
+		size:    info.Size(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if options.Sync == SyncBatched {
+		go wal.syncPeriodically()
+	} else {
+		close(wal.done)
+	}
+	return wal, nil
+}
+
+func (w *WAL) syncPeriodically() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.options.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty && w.err == nil {
+				w.err = w.file.Sync()
+				w.dirty = false
+			}
+			w.mu.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Sequence returns the sequence number of the last record in the log.
+func (w *WAL) Sequence() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sequence
+}
+
+// Size returns the length of the log in bytes.
+func (w *WAL) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+// append writes records in one go, so a batch costs a single fsync under
+// SyncAlways. The whole batch is encoded before any of it is written: a
+// record that can't be encoded leaves the log untouched.
+func (w *WAL) append(records ...walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	var batch bytes.Buffer
+	frame := codec.NewWriter(&batch)
+	for i, record := range records {
+		record.sequence = w.sequence + uint64(i) + 1
+		payload, err := encodeRecord(record)
+		if err != nil {
+			return err
+		}
+		frame.Uint32(uint32(len(payload)))
+		frame.Uint32(crc32.ChecksumIEEE(payload))
+		frame.Raw(payload)
+	}
+
+	if _, w.err = w.writer.Write(batch.Bytes()); w.err != nil {
+		return w.err
+	}
+	w.sequence += uint64(len(records))
+	w.size += int64(batch.Len())
+	if w.err = w.writer.Flush(); w.err != nil {
+		return w.err
+	}
+	switch w.options.Sync {
+	case SyncAlways:
+		w.err = w.file.Sync()
+	case SyncBatched:
+		w.dirty = true
+	}
+	return w.err
+}
+
+// Truncate empties the log once everything in it is safely in a snapshot.
+// Sequence numbers keep growing from where they were.
+func (w *WAL) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = w.writer.Flush(); w.err != nil {
+		return w.err
+	}
+	if w.err = w.file.Truncate(0); w.err != nil {
+		return w.err
+	}
+	w.size = 0
+	w.err = w.file.Sync()
+	w.dirty = false
+	return w.err
+}
+
+// Close flushes and fsyncs the log whatever the sync policy, then closes it.
+func (w *WAL) Close() error {
+	close(w.stop)
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.err
+	if err == nil {
+		err = w.writer.Flush()
+	}
+	if err == nil {
+		err = w.file.Sync()
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if w.err == nil {
+		w.err = os.ErrClosed
+	}
+	return err
+}
+
+/*
+replay calls apply on every record of the log, in order. A torn or corrupt
+record ends the log: a warning is logged and the file is cut right before
+it, so that new records don't end up behind it.
+*/
+func (w *WAL) replay(apply func(walRecord) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	reader := bufio.NewReader(io.NewSectionReader(w.file, 0, 1<<62))
+	var offset int64
+
+	for {
+		record, size, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			info, statErr := w.file.Stat()
+			if statErr != nil {
+				return statErr
+			}
+			log.Printf("WAL: skipping corrupt tail at offset %d (%d bytes): %v", offset, info.Size()-offset, err)
+			if err := w.file.Truncate(offset); err != nil {
+				return err
+			}
+			w.size = offset
+			if err := w.file.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+		offset += size
+
+		if err := apply(record); err != nil {
+			return fmt.Errorf("replaying record %d: %w", record.sequence, err)
+		}
+		w.sequence = record.sequence
+	}
+	return nil
+}
+
+// readRecord reads one framed record and returns it with its size on disk.
+// io.EOF means the log ended cleanly between two records.
+func readRecord(r io.Reader) (walRecord, int64, error) {
+	var header [8]byte
+	n, err := io.ReadFull(r, header[:])
+	if err == io.EOF {
+		return walRecord{}, 0, io.EOF
+	}
+	if err != nil {
+		return walRecord{}, 0, fmt.Errorf("%w: torn record header (%d bytes)", ErrCorruptWAL, n)
+	}
+
+	length := binary.LittleEndian.Uint32(header[:4])
+	checksum := binary.LittleEndian.Uint32(header[4:])
+	if length > codec.MaxLength {
+		return walRecord{}, 0, fmt.Errorf("%w: record of %d bytes", ErrCorruptWAL, length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return walRecord{}, 0, fmt.Errorf("%w: torn record", ErrCorruptWAL)
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return walRecord{}, 0, fmt.Errorf("%w: checksum mismatch", ErrCorruptWAL)
+	}
+
+	record, err := decodeRecord(payload)
+	if err != nil {
+		return walRecord{}, 0, fmt.Errorf("%w: %v", ErrCorruptWAL, err)
+	}
+	return record, int64(len(header)) + int64(length), nil
+}
+
+func encodeRecord(record walRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	out := codec.NewWriter(&buf)
+	out.Uint64(record.sequence)
+	if record.op == opPutEntry && record.entry.Vector.IsSparse() {
+		record.op = opPutSparseEntry
+	}
+	out.Byte(record.op)
+	out.String(record.database)
+
+	switch record.op {
+	case opCreateDatabase:
+		out.String(record.algorithm)
+		if err := writeSettings(out, record.settings); err != nil {
+			return nil, err
+		}
+	case opDeleteDatabase:
+	case opPutEntry, opPutSparseEntry:
+		out.Int64(int64(record.entry.Id))
+		out.String(record.entry.ExternalId)
+		out.Float64s(record.entry.Vector.Values)
+		if record.op == opPutSparseEntry {
+			out.Uint32s(record.entry.Vector.Indices)
+		}
+		out.StringMap(record.entry.Metadata)
+	case opDeleteEntry:
+		out.Int64(int64(record.id))
+	default:
+		return nil, fmt.Errorf("unknown wal operation %d", record.op)
+	}
+	return buf.Bytes(), out.Err()
+}
+
+func decodeRecord(payload []byte) (walRecord, error) {
+	in := codec.NewReader(bytes.NewReader(payload))
+	record := walRecord{
+		sequence: in.Uint64(),
+		op:       in.Byte(),
+		database: in.String(),
+	}
+
+	switch record.op {
+	case opCreateDatabase:
+		record.algorithm = in.String()
+		settings, err := readSettings(in)
+		if err != nil {
+			return record, err
+		}
+		record.settings = settings
+	case opDeleteDatabase:
+	case opPutEntry, opPutSparseEntry:
+		record.entry.Id = int(in.Int64())
+		record.entry.ExternalId = in.String()
+		record.entry.Vector = vector.Vector{Values: in.Float64s()}
+		if record.op == opPutSparseEntry {
+			record.op = opPutEntry
+			record.entry.Vector.Indices = in.Uint32s()
+		}
+		record.entry.Metadata = in.StringMap()
+	case opDeleteEntry:
+		record.id = int(in.Int64())
+	default:
+		if in.Err() == nil {
+			return record, fmt.Errorf("unknown operation %d", record.op)
+		}
+	}
+	return record, in.Err()
+}
+
+/*
+AttachWAL replays the records of wal that the databases don't hold yet, that
+is the ones written after the snapshot they were loaded from, then logs every
+later change to it. It returns the number of records replayed.
+*/
+func (dm *DatabaseManager) AttachWAL(wal *WAL) (int, error) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	replayed := 0
+	err := wal.replay(func(record walRecord) error {
+		if record.sequence <= dm.sequence {
+			return nil
+		}
+		if err := dm.apply(record); err != nil {
+			return err
+		}
+		dm.sequence = record.sequence
+		replayed++
+		return nil
+	})
+	if err != nil {
+		return replayed, err
+	}
+
+	wal.mu.Lock()
+	if wal.sequence < dm.sequence {
+		wal.sequence = dm.sequence
+	}
+	wal.mu.Unlock()
+
+	dm.wal = wal
+	for _, db := range dm.databases {
+		db.mu.Lock()
+		db.wal = wal
+		db.mu.Unlock()
+	}
+	return replayed, nil
+}
+
+// apply redoes the change described by record, without logging it again.
+// A record the database can't take, such as a vector of another dimension,
+// is an error. The caller holds dm.mu, replaying happens before the databases
+// are served.
+func (dm *DatabaseManager) apply(record walRecord) error {
+	if record.op == opCreateDatabase {
+		if _, exists := dm.databases[record.database]; exists {
+			return fmt.Errorf("%w: %s", ErrDatabaseExists, record.database)
+		}
+		algorithm, settings, err := NewAlgorithm(record.algorithm, record.settings)
+		if err != nil {
+			return err
+		}
+		if err := dm.createFile(algorithm); err != nil {
+			return err
+		}
+		db := NewDatabase(record.database, algorithm)
+		db.AlgorithmName = record.algorithm
+		db.Settings = settings
+		dm.databases[record.database] = db
+		return nil
+	}
+
+	db, exists := dm.databases[record.database]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrDatabaseNotFound, record.database)
+	}
+
+	switch record.op {
+	case opDeleteDatabase:
+		delete(dm.databases, record.database)
+		dm.drop(db)
+	case opPutEntry:
+		if err := db.checkVector(&record.entry.Vector, db.Dimension); err != nil {
+			return fmt.Errorf("entry %d of %s: %w", record.entry.Id, record.database, err)
+		}
+		if err := db.reserve(record.entry); err != nil {
+			return err
+		}
+		db.putEntry(record.entry)
+	case opDeleteEntry:
+		if err := db.reserve(); err != nil {
+			return err
+		}
+		if !db.removeEntry(record.id) {
+			return fmt.Errorf("%w: %d", ErrEntryNotFound, record.id)
+		}
+	}
+	return nil
+}
+
+// lastSequence is the sequence number of the last change made to the
+// databases. The caller holds dm.mu.
+func (dm *DatabaseManager) lastSequence() uint64 {
+	if dm.wal != nil {
+		return dm.wal.Sequence()
+	}
+	return dm.sequence
+}
+
+// Checkpoint saves a snapshot to dir and empties the WAL, whose records the
+// snapshot now holds. Writes wait until both are done, so none of them can
+// land in the WAL between the snapshot and the truncation.
+func (dm *DatabaseManager) Checkpoint(dir string) error {
+	unfreeze := dm.freeze()
+	defer unfreeze()
+
+	if err := dm.saveSnapshot(dir); err != nil {
+		return err
+	}
+	if dm.wal == nil {
+		return nil
+	}
+	return dm.wal.Truncate()
+}
+
+// CheckpointOptions decide when RunCheckpoints saves a snapshot.
+type CheckpointOptions struct {
+	// Interval is the longest time a change stays in the WAL only,
+	// DefaultCheckpointInterval if zero.
+	Interval time.Duration
+	// WALSize is the size in bytes past which the WAL is checkpointed
+	// without waiting for the interval, DefaultCheckpointWALSize if zero.
+	WALSize int64
+}
+
+const (
+	DefaultCheckpointInterval = 5 * time.Minute
+	DefaultCheckpointWALSize  = 64 << 20
+)
+
+// checkpointPoll is how often RunCheckpoints checks the size of the WAL.
+const checkpointPoll = time.Second
+
+/*
+RunCheckpoints calls Checkpoint with dir until stop is closed: once every
+interval, and as soon as the WAL grows past options.WALSize, so that a
+restart after a crash only replays the changes made since. Nothing is saved
+while the WAL is empty. A failed checkpoint is logged and tried again at the
+next poll, the WAL still holds every change.
+*/
+func (dm *DatabaseManager) RunCheckpoints(dir string, options CheckpointOptions, stop <-chan struct{}) {
+	if options.Interval <= 0 {
+		options.Interval = DefaultCheckpointInterval
+	}
+	if options.WALSize <= 0 {
+		options.WALSize = DefaultCheckpointWALSize
+	}
+	dm.mu.RLock()
+	wal := dm.wal
+	dm.mu.RUnlock()
+	if wal == nil {
+		return
+	}
+
+	ticker := time.NewTicker(min(checkpointPoll, options.Interval))
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			size := wal.Size()
+			if size == 0 {
+				last = now
+				continue
+			}
+			if size < options.WALSize && now.Sub(last) < options.Interval {
+				continue
+			}
+			if err := dm.Checkpoint(dir); err != nil {
+				log.Printf("Checkpoint failed: %v", err)
+				continue
+			}
+			last = now
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+
+	"VectorLite/internal/algorithms"
+	"VectorLite/internal/vector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALAppendRejectedBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), WALFile)
+	wal, err := OpenWAL(path, WALOptions{Sync: SyncNone})
+	require.NoError(t, err)
+
+	put := walRecord{op: opPutEntry, database: "docs", entry: algorithms.Entry{Vector: *vector.NewVector(1, 0), Id: 1}}
+	bad := walRecord{op: opCreateDatabase, database: "other", settings: map[string]interface{}{"M": []int{1}}}
+	assert.Error(t, wal.append(put, bad))
+	assert.Zero(t, wal.Sequence())
+
+	put.entry.Id = 2
+	require.NoError(t, wal.append(put))
+	require.NoError(t, wal.Close())
+
+	wal, err = OpenWAL(path, WALOptions{Sync: SyncNone})
+	require.NoError(t, err)
+	defer wal.Close()
+	ids := []int{}
+	require.NoError(t, wal.replay(func(record walRecord) error {
+		ids = append(ids, record.entry.Id)
+		return nil
+	}))
+	assert.Equal(t, []int{2}, ids, "nothing of the rejected batch reaches the file")
+}
@@ -0,0 +1,295 @@
+package engine_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"VectorLite/internal/engine"
+	"VectorLite/internal/vector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openDataDir loads dir the way serve does: snapshot first, then the WAL.
+func openDataDir(t *testing.T, dir string, sync engine.SyncPolicy) (*engine.DatabaseManager, *engine.WAL, int) {
+	dm := engine.NewDatabaseManager()
+	require.NoError(t, dm.LoadSnapshot(dir))
+
+	wal, err := engine.OpenWAL(filepath.Join(dir, engine.WALFile), engine.WALOptions{Sync: sync})
+	require.NoError(t, err)
+	replayed, err := dm.AttachWAL(wal)
+	require.NoError(t, err)
+	return dm, wal, replayed
+}
+
+// writeChanges exercises every kind of WAL record.
+func writeChanges(t *testing.T, dm *engine.DatabaseManager) {
+	db, err := dm.CreateDatabase("docs", "hnsw", map[string]interface{}{"M": 4.0, "seed": 3.0})
+	require.NoError(t, err)
+	_, err = db.PutEntries([]string{"a", "b", "c"}, []vector.Vector{
+		*vector.NewVector(1, 0), *vector.NewVector(0, 1), *vector.NewVector(1, 1),
+	}, []map[string]string{{"n": "1"}, {"n": "2"}, {"n": "3"}})
+	require.NoError(t, err)
+	_, err = db.AddEntry(*vector.NewVector(2, 1), map[string]string{})
+	require.NoError(t, err)
+	_, err = db.UpsertEntry(10, *vector.NewVector(1, 2), map[string]string{"n": "10"})
+	require.NoError(t, err)
+	_, _, err = db.PutEntry("b", *vector.NewVector(0, 2), map[string]string{"n": "2b"})
+	require.NoError(t, err)
+	lang := "en"
+	require.NoError(t, db.UpdateMetadata(1, map[string]*string{"lang": &lang, "n": nil}))
+	require.NoError(t, db.DeleteEntry(3))
+
+	_, err = dm.CreateDatabase("scratch", "bruteforce", nil)
+	require.NoError(t, err)
+	require.NoError(t, dm.DeleteDatabase("scratch"))
+}
+
+func assertSameDatabases(t *testing.T, expected *engine.DatabaseManager, actual *engine.DatabaseManager) {
+	assert.ElementsMatch(t, expected.ListDatabases(), actual.ListDatabases())
+	for _, name := range expected.ListDatabases() {
+		want, _ := expected.GetDatabase(name)
+		got, err := actual.GetDatabase(name)
+		require.NoError(t, err)
+		assert.Equal(t, want.Settings, got.Settings)
+		assert.Equal(t, want.NumberEntries, got.NumberEntries)
+		assert.Equal(t, want.Dimension, got.Dimension)
+		assert.ElementsMatch(t, want.ListEntries(), got.ListEntries())
+	}
+}
+
+func TestWALReplay(t *testing.T) {
+	for _, sync := range []engine.SyncPolicy{engine.SyncAlways, engine.SyncBatched, engine.SyncNone} {
+		t.Run(string(sync), func(t *testing.T) {
+			dir := t.TempDir()
+			dm, wal, replayed := openDataDir(t, dir, sync)
+			assert.Equal(t, 0, replayed)
+			writeChanges(t, dm)
+			require.NoError(t, wal.Close())
+
+			restored, wal, replayed := openDataDir(t, dir, sync)
+			defer wal.Close()
+			assert.Equal(t, 11, replayed)
+			assertSameDatabases(t, dm, restored)
+
+			db, _ := restored.GetDatabase("docs")
+			id, err := db.ResolveId("b")
+			require.NoError(t, err)
+			assert.Equal(t, 2, id)
+			_, err = db.ResolveId("c")
+			assert.ErrorIs(t, err, engine.ErrEntryNotFound)
+		})
+	}
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	dm, wal, _ := openDataDir(t, dir, engine.SyncAlways)
+	writeChanges(t, dm)
+	require.NoError(t, dm.Checkpoint(dir))
+
+	info, err := os.Stat(filepath.Join(dir, engine.WALFile))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "checkpoint empties the WAL")
+
+	db, _ := dm.GetDatabase("docs")
+	_, err = db.AddEntry(*vector.NewVector(3, 3), map[string]string{})
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	restored, wal, replayed := openDataDir(t, dir, engine.SyncAlways)
+	defer wal.Close()
+	assert.Equal(t, 1, replayed, "only changes made after the snapshot are replayed")
+	assertSameDatabases(t, dm, restored)
+}
+
+func TestRunCheckpoints(t *testing.T) {
+	tests := map[string]engine.CheckpointOptions{
+		"interval": {Interval: 20 * time.Millisecond},
+		"wal size": {Interval: time.Hour, WALSize: 1},
+	}
+	for name, options := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			dm, wal, _ := openDataDir(t, dir, engine.SyncNone)
+			defer wal.Close()
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				dm.RunCheckpoints(dir, options, stop)
+			}()
+
+			writeChanges(t, dm)
+			require.Eventually(t, func() bool { return wal.Size() == 0 }, 5*time.Second, 10*time.Millisecond, "the WAL is emptied")
+			close(stop)
+			<-done
+
+			restored := engine.NewDatabaseManager()
+			require.NoError(t, restored.LoadSnapshot(dir))
+			assertSameDatabases(t, dm, restored)
+		})
+	}
+}
+
+func TestWALSkipsRecordsInSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	dm, wal, _ := openDataDir(t, dir, engine.SyncAlways)
+	writeChanges(t, dm)
+	// a crash between saving the snapshot and truncating the WAL
+	require.NoError(t, dm.SaveSnapshot(dir))
+	require.NoError(t, wal.Close())
+
+	restored, wal, replayed := openDataDir(t, dir, engine.SyncAlways)
+	assert.Equal(t, 0, replayed)
+	assertSameDatabases(t, dm, restored)
+
+	// sequence numbers carry on past the ones already in the log
+	db, _ := restored.GetDatabase("docs")
+	_, err := db.AddEntry(*vector.NewVector(3, 3), map[string]string{})
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	again, wal, replayed := openDataDir(t, dir, engine.SyncAlways)
+	defer wal.Close()
+	assert.Equal(t, 1, replayed)
+	assertSameDatabases(t, restored, again)
+}
+
+func TestWALReplaySparse(t *testing.T) {
+	dir := t.TempDir()
+	dm, wal, _ := openDataDir(t, dir, engine.SyncAlways)
+	db, err := dm.CreateDatabase("terms", "sparse", map[string]interface{}{"metric": "cosine"})
+	require.NoError(t, err)
+	v1, _ := vector.NewSparseVector([]uint32{3, 70000}, []float64{0.5, 1.5})
+	v2, _ := vector.NewSparseVector([]uint32{}, []float64{})
+	_, err = db.PutEntries([]string{"a", "b"}, []vector.Vector{*v1, *v2}, []map[string]string{{"n": "1"}, {}})
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	restored, wal, replayed := openDataDir(t, dir, engine.SyncAlways)
+	defer wal.Close()
+	assert.Equal(t, 3, replayed)
+	assertSameDatabases(t, dm, restored)
+	terms, _ := restored.GetDatabase("terms")
+	entry, err := terms.GetEntry(2)
+	require.NoError(t, err)
+	assert.True(t, entry.Vector.IsSparse(), "an empty sparse vector stays sparse")
+}
+
+// walFrames splits the records of a WAL file.
+func walFrames(data []byte) [][]byte {
+	frames := [][]byte{}
+	for len(data) > 0 {
+		size := 8 + int(binary.LittleEndian.Uint32(data))
+		frames = append(frames, data[:size])
+		data = data[size:]
+	}
+	return frames
+}
+
+func TestWALReplayRejectsDimension(t *testing.T) {
+	// two logs of the same database, whose entries have other dimensions
+	logs := [][]byte{}
+	for _, values := range [][]float64{{1, 0}, {1, 0, 0}} {
+		dir := t.TempDir()
+		dm, wal, _ := openDataDir(t, dir, engine.SyncAlways)
+		db, err := dm.CreateDatabase("docs", "hnsw", nil)
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			_, err = db.AddEntry(*vector.NewVector(values...), nil)
+			require.NoError(t, err)
+		}
+		_, err = db.AddEntry(*vector.NewVector(1), nil)
+		assert.ErrorIs(t, err, engine.ErrVectorType)
+		assert.Equal(t, uint64(3), wal.Sequence(), "rejected entries aren't logged")
+		require.NoError(t, wal.Close())
+
+		data, err := os.ReadFile(filepath.Join(dir, engine.WALFile))
+		require.NoError(t, err)
+		logs = append(logs, data)
+	}
+
+	first, second := walFrames(logs[0]), walFrames(logs[1])
+	require.Len(t, first, 3)
+	require.Len(t, second, 3)
+	dir := t.TempDir()
+	mixed := append(append(append([]byte{}, first[0]...), first[1]...), second[2]...)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, engine.WALFile), mixed, 0o644))
+
+	wal, err := engine.OpenWAL(filepath.Join(dir, engine.WALFile), engine.WALOptions{Sync: engine.SyncAlways})
+	require.NoError(t, err)
+	defer wal.Close()
+	replayed, err := engine.NewDatabaseManager().AttachWAL(wal)
+	assert.ErrorIs(t, err, engine.ErrVectorType)
+	assert.Equal(t, 2, replayed)
+}
+
+func TestWALCorruptTail(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, engine.WALFile)
+
+	dm, wal, _ := openDataDir(t, dir, engine.SyncAlways)
+	db, err := dm.CreateDatabase("docs", "bruteforce", nil)
+	require.NoError(t, err)
+	_, err = db.AddEntry(*vector.NewVector(1, 0), map[string]string{})
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	valid, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	tests := map[string][]byte{
+		"torn header":  append(append([]byte{}, valid...), 0x10, 0x00),
+		"torn payload": append(append([]byte{}, valid...), 0x20, 0, 0, 0, 1, 2, 3, 4, 5),
+		"bad checksum": func() []byte {
+			// the same records again, with a payload byte flipped
+			data := append(append([]byte{}, valid...), valid...)
+			data[len(valid)+10] ^= 0xff
+			return data
+		}(),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(path, data, 0o644))
+
+			restored, wal, replayed := openDataDir(t, dir, engine.SyncAlways)
+			assert.Equal(t, 2, replayed, "records before the corrupt tail are kept")
+			assertSameDatabases(t, dm, restored)
+
+			truncated, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, valid, truncated, "the corrupt tail is cut off")
+
+			// new records are readable after the cut
+			db, _ := restored.GetDatabase("docs")
+			_, err = db.AddEntry(*vector.NewVector(0, 1), map[string]string{})
+			require.NoError(t, err)
+			require.NoError(t, wal.Close())
+
+			_, wal, replayed = openDataDir(t, dir, engine.SyncAlways)
+			require.NoError(t, wal.Close())
+			assert.Equal(t, 3, replayed)
+		})
+	}
+}
+
+func TestOpenWALInvalidSync(t *testing.T) {
+	_, err := engine.OpenWAL(filepath.Join(t.TempDir(), engine.WALFile), engine.WALOptions{Sync: "sometimes"})
+	assert.ErrorIs(t, err, engine.ErrInvalidSyncPolicy)
+}
+
+func TestWALClosed(t *testing.T) {
+	dir := t.TempDir()
+	dm, wal, _ := openDataDir(t, dir, engine.SyncNone)
+	require.NoError(t, wal.Close())
+
+	_, err := dm.CreateDatabase("docs", "bruteforce", nil)
+	assert.ErrorIs(t, err, os.ErrClosed)
+	assert.Empty(t, dm.ListDatabases(), "changes that can't be logged aren't applied")
+}
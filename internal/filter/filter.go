@@ -0,0 +1,274 @@
+/*
+Package filter implements the metadata filter expressions accepted by /query.
+
+An expression is a JSON object that is either a combination of expressions:
+
+	{"and": [<expr>, ...]}
+	{"or": [<expr>, ...]}
+	{"not": <expr>}
+
+or a condition on a single metadata field:
+
+	{"field": "lang", "eq": "en"}
+	{"field": "tag", "in": ["news", "blog"]}
+	{"field": "year", "gte": 2000, "lt": 2010}
+
+Metadata values are strings. eq and in compare strings, unless the expected
+value is a number: then the metadata value is parsed as a number first. The
+range operators gt, gte, lt and lte only match values that parse as numbers.
+A condition holding several operators matches when all of them do, and a
+missing field never matches a condition.
+*/
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+type Filter interface {
+	Match(metadata map[string]string) bool
+}
+
+// Parse builds a Filter from a decoded JSON expression.
+func Parse(raw map[string]interface{}) (Filter, error) {
+	if len(raw) == 0 {
+		return nil, invalid("empty expression")
+	}
+
+	if _, isCondition := raw["field"]; isCondition {
+		return parseCondition(raw)
+	}
+
+	if len(raw) != 1 {
+		return nil, invalid("and, or and not must be the only key of their expression")
+	}
+
+	for key, value := range raw {
+		switch key {
+		case "and", "or":
+			filters, err := parseList(key, value)
+			if err != nil {
+				return nil, err
+			}
+			if key == "and" {
+				return andFilter(filters), nil
+			}
+			return orFilter(filters), nil
+		case "not":
+			expr, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, invalid("not expects an expression")
+			}
+			inner, err := Parse(expr)
+			if err != nil {
+				return nil, err
+			}
+			return notFilter{inner}, nil
+		default:
+			return nil, invalid(fmt.Sprintf("unknown operator %q", key))
+		}
+	}
+	return nil, nil
+}
+
+func invalid(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidFilter, reason)
+}
+
+func parseList(key string, value interface{}) ([]Filter, error) {
+	items, ok := value.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, invalid(key + " expects a non-empty list of expressions")
+	}
+
+	filters := make([]Filter, len(items))
+	for i, item := range items {
+		expr, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, invalid(key + " expects a non-empty list of expressions")
+		}
+		filter, err := Parse(expr)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = filter
+	}
+	return filters, nil
+}
+
+func parseCondition(raw map[string]interface{}) (Filter, error) {
+	field, ok := raw["field"].(string)
+	if !ok || field == "" {
+		return nil, invalid("field must be a non-empty string")
+	}
+
+	// both set the accepted values, one would silently win
+	_, hasEq := raw["eq"]
+	if _, hasIn := raw["in"]; hasEq && hasIn {
+		return nil, invalid(fmt.Sprintf("eq and in on field %q", field))
+	}
+
+	condition := fieldFilter{field: field}
+	for key, value := range raw {
+		switch key {
+		case "field":
+		case "eq":
+			expected, err := parseOperand(key, value)
+			if err != nil {
+				return nil, err
+			}
+			condition.in = []operand{expected}
+			condition.hasIn = true
+		case "in":
+			items, ok := value.([]interface{})
+			if !ok {
+				return nil, invalid("in expects a list of values")
+			}
+			condition.in = make([]operand, len(items))
+			for i, item := range items {
+				expected, err := parseOperand(key, item)
+				if err != nil {
+					return nil, err
+				}
+				condition.in[i] = expected
+			}
+			condition.hasIn = true
+		case "gt", "gte", "lt", "lte":
+			bound, err := parseNumber(key, value)
+			if err != nil {
+				return nil, err
+			}
+			condition.ranges = append(condition.ranges, rangeBound{op: key, bound: bound})
+		default:
+			return nil, invalid(fmt.Sprintf("unknown operator %q on field %q", key, field))
+		}
+	}
+
+	if !condition.hasIn && len(condition.ranges) == 0 {
+		return nil, invalid(fmt.Sprintf("no operator on field %q", field))
+	}
+	return condition, nil
+}
+
+// operand is a string or number a metadata value is compared to
+type operand struct {
+	text     string
+	number   float64
+	isNumber bool
+}
+
+func parseOperand(key string, raw interface{}) (operand, error) {
+	switch v := raw.(type) {
+	case string:
+		return operand{text: v}, nil
+	case float64:
+		return operand{number: v, isNumber: true}, nil
+	}
+	return operand{}, invalid(key + " expects strings or numbers")
+}
+
+func parseNumber(key string, raw interface{}) (float64, error) {
+	switch v := raw.(type) {
+	case float64:
+		return v, nil
+	case string:
+		if number, err := strconv.ParseFloat(v, 64); err == nil {
+			return number, nil
+		}
+	}
+	return 0, invalid(key + " expects a number")
+}
+
+type andFilter []Filter
+
+func (f andFilter) Match(metadata map[string]string) bool {
+	for _, filter := range f {
+		if !filter.Match(metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+type orFilter []Filter
+
+func (f orFilter) Match(metadata map[string]string) bool {
+	for _, filter := range f {
+		if filter.Match(metadata) {
+			return true
+		}
+	}
+	return false
+}
+
+type notFilter struct {
+	inner Filter
+}
+
+func (f notFilter) Match(metadata map[string]string) bool {
+	return !f.inner.Match(metadata)
+}
+
+type rangeBound struct {
+	op    string
+	bound float64
+}
+
+type fieldFilter struct {
+	field  string
+	in     []operand
+	hasIn  bool
+	ranges []rangeBound
+}
+
+func (f fieldFilter) Match(metadata map[string]string) bool {
+	actual, exists := metadata[f.field]
+	if !exists {
+		return false
+	}
+
+	number, numberErr := strconv.ParseFloat(actual, 64)
+	isNumber := numberErr == nil
+
+	if f.hasIn {
+		found := false
+		for _, expected := range f.in {
+			if expected.isNumber {
+				found = isNumber && number == expected.number
+			} else {
+				found = actual == expected.text
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.ranges) > 0 && !isNumber {
+		return false
+	}
+	for _, r := range f.ranges {
+		var ok bool
+		switch r.op {
+		case "gt":
+			ok = number > r.bound
+		case "gte":
+			ok = number >= r.bound
+		case "lt":
+			ok = number < r.bound
+		case "lte":
+			ok = number <= r.bound
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package filter_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"VectorLite/internal/filter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, expression string) (filter.Filter, error) {
+	var raw map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(expression), &raw))
+	return filter.Parse(raw)
+}
+
+func TestMatch(t *testing.T) {
+	metadata := map[string]string{"lang": "en", "tag": "news", "year": "2005", "score": "0.75", "title": "Go"}
+
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{`{"field": "lang", "eq": "en"}`, true},
+		{`{"field": "lang", "eq": "fr"}`, false},
+		{`{"field": "missing", "eq": "en"}`, false},
+		{`{"field": "year", "eq": 2005}`, true},
+		{`{"field": "year", "eq": "2005.0"}`, false},
+		{`{"field": "tag", "in": ["blog", "news"]}`, true},
+		{`{"field": "tag", "in": ["blog", "wiki"]}`, false},
+		{`{"field": "year", "in": [2004, 2005]}`, true},
+		{`{"field": "year", "gte": 2000, "lt": 2010}`, true},
+		{`{"field": "year", "gt": 2005}`, false},
+		{`{"field": "year", "lte": "2005"}`, true},
+		{`{"field": "score", "gt": 0.5}`, true},
+		{`{"field": "title", "gt": 0}`, false},
+		{`{"not": {"field": "lang", "eq": "fr"}}`, true},
+		{`{"not": {"field": "missing", "eq": "x"}}`, true},
+		{`{"and": [{"field": "lang", "eq": "en"}, {"field": "year", "lt": 2000}]}`, false},
+		{`{"or": [{"field": "lang", "eq": "fr"}, {"field": "year", "lt": 2010}]}`, true},
+		{`{"and": [{"field": "lang", "eq": "en"}, {"or": [{"field": "tag", "eq": "blog"}, {"not": {"field": "score", "lt": 0.5}}]}]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			f, err := parse(t, tt.expression)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, f.Match(metadata))
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	expressions := []string{
+		`{}`,
+		`{"field": "lang"}`,
+		`{"field": 3, "eq": "en"}`,
+		`{"field": "lang", "like": "e%"}`,
+		`{"field": "lang", "eq": true}`,
+		`{"field": "year", "gt": "soon"}`,
+		`{"field": "tag", "in": "news"}`,
+		`{"field": "lang", "eq": "en", "in": ["en", "fr"]}`,
+		`{"and": []}`,
+		`{"or": [1, 2]}`,
+		`{"not": [{"field": "lang", "eq": "en"}]}`,
+		`{"xor": [{"field": "lang", "eq": "en"}]}`,
+		`{"and": [{"field": "lang", "eq": "en"}], "or": [{"field": "lang", "eq": "en"}]}`,
+	}
+
+	for _, expression := range expressions {
+		t.Run(expression, func(t *testing.T) {
+			_, err := parse(t, expression)
+			assert.ErrorIs(t, err, filter.ErrInvalidFilter)
+		})
+	}
+}
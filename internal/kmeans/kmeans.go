@@ -0,0 +1,222 @@
+/*
+Package kmeans clusters points with Lloyd's algorithm, seeded with k-means++.
+The quantizing algorithms train their centroids with it.
+
+Points are plain slices of values. With euclidean clustering a point belongs
+to the centroid it is closest to. Spherical clustering groups points by
+direction: points must be unit vectors (see Normalize), centroids are kept
+normalized, and a point belongs to the centroid with the largest dot
+product, which is also its closest by cosine distance.
+*/
+package kmeans
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+type Config struct {
+	// K is the number of centroids, at most the number of points.
+	K int
+	// Iterations is the most rounds run, training stops earlier once no
+	// point changes centroid.
+	Iterations int
+	Spherical  bool
+}
+
+/*
+Train places config.K centroids among points. Every round assigns the points
+to their nearest centroid, then moves each centroid to the mean of its
+points. A centroid left without points is moved to a random point, so none is
+wasted. Training is reproducible from the state of rng.
+*/
+func Train(points [][]float64, config Config, rng *rand.Rand) [][]float64 {
+	k := min(config.K, len(points))
+	if k < 1 {
+		return nil
+	}
+	centroids := seed(points, k, rng)
+	dims := len(points[0])
+
+	var assignments []int
+	for iteration := 0; iteration < config.Iterations; iteration++ {
+		previous := assignments
+		assignments = Assign(points, centroids, config.Spherical)
+		if previous != nil && slices.Equal(previous, assignments) {
+			break
+		}
+
+		sums := make([][]float64, k)
+		counts := make([]int, k)
+		for i := range sums {
+			sums[i] = make([]float64, dims)
+		}
+		for i, point := range points {
+			centroid := assignments[i]
+			counts[centroid]++
+			for d, value := range point {
+				sums[centroid][d] += value
+			}
+		}
+
+		for i := range centroids {
+			if counts[i] == 0 {
+				centroids[i] = slices.Clone(points[rng.Intn(len(points))])
+				continue
+			}
+			for d := range sums[i] {
+				sums[i][d] /= float64(counts[i])
+			}
+			if config.Spherical {
+				sums[i] = Normalize(sums[i])
+			}
+			centroids[i] = sums[i]
+		}
+	}
+	return centroids
+}
+
+/*
+seed draws the k initial centroids among points with k-means++: every new
+seed is drawn with a probability proportional to the squared distance of the
+point to the closest seed drawn so far.
+*/
+func seed(points [][]float64, k int, rng *rand.Rand) [][]float64 {
+	centroids := make([][]float64, 0, k)
+	centroids = append(centroids, slices.Clone(points[rng.Intn(len(points))]))
+
+	closest := make([]float64, len(points))
+	for i := range closest {
+		closest[i] = math.Inf(1)
+	}
+	for len(centroids) < k {
+		last := centroids[len(centroids)-1]
+		total := 0.0
+		for i, point := range points {
+			closest[i] = min(closest[i], SquaredDistance(point, last))
+			total += closest[i]
+		}
+
+		// every point is a seed already, any of them will do
+		if total == 0 {
+			centroids = append(centroids, slices.Clone(points[rng.Intn(len(points))]))
+			continue
+		}
+
+		target := rng.Float64() * total
+		picked := len(points) - 1
+		for i, weight := range closest {
+			target -= weight
+			if target < 0 {
+				picked = i
+				break
+			}
+		}
+		centroids = append(centroids, slices.Clone(points[picked]))
+	}
+	return centroids
+}
+
+// Distance scores a point against a centroid, lower is closer: the squared
+// euclidean distance, or the opposite of the dot product when spherical.
+func Distance(point []float64, centroid []float64, spherical bool) float64 {
+	if !spherical {
+		return SquaredDistance(point, centroid)
+	}
+	score := 0.0
+	for d, value := range point {
+		score -= value * centroid[d]
+	}
+	return score
+}
+
+func SquaredDistance(v1 []float64, v2 []float64) float64 {
+	distance := 0.0
+	for d, value := range v1 {
+		diff := value - v2[d]
+		distance += diff * diff
+	}
+	return distance
+}
+
+// Nearest returns the position of the centroid closest to point.
+func Nearest(point []float64, centroids [][]float64, spherical bool) int {
+	best := 0
+	bestScore := math.Inf(1)
+	for i, centroid := range centroids {
+		if score := Distance(point, centroid, spherical); score < bestScore {
+			best = i
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// Assign returns the position of the nearest centroid of every point,
+// computed by one goroutine per CPU.
+func Assign(points [][]float64, centroids [][]float64, spherical bool) []int {
+	assignments := make([]int, len(points))
+	Parallel(len(points), func(i int) {
+		assignments[i] = Nearest(points[i], centroids, spherical)
+	})
+	return assignments
+}
+
+// Parallel calls do for every index below n, split in contiguous chunks
+// over one goroutine per CPU.
+func Parallel(n int, do func(i int)) {
+	workers := min(runtime.GOMAXPROCS(0), n)
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			do(i)
+		}
+		return
+	}
+	chunk := (n + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += chunk {
+		end := min(start+chunk, n)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				do(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Sample picks up to size points at random.
+func Sample(points [][]float64, size int, rng *rand.Rand) [][]float64 {
+	size = min(size, len(points))
+	picked := slices.Clone(points)
+	// partial Fisher-Yates shuffle, the first size points are the sample
+	for i := 0; i < size; i++ {
+		j := i + rng.Intn(len(picked)-i)
+		picked[i], picked[j] = picked[j], picked[i]
+	}
+	return picked[:size]
+}
+
+// Normalize returns a copy of values scaled to a length of 1. Zero vectors,
+// which have no direction, stay zero.
+func Normalize(values []float64) []float64 {
+	norm := 0.0
+	for _, value := range values {
+		norm += value * value
+	}
+	normalized := make([]float64, len(values))
+	if norm == 0 {
+		return normalized
+	}
+	norm = math.Sqrt(norm)
+	for d, value := range values {
+		normalized[d] = value / norm
+	}
+	return normalized
+}
@@ -0,0 +1,123 @@
+package kmeans_test
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"testing"
+
+	"VectorLite/internal/kmeans"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrain(t *testing.T) {
+	centres := [][]float64{{10, 10}, {-10, 10}, {10, -10}, {-10, -10}}
+	rng := rand.New(rand.NewSource(1))
+	points := [][]float64{}
+	for i := 0; i < 400; i++ {
+		centre := centres[i%len(centres)]
+		points = append(points, []float64{centre[0] + rng.NormFloat64(), centre[1] + rng.NormFloat64()})
+	}
+
+	config := kmeans.Config{K: 4, Iterations: 20}
+	centroids := kmeans.Train(points, config, rand.New(rand.NewSource(1)))
+	require.Len(t, centroids, 4)
+	for _, centre := range centres {
+		closest := centroids[kmeans.Nearest(centre, centroids, false)]
+		assert.Less(t, kmeans.SquaredDistance(closest, centre), 0.25, "no centroid near %v", centre)
+	}
+
+	again := kmeans.Train(points, config, rand.New(rand.NewSource(1)))
+	assert.Equal(t, centroids, again, "same seed, same centroids")
+}
+
+func TestTrain_Spherical(t *testing.T) {
+	// two directions, at different scales
+	rng := rand.New(rand.NewSource(2))
+	points := [][]float64{}
+	for i := 0; i < 200; i++ {
+		scale := 1 + rng.Float64()*100
+		if i%2 == 0 {
+			points = append(points, kmeans.Normalize([]float64{scale, scale * rng.Float64() * 0.1}))
+		} else {
+			points = append(points, kmeans.Normalize([]float64{scale * rng.Float64() * 0.1, scale}))
+		}
+	}
+
+	centroids := kmeans.Train(points, kmeans.Config{K: 2, Iterations: 20, Spherical: true}, rng)
+	assignments := kmeans.Assign(points, centroids, true)
+	for i := range points {
+		assert.Equal(t, assignments[i%2], assignments[i], "point %d", i)
+	}
+	assert.NotEqual(t, assignments[0], assignments[1])
+	for _, centroid := range centroids {
+		assert.InDelta(t, 1, kmeans.SquaredDistance(centroid, make([]float64, 2)), 1e-9, "centroids are normalized")
+	}
+}
+
+func TestTrain_FewPoints(t *testing.T) {
+	duplicates := [][]float64{{1, 2}, {1, 2}, {1, 2}, {1, 2}, {1, 2}}
+	centroids := kmeans.Train(duplicates, kmeans.Config{K: 3, Iterations: 5}, rand.New(rand.NewSource(1)))
+	require.Len(t, centroids, 3)
+	for _, centroid := range centroids {
+		assert.Equal(t, []float64{1, 2}, centroid)
+	}
+
+	centroids = kmeans.Train(duplicates[:2], kmeans.Config{K: 3, Iterations: 5}, rand.New(rand.NewSource(1)))
+	assert.Len(t, centroids, 2, "at most one centroid per point")
+	assert.Nil(t, kmeans.Train(nil, kmeans.Config{K: 3, Iterations: 5}, rand.New(rand.NewSource(1))))
+}
+
+func TestNearest(t *testing.T) {
+	centroids := [][]float64{{1, 0}, {0, 1}, {-1, 0}}
+	assert.Equal(t, 0, kmeans.Nearest([]float64{2, 0.5}, centroids, false))
+	assert.Equal(t, 2, kmeans.Nearest([]float64{-0.1, 0}, centroids, false))
+	assert.Equal(t, 1, kmeans.Nearest(kmeans.Normalize([]float64{0.1, 5}), centroids, true))
+
+	// far along the first centroid, but pointing at the second
+	assert.Equal(t, 0, kmeans.Nearest([]float64{3, 3.1}, [][]float64{{3, 3}, {0, 1}}, false))
+	assert.Equal(t, 0, kmeans.Nearest(kmeans.Normalize([]float64{3, 3.1}), [][]float64{kmeans.Normalize([]float64{1, 1}), {0, 1}}, true))
+}
+
+func TestNormalize(t *testing.T) {
+	assert.InDeltaSlice(t, []float64{0.6, 0.8}, kmeans.Normalize([]float64{3, 4}), 1e-12)
+	assert.Equal(t, []float64{0, 0}, kmeans.Normalize([]float64{0, 0}))
+
+	values := []float64{3, 4}
+	kmeans.Normalize(values)
+	assert.Equal(t, []float64{3, 4}, values, "Normalize copies")
+}
+
+func TestSample(t *testing.T) {
+	points := make([][]float64, 50)
+	for i := range points {
+		points[i] = []float64{float64(i)}
+	}
+
+	sample := kmeans.Sample(points, 10, rand.New(rand.NewSource(1)))
+	assert.Len(t, sample, 10)
+	seen := map[float64]bool{}
+	for _, point := range sample {
+		assert.False(t, seen[point[0]], "sampled twice")
+		seen[point[0]] = true
+	}
+	assert.Equal(t, float64(0), points[0][0], "points are left in place")
+
+	assert.Len(t, kmeans.Sample(points[:4], 10, rand.New(rand.NewSource(1))), 4)
+}
+
+func TestParallel(t *testing.T) {
+	for _, n := range []int{0, 1, 7, 1000} {
+		var calls atomic.Int64
+		seen := make([]atomic.Bool, n)
+		kmeans.Parallel(n, func(i int) {
+			calls.Add(1)
+			seen[i].Store(true)
+		})
+		assert.Equal(t, int64(n), calls.Load())
+		for i := range seen {
+			assert.True(t, seen[i].Load(), "index %d", i)
+		}
+	}
+}
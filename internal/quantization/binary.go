@@ -0,0 +1,80 @@
+package quantization
+
+import "math/bits"
+
+// Binary is the binary quantizer: every dimension is reduced to its sign, one
+// bit set for positive values, packed 64 dimensions to a uint64 word. A vector
+// of float64 values shrinks 64 times and codes are compared by the number of
+// bits they differ in, the Hamming distance, with one popcount per word.
+//
+// Signs only keep the direction of a vector, roughly: the fraction of bits two
+// codes differ in estimates the angle between the vectors. Binary codes are a
+// first pass, the originals are always kept to score the candidates again.
+// The quantizer needs no training.
+type Binary struct{}
+
+// Binarize packs the signs of values, bit d%64 of word d/64 being set when
+// values[d] is positive.
+func Binarize(values []float64) []uint64 {
+	words := make([]uint64, (len(values)+63)/64)
+	for d, value := range values {
+		if value > 0 {
+			words[d/64] |= 1 << (d % 64)
+		}
+	}
+	return words
+}
+
+// Hamming counts the bits set in one of w1 and w2 but not in the other, words
+// missing from the shorter one count as 0.
+func Hamming(w1 []uint64, w2 []uint64) int {
+	if len(w1) < len(w2) {
+		w1, w2 = w2, w1
+	}
+	distance := 0
+	for i, word := range w1 {
+		if i < len(w2) {
+			word ^= w2[i]
+		}
+		distance += bits.OnesCount64(word)
+	}
+	return distance
+}
+
+// Encode packs the signs of values.
+func (Binary) Encode(values []float64) Code {
+	return Code{Bits: Binarize(values), dims: len(values)}
+}
+
+// Decode returns 1 for the positive dimensions of code and -1 for the others,
+// the vector with the signs of the encoded one.
+func (Binary) Decode(code Code) []float64 {
+	values := make([]float64, code.dims)
+	for d := range values {
+		values[d] = -1
+		if code.Bits[d/64]&(1<<(d%64)) != 0 {
+			values[d] = 1
+		}
+	}
+	return values
+}
+
+// Distance returns the Hamming distance between two codes, whatever the
+// metric: it is the vector.Distance_score of their vectors with the hamming
+// metric, and ranks them by angle with the others.
+func (Binary) Distance(c1 Code, c2 Code, metric string) float64 {
+	return float64(Hamming(c1.Bits, c2.Bits))
+}
+
+// Query packs the signs of values, the query is scored as a code.
+func (b Binary) Query(values []float64) Query {
+	return binaryQuery{bits: Binarize(values)}
+}
+
+type binaryQuery struct {
+	bits []uint64
+}
+
+func (q binaryQuery) Distance(code Code, metric string) float64 {
+	return float64(Hamming(q.bits, code.Bits))
+}
@@ -0,0 +1,57 @@
+package quantization_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"VectorLite/internal/quantization"
+	"VectorLite/internal/vector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinarize(t *testing.T) {
+	assert.Empty(t, quantization.Binarize(nil))
+	assert.Equal(t, []uint64{0b1001}, quantization.Binarize([]float64{1, 0, -2, 0.5}))
+
+	values := make([]float64, 130)
+	values[0], values[64], values[129] = 1, 1, 1
+	assert.Equal(t, []uint64{1, 1, 2}, quantization.Binarize(values), "64 dimensions per word")
+}
+
+func TestHamming(t *testing.T) {
+	assert.Equal(t, 0, quantization.Hamming(nil, nil))
+	assert.Equal(t, 2, quantization.Hamming([]uint64{0b101}, []uint64{0b110}))
+	assert.Equal(t, 2, quantization.Hamming([]uint64{0b1}, []uint64{0b1, 0b11}), "missing words are 0")
+	assert.Equal(t, 64, quantization.Hamming([]uint64{^uint64(0)}, []uint64{0}))
+}
+
+func TestBinary_Distance(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	points := randomPoints(rng, 20, 100)
+	query := randomPoints(rng, 1, 100)[0]
+	binary := quantization.Binary{}
+	prepared := binary.Query(query)
+
+	for i, point := range points[:10] {
+		code := binary.Encode(point)
+		require.Len(t, code.Bits, 2)
+		other := binary.Encode(points[i+1])
+
+		expected := vector.NewVector(query...).Distance_score(vector.NewVector(point...), "hamming")
+		assert.Equal(t, expected, prepared.Distance(code, "cosine"))
+		expected = vector.NewVector(point...).Distance_score(vector.NewVector(points[i+1]...), "hamming")
+		assert.Equal(t, expected, binary.Distance(code, other, "euclidean"), "codes are compared by their bits whatever the metric")
+
+		decoded := vector.Vector{Values: binary.Decode(code)}
+		require.Len(t, decoded.Values, 100)
+		assert.Zero(t, decoded.Distance_score(vector.NewVector(point...), "hamming"), "decoded vectors keep the signs")
+	}
+}
+
+func TestCode_IsZero(t *testing.T) {
+	assert.True(t, quantization.Code{}.IsZero())
+	assert.False(t, quantization.Binary{}.Encode(nil).IsZero(), "an empty vector is still encoded")
+	assert.False(t, quantization.TrainScalar([][]float64{{1}}).Encode([]float64{1}).IsZero())
+}
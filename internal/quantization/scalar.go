@@ -0,0 +1,328 @@
+/*
+Package quantization compresses the vectors stored by the algorithms.
+
+Scalar quantization, the int8 storage mode, maps every dimension to a byte:
+one of 256 steps spread evenly between the smallest and the largest value the
+dimension takes among the training vectors. Values outside that range are
+clamped to it. A vector of float64 values shrinks 8 times, and distances are
+computed from the bytes directly, without decoding them.
+
+Binary quantization, the binary storage mode, keeps only the sign of every
+dimension, see Binary.
+*/
+package quantization
+
+import (
+	"VectorLite/internal/codec"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Storage modes.
+const (
+	None = "none"
+	Int8 = "int8"
+	Bits = "binary"
+)
+
+const (
+	// DefaultTrainSize is the number of entries stored whole before the
+	// quantizer is trained on them.
+	DefaultTrainSize = 1000
+	// DefaultRerankFactor re-scores four candidates per result.
+	DefaultRerankFactor = 4
+)
+
+// steps is the highest byte a value is quantized to.
+const steps = math.MaxUint8
+
+var ErrCorruptQuantizer = errors.New("corrupt quantizer")
+
+// Config holds the storage settings shared by the algorithms that can
+// quantize their vectors.
+type Config struct {
+	// Mode is None, Int8 or Bits.
+	Mode string
+	// TrainSize is the number of entries that triggers training.
+	TrainSize int
+	// KeepOriginals keeps the full vectors next to the quantized ones, it is
+	// implied by Bits.
+	KeepOriginals bool
+	// RerankFactor is only used with KeepOriginals: queries for k entries
+	// re-score the RerankFactor × k closest quantized vectors with the
+	// original ones. Zero disables re-scoring.
+	RerankFactor int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Mode:         None,
+		TrainSize:    DefaultTrainSize,
+		RerankFactor: DefaultRerankFactor,
+	}
+}
+
+// Enabled reports whether vectors are quantized.
+func (c Config) Enabled() bool {
+	return c.Mode == Int8 || c.Mode == Bits
+}
+
+// Trains reports whether the quantizer is trained on the first TrainSize
+// vectors. The others quantize vectors from the start.
+func (c Config) Trains() bool {
+	return c.Mode == Int8
+}
+
+// Quantizer returns the quantizer of the modes that aren't trained, nil
+// otherwise.
+func (c Config) Quantizer() Quantizer {
+	if c.Mode == Bits {
+		return Binary{}
+	}
+	return nil
+}
+
+// Rerank reports how many candidates per result queries re-score with the
+// original vectors, 0 if they don't.
+func (c Config) Rerank() int {
+	return c.Oversample(0)
+}
+
+// Oversample is Rerank for a query asking to re-score oversample candidates
+// per result, the RerankFactor being used when it is 0.
+func (c Config) Oversample(oversample int) int {
+	if !c.KeepOriginals && c.Mode != Bits {
+		return 0
+	}
+	if oversample > 0 {
+		return oversample
+	}
+	return c.RerankFactor
+}
+
+// Quantizer encodes vectors and scores the codes. Codes are only meaningful
+// to the quantizer that encoded them.
+type Quantizer interface {
+	Encode(values []float64) Code
+	// Decode returns the values code stands for.
+	Decode(code Code) []float64
+	// Distance scores two codes, lower is closer.
+	Distance(c1 Code, c2 Code, metric string) float64
+	// Query prepares values to be scored against codes.
+	Query(values []float64) Query
+}
+
+// Query is a query vector prepared to be scored against the codes of a
+// quantizer.
+type Query interface {
+	Distance(code Code, metric string) float64
+}
+
+// Scalar is a trained scalar quantizer.
+type Scalar struct {
+	min []float64
+	// step is the value of one step in every dimension, 0 for dimensions
+	// that only took one value
+	step []float64
+}
+
+// Code is a quantized vector, Bytes for Scalar and Bits for Binary.
+type Code struct {
+	Bytes []uint8
+	Bits  []uint64
+	// norm is the squared length of the vector a scalar code decodes to
+	norm float64
+	// dims is the number of dimensions of a binary code
+	dims int
+}
+
+// IsZero reports whether code is the zero Code, which stands for no vector
+// rather than an encoded one.
+func (c Code) IsZero() bool {
+	return c.Bytes == nil && c.Bits == nil
+}
+
+// TrainScalar fits a quantizer to the range of every dimension of points.
+// Points are expected to have the dimensions of the first one, missing
+// values count as 0.
+func TrainScalar(points [][]float64) *Scalar {
+	dims := 0
+	if len(points) > 0 {
+		dims = len(points[0])
+	}
+	low := make([]float64, dims)
+	high := make([]float64, dims)
+	for d := range low {
+		low[d] = math.Inf(1)
+		high[d] = math.Inf(-1)
+	}
+	for _, point := range points {
+		for d := range low {
+			value := valueAt(point, d)
+			low[d] = min(low[d], value)
+			high[d] = max(high[d], value)
+		}
+	}
+
+	step := make([]float64, dims)
+	for d := range step {
+		step[d] = (high[d] - low[d]) / steps
+	}
+	return &Scalar{min: low, step: step}
+}
+
+func valueAt(values []float64, d int) float64 {
+	if d < len(values) {
+		return values[d]
+	}
+	return 0
+}
+
+// Dims returns the number of dimensions, and of bytes, of a code.
+func (s *Scalar) Dims() int {
+	return len(s.min)
+}
+
+// Encode quantizes values, extra values are ignored.
+func (s *Scalar) Encode(values []float64) Code {
+	bytes := make([]uint8, s.Dims())
+	for d := range bytes {
+		if s.step[d] == 0 {
+			continue
+		}
+		position := math.Round((valueAt(values, d) - s.min[d]) / s.step[d])
+		bytes[d] = uint8(max(min(position, steps), 0))
+	}
+	return s.Restore(bytes)
+}
+
+// Restore returns the code made of bytes, as written from Code.Bytes.
+func (s *Scalar) Restore(bytes []uint8) Code {
+	norm := 0.0
+	for d, b := range bytes {
+		value := s.value(d, b)
+		norm += value * value
+	}
+	return Code{Bytes: bytes, norm: norm}
+}
+
+func (s *Scalar) value(d int, b uint8) float64 {
+	return s.min[d] + float64(b)*s.step[d]
+}
+
+// Decode returns the values code stands for.
+func (s *Scalar) Decode(code Code) []float64 {
+	values := make([]float64, len(code.Bytes))
+	for d, b := range code.Bytes {
+		values[d] = s.value(d, b)
+	}
+	return values
+}
+
+// Distance scores two codes with metric, as vector.Distance_score scores the
+// vectors they decode to.
+func (s *Scalar) Distance(c1 Code, c2 Code, metric string) float64 {
+	if metric == "hamming" {
+		return s.hamming(c1, s.Decode(c2))
+	}
+	dot := 0.0
+	for d, b := range c1.Bytes {
+		dot += s.value(d, b) * s.value(d, c2.Bytes[d])
+	}
+	return score(dot, c1.norm, c2.norm, metric)
+}
+
+// hamming counts the dimensions where code decodes to a positive value and
+// values doesn't, or the other way around.
+func (s *Scalar) hamming(code Code, values []float64) float64 {
+	distance := 0
+	for d, b := range code.Bytes {
+		if (s.value(d, b) > 0) != (valueAt(values, d) > 0) {
+			distance++
+		}
+	}
+	for _, value := range values[min(len(code.Bytes), len(values)):] {
+		if value > 0 {
+			distance++
+		}
+	}
+	return float64(distance)
+}
+
+/*
+scalarQuery is a query vector prepared to be scored against codes. The dot product
+of the query with a decoded vector is the sum over the dimensions of
+query[d] × (min[d] + byte × step[d]): the query[d] × min[d] part is the same
+for every code and the query[d] × step[d] weights are computed once, so a
+code is scored with one multiplication per dimension.
+*/
+type scalarQuery struct {
+	scalar  *Scalar
+	values  []float64
+	weights []float64
+	offset  float64
+	norm    float64
+}
+
+// Query prepares values to be scored against codes. Values missing from it
+// count as 0.
+func (s *Scalar) Query(values []float64) Query {
+	query := &scalarQuery{scalar: s, values: values, weights: make([]float64, s.Dims())}
+	for d := range query.weights {
+		value := valueAt(values, d)
+		query.weights[d] = value * s.step[d]
+		query.offset += value * s.min[d]
+	}
+	for _, value := range values {
+		query.norm += value * value
+	}
+	return query
+}
+
+// Distance scores the query against code with metric, as
+// vector.Distance_score scores it against the vector code decodes to.
+func (q *scalarQuery) Distance(code Code, metric string) float64 {
+	if metric == "hamming" {
+		return q.scalar.hamming(code, q.values)
+	}
+	dot := q.offset
+	for d, b := range code.Bytes {
+		dot += q.weights[d] * float64(b)
+	}
+	return score(dot, q.norm, code.norm, metric)
+}
+
+// score turns the dot product and squared lengths of two vectors into the
+// score of vector.Distance_score. Zero vectors have no direction, their
+// cosine similarity to anything is taken as 0.
+func score(dot float64, norm1 float64, norm2 float64, metric string) float64 {
+	switch metric {
+	case "cosine", "dot_product":
+		similarity := 0.0
+		if norm1 > 0 && norm2 > 0 {
+			similarity = dot / math.Sqrt(norm1*norm2)
+		}
+		return 1 - (1+similarity)/2
+	case "euclidean":
+		return math.Sqrt(max(norm1-2*dot+norm2, 0))
+	}
+	return math.Inf(1)
+}
+
+// Save writes the quantizer, LoadScalar reads it back.
+func (s *Scalar) Save(out *codec.Writer) {
+	out.Float64s(s.min)
+	out.Float64s(s.step)
+}
+
+func LoadScalar(in *codec.Reader) (*Scalar, error) {
+	s := &Scalar{min: in.Float64s(), step: in.Float64s()}
+	if err := in.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.min) != len(s.step) {
+		return nil, fmt.Errorf("%w: %d minimums for %d steps", ErrCorruptQuantizer, len(s.min), len(s.step))
+	}
+	return s, nil
+}
@@ -0,0 +1,119 @@
+package quantization_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"VectorLite/internal/codec"
+	"VectorLite/internal/quantization"
+	"VectorLite/internal/vector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomPoints(rng *rand.Rand, n int, dims int) [][]float64 {
+	points := make([][]float64, n)
+	for i := range points {
+		points[i] = make([]float64, dims)
+		for d := range points[i] {
+			points[i][d] = rng.NormFloat64() * float64(d+1)
+		}
+	}
+	return points
+}
+
+func TestScalar_EncodeDecode(t *testing.T) {
+	points := randomPoints(rand.New(rand.NewSource(1)), 100, 8)
+	scalar := quantization.TrainScalar(points)
+	require.Equal(t, 8, scalar.Dims())
+
+	for _, point := range points {
+		code := scalar.Encode(point)
+		require.Len(t, code.Bytes, 8)
+		decoded := scalar.Decode(code)
+		for d, value := range point {
+			// values land on the closest of 256 steps across the range of
+			// the dimension, a range of about 6 standard deviations
+			assert.InDelta(t, value, decoded[d], float64(d+1)*8/255/2+1e-9)
+		}
+	}
+}
+
+func TestScalar_Encode_Range(t *testing.T) {
+	scalar := quantization.TrainScalar([][]float64{{0, 5, -1}, {10, 5, 1}})
+
+	code := scalar.Encode([]float64{-3, 7, 0.5})
+	assert.Equal(t, []uint8{0, 0, 191}, code.Bytes, "clamped, constant, in range")
+	assert.Equal(t, []float64{0, 5, -1 + 191*2.0/255}, scalar.Decode(code))
+
+	assert.Equal(t, []uint8{255, 0, 128}, scalar.Encode([]float64{10}).Bytes, "missing values are 0")
+	assert.Equal(t, []uint8{0, 0, 0}, scalar.Encode([]float64{0, 5, -1, 8}).Bytes, "extra values are ignored")
+}
+
+func TestScalar_Distance(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	points := randomPoints(rng, 50, 16)
+	scalar := quantization.TrainScalar(points)
+	query := randomPoints(rng, 1, 16)[0]
+	prepared := scalar.Query(query)
+
+	for _, metric := range []string{"cosine", "dot_product", "euclidean", "hamming"} {
+		for i, point := range points[:10] {
+			code := scalar.Encode(point)
+			other := scalar.Encode(points[i+1])
+			decoded := vector.Vector{Values: scalar.Decode(code)}
+			decodedOther := vector.Vector{Values: scalar.Decode(other)}
+
+			expected := vector.NewVector(query...).Distance_score(&decoded, metric)
+			assert.InDelta(t, expected, prepared.Distance(code, metric), 1e-9, metric)
+			expected = decoded.Distance_score(&decodedOther, metric)
+			assert.InDelta(t, expected, scalar.Distance(code, other, metric), 1e-9, metric)
+		}
+	}
+
+	zero := scalar.Query(make([]float64, 16))
+	assert.Equal(t, 0.5, zero.Distance(scalar.Encode(points[0]), "cosine"), "zero vectors are orthogonal to everything")
+}
+
+func TestScalar_SaveLoad(t *testing.T) {
+	scalar := quantization.TrainScalar(randomPoints(rand.New(rand.NewSource(3)), 20, 4))
+
+	var buf bytes.Buffer
+	out := codec.NewWriter(&buf)
+	scalar.Save(out)
+	require.NoError(t, out.Err())
+
+	loaded, err := quantization.LoadScalar(codec.NewReader(&buf))
+	require.NoError(t, err)
+	assert.Equal(t, scalar, loaded)
+
+	buf.Reset()
+	out = codec.NewWriter(&buf)
+	out.Float64s([]float64{1, 2})
+	out.Float64s([]float64{1})
+	_, err = quantization.LoadScalar(codec.NewReader(&buf))
+	assert.ErrorIs(t, err, quantization.ErrCorruptQuantizer)
+}
+
+func TestConfig_Rerank(t *testing.T) {
+	config := quantization.DefaultConfig()
+	assert.False(t, config.Enabled())
+	assert.Zero(t, config.Rerank(), "nothing to re-score with")
+
+	config.Mode = quantization.Int8
+	config.KeepOriginals = true
+	assert.True(t, config.Enabled())
+	assert.Equal(t, quantization.DefaultRerankFactor, config.Rerank())
+	assert.Equal(t, 10, config.Oversample(10), "queries can ask for more")
+
+	config = quantization.DefaultConfig()
+	config.Mode = quantization.Int8
+	assert.Zero(t, config.Oversample(10), "nothing to re-score with")
+
+	config.Mode = quantization.Bits
+	assert.True(t, config.Enabled())
+	assert.False(t, config.Trains())
+	assert.Equal(t, quantization.DefaultRerankFactor, config.Rerank(), "originals are always kept")
+}
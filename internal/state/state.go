@@ -2,12 +2,12 @@ package state
 
 import (
 	"VectorLite/internal/engine"
-	"sync"
 )
 
+// GlobalState holds what the API handlers share. DatabaseManager does its
+// own locking, so handlers can use it concurrently.
 type GlobalState struct {
 	DatabaseManager *engine.DatabaseManager
-	Mu              sync.Mutex
 }
 
 var State = GlobalState{
@@ -0,0 +1,83 @@
+package vector
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var ErrInvalidSparseVector = errors.New("invalid sparse vector")
+
+// NewSparseVector returns the sparse vector holding values at the dimensions
+// indices, sorted by dimension. Both lists must have the same length, and no
+// dimension may appear twice.
+func NewSparseVector(indices []uint32, values []float64) (*Vector, error) {
+	if len(indices) != len(values) {
+		return nil, fmt.Errorf("%w: %d indices for %d values", ErrInvalidSparseVector, len(indices), len(values))
+	}
+	order := make([]int, len(indices))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(i int, j int) int {
+		return cmp.Compare(indices[i], indices[j])
+	})
+
+	v := &Vector{Values: make([]float64, len(values)), Indices: make([]uint32, len(indices))}
+	for i, from := range order {
+		if i > 0 && indices[from] == v.Indices[i-1] {
+			return nil, fmt.Errorf("%w: dimension %d appears twice", ErrInvalidSparseVector, indices[from])
+		}
+		v.Indices[i] = indices[from]
+		v.Values[i] = values[from]
+	}
+	return v, nil
+}
+
+// IsSparse reports whether the vector holds its values by dimension.
+func (vector *Vector) IsSparse() bool {
+	return vector.Indices != nil
+}
+
+// Get returns the value of dimension i, 0 when the vector doesn't hold it.
+func (vector *Vector) Get(i uint32) float64 {
+	if !vector.IsSparse() {
+		if int(i) < len(vector.Values) {
+			return vector.Values[i]
+		}
+		return 0
+	}
+	if at, found := slices.BinarySearch(vector.Indices, i); found {
+		return vector.Values[at]
+	}
+	return 0
+}
+
+// sparseDotProduct is Dot_product when either vector is sparse, walking the
+// dimensions both hold.
+func (v1 *Vector) sparseDotProduct(v2 *Vector) float64 {
+	if !v1.IsSparse() {
+		v1, v2 = v2, v1
+	}
+	dot_product := 0.0
+	if !v2.IsSparse() {
+		for i, index := range v1.Indices {
+			dot_product += v1.Values[i] * v2.Get(index)
+		}
+		return dot_product
+	}
+	for i, j := 0, 0; i < len(v1.Indices) && j < len(v2.Indices); {
+		switch {
+		case v1.Indices[i] < v2.Indices[j]:
+			i++
+		case v1.Indices[i] > v2.Indices[j]:
+			j++
+		default:
+			dot_product += v1.Values[i] * v2.Values[j]
+			i++
+			j++
+		}
+	}
+	return dot_product
+}
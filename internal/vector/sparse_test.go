@@ -0,0 +1,75 @@
+package vector_test
+
+import (
+	"VectorLite/internal/vector"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sparse(t *testing.T, indices []uint32, values []float64) *vector.Vector {
+	t.Helper()
+	v, err := vector.NewSparseVector(indices, values)
+	require.NoError(t, err)
+	return v
+}
+
+func TestNewSparseVector(t *testing.T) {
+	v := sparse(t, []uint32{7, 2, 40}, []float64{0.5, 1.5, -1})
+	assert.Equal(t, []uint32{2, 7, 40}, v.Indices, "sorted by dimension")
+	assert.Equal(t, []float64{1.5, 0.5, -1}, v.Values)
+	assert.True(t, v.IsSparse())
+	assert.False(t, vector.NewVector(1, 2).IsSparse())
+
+	empty := sparse(t, []uint32{}, []float64{})
+	assert.True(t, empty.IsSparse(), "an empty sparse vector is still sparse")
+
+	_, err := vector.NewSparseVector([]uint32{1, 2}, []float64{1})
+	assert.ErrorIs(t, err, vector.ErrInvalidSparseVector)
+	_, err = vector.NewSparseVector([]uint32{3, 1, 3}, []float64{1, 2, 3})
+	assert.ErrorIs(t, err, vector.ErrInvalidSparseVector)
+}
+
+func TestSparseGet(t *testing.T) {
+	v := sparse(t, []uint32{2, 7}, []float64{1.5, 0.5})
+	assert.Equal(t, 1.5, v.Get(2))
+	assert.Equal(t, 0.5, v.Get(7))
+	assert.Zero(t, v.Get(3))
+	assert.Equal(t, 2.0, vector.NewVector(1, 2).Get(1))
+	assert.Zero(t, vector.NewVector(1, 2).Get(5))
+}
+
+func TestSparseDotProduct(t *testing.T) {
+	v1 := sparse(t, []uint32{1, 4, 9}, []float64{2, 3, 4})
+	v2 := sparse(t, []uint32{0, 4, 9, 12}, []float64{5, -1, 0.5, 8})
+	assert.Equal(t, -3+2.0, v1.Dot_product(v2))
+	assert.Equal(t, v1.Dot_product(v2), v2.Dot_product(v1))
+	assert.Zero(t, v1.Dot_product(sparse(t, []uint32{2, 3}, []float64{1, 1})), "no shared dimension")
+
+	dense := vector.NewVector(1, 1, 0, 0, 2)
+	assert.Equal(t, 2+6.0, v1.Dot_product(dense), "dimensions past the dense vector count as 0")
+	assert.Equal(t, 2+6.0, dense.Dot_product(v1))
+}
+
+func TestSparseDistanceScore(t *testing.T) {
+	v1 := sparse(t, []uint32{1, 4}, []float64{3, 4})
+	v2 := sparse(t, []uint32{4, 8}, []float64{2, 1})
+	assert.Equal(t, -8.0, v1.Distance_score(v2, "dot_product"), "the raw dot product, negated")
+	assert.InDelta(t, 1-(1+8/(5*math.Sqrt(5)))/2, v1.Distance_score(v2, "cosine"), 1e-12)
+	assert.InDelta(t, 0, v1.Distance_score(v1, "cosine"), 1e-12)
+	assert.True(t, math.IsInf(v1.Distance_score(v2, "euclidean"), 1), "positions mean nothing to sparse vectors")
+}
+
+func TestSparseEqual(t *testing.T) {
+	v := sparse(t, []uint32{1, 4}, []float64{3, 4})
+	assert.True(t, v.Equal(sparse(t, []uint32{4, 1}, []float64{4, 3})))
+	assert.False(t, v.Equal(sparse(t, []uint32{1, 5}, []float64{3, 4})))
+	assert.False(t, v.Equal(vector.NewVector(3, 4)))
+	assert.False(t, vector.NewVector(3, 4).Equal(v))
+
+	normalized := v.Normalize()
+	assert.Equal(t, v.Indices, normalized.Indices)
+	assert.InDelta(t, 1, normalized.Magnitude(), 1e-12)
+}
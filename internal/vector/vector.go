@@ -2,8 +2,12 @@ package vector
 
 import "math"
 
+// Vector is dense, one value per dimension, unless Indices is set: a sparse
+// vector holds the values of the dimensions in Indices only, see
+// NewSparseVector.
 type Vector struct {
-	Values []float64
+	Values  []float64
+	Indices []uint32
 }
 
 func NewVector(values ...float64) *Vector {
@@ -25,11 +29,33 @@ func (v1 *Vector) Normalize() *Vector {
 		new_value := value / magnitude
 		new_values = append(new_values, new_value)
 	}
-	new_vector := Vector{Values: new_values}
+	new_vector := Vector{Values: new_values, Indices: v1.Indices}
 	return &new_vector
 }
 
+// Equal reports whether both vectors hold exactly the same values, in the
+// same dimensions.
+func (v1 *Vector) Equal(v2 *Vector) bool {
+	if len(v1.Values) != len(v2.Values) || v1.IsSparse() != v2.IsSparse() {
+		return false
+	}
+	for i, index := range v1.Indices {
+		if index != v2.Indices[i] {
+			return false
+		}
+	}
+	for i, value1 := range v1.Values {
+		if value1 != v2.Values[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (v1 *Vector) Dot_product(v2 *Vector) float64 {
+	if v1.IsSparse() || v2.IsSparse() {
+		return v1.sparseDotProduct(v2)
+	}
 	dot_product := 0.0
 	for i, value1 := range v1.Values {
 		value2 := v2.Values[i]
@@ -51,8 +77,42 @@ func (v1 *Vector) Euclidean_distance(v2 *Vector) float64 {
 	return math.Sqrt(x)
 }
 
+// Hamming_distance counts the dimensions where one vector is positive and the
+// other isn't, comparing the vectors by their signs alone. Missing values
+// count as 0.
+func (v1 *Vector) Hamming_distance(v2 *Vector) float64 {
+	distance := 0
+	for i := 0; i < max(len(v1.Values), len(v2.Values)); i++ {
+		if (i < len(v1.Values) && v1.Values[i] > 0) != (i < len(v2.Values) && v2.Values[i] > 0) {
+			distance++
+		}
+	}
+	return float64(distance)
+}
+
+// IsValidMetric reports whether metric is understood by Distance_score.
+func IsValidMetric(metric string) bool {
+	switch metric {
+	case "cosine", "dot_product", "euclidean", "hamming":
+		return true
+	}
+	return false
+}
+
+// Distance_score is lower for closer vectors. Sparse vectors are only scored
+// with cosine and dot_product, where their raw dot product is negated: learned
+// sparse models, such as SPLADE, rank by it as it is.
 func (v1 *Vector) Distance_score(v2 *Vector, metric string) float64 {
 	score := math.Inf(1)
+	if v1.IsSparse() || v2.IsSparse() {
+		switch metric {
+		case "cosine":
+			score = 1 - (1+v1.Cosine_similarity(v2))/2
+		case "dot_product":
+			score = -v1.Dot_product(v2)
+		}
+		return score
+	}
 	switch metric {
 	case "cosine":
 		score = 1 - (1+v1.Cosine_similarity(v2))/2
@@ -60,6 +120,8 @@ func (v1 *Vector) Distance_score(v2 *Vector, metric string) float64 {
 		score = 1 - (1+v1.Normalize().Cosine_similarity(v2.Normalize()))/2
 	case "euclidean":
 		score = v1.Euclidean_distance(v2)
+	case "hamming":
+		score = v1.Hamming_distance(v2)
 	}
 	return score
 }
@@ -150,6 +150,10 @@ func TestDistanceScore(t *testing.T) {
 		{v1, v2, "dot_product", 0.5},
 		{v1, v2, "euclidean", math.Sqrt(2)},
 		{v1, v1, "euclidean", 0},
+		{v1, v2, "hamming", 2},
+		{v1, v1, "hamming", 0},
+		{vector.Vector{Values: []float64{-1, 2, 0}}, vector.Vector{Values: []float64{-3, 5}}, "hamming", 0},
+		{vector.Vector{Values: []float64{1, 2, 3}}, vector.Vector{Values: []float64{-1}}, "hamming", 3},
 	}
 
 	for _, tt := range tests {
@@ -160,3 +164,18 @@ func TestDistanceScore(t *testing.T) {
 		})
 	}
 }
+
+func TestIsValidMetric(t *testing.T) {
+	for _, metric := range []string{"cosine", "dot_product", "euclidean", "hamming"} {
+		assert.True(t, vector.IsValidMetric(metric), "%s should be a valid metric", metric)
+	}
+	assert.False(t, vector.IsValidMetric("manhattan"))
+	assert.False(t, vector.IsValidMetric(""))
+}
+
+func TestEqual(t *testing.T) {
+	v1 := vector.NewVector(1, 2, 3)
+	assert.True(t, v1.Equal(vector.NewVector(1, 2, 3)))
+	assert.False(t, v1.Equal(vector.NewVector(1, 2, 4)))
+	assert.False(t, v1.Equal(vector.NewVector(1, 2)))
+}